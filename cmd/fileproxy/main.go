@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -10,50 +12,446 @@ import (
 	"github.com/shared-utils/fileproxy/fileproxy"
 )
 
+// commands 子命令，未指定時執行代理
+type commands struct {
+	Serve CLI     `cmd:"" default:"withargs" help:"Run the caching proxy (default)"`
+	Soak  SoakCmd `cmd:"" help:"Run the proxy against a fault-injecting fake upstream for hours, checking invariants after every cycle"`
+}
+
 type CLI struct {
-	Listen      string        `help:"Listen address" default:":8080" env:"LISTEN_ADDR"`
-	Upstream    string        `help:"Upstream URL" required:"" env:"UPSTREAM_URL"`
-	CacheDir    string        `help:"Cache directory" default:"./cache" env:"CACHE_DIR" type:"path"`
-	MaxCacheGB  float64       `help:"Max cache size in GB" default:"1.0" name:"max-cache-gb" env:"MAX_CACHE_GB"`
-	CacheTTL    time.Duration `help:"Cache TTL" default:"1h" name:"cache-ttl" env:"CACHE_TTL"`
-	NotFoundTTL time.Duration `help:"NotFound cache TTL" default:"5s" name:"notfound-ttl" env:"NOTFOUND_TTL"`
-	TLSCert     string        `help:"TLS certificate file" name:"tls-cert" env:"TLS_CERT" type:"existingfile"`
-	TLSKey      string        `help:"TLS private key file" name:"tls-key" env:"TLS_KEY" type:"existingfile"`
-	Debug       bool          `help:"Enable debug logging" env:"DEBUG"`
+	Listen               string        `help:"Listen address (host:port, or unix:///path/to.sock for a Unix domain socket)" default:":8080" env:"LISTEN_ADDR"`
+	Upstream             string        `help:"Upstream URL, or file:///path to serve a local directory (optional when routes are set)" env:"UPSTREAM_URL"`
+	Routes               []string      `help:"Route a path prefix to its own upstream (PREFIX=URL)" name:"route" env:"ROUTES"`
+	Rewrites             []string      `help:"Rewrite the upstream path, applied in order: strip:/PREFIX, add:/PREFIX or regex:PATTERN=>REPLACEMENT; cache keys keep the public path" name:"rewrite" env:"REWRITES" sep:";"`
+	CacheDir             string        `help:"Cache directory" default:"./cache" env:"CACHE_DIR" type:"path"`
+	MaxCacheGB           float64       `help:"Max cache size in GB" default:"1.0" name:"max-cache-gb" env:"MAX_CACHE_GB"`
+	EvictMinAge          time.Duration `help:"Never evict entries younger than this to stay under max-cache-gb, so freshly fetched files survive a burst (0 to disable)" default:"0" name:"evict-min-age" env:"EVICT_MIN_AGE"`
+	KeepVersions         int           `help:"Previous versions kept when a refetch changes an entry's content, served with ?version=previous or ?version=<RFC 3339 time> (0 to disable)" default:"0" name:"keep-versions" env:"KEEP_VERSIONS"`
+	EvictionPolicy       string        `help:"Which entries to evict when the cache is full: lru, lfu, gdsf (size-aware, keeps many small hot files over a few huge ones) or fifo" default:"lru" enum:"lru,lfu,gdsf,fifo" name:"eviction-policy" env:"EVICTION_POLICY"`
+	ShadowPolicies       []string      `help:"Simulate alternative policies POLICY[+second-hit][+max=SIZE] alongside the active one and log what each would do on every fill" name:"shadow-policy" env:"SHADOW_POLICIES"`
+	MinFreeDiskGB        float64       `help:"Evict oldest entries when free space on the cache filesystem drops below this many GB (0 to disable)" default:"0" name:"min-free-disk-gb" env:"MIN_FREE_DISK_GB"`
+	MinFreeDiskPercent   float64       `help:"Evict oldest entries when free space on the cache filesystem drops below this percentage (0 to disable)" default:"0" name:"min-free-disk-percent" env:"MIN_FREE_DISK_PERCENT"`
+	CacheTTL             time.Duration `help:"Cache TTL" default:"1h" name:"cache-ttl" env:"CACHE_TTL"`
+	NotFoundTTL          time.Duration `help:"NotFound cache TTL" default:"5s" name:"notfound-ttl" env:"NOTFOUND_TTL"`
+	ForbiddenTTL         time.Duration `help:"How long an upstream 403 is cached (0 to disable)" default:"5s" name:"forbidden-ttl" env:"FORBIDDEN_TTL"`
+	GoneTTL              time.Duration `help:"How long an upstream 410 is cached (0 to disable)" default:"1h" name:"gone-ttl" env:"GONE_TTL"`
+	ServerErrorTTL       time.Duration `help:"How long an upstream 5xx is cached (0 to disable); network errors are never cached" default:"0" name:"server-error-ttl" env:"SERVER_ERROR_TTL"`
+	NegativeCacheSize    int           `help:"Maximum number of negative cache entries (0 for no count limit)" default:"10000" name:"negative-cache-size" env:"NEGATIVE_CACHE_SIZE"`
+	NegativeCacheMB      float64       `help:"Estimated memory limit of the negative cache in MB (0 for no memory limit)" default:"0" name:"negative-cache-mb" env:"NEGATIVE_CACHE_MB"`
+	StaleIfErrorTTL      time.Duration `help:"How long expired files may be served when upstream fails (0 to disable)" default:"0" name:"stale-if-error-ttl" env:"STALE_IF_ERROR_TTL"`
+	MinCacheTTL          time.Duration `help:"Lower bound for upstream-provided TTL" default:"0" name:"min-cache-ttl" env:"MIN_CACHE_TTL"`
+	MaxCacheTTL          time.Duration `help:"Upper bound for upstream-provided TTL (0 to cap at cache-ttl)" default:"0" name:"max-cache-ttl" env:"MAX_CACHE_TTL"`
+	TextContentTTL       time.Duration `help:"Default TTL for JSON, XML and text responses without upstream cache headers (0 to use cache-ttl)" default:"5m" name:"text-content-ttl" env:"TEXT_CONTENT_TTL"`
+	BinaryContentTTL     time.Duration `help:"Default TTL for images, media and archives without upstream cache headers (0 to use cache-ttl)" default:"168h" name:"binary-content-ttl" env:"BINARY_CONTENT_TTL"`
+	IgnoreNoStore        bool          `help:"Cache responses even when upstream sends Cache-Control: no-store or private" name:"ignore-upstream-no-store" env:"IGNORE_UPSTREAM_NO_STORE"`
+	HonorImmutable       bool          `help:"Never expire responses marked Cache-Control: immutable by TTL; evict them only under size pressure" default:"true" negatable:"" name:"honor-immutable" env:"HONOR_IMMUTABLE"`
+	CacheRules           []string      `help:"Per-path cache rule PATTERN:OPTIONS, e.g. /blobs/**:ttl=720h or /live/*:no-cache" name:"cache-rule" env:"CACHE_RULES" sep:";"`
+	CacheKeyQuery        []string      `help:"Query parameters included in the cache key and forwarded upstream, or * for all (default: query strings are ignored)" name:"cache-key-query" env:"CACHE_KEY_QUERY"`
+	CacheKeyStrip        []string      `help:"Tracking parameters never included in the cache key; a trailing * matches a prefix" default:"utm_*,fbclid,gclid,msclkid,mc_cid,mc_eid,_ga" name:"cache-key-strip" env:"CACHE_KEY_STRIP"`
+	KeyFoldCase          bool          `help:"Lowercase paths in cache keys, for case-insensitive upstreams" name:"cache-key-fold-case" env:"CACHE_KEY_FOLD_CASE"`
+	KeyMergeSlashes      bool          `help:"Collapse repeated slashes in cache key paths" name:"cache-key-merge-slashes" env:"CACHE_KEY_MERGE_SLASHES"`
+	KeyTrimSlash         bool          `help:"Strip trailing slashes from cache key paths" name:"cache-key-trim-slash" env:"CACHE_KEY_TRIM_SLASH"`
+	ExprRules            []string      `help:"Expression rule EXPR => OPTIONS over request attributes, checked after cache rules" name:"expr-rule" env:"EXPR_RULES" sep:";"`
+	MirrorProfiles       []string      `help:"Package mirror profile (apt, yum, npm, pypi, huggingface): short TTLs for repo metadata, packages cached as immutable; checked after all other rules" enum:"apt,yum,npm,pypi,huggingface" name:"mirror-profile" env:"MIRROR_PROFILES"`
+	MirrorMetaTTL        time.Duration `help:"TTL of repo metadata (Release, Packages, repomd.xml) under a mirror profile" default:"30s" name:"mirror-metadata-ttl" env:"MIRROR_METADATA_TTL"`
+	PublicURL            string        `help:"Base URL clients use to reach the proxy; upstream links in npm and PyPI metadata are rewritten to it" name:"public-url" env:"PUBLIC_URL"`
+	Metalink             bool          `help:"Accept Metalink (RFC 5854) from upstreams and download from the listed mirrors, failing over between them and verifying size and hash" name:"metalink" env:"METALINK"`
+	CacheErrorBudget     int           `help:"Cache errors (disk writes, commits, opens, index writes) tolerated per window before switching to pure passthrough (0 to disable)" default:"50" name:"cache-error-budget" env:"CACHE_ERROR_BUDGET"`
+	CacheErrorWindow     time.Duration `help:"Window for the cache error budget, also the wait before probing the disk again" default:"1m" name:"cache-error-window" env:"CACHE_ERROR_WINDOW"`
+	HotRefreshCount      int           `help:"Number of hottest entries refreshed in the background shortly before they expire (0 to disable)" default:"0" name:"hot-refresh-count" env:"HOT_REFRESH_COUNT"`
+	HotRefreshAhead      time.Duration `help:"How long before expiry hot entries are refreshed" default:"1m" name:"hot-refresh-ahead" env:"HOT_REFRESH_AHEAD"`
+	CompleteOnDisconnect bool          `help:"Finish a download in the background when the client that started it disconnects" default:"true" negatable:"" name:"complete-on-disconnect" env:"COMPLETE_ON_DISCONNECT"`
+	DisconnectRemainMB   int64         `help:"Abandon a disconnected download when more than this many MB remain (0 for no limit)" default:"0" name:"disconnect-max-remaining-mb" env:"DISCONNECT_MAX_REMAINING_MB"`
+	DisconnectMinPercent float64       `help:"Abandon a disconnected download when less than this percentage was fetched (0 for no limit)" default:"0" name:"disconnect-min-percent" env:"DISCONNECT_MIN_PERCENT"`
+	PurgeRefetchRate     int           `help:"Entries refetched per second after a purge with ?refetch=1 (0 to disable the option)" default:"10" name:"purge-refetch-rate" env:"PURGE_REFETCH_RATE"`
+	PurgeStaleWindow     time.Duration `help:"How long purged entries awaiting refetch are still served as stale" default:"1m" name:"purge-stale-window" env:"PURGE_STALE_WINDOW"`
+	VerifyOnServe        bool          `help:"Verify SHA-256 of cached files before serving them from disk" name:"verify-on-serve" env:"VERIFY_ON_SERVE"`
+	ScrubInterval        time.Duration `help:"Interval for background checksum verification of all cached files (0 to disable)" default:"0" name:"scrub-interval" env:"SCRUB_INTERVAL"`
+	StatCacheTTL         time.Duration `help:"How long a cache hit reuses the last file existence check instead of stat-ing again (0 to stat on every hit)" default:"1s" name:"stat-cache-ttl" env:"STAT_CACHE_TTL"`
+	RecordProvenance     bool          `help:"Record the upstream URL, response headers, fetch time and upstream IP of each cached entry for auditing" name:"record-provenance" env:"RECORD_PROVENANCE"`
+	ExpiryReportAt       string        `help:"Local time (HH:MM) to log a daily report of entries about to expire (empty to disable)" name:"expiry-report-at" env:"EXPIRY_REPORT_AT"`
+	ExpiryReportWindow   time.Duration `help:"Report entries expiring within this window" default:"24h" name:"expiry-report-window" env:"EXPIRY_REPORT_WINDOW"`
+	ExpiryReportDepth    int           `help:"Group the expiry report by this many leading path segments" default:"2" name:"expiry-report-depth" env:"EXPIRY_REPORT_DEPTH"`
+	MemoryCacheMB        float64       `help:"In-memory hot tier size in MB (0 to disable)" default:"0" name:"memory-cache-mb" env:"MEMORY_CACHE_MB"`
+	MemoryCacheMaxFileKB int64         `help:"Max file size kept in memory in KB" default:"64" name:"memory-cache-max-file-kb" env:"MEMORY_CACHE_MAX_FILE_KB"`
+	RangeReadaheadKB     int           `help:"Readahead buffer in KB for connections issuing sequential range requests on one cached file (0 to disable)" default:"256" name:"range-readahead-kb" env:"RANGE_READAHEAD_KB"`
+	OutboundAddrs        []string      `help:"Local IPs or interface names to bind upstream connections to, rotated per connection" name:"outbound-addr" env:"OUTBOUND_ADDRS"`
+	UpstreamSRV          string        `help:"DNS SRV name resolving the upstream host to its servers, re-resolved periodically (e.g. _https._tcp.files.example.com)" name:"upstream-srv" env:"UPSTREAM_SRV"`
+	UpstreamSRVInterval  time.Duration `help:"How often the upstream SRV records are re-resolved" default:"30s" name:"upstream-srv-interval" env:"UPSTREAM_SRV_INTERVAL"`
+	MaxRedirects         int           `help:"Upstream redirects followed before failing with 502; the final body is cached under the original key" default:"10" name:"max-redirects" env:"MAX_REDIRECTS"`
+	PassRedirects        bool          `help:"Return upstream 3xx responses to the client unmodified instead of following them" name:"pass-redirects" env:"PASS_REDIRECTS"`
+	UpstreamRateHeaders  bool          `help:"Pace upstream requests by the X-RateLimit-Remaining/Reset headers upstreams return instead of running into 429s" default:"true" negatable:"" name:"upstream-rate-headers" env:"UPSTREAM_RATE_HEADERS"`
+	UpstreamRateReserve  float64       `help:"Fraction of the upstream rate limit below which remaining requests are spread evenly until the reset" default:"0.2" name:"upstream-rate-reserve" env:"UPSTREAM_RATE_RESERVE"`
+	UpstreamRateMaxWait  time.Duration `help:"Longest a request waits for an exhausted upstream rate limit to reset before returning 503 (0 to wait indefinitely)" default:"30s" name:"upstream-rate-max-wait" env:"UPSTREAM_RATE_MAX_WAIT"`
+	ProbeInterval        time.Duration `help:"How often upstreams are re-probed for HTTP/2 and Range support (0 to probe only at startup)" default:"10m" name:"upstream-probe-interval" env:"UPSTREAM_PROBE_INTERVAL"`
+	ResumeAttempts       int           `help:"Times an interrupted upstream download is resumed with a Range request when the upstream supports it (0 to disable)" default:"3" name:"fetch-resume-attempts" env:"FETCH_RESUME_ATTEMPTS"`
+	ParallelStreams      int           `help:"Ranges fetched in parallel for large files when the upstream supports HTTP/2 and Range (0 or 1 to disable)" default:"4" name:"parallel-fetch-streams" env:"PARALLEL_FETCH_STREAMS"`
+	ParallelMinMB        int64         `help:"Minimum file size in MB for parallel range fetches" default:"32" name:"parallel-fetch-min-mb" env:"PARALLEL_FETCH_MIN_MB"`
+	RateLimit            float64       `help:"Requests per second allowed per client IP (0 for unlimited)" default:"0" name:"rate-limit" env:"RATE_LIMIT"`
+	RateBurst            int           `help:"Token bucket size per client IP (0 to match rate-limit)" default:"0" name:"rate-burst" env:"RATE_BURST"`
+	MaxConcurrentPerIP   int           `help:"Max in-flight requests per client IP (0 for unlimited)" default:"0" name:"max-concurrent-per-ip" env:"MAX_CONCURRENT_PER_IP"`
+	RateLimitRules       []string      `help:"Per-prefix rate limit PREFIX:OPTIONS overriding the global limit, e.g. /npm:rate=20,burst=40,concurrent=4" name:"rate-limit-rule" env:"RATE_LIMIT_RULES" sep:";"`
+	ScanNotFoundLimit    int           `help:"404/410 responses allowed per client IP within --scan-window before it is blocked (0 to disable)" default:"0" name:"scan-notfound-limit" env:"SCAN_NOTFOUND_LIMIT"`
+	ScanWindow           time.Duration `help:"Window over which 404/410 responses are counted per client IP" default:"1m" name:"scan-window" env:"SCAN_WINDOW"`
+	ScanBlockFor         time.Duration `help:"How long a client IP that exceeded --scan-notfound-limit is answered with 429" default:"5m" name:"scan-block-for" env:"SCAN_BLOCK_FOR"`
+	ScanTarpit           time.Duration `help:"Delay before answering a blocked client with 429 (0 to answer immediately)" default:"0" name:"scan-tarpit" env:"SCAN_TARPIT"`
+	MaxBandwidthMB       float64       `help:"Global download speed limit in MB/s (0 for unlimited)" default:"0" name:"max-bandwidth-mb" env:"MAX_BANDWIDTH_MB"`
+	MaxConnBandwidthMB   float64       `help:"Per-connection download speed limit in MB/s (0 for unlimited)" default:"0" name:"max-conn-bandwidth-mb" env:"MAX_CONN_BANDWIDTH_MB"`
+	MaxConcurrentFetches int           `help:"Max concurrent upstream fetches (0 for unlimited)" default:"0" name:"max-concurrent-fetches" env:"MAX_CONCURRENT_FETCHES"`
+	MaxFetchQueue        int           `help:"Max requests waiting for an upstream fetch slot before returning 503 (0 for unlimited)" default:"0" name:"max-fetch-queue" env:"MAX_FETCH_QUEUE"`
+	FetchQueueTimeout    time.Duration `help:"Max time to wait for an upstream fetch slot before returning 503 (0 to wait indefinitely)" default:"0" name:"fetch-queue-timeout" env:"FETCH_QUEUE_TIMEOUT"`
+	MaxStreamWaiters     int           `help:"Max requests attached to one in-flight download before returning 503 (0 for unlimited)" default:"0" name:"coalesce-max-waiters" env:"COALESCE_MAX_WAITERS"`
+	StreamWaitTimeout    time.Duration `help:"Abort requests attached to an in-flight download after it makes no progress for this long (0 to wait indefinitely)" default:"0" name:"coalesce-wait-timeout" env:"COALESCE_WAIT_TIMEOUT"`
+	PendingStallTimeout  time.Duration `help:"Abort an in-flight download that writes nothing for this long so the next request fetches afresh (0 to disable)" default:"0" name:"pending-stall-timeout" env:"PENDING_STALL_TIMEOUT"`
+	ShutdownTimeout      time.Duration `help:"On shutdown, wait this long for in-flight downloads to finish and be cached before aborting them" default:"30s" name:"shutdown-timeout" env:"SHUTDOWN_TIMEOUT"`
+	PIDFile              string        `help:"Write the process ID to this file at startup; rewritten by the new process on a SIGUSR2 handoff" name:"pid-file" env:"PID_FILE"`
+	LowPriorityPrefixes  []string      `help:"Path prefixes treated as background traffic" name:"low-priority-prefix" env:"LOW_PRIORITY_PREFIXES"`
+	PrefixFetchLimits    []string      `help:"Max concurrent upstream fetches for a path prefix PREFIX=N; excess misses wait in line" name:"prefix-fetch-limit" env:"PREFIX_FETCH_LIMITS"`
+	PrefetchWorkers      int           `help:"Concurrent downloads per prefetch job (0 disables POST /admin/prefetch)" default:"4" name:"prefetch-workers" env:"PREFETCH_WORKERS"`
+	MaxStreamReaders     int           `help:"Max requests attached to in-flight downloads across all keys before returning 503 (0 for unlimited)" default:"0" name:"max-stream-readers" env:"MAX_STREAM_READERS"`
+	MaxFetchers          int           `help:"Max upstream fetches, including those waiting for a slot, before returning 503 (0 for unlimited)" default:"0" name:"max-fetchers" env:"MAX_FETCHERS"`
+	MaxBackgroundJobs    int           `help:"Max goroutines for prefetch, refetch and revalidation; excess work runs sequentially (0 for unlimited)" default:"0" name:"max-background-jobs" env:"MAX_BACKGROUND_JOBS"`
+	GoroutineLeakAge     time.Duration `help:"Warn about tracked goroutines running longer than this (0 to disable)" default:"1h" name:"goroutine-leak-age" env:"GOROUTINE_LEAK_AGE"`
+	StrictHTTP           bool          `help:"Strict RFC 9110/9111 compliance (validators, conditional and multi-range requests)" name:"strict-http" env:"STRICT_HTTP"`
+	MaxHeaderKB          int           `help:"Max request header size in KB" default:"32" name:"max-header-kb" env:"MAX_HEADER_KB"`
+	MaxPathLength        int           `help:"Max request path length (0 for unlimited)" default:"4096" name:"max-path-length" env:"MAX_PATH_LENGTH"`
+	TLSCert              string        `help:"TLS certificate file" name:"tls-cert" env:"TLS_CERT" type:"existingfile"`
+	TLSKey               string        `help:"TLS private key file" name:"tls-key" env:"TLS_KEY" type:"existingfile"`
+	TLSCurves            []string      `help:"Key exchange curves in preference order (x25519, x25519mlkem768, p256, p384, p521)" name:"tls-curve" env:"TLS_CURVES"`
+	TLSTicketKeys        string        `help:"File of hex session ticket keys shared across instances (first key encrypts)" name:"tls-ticket-keys" env:"TLS_TICKET_KEYS" type:"existingfile"`
+	TLSNoSessionTickets  bool          `help:"Disable TLS session ticket resumption" name:"tls-no-session-tickets" env:"TLS_NO_SESSION_TICKETS"`
+	ReusePort            bool          `help:"Set SO_REUSEPORT on listeners so several processes can share a port (Linux only)" name:"reuse-port" env:"REUSE_PORT"`
+	SocketMode           string        `help:"Permissions of unix:// listen sockets in octal, e.g. 0660 (empty to follow the umask)" name:"socket-mode" env:"SOCKET_MODE"`
+	SocketOwner          string        `help:"Owner of unix:// listen sockets as user[:group], names or numeric IDs" name:"socket-owner" env:"SOCKET_OWNER"`
+	TCPNoDelay           bool          `help:"Enable TCP_NODELAY on accepted connections" default:"true" negatable:"" name:"tcp-nodelay" env:"TCP_NODELAY"`
+	SocketRecvBufferKB   int           `help:"SO_RCVBUF for accepted connections in KB (0 for system default)" default:"0" name:"socket-recv-buffer-kb" env:"SOCKET_RECV_BUFFER_KB"`
+	SocketSendBufferKB   int           `help:"SO_SNDBUF for accepted connections in KB (0 for system default)" default:"0" name:"socket-send-buffer-kb" env:"SOCKET_SEND_BUFFER_KB"`
+	TCPKeepAliveIdle     time.Duration `help:"Idle time before TCP keepalive probes on accepted connections (0 for 15s, negative to disable keepalive)" default:"0" name:"tcp-keepalive-idle" env:"TCP_KEEPALIVE_IDLE"`
+	TCPKeepAliveInterval time.Duration `help:"Interval between TCP keepalive probes (0 for 15s)" default:"0" name:"tcp-keepalive-interval" env:"TCP_KEEPALIVE_INTERVAL"`
+	TCPKeepAliveCount    int           `help:"Unanswered TCP keepalive probes before the connection is dropped (0 for 9)" default:"0" name:"tcp-keepalive-count" env:"TCP_KEEPALIVE_COUNT"`
+	AdminToken           string        `help:"Bearer token for admin endpoints" name:"admin-token" env:"ADMIN_TOKEN"`
+	AdminListen          string        `help:"Separate listen address for /health, /stats, the dashboard and admin endpoints (empty to serve them on the proxy port)" name:"admin-listen" env:"ADMIN_LISTEN_ADDR"`
+	APIKeysFile          string        `help:"File of client API keys (NAME KEY [PREFIX...] per line); requests without a valid key are rejected" name:"api-keys-file" env:"API_KEYS_FILE" type:"existingfile"`
+	ForwardAuth          bool          `help:"Forward client Authorization upstream and isolate cached content per credential" name:"forward-auth" env:"FORWARD_AUTH"`
+	Forwarded            bool          `help:"Send an RFC 7239 Forwarded header with the client IP, host and protocol upstream" default:"true" negatable:"" name:"forwarded" env:"FORWARDED"`
+	CORSOrigins          []string      `help:"Origins allowed to read proxied files from browser scripts, or * for any" name:"cors-origin" env:"CORS_ORIGINS"`
+	Middleware           []string      `help:"Built-in middleware in front of the proxy handler, outermost first" default:"logging,cors,scan_guard,rate_limit,auth" name:"middleware" env:"MIDDLEWARE"`
+	ReplicationPeers     []string      `help:"Peer proxy URLs to push completed fills to (formerly --replication-peer)" name:"replicate-to" env:"REPLICATE_TO"`
+	StandbyURL           string        `help:"Warm standby proxy URL that cache index updates are streamed to" name:"standby" env:"STANDBY_URL"`
+	StandbyHotHits       int64         `help:"Also push the bodies of entries hit at least this many times to the standby (0 to sync only the index)" default:"0" name:"standby-hot-hits" env:"STANDBY_HOT_HITS"`
+	ArchiveDir           string        `help:"Directory that keeps a permanent copy of every fetched object (must be outside cache-dir)" name:"archive-dir" env:"ARCHIVE_DIR" type:"path"`
+	OTLPEndpoint         string        `help:"OTLP/HTTP trace exporter endpoint (host:port or URL); empty only forwards traceparent" name:"otlp-endpoint" env:"OTLP_ENDPOINT"`
+	OTLPInsecure         bool          `help:"Use plain HTTP for a host:port OTLP endpoint" name:"otlp-insecure" env:"OTLP_INSECURE"`
+	TraceSampleRatio     float64       `help:"Fraction of new traces to sample when the caller made no sampling decision" default:"1.0" name:"trace-sample-ratio" env:"TRACE_SAMPLE_RATIO"`
+	TraceServiceName     string        `help:"Service name reported in traces" default:"fileproxy" name:"trace-service-name" env:"TRACE_SERVICE_NAME"`
+	Offline              bool          `help:"Start in offline mode: never contact upstream and serve only cached content, ignoring TTLs (toggle at runtime via /admin/offline)" name:"offline" env:"OFFLINE"`
+	SnapshotDir          string        `help:"Parent directory for cache snapshots created via POST /admin/snapshot (same filesystem as cache-dir)" name:"snapshot-dir" env:"SNAPSHOT_DIR" type:"path"`
+	EnableUpload         bool          `help:"Accept PUT uploads and push them to upstream asynchronously" name:"enable-upload" env:"ENABLE_UPLOAD"`
+	PassthroughWrites    bool          `help:"Reverse-proxy POST, PUT, PATCH and DELETE to upstream without caching and invalidate the cached path on success" name:"passthrough-writes" env:"PASSTHROUGH_WRITES"`
+	PeerListen           string        `help:"UDP address for sibling cache queries (empty to disable)" name:"peer-listen" env:"PEER_LISTEN"`
+	Peers                []string      `help:"Sibling UDP addresses to query before going upstream" name:"peer" env:"PEERS"`
+	PeerAdvertiseURL     string        `help:"HTTP URL of this node announced to siblings on hits" name:"peer-advertise-url" env:"PEER_ADVERTISE_URL"`
+	PeerTimeout          time.Duration `help:"Time to wait for sibling replies" default:"50ms" name:"peer-timeout" env:"PEER_TIMEOUT"`
+	PeerDigestInterval   time.Duration `help:"Interval for exchanging cache digests with siblings (0 to disable)" default:"5m" name:"peer-digest-interval" env:"PEER_DIGEST_INTERVAL"`
+	Debug                bool          `help:"Enable debug logging" env:"DEBUG"`
+	LogFormat            string        `help:"Log output format" default:"text" enum:"text,json" name:"log-format" env:"LOG_FORMAT"`
+	LogSamples           []string      `help:"Keep only a fraction of a log message, MESSAGE=RATE, e.g. 'cache hit=0.01'" name:"log-sample" env:"LOG_SAMPLES"`
+	LogFields            []string      `help:"Static field added to every log line (NAME=VALUE)" name:"log-field" env:"LOG_FIELDS"`
+	AccessLog            bool          `help:"Log one line per request with status, bytes, duration, cache result and request ID" name:"access-log" env:"ACCESS_LOG"`
+	AccessLogFile        string        `help:"Write the access log as JSON lines to this file instead of the main log (implies --access-log)" name:"access-log-file" env:"ACCESS_LOG_FILE" type:"path"`
+	LogRename            []string      `help:"Rename a top-level log key (FROM=TO), e.g. msg=message" name:"log-rename" env:"LOG_RENAME"`
+	ConfigVersion        int           `help:"Config version these flags were written for; renamed flags older than it are rejected (0 to accept all)" default:"0" name:"config-version" env:"CONFIG_VERSION"`
+
+	migrations []fileproxy.ConfigMigration // 啟動時自動轉換的舊名稱
 }
 
 func (c *CLI) Run() error {
 	// 初始化 slog
-	level := slog.LevelInfo
+	logOpts := fileproxy.LogOptions{
+		Level:   slog.LevelInfo,
+		Format:  c.LogFormat,
+		Samples: make(map[string]float64),
+		Fields:  make(map[string]string),
+		Rename:  make(map[string]string),
+	}
 	if c.Debug {
-		level = slog.LevelDebug
+		logOpts.Level = slog.LevelDebug
+	}
+	for _, spec := range c.LogSamples {
+		msg, rate, err := fileproxy.ParseLogSample(spec)
+		if err != nil {
+			return err
+		}
+		logOpts.Samples[msg] = rate
+	}
+	for _, spec := range c.LogFields {
+		name, value, err := fileproxy.ParseLogPair(spec)
+		if err != nil {
+			return err
+		}
+		logOpts.Fields[name] = value
+	}
+	for _, spec := range c.LogRename {
+		from, to, err := fileproxy.ParseLogPair(spec)
+		if err != nil {
+			return err
+		}
+		logOpts.Rename[from] = to
+	}
+	handler, err := fileproxy.NewLogHandler(os.Stderr, logOpts)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+
+	if err := fileproxy.CheckConfigVersion(c.ConfigVersion); err != nil {
+		return err
+	}
+	for _, m := range c.migrations {
+		if c.ConfigVersion >= m.Since {
+			return fmt.Errorf("%s was renamed to %s in config version %d", m.Old(), m.New(), m.Since)
+		}
+		if m.Ignored {
+			slog.Warn("deprecated config ignored, new name is set", "old", m.Old(), "new", m.New())
+			continue
+		}
+		slog.Warn("deprecated config name, please rename", "old", m.Old(), "new", m.New(), "since_version", m.Since)
+	}
+
+	exprRules := make([]fileproxy.ExprRule, 0, len(c.ExprRules))
+	for _, spec := range c.ExprRules {
+		rule, err := fileproxy.ParseExprRule(spec)
+		if err != nil {
+			return err
+		}
+		exprRules = append(exprRules, rule)
+	}
+
+	rateRules := make([]fileproxy.RateLimitRule, 0, len(c.RateLimitRules))
+	for _, spec := range c.RateLimitRules {
+		rule, err := fileproxy.ParseRateLimitRule(spec)
+		if err != nil {
+			return err
+		}
+		rateRules = append(rateRules, rule)
+	}
+
+	prefixLimits := make([]fileproxy.PrefixLimit, 0, len(c.PrefixFetchLimits))
+	for _, spec := range c.PrefixFetchLimits {
+		limit, err := fileproxy.ParsePrefixLimit(spec)
+		if err != nil {
+			return err
+		}
+		prefixLimits = append(prefixLimits, limit)
+	}
+
+	rules := make([]fileproxy.CacheRule, 0, len(c.CacheRules))
+	for _, spec := range c.CacheRules {
+		rule, err := fileproxy.ParseCacheRule(spec)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	routes := make([]fileproxy.Route, 0, len(c.Routes))
+	for _, spec := range c.Routes {
+		route, err := fileproxy.ParseRoute(spec)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, route)
+	}
+
+	rewrites := make([]fileproxy.Rewrite, 0, len(c.Rewrites))
+	for _, spec := range c.Rewrites {
+		rewrite, err := fileproxy.ParseRewrite(spec)
+		if err != nil {
+			return err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+
+	middleware := make([]fileproxy.Middleware, 0, len(c.Middleware))
+	for _, name := range c.Middleware {
+		middleware = append(middleware, fileproxy.Middleware{Name: name})
+	}
+
+	var apiKeys []fileproxy.APIKey
+	if c.APIKeysFile != "" {
+		var err error
+		if apiKeys, err = fileproxy.LoadAPIKeys(c.APIKeysFile); err != nil {
+			return err
+		}
+	}
+
+	var socketMode uint64
+	if c.SocketMode != "" {
+		var err error
+		if socketMode, err = strconv.ParseUint(c.SocketMode, 8, 32); err != nil {
+			return fmt.Errorf("invalid socket mode %q: %w", c.SocketMode, err)
+		}
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
 
 	cfg := &fileproxy.Config{
-		ListenAddr:          c.Listen,
-		UpstreamURL:         c.Upstream,
-		CacheDir:            c.CacheDir,
-		MaxCacheSize:        int64(c.MaxCacheGB * 1024 * 1024 * 1024),
-		DefaultCacheTTL:     c.CacheTTL,
-		NotFoundCacheTTL:    c.NotFoundTTL,
-		UpstreamTimeout:     5 * time.Minute,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		TLSCertFile:         c.TLSCert,
-		TLSKeyFile:          c.TLSKey,
+		ListenAddr:             c.Listen,
+		UpstreamURL:            c.Upstream,
+		Routes:                 routes,
+		Rewrites:               rewrites,
+		CacheDir:               c.CacheDir,
+		MaxCacheSize:           int64(c.MaxCacheGB * 1024 * 1024 * 1024),
+		EvictMinAge:            c.EvictMinAge,
+		KeepVersions:           c.KeepVersions,
+		EvictionPolicy:         c.EvictionPolicy,
+		ShadowPolicies:         c.ShadowPolicies,
+		MinFreeDiskBytes:       int64(c.MinFreeDiskGB * 1024 * 1024 * 1024),
+		MinFreeDiskPercent:     c.MinFreeDiskPercent,
+		DefaultCacheTTL:        c.CacheTTL,
+		NotFoundCacheTTL:       c.NotFoundTTL,
+		ForbiddenCacheTTL:      c.ForbiddenTTL,
+		GoneCacheTTL:           c.GoneTTL,
+		ServerErrorCacheTTL:    c.ServerErrorTTL,
+		NegativeCacheSize:      c.NegativeCacheSize,
+		NegativeCacheMemory:    int64(c.NegativeCacheMB * 1024 * 1024),
+		StaleIfErrorTTL:        c.StaleIfErrorTTL,
+		MinCacheTTL:            c.MinCacheTTL,
+		MaxCacheTTL:            c.MaxCacheTTL,
+		TextContentTTL:         c.TextContentTTL,
+		BinaryContentTTL:       c.BinaryContentTTL,
+		IgnoreNoStore:          c.IgnoreNoStore,
+		HonorImmutable:         c.HonorImmutable,
+		CacheRules:             rules,
+		CacheKeyQuery:          c.CacheKeyQuery,
+		CacheKeyStrip:          c.CacheKeyStrip,
+		KeyFoldCase:            c.KeyFoldCase,
+		KeyMergeSlashes:        c.KeyMergeSlashes,
+		KeyTrimSlash:           c.KeyTrimSlash,
+		ExprRules:              exprRules,
+		MirrorProfiles:         c.MirrorProfiles,
+		MirrorMetaTTL:          c.MirrorMetaTTL,
+		PublicURL:              c.PublicURL,
+		Metalink:               c.Metalink,
+		CacheErrorBudget:       c.CacheErrorBudget,
+		CacheErrorWindow:       c.CacheErrorWindow,
+		HotRefreshCount:        c.HotRefreshCount,
+		HotRefreshAhead:        c.HotRefreshAhead,
+		CompleteOnDisconnect:   c.CompleteOnDisconnect,
+		DisconnectMaxRemaining: c.DisconnectRemainMB << 20,
+		DisconnectMinPercent:   c.DisconnectMinPercent,
+		PurgeRefetchRate:       c.PurgeRefetchRate,
+		PurgeStaleWindow:       c.PurgeStaleWindow,
+		VerifyOnServe:          c.VerifyOnServe,
+		ScrubInterval:          c.ScrubInterval,
+		StatCacheTTL:           c.StatCacheTTL,
+		RecordProvenance:       c.RecordProvenance,
+		ExpiryReportAt:         c.ExpiryReportAt,
+		ExpiryReportWindow:     c.ExpiryReportWindow,
+		ExpiryReportDepth:      c.ExpiryReportDepth,
+		MemoryCacheSize:        int64(c.MemoryCacheMB * 1024 * 1024),
+		MemoryCacheMaxFileSize: c.MemoryCacheMaxFileKB * 1024,
+		RangeReadahead:         c.RangeReadaheadKB * 1024,
+		UpstreamTimeout:        5 * time.Minute,
+		MaxIdleConns:           100,
+		MaxIdleConnsPerHost:    10,
+		OutboundAddrs:          c.OutboundAddrs,
+		UpstreamSRV:            c.UpstreamSRV,
+		UpstreamSRVInterval:    c.UpstreamSRVInterval,
+		MaxRedirects:           c.MaxRedirects,
+		PassRedirects:          c.PassRedirects,
+		UpstreamRateHeaders:    c.UpstreamRateHeaders,
+		UpstreamRateReserve:    c.UpstreamRateReserve,
+		UpstreamRateMaxWait:    c.UpstreamRateMaxWait,
+		UpstreamProbeInterval:  c.ProbeInterval,
+		FetchResumeAttempts:    c.ResumeAttempts,
+		ParallelFetchStreams:   c.ParallelStreams,
+		ParallelFetchMinSize:   c.ParallelMinMB << 20,
+		RateLimit: fileproxy.RateLimit{
+			Rate:          c.RateLimit,
+			Burst:         c.RateBurst,
+			MaxConcurrent: c.MaxConcurrentPerIP,
+		},
+		RateLimitRules:           rateRules,
+		ScanNotFoundLimit:        c.ScanNotFoundLimit,
+		ScanWindow:               c.ScanWindow,
+		ScanBlockFor:             c.ScanBlockFor,
+		ScanTarpit:               c.ScanTarpit,
+		MaxBandwidth:             int64(c.MaxBandwidthMB * 1024 * 1024),
+		MaxConnBandwidth:         int64(c.MaxConnBandwidthMB * 1024 * 1024),
+		MaxConcurrentFetches:     c.MaxConcurrentFetches,
+		MaxFetchQueue:            c.MaxFetchQueue,
+		FetchQueueTimeout:        c.FetchQueueTimeout,
+		MaxStreamWaiters:         c.MaxStreamWaiters,
+		StreamWaitTimeout:        c.StreamWaitTimeout,
+		PendingStallTimeout:      c.PendingStallTimeout,
+		ShutdownTimeout:          c.ShutdownTimeout,
+		PIDFile:                  c.PIDFile,
+		LowPriorityPrefixes:      c.LowPriorityPrefixes,
+		PrefixFetchLimits:        prefixLimits,
+		PrefetchWorkers:          c.PrefetchWorkers,
+		MaxStreamReaders:         c.MaxStreamReaders,
+		MaxFetchers:              c.MaxFetchers,
+		MaxBackgroundJobs:        c.MaxBackgroundJobs,
+		GoroutineLeakAge:         c.GoroutineLeakAge,
+		StrictHTTP:               c.StrictHTTP,
+		MaxHeaderBytes:           c.MaxHeaderKB * 1024,
+		MaxPathLength:            c.MaxPathLength,
+		TLSCertFile:              c.TLSCert,
+		TLSKeyFile:               c.TLSKey,
+		TLSCurves:                c.TLSCurves,
+		TLSSessionTicketKeyFile:  c.TLSTicketKeys,
+		TLSDisableSessionTickets: c.TLSNoSessionTickets,
+		ListenReusePort:          c.ReusePort,
+		ListenSocketMode:         os.FileMode(socketMode),
+		ListenSocketOwner:        c.SocketOwner,
+		ListenNoDelay:            c.TCPNoDelay,
+		ListenRecvBuffer:         c.SocketRecvBufferKB * 1024,
+		ListenSendBuffer:         c.SocketSendBufferKB * 1024,
+		KeepAliveIdle:            c.TCPKeepAliveIdle,
+		KeepAliveIntvl:           c.TCPKeepAliveInterval,
+		KeepAliveCount:           c.TCPKeepAliveCount,
+		AdminToken:               c.AdminToken,
+		AdminListenAddr:          c.AdminListen,
+		APIKeys:                  apiKeys,
+		ForwardAuthorization:     c.ForwardAuth,
+		SendForwarded:            c.Forwarded,
+		Middleware:               middleware,
+		CORSOrigins:              c.CORSOrigins,
+		ReplicationPeers:         c.ReplicationPeers,
+		StandbyURL:               c.StandbyURL,
+		StandbyHotHits:           c.StandbyHotHits,
+		ArchiveDir:               c.ArchiveDir,
+		Offline:                  c.Offline,
+		SnapshotDir:              c.SnapshotDir,
+		AccessLog:                c.AccessLog,
+		AccessLogFile:            c.AccessLogFile,
+		OTLPEndpoint:             c.OTLPEndpoint,
+		OTLPInsecure:             c.OTLPInsecure,
+		TraceSampleRatio:         c.TraceSampleRatio,
+		TraceServiceName:         c.TraceServiceName,
+		EnableUpload:             c.EnableUpload,
+		PassthroughWrites:        c.PassthroughWrites,
+		PeerListenAddr:           c.PeerListen,
+		PeerAddrs:                c.Peers,
+		PeerAdvertiseURL:         c.PeerAdvertiseURL,
+		PeerTimeout:              c.PeerTimeout,
+		PeerDigestInterval:       c.PeerDigestInterval,
 	}
 
 	return fileproxy.Run(cfg)
 }
 
 func main() {
-	var cli CLI
-	ctx := kong.Parse(&cli,
+	var cli commands
+	// 舊的旗標與環境變數名稱在解析前轉為新名稱，警告在日誌初始化後輸出
+	args, migrations := fileproxy.MigrateArgs(os.Args[1:])
+	cli.Serve.migrations = append(migrations, fileproxy.MigrateEnv()...)
+	parser := kong.Must(&cli,
 		kong.Name("fileproxy"),
 		kong.Description("HTTP file proxy with caching and range support"),
 		kong.UsageOnError(),
 	)
+	ctx, err := parser.Parse(args)
+	parser.FatalIfErrorf(err)
 	if err := ctx.Run(); err != nil {
 		slog.Error("fatal", "error", err)
 		os.Exit(1)
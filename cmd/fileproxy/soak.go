@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/shared-utils/fileproxy/fileproxy"
+)
+
+// SoakCmd 以注入故障的假上游長時間驅動代理並檢查不變量
+type SoakCmd struct {
+	Duration       time.Duration `help:"How long to run" default:"1h" env:"SOAK_DURATION"`
+	Cycle          time.Duration `help:"Load period between invariant checks" default:"1m" env:"SOAK_CYCLE"`
+	Clients        int           `help:"Concurrent clients" default:"32" env:"SOAK_CLIENTS"`
+	Keys           int           `help:"Distinct objects served by the fake upstream" default:"2000" env:"SOAK_KEYS"`
+	MaxObjectMB    float64       `help:"Largest object size in MB (sizes are log-uniform, mostly small)" default:"4" name:"max-object-mb" env:"SOAK_MAX_OBJECT_MB"`
+	MaxCacheMB     float64       `help:"Cache size in MB, smaller than the objects so eviction runs constantly" default:"256" name:"max-cache-mb" env:"SOAK_MAX_CACHE_MB"`
+	FaultRate      float64       `help:"Probability that the fake upstream fails a request (0-1)" default:"0.05" env:"SOAK_FAULT_RATE"`
+	GoroutineSlack int           `help:"Goroutines tolerated above the first cycle before reporting a leak" default:"50" env:"SOAK_GOROUTINE_SLACK"`
+	DrainTimeout   time.Duration `help:"How long to wait for background fills after load stops" default:"1m" env:"SOAK_DRAIN_TIMEOUT"`
+	CacheDir       string        `help:"Cache directory (default: a temporary directory removed on exit)" env:"SOAK_CACHE_DIR" type:"path"`
+	DiagDir        string        `help:"Directory for diagnostics written on an invariant violation" default:"." env:"SOAK_DIAG_DIR" type:"path"`
+	Debug          bool          `help:"Enable debug logging" env:"DEBUG"`
+}
+
+func (c *SoakCmd) Run() error {
+	level := slog.LevelInfo
+	if c.Debug {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return fileproxy.Soak(ctx, fileproxy.SoakOptions{
+		Duration:       c.Duration,
+		Cycle:          c.Cycle,
+		Clients:        c.Clients,
+		Keys:           c.Keys,
+		MaxObjectSize:  int64(c.MaxObjectMB * 1024 * 1024),
+		MaxCacheSize:   int64(c.MaxCacheMB * 1024 * 1024),
+		FaultRate:      c.FaultRate,
+		GoroutineSlack: c.GoroutineSlack,
+		DrainTimeout:   c.DrainTimeout,
+		CacheDir:       c.CacheDir,
+		DiagDir:        c.DiagDir,
+	})
+}
@@ -0,0 +1,173 @@
+package fileproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader   = "X-Request-ID"
+	maxRequestIDBytes = 128 // 客戶端提供的請求 ID 長度上限，超過時另行產生
+)
+
+// accessLogger 每個請求寫一筆存取日誌
+//
+// 未指定檔案時寫入主日誌（訊息為 "access"，同樣受 --log-sample 等設定影響）；
+// 指定檔案時以 JSON 逐行附加，與主日誌分開以便送入流量分析。
+type accessLogger struct {
+	logger *slog.Logger
+	file   *os.File // 寫入主日誌時為 nil
+}
+
+// newAccessLogger 依配置建立存取日誌，未啟用時返回 nil
+func newAccessLogger(cfg *Config) (*accessLogger, error) {
+	if !cfg.AccessLog && cfg.AccessLogFile == "" {
+		return nil, nil
+	}
+	if cfg.AccessLogFile == "" {
+		return &accessLogger{logger: slog.Default()}, nil
+	}
+	file, err := os.OpenFile(cfg.AccessLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open access log: %w", err)
+	}
+	return &accessLogger{logger: slog.New(slog.NewJSONHandler(file, nil)), file: file}, nil
+}
+
+// Close 關閉存取日誌檔案
+func (a *accessLogger) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// accessLogKey 最外層存取日誌狀態的 context key
+type accessLogKey struct{}
+
+// accessLogState 最外層存取日誌交給中介層鏈的狀態
+type accessLogState struct {
+	logger  *accessLogger
+	id      string
+	claimed bool // 已由鏈中的 logging 中介層記錄，最外層不再記錄
+}
+
+// middleware 在最外層產生請求 ID 並記錄請求
+//
+// 代理請求改由中介層鏈中的 logging 在其位置記錄，最外層只記錄管理端點與請求驗證拒絕的請求。
+func (a *accessLogger) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &accessLogState{logger: a, id: requestID(r)}
+		w.Header().Set(requestIDHeader, state.id)
+		r = r.WithContext(context.WithValue(r.Context(), accessLogKey{}, state))
+		a.serve(next, w, r, state, false)
+	})
+}
+
+// accessLogMiddleware 中介層鏈中的 logging：在鏈中的位置記錄代理請求，
+// 放在 auth 之後時不記錄被拒絕的請求，時間也只涵蓋之後的處理
+func (p *Proxy) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, _ := r.Context().Value(accessLogKey{}).(*accessLogState)
+		if state == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		state.claimed = true
+		state.logger.serve(next, w, r, state, true)
+	})
+}
+
+// serve 執行 next 並寫一筆存取日誌；最外層（inner 為 false）在請求已由鏈中記錄時略過
+func (a *accessLogger) serve(next http.Handler, w http.ResponseWriter, r *http.Request, state *accessLogState, inner bool) {
+	start := time.Now()
+	aw := &accessWriter{ResponseWriter: w}
+	defer func() {
+		if !inner && state.claimed {
+			return
+		}
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		a.logger.LogAttrs(r.Context(), slog.LevelInfo, "access",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("bytes", aw.written),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("cache", aw.Header().Get("X-Cache")),
+			slog.String("client_ip", ip),
+			slog.String("request_id", state.id),
+		)
+	}()
+	next.ServeHTTP(aw, r)
+}
+
+// requestID 沿用客戶端提供的請求 ID，沒有或不合法時產生新的
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDBytes && !strings.ContainsFunc(id, isControlRune) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// accessWriter 記錄回應狀態碼與寫出的位元組數
+type accessWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (a *accessWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessWriter) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.written += int64(n)
+	return n, err
+}
+
+// ReadFrom 保留底層回應的 sendfile 路徑
+func (a *accessWriter) ReadFrom(r io.Reader) (int64, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := io.Copy(a.ResponseWriter, r)
+	a.written += n
+	return n, err
+}
+
+// Flush 轉交給底層回應，保持串流即時送出
+func (a *accessWriter) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 取得底層回應
+func (a *accessWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
@@ -0,0 +1,530 @@
+// Package adminclient 提供 fileproxy 管理與統計 API 的 Go 客戶端
+//
+// 客戶端自帶請求與回應型別，不依賴 fileproxy 套件本身；
+// 連線錯誤、429 與 5xx 會依 Retry-After 或指數退避重試。
+package adminclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryWait  = 200 * time.Millisecond
+	maxRetryWait      = 10 * time.Second
+	maxErrorBody      = 4096 // 錯誤訊息最多保留的回應位元組
+)
+
+// Options 客戶端選項
+type Options struct {
+	Token      string        // 管理 Token，以 Bearer 送出
+	HTTPClient *http.Client  // 為 nil 時使用 30 秒逾時的預設客戶端
+	MaxRetries int           // 失敗後的重試次數，0 使用預設值 3，負數停用重試
+	RetryWait  time.Duration // 首次重試的等待時間，之後每次倍增，0 使用預設值 200ms
+}
+
+// Client fileproxy 管理 API 客戶端，可安全地並發使用
+type Client struct {
+	base    *url.URL
+	token   string
+	http    *http.Client
+	retries int
+	wait    time.Duration
+}
+
+// New 建立指向 baseURL（例如 https://proxy:8080）的客戶端
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: expected http(s)://host", baseURL)
+	}
+
+	c := &Client{
+		base:    base,
+		token:   opts.Token,
+		http:    opts.HTTPClient,
+		retries: opts.MaxRetries,
+		wait:    opts.RetryWait,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.retries == 0 {
+		c.retries = defaultMaxRetries
+	}
+	if c.wait <= 0 {
+		c.wait = defaultRetryWait
+	}
+	return c, nil
+}
+
+// StatusError 伺服器返回的非成功狀態
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.StatusCode,
+		http.StatusText(e.StatusCode), strings.TrimSpace(e.Body))
+}
+
+// retryable 判斷狀態碼是否值得重試
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// do 送出請求並將 JSON 回應解碼到 out，body 不為 nil 時以 JSON 編碼送出，失敗時依策略重試
+//
+// 只用於冪等的請求，重試不會造成重複的副作用。
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	target := u.String()
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, lastErr = c.attempt(ctx, method, target, payload, out)
+		if lastErr == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= c.retries || ctx.Err() != nil {
+			return lastErr
+		}
+
+		wait := retryAfter
+		if wait == 0 {
+			wait = min(c.wait<<attempt, maxRetryWait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return lastErr
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt 送出單次請求，返回建議的重試等待與錯誤
+//
+// 等待為負數表示錯誤不可重試，0 表示使用退避時間。
+func (c *Client) attempt(ctx context.Context, method, target string, payload []byte, out any) (time.Duration, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return -1, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// send 加上驗證頭送出請求並解碼回應，返回建議的重試等待與錯誤
+func (c *Client) send(req *http.Request, out any) (time.Duration, error) {
+	method, target := req.Method, req.URL.String()
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		statusErr := &StatusError{Method: method, URL: target, StatusCode: resp.StatusCode, Body: string(body)}
+		if !retryable(resp.StatusCode) {
+			return -1, statusErr
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			return min(time.Duration(secs)*time.Second, maxRetryWait), statusErr
+		}
+		return 0, statusErr
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return 0, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("decode %s response: %w", target, err)
+	}
+	return 0, nil
+}
+
+// Health 檢查代理是否存活
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/health", nil, nil, nil)
+}
+
+// statsQuery 要求版本 2 的分節統計
+var statsQuery = url.Values{"version": {"2"}}
+
+// Stats 取得快取與各元件的統計資訊
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/stats", statsQuery, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Purge 清除單一路徑的快取，下一個請求會重新回源
+func (c *Client) Purge(ctx context.Context, path string) (*PurgeResult, error) {
+	return c.purge(ctx, path, nil)
+}
+
+// PurgeRefetch 清除單一路徑並在背景重新下載，完成前舊內容以 STALE 回應
+func (c *Client) PurgeRefetch(ctx context.Context, path string) (*PurgeResult, error) {
+	return c.purge(ctx, path, refetchQuery)
+}
+
+// refetchQuery 清除端點的 refetch 選項
+var refetchQuery = url.Values{"refetch": {"1"}}
+
+func (c *Client) purge(ctx context.Context, path string, query url.Values) (*PurgeResult, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("purge path %q must start with / and not be the root", path)
+	}
+	var result PurgeResult
+	if err := c.do(ctx, http.MethodDelete, "/admin/purge"+path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Invalidate 依上游變更通知清除、重新下載或重新驗證多個路徑與標籤
+func (c *Client) Invalidate(ctx context.Context, req InvalidateRequest) (*InvalidateResult, error) {
+	var result InvalidateResult
+	if err := c.do(ctx, http.MethodPost, "/admin/invalidate", nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Provenance 取得單一路徑最近一次下載的來源記錄
+func (c *Client) Provenance(ctx context.Context, path string) (*Provenance, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("provenance path %q must start with / and not be the root", path)
+	}
+	var result Provenance
+	if err := c.do(ctx, http.MethodGet, "/admin/provenance"+path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PutObject 不經上游直接將內容寫入快取，size 為 -1 時不檢查大小
+//
+// 本體以串流送出，失敗時不重試。
+func (c *Client) PutObject(ctx context.Context, path string, body io.Reader, size int64, opts ObjectOptions) (*EntryInfo, error) {
+	if !strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return nil, fmt.Errorf("object path %q must start with / and not end with /", path)
+	}
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/admin/objects" + path
+	query := url.Values{"tag": opts.Tags}
+	switch {
+	case opts.Immutable:
+		query.Set("ttl", "immutable")
+	case opts.TTL > 0:
+		query.Set("ttl", opts.TTL.String())
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if opts.ContentType != "" {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+	var info EntryInfo
+	if _, err := c.send(req, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// DeleteObject 刪除快取內容，等同 Purge
+func (c *Client) DeleteObject(ctx context.Context, path string) (*PurgeResult, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("object path %q must start with / and not be the root", path)
+	}
+	var result PurgeResult
+	if err := c.do(ctx, http.MethodDelete, "/admin/objects"+path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Tags 取得各標籤的條目數與大小
+func (c *Client) Tags(ctx context.Context) ([]TagInfo, error) {
+	var tags []TagInfo
+	if err := c.do(ctx, http.MethodGet, "/admin/tags", nil, nil, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// PurgeTag 清除帶有標籤的所有條目並解除標籤的釘選
+func (c *Client) PurgeTag(ctx context.Context, tag string) (*TagResult, error) {
+	return c.tagAction(ctx, http.MethodDelete, tag, "", nil)
+}
+
+// PurgeTagRefetch 清除帶有標籤的條目並在背景錯開重新下載，完成前舊內容以 STALE 回應
+func (c *Client) PurgeTagRefetch(ctx context.Context, tag string) (*TagResult, error) {
+	return c.tagAction(ctx, http.MethodDelete, tag, "", refetchQuery)
+}
+
+// PinTag 釘選目前帶有標籤的條目，之後才加上標籤的條目不受影響
+func (c *Client) PinTag(ctx context.Context, tag string) (*TagResult, error) {
+	return c.tagAction(ctx, http.MethodPut, tag, "/pin", nil)
+}
+
+// UnpinTag 解除標籤的釘選
+func (c *Client) UnpinTag(ctx context.Context, tag string) (*TagResult, error) {
+	return c.tagAction(ctx, http.MethodDelete, tag, "/pin", nil)
+}
+
+func (c *Client) tagAction(ctx context.Context, method, tag, suffix string, query url.Values) (*TagResult, error) {
+	if tag == "" || strings.ContainsAny(tag, "/?#") {
+		return nil, fmt.Errorf("invalid tag %q", tag)
+	}
+	var result TagResult
+	if err := c.do(ctx, method, "/admin/tags/"+tag+suffix, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Quotas 列出有額度或限速的 API Key 與目前期間的用量
+func (c *Client) Quotas(ctx context.Context) ([]QuotaUsage, error) {
+	var quotas []QuotaUsage
+	if err := c.do(ctx, http.MethodGet, "/admin/quotas", nil, nil, &quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// Quota 查詢單一 API Key 的用量
+func (c *Client) Quota(ctx context.Context, name string) (*QuotaUsage, error) {
+	return c.quotaAction(ctx, http.MethodGet, name)
+}
+
+// ResetQuota 將 API Key 目前期間的日、月用量歸零
+func (c *Client) ResetQuota(ctx context.Context, name string) (*QuotaUsage, error) {
+	return c.quotaAction(ctx, http.MethodDelete, name)
+}
+
+func (c *Client) quotaAction(ctx context.Context, method, name string) (*QuotaUsage, error) {
+	if name == "" || strings.ContainsAny(name, "/?#") {
+		return nil, fmt.Errorf("invalid api key name %q", name)
+	}
+	var usage QuotaUsage
+	if err := c.do(ctx, method, "/admin/quotas/"+name, nil, nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// Offline 查詢離線模式
+func (c *Client) Offline(ctx context.Context) (*OfflineStatus, error) {
+	return c.offlineAction(ctx, http.MethodGet)
+}
+
+// SetOffline 進入或離開離線模式；離線期間代理不連線上游，只以快取回應
+func (c *Client) SetOffline(ctx context.Context, offline bool) (*OfflineStatus, error) {
+	if offline {
+		return c.offlineAction(ctx, http.MethodPut)
+	}
+	return c.offlineAction(ctx, http.MethodDelete)
+}
+
+func (c *Client) offlineAction(ctx context.Context, method string) (*OfflineStatus, error) {
+	var status OfflineStatus
+	if err := c.do(ctx, method, "/admin/offline", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ExpiryReport 取得即將過期條目的報表，window 與 depth 為 0 時使用伺服器設定
+func (c *Client) ExpiryReport(ctx context.Context, window time.Duration, depth int) (*ExpiryReport, error) {
+	query := url.Values{}
+	if window > 0 {
+		query.Set("window", window.String())
+	}
+	if depth > 0 {
+		query.Set("depth", strconv.Itoa(depth))
+	}
+	var report ExpiryReport
+	if err := c.do(ctx, http.MethodGet, "/admin/expiry-report", query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Entries 分頁列出快取條目，以 NextCursor 取得下一頁
+func (c *Client) Entries(ctx context.Context, q EntryQuery) (*EntryList, error) {
+	query := url.Values{}
+	if q.Prefix != "" {
+		query.Set("prefix", q.Prefix)
+	}
+	if q.Tag != "" {
+		query.Set("tag", q.Tag)
+	}
+	if q.Sort != "" {
+		query.Set("sort", q.Sort)
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		query.Set("cursor", q.Cursor)
+	}
+	var list EntryList
+	if err := c.do(ctx, http.MethodGet, "/admin/cache/entries", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Uploads 取得寫後上傳任務狀態，key 不為空時只返回該路徑的任務
+func (c *Client) Uploads(ctx context.Context, key string) ([]Upload, error) {
+	query := url.Values{}
+	if key != "" {
+		query.Set("key", key)
+	}
+	var uploads []Upload
+	if err := c.do(ctx, http.MethodGet, "/admin/uploads", query, nil, &uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// Snapshot 以 name 為目錄名稱建立快取快照
+//
+// 名稱由呼叫端指定，連線中斷後的重試不會重複建立快照，而是得到 409 狀態。
+func (c *Client) Snapshot(ctx context.Context, name string) (*SnapshotResult, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid snapshot name %q", name)
+	}
+	var result SnapshotResult
+	query := url.Values{"name": {name}}
+	if err := c.do(ctx, http.MethodPost, "/admin/snapshot", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Prefetch 提交預取任務，在背景將 paths 下載到代理的快取
+//
+// 重試可能建立重複的任務，但已快取的路徑會被略過，不產生額外的上游流量。
+func (c *Client) Prefetch(ctx context.Context, paths []string) (*PrefetchJob, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("prefetch requires at least one path")
+	}
+	return c.submitPrefetch(ctx, map[string]any{"paths": paths})
+}
+
+// PrefetchAtomic 提交交易式預取任務：paths 全部下載成功才一併寫入快取並釘選，否則不寫入任何路徑
+//
+// 任務結束後以 Committed 判斷結果，不再需要時以 ReleasePrefetch 解除釘選。
+func (c *Client) PrefetchAtomic(ctx context.Context, paths []string) (*PrefetchJob, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("prefetch requires at least one path")
+	}
+	return c.submitPrefetch(ctx, map[string]any{"paths": paths, "atomic": true})
+}
+
+// PrefetchWithOptions 以選項提交預取任務，例如為下載的條目加上標籤
+func (c *Client) PrefetchWithOptions(ctx context.Context, paths []string, opts PrefetchOptions) (*PrefetchJob, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("prefetch requires at least one path")
+	}
+	return c.submitPrefetch(ctx, map[string]any{"paths": paths, "atomic": opts.Atomic, "tags": opts.Tags})
+}
+
+// PrefetchManifest 提交預取任務，由代理下載 manifestURL 取得路徑清單
+func (c *Client) PrefetchManifest(ctx context.Context, manifestURL string) (*PrefetchJob, error) {
+	if manifestURL == "" {
+		return nil, fmt.Errorf("prefetch manifest url is empty")
+	}
+	return c.submitPrefetch(ctx, map[string]any{"manifest": manifestURL})
+}
+
+func (c *Client) submitPrefetch(ctx context.Context, body any) (*PrefetchJob, error) {
+	var job PrefetchJob
+	if err := c.do(ctx, http.MethodPost, "/admin/prefetch", nil, body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// PrefetchStatus 取得預取任務的進度
+func (c *Client) PrefetchStatus(ctx context.Context, id string) (*PrefetchJob, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, fmt.Errorf("invalid prefetch job id %q", id)
+	}
+	var job PrefetchJob
+	if err := c.do(ctx, http.MethodGet, "/admin/prefetch/"+id, nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ReleasePrefetch 解除交易式預取任務的釘選，條目恢復依淘汰策略與過期時間回收
+func (c *Client) ReleasePrefetch(ctx context.Context, id string) (*PrefetchJob, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, fmt.Errorf("invalid prefetch job id %q", id)
+	}
+	var job PrefetchJob
+	if err := c.do(ctx, http.MethodDelete, "/admin/prefetch/"+id, nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// PrefetchJobs 取得進行中與最近結束的預取任務，新的在前
+func (c *Client) PrefetchJobs(ctx context.Context) ([]PrefetchJob, error) {
+	var jobs []PrefetchJob
+	if err := c.do(ctx, http.MethodGet, "/admin/prefetch", nil, nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,286 @@
+package adminclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Stats /stats?version=2 的回應
+type Stats struct {
+	Version  int           `json:"version"`
+	Cache    CacheStats    `json:"cache"`
+	Disk     DiskStats     `json:"disk"`
+	HTTP     RequestStats  `json:"http"`
+	Upstream UpstreamStats `json:"upstream"`
+
+	// Sections 其他元件的統計（scheduler、memory、peers、rate_limit 等），
+	// 以元件名稱索引；未啟用的元件不會出現
+	Sections map[string]json.RawMessage `json:"components"`
+}
+
+// CacheStats 快取條目與容量
+type CacheStats struct {
+	FileEntries     int     `json:"file_entries"`
+	NegativeEntries int     `json:"negative_entries"`
+	HeadEntries     int     `json:"head_entries"`
+	TotalSize       int64   `json:"total_size"`
+	MaxSize         int64   `json:"max_size"`
+	UsagePercent    float64 `json:"usage_percent"`
+	Pending         int     `json:"pending"`
+	Pinned          int     `json:"pinned"`
+	Corrupted       int64   `json:"corrupted"`
+	Failures        int64   `json:"failures"` // 快取子系統的累計錯誤數
+	EvictBlocked    int64   `json:"evict_blocked"`
+	VersionsKept    int64   `json:"versions_kept"`   // 內容改變時保留為舊版本的條目數
+	PendingStalled  int64   `json:"pending_stalled"` // 沒有進度而中止的下載
+}
+
+// DiskStats 快取所在檔案系統
+type DiskStats struct {
+	Free            int64 `json:"free"`     // 最近一次檢查的剩餘空間，未啟用水位時為 0
+	MinFree         int64 `json:"min_free"` // 剩餘空間水位，0 表示停用
+	Evictions       int64 `json:"evictions"`
+	DeferredDeletes int64 `json:"deferred_deletes"`
+}
+
+// UpstreamStats 上游能力與離線狀態
+type UpstreamStats struct {
+	Offline       bool                    `json:"offline"`
+	OfflineSince  time.Time               `json:"offline_since"`
+	OfflineMisses int64                   `json:"offline_misses"`
+	ProbeInterval string                  `json:"probe_interval"`
+	Probes        int64                   `json:"probes"`
+	ProbeFailures int64                   `json:"probe_failures"`
+	Resumed       int64                   `json:"resumed"`
+	Parallel      int64                   `json:"parallel"`
+	Origins       map[string]UpstreamCaps `json:"origins"` // 依來源（scheme://host）
+}
+
+// UpstreamCaps 單一上游來源的能力
+type UpstreamCaps struct {
+	HTTP2    bool      `json:"http2"`
+	Ranges   bool      `json:"ranges"`
+	ProbedAt time.Time `json:"probed_at"`
+	Error    string    `json:"error"`
+}
+
+// Section 將指定元件的統計解碼到 out，元件未啟用時返回 false
+func (s *Stats) Section(name string, out any) (bool, error) {
+	raw, ok := s.Sections[name]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, out)
+}
+
+// RequestStats 請求計數
+type RequestStats struct {
+	Hits         int64   `json:"hits"`
+	Misses       int64   `json:"misses"`
+	Streaming    int64   `json:"streaming"`
+	NotFound     int64   `json:"not_found"`
+	Negative     int64   `json:"negative"`
+	Stale        int64   `json:"stale"`
+	Revalidated  int64   `json:"revalidated"`
+	Detached     int64   `json:"detached"`
+	Abandoned    int64   `json:"abandoned"`
+	WaitRejected int64   `json:"wait_rejected"` // 同一下載的讀取者已達上限而拒絕
+	WaitTimeouts int64   `json:"wait_timeouts"` // 等待的下載沒有進度而逾時
+	Versions     int64   `json:"versions"`      // 以 ?version= 提供的舊版本
+	Errors       int64   `json:"errors"`
+	HitRatio     float64 `json:"hit_ratio"`
+}
+
+// PurgeResult 清除請求的結果
+type PurgeResult struct {
+	Key    string `json:"key"`
+	Purged bool   `json:"purged"` // false 表示原本就沒有快取
+
+	Refetching bool `json:"refetching,omitempty"` // 舊內容暫時以 STALE 回應，直到背景重新下載完成
+}
+
+// InvalidateRequest /admin/invalidate 的請求本體
+type InvalidateRequest struct {
+	Paths  []string `json:"paths,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Action string   `json:"action,omitempty"` // purge（預設）、refetch 或 revalidate
+}
+
+// InvalidatedPath 單一路徑的失效結果
+type InvalidatedPath struct {
+	Key    string `json:"key"`
+	Cached bool   `json:"cached"` // false 表示原本就沒有快取
+	Result string `json:"result"` // purged、refetching、revalidated（未變更）、refreshed（已更新）或 failed
+	Error  string `json:"error,omitempty"`
+}
+
+// InvalidateResult /admin/invalidate 的回應
+type InvalidateResult struct {
+	Action string            `json:"action"`
+	Paths  []InvalidatedPath `json:"paths"`
+	Tags   []TagResult       `json:"tags,omitempty"`
+}
+
+// Provenance /admin/provenance 的回應，條目下載時的來源記錄
+type Provenance struct {
+	Key        string      `json:"key"`
+	URL        string      `json:"url"`
+	FinalURL   string      `json:"final_url,omitempty"` // 跟隨重新導向後的 URL
+	Source     string      `json:"source"`              // upstream 或 peer
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"`
+	FetchedAt  time.Time   `json:"fetched_at"`
+	Size       int64       `json:"size"`
+	SHA256     string      `json:"sha256,omitempty"`
+	Cached     bool        `json:"cached"`  // 條目目前仍在快取中
+	Current    bool        `json:"current"` // 記錄的內容雜湊與目前的條目相符
+}
+
+// ExpiryGroup 同一前綴下即將過期的條目
+type ExpiryGroup struct {
+	Prefix      string    `json:"prefix"`
+	Entries     int       `json:"entries"`
+	Bytes       int64     `json:"bytes"`
+	FirstExpiry time.Time `json:"first_expiry"`
+}
+
+// ExpiryReport 即將過期條目的報表，依位元組數由大到小排序
+type ExpiryReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Window      string        `json:"window"`
+	Depth       int           `json:"depth"`
+	Entries     int           `json:"entries"`
+	Bytes       int64         `json:"bytes"`
+	Groups      []ExpiryGroup `json:"groups"`
+}
+
+// EntryInfo 單一快取條目
+type EntryInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	Age         string    `json:"age"`
+	Hits        int64     `json:"hits"`
+	ExpiresAt   time.Time `json:"expires_at"` // 不可變條目為零值
+	Immutable   bool      `json:"immutable"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+// ObjectOptions PutObject 的選項
+type ObjectOptions struct {
+	ContentType string        // 為空時為 application/octet-stream
+	TTL         time.Duration // 固定存活時間，0 表示使用預設的滑動過期
+	Immutable   bool          // 永不過期，優先於 TTL
+	Tags        []string
+}
+
+// EntryList 條目列表的一頁
+type EntryList struct {
+	Entries    []EntryInfo `json:"entries"`
+	Total      int         `json:"total"`                 // 符合篩選條件的條目數
+	NextCursor string      `json:"next_cursor,omitempty"` // 為空表示已是最後一頁
+}
+
+// EntryQuery 條目列表的篩選與分頁參數
+type EntryQuery struct {
+	Prefix string // 只列出 key 以此開頭的條目
+	Tag    string // 只列出帶有此標籤的條目
+	Sort   string // key（預設）、size、hits、age 或 expiry
+	Limit  int    // 每頁筆數，0 表示伺服器預設（100），上限 1000
+	Cursor string // 上一頁的 NextCursor
+}
+
+// PrefetchOptions 預取任務的選項
+type PrefetchOptions struct {
+	Atomic bool     // 全部下載成功才一併寫入快取並釘選
+	Tags   []string // 為下載的條目加上的標籤
+}
+
+// TagInfo 單一標籤的統計
+type TagInfo struct {
+	Tag     string `json:"tag"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	Pinned  bool   `json:"pinned"`
+}
+
+// TagResult 標籤操作的結果
+type TagResult struct {
+	Tag     string `json:"tag"`
+	Entries int    `json:"entries"` // 清除或釘選的條目數
+	Bytes   int64  `json:"bytes"`
+
+	Refetching int `json:"refetching,omitempty"` // 以 refetch 選項清除時排入重新下載的條目數
+}
+
+// OfflineStatus /admin/offline 的回應
+type OfflineStatus struct {
+	Offline bool      `json:"offline"`
+	Since   time.Time `json:"since"`  // 進入離線模式的時間，未離線時為零值
+	Misses  int64     `json:"misses"` // 離線期間未快取而返回 504 的請求數（累計）
+}
+
+// QuotaUsage /admin/quotas 的單一 API Key 額度與用量，期間以 UTC 計算
+type QuotaUsage struct {
+	Name         string `json:"name"`
+	Rate         int64  `json:"rate"` // 每秒位元組數，0 表示不限
+	DailyQuota   int64  `json:"daily_quota"`
+	DailyBytes   int64  `json:"daily_bytes"`
+	MonthlyQuota int64  `json:"monthly_quota"`
+	MonthlyBytes int64  `json:"monthly_bytes"`
+	OverQuota    bool   `json:"over_quota"`
+}
+
+// Upload 寫後上傳任務
+type Upload struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SnapshotResult 快照建立結果
+type SnapshotResult struct {
+	Dir      string `json:"dir"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	Skipped  int    `json:"skipped"` // 建立期間被淘汰或替換的條目
+	Duration string `json:"duration"`
+}
+
+// PrefetchJob 預取任務與進度
+type PrefetchJob struct {
+	ID         string          `json:"id"`
+	Source     string          `json:"source"` // 清單 URL，直接提交路徑時為 "paths"
+	State      string          `json:"state"`  // queued、running 或 done
+	Total      int             `json:"total"`
+	Completed  int             `json:"completed"`
+	Cached     int             `json:"cached"`  // 已在快取中，未回源
+	Fetched    int             `json:"fetched"` // 下載到快取
+	Failed     int             `json:"failed"`
+	Bytes      int64           `json:"bytes"`
+	Errors     []PrefetchError `json:"errors,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Atomic     bool            `json:"atomic,omitempty"`
+	Committed  bool            `json:"committed,omitempty"` // 交易式任務的所有路徑已寫入快取
+	Pinned     bool            `json:"pinned,omitempty"`    // 交易式任務的路徑仍被釘選
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  time.Time       `json:"started_at,omitzero"`
+	FinishedAt time.Time       `json:"finished_at,omitzero"`
+}
+
+// Done 任務是否已結束
+func (j *PrefetchJob) Done() bool { return j.State == "done" }
+
+// PrefetchError 單一路徑的失敗原因
+type PrefetchError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
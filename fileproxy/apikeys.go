@@ -0,0 +1,181 @@
+package fileproxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// APIKey 客戶端 API Key 與允許存取的路徑前綴
+type APIKey struct {
+	Name     string   // 名稱，用於日誌，不含密鑰本身
+	Key      string   // 密鑰
+	Prefixes []string // 允許的路徑前綴，為空時允許所有路徑
+
+	Rate         int64 // 此 Key 所有下載共用的每秒位元組數，0 表示不限
+	DailyQuota   int64 // 每日（UTC）下載位元組上限，0 表示不限
+	MonthlyQuota int64 // 每月（UTC）下載位元組上限，0 表示不限
+}
+
+// allows 檢查 key 是否在允許的前綴內
+func (k *APIKey) allows(key string) bool {
+	if len(k.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range k.Prefixes {
+		if prefix == "/" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAPIKeys 讀取 API Key 檔案
+//
+// 每行格式為 NAME KEY [PREFIX...] [rate=SIZE] [daily=SIZE] [monthly=SIZE]，以空白分隔，
+// SIZE 接受 KB、MB、GB、TB 後綴；空行與 # 開頭的行會被忽略。
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open api keys: %w", err)
+	}
+	defer file.Close()
+
+	var keys []APIKey
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("api keys line %d: expected NAME KEY [PREFIX...]", line)
+		}
+		key := APIKey{Name: fields[0], Key: fields[1]}
+		for _, prefix := range fields[2:] {
+			if option, value, ok := strings.Cut(prefix, "="); ok && !strings.HasPrefix(prefix, "/") {
+				size, err := parseByteSize(value)
+				switch {
+				case err != nil:
+					return nil, fmt.Errorf("api keys line %d: %s: %w", line, option, err)
+				case option == "rate":
+					key.Rate = size
+				case option == "daily":
+					key.DailyQuota = size
+				case option == "monthly":
+					key.MonthlyQuota = size
+				default:
+					return nil, fmt.Errorf("api keys line %d: unknown option %q", line, option)
+				}
+				continue
+			}
+			if !strings.HasPrefix(prefix, "/") {
+				return nil, fmt.Errorf("api keys line %d: prefix %q must start with /", line, prefix)
+			}
+			if prefix != "/" {
+				prefix = strings.TrimSuffix(prefix, "/")
+			}
+			key.Prefixes = append(key.Prefixes, prefix)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// keyring 驗證代理請求的 API Key
+//
+// 以密鑰的 SHA-256 查表，比對時間與密鑰內容無關。
+type keyring struct {
+	keys  map[[sha256.Size]byte]*APIKey
+	admin [sha256.Size]byte // 管理 Token 可存取所有路徑（兄弟節點之間的請求使用）
+
+	forwardAuth bool
+
+	// 有額度或限速的 Key 的用量，定期保存在快取目錄，見 quota.go
+	meteredKeys []*APIKey
+	usage       map[string]*keyUsage // 依 Key 名稱
+	usagePath   string
+	closeCh     chan struct{}
+	wg          sync.WaitGroup
+
+	allowed      atomic.Int64
+	unauthorized atomic.Int64
+	forbidden    atomic.Int64
+	overQuota    atomic.Int64
+	metered      atomic.Int64 // 計量 Key 的累計下載位元組數
+}
+
+// newKeyring 建立 keyring，未設定任何 API Key 時返回 nil（停用驗證）
+func newKeyring(cfg *Config) *keyring {
+	if len(cfg.APIKeys) == 0 {
+		return nil
+	}
+	kr := &keyring{
+		keys:        make(map[[sha256.Size]byte]*APIKey, len(cfg.APIKeys)),
+		forwardAuth: cfg.ForwardAuthorization,
+	}
+	for i := range cfg.APIKeys {
+		kr.keys[sha256.Sum256([]byte(cfg.APIKeys[i].Key))] = &cfg.APIKeys[i]
+	}
+	if cfg.AdminToken != "" {
+		kr.admin = sha256.Sum256([]byte(cfg.AdminToken))
+	}
+	kr.newQuotaState(cfg)
+	return kr
+}
+
+// requestKey 取得請求攜帶的密鑰：Authorization: Bearer 或 X-API-Key
+//
+// 轉發 Authorization 到上游時，該頭屬於上游憑證，只接受 X-API-Key。
+func (kr *keyring) requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" || kr.forwardAuth {
+		return key
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// authorize 驗證請求，返回 0 表示通過，否則為應返回的狀態碼；管理 Token 通過時 apiKey 為 nil
+func (kr *keyring) authorize(r *http.Request, key string) (*APIKey, int) {
+	secret := kr.requestKey(r)
+	if secret == "" {
+		kr.unauthorized.Add(1)
+		return nil, http.StatusUnauthorized
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if sum == kr.admin && kr.admin != ([sha256.Size]byte{}) {
+		kr.allowed.Add(1)
+		return nil, 0
+	}
+	apiKey, ok := kr.keys[sum]
+	if !ok {
+		kr.unauthorized.Add(1)
+		return nil, http.StatusUnauthorized
+	}
+	if !apiKey.allows(key) {
+		kr.forbidden.Add(1)
+		return apiKey, http.StatusForbidden
+	}
+	kr.allowed.Add(1)
+	return apiKey, 0
+}
+
+// Stats 返回驗證統計資訊
+func (kr *keyring) Stats() map[string]any {
+	return map[string]any{
+		"keys":         len(kr.keys),
+		"allowed":      kr.allowed.Load(),
+		"unauthorized": kr.unauthorized.Load(),
+		"forbidden":    kr.forbidden.Load(),
+		"over_quota":   kr.overQuota.Load(),
+		"metered":      kr.metered.Load(),
+	}
+}
@@ -0,0 +1,270 @@
+package fileproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	archiveQueue     = 1024     // 待歸檔佇列長度
+	archiveIndexName = "_index" // 以 / 結尾的 key 在歸檔目錄中的檔名
+)
+
+// archiver 將下載完成的物件保存到只增不刪的本機歸檔目錄
+//
+// 歸檔與快取分開，不參與淘汰，隨時間累積成上游的永久鏡像。檔案以 key 的路徑存放，
+// 同一目錄所在的檔案系統允許時以硬連結共用快取檔案，不額外佔用空間與磁碟 IO。
+// 已存在的檔案不會被覆寫，內容改變時另存為帶時間戳的新版本。
+type archiver struct {
+	dir   string
+	cache *Cache
+	queue chan string
+
+	linked   atomic.Int64
+	cloned   atomic.Int64
+	copied   atomic.Int64
+	versions atomic.Int64
+	existing atomic.Int64
+	failed   atomic.Int64
+	dropped  atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newArchiver 建立歸檔器並啟動 worker
+func newArchiver(cfg *Config, cache *Cache) (*archiver, error) {
+	if err := os.MkdirAll(cfg.ArchiveDir, 0755); err != nil {
+		return nil, fmt.Errorf("create archive directory: %w", err)
+	}
+	a := &archiver{
+		dir:     cfg.ArchiveDir,
+		cache:   cache,
+		queue:   make(chan string, archiveQueue),
+		closeCh: make(chan struct{}),
+	}
+	a.wg.Add(1)
+	go a.worker()
+	return a, nil
+}
+
+// Close 停止 worker，未歸檔的項目會被丟棄，下次下載時再歸檔
+func (a *archiver) Close() {
+	close(a.closeCh)
+	a.wg.Wait()
+}
+
+// Enqueue 排入待歸檔的 key，佇列已滿時丟棄
+func (a *archiver) Enqueue(key string) {
+	select {
+	case a.queue <- key:
+	case <-a.closeCh:
+	default:
+		a.dropped.Add(1)
+		slog.Warn("archive queue full, dropped", "key", key)
+	}
+}
+
+// worker 從佇列取出 key 並歸檔
+func (a *archiver) worker() {
+	defer a.wg.Done()
+	for {
+		select {
+		case <-a.closeCh:
+			return
+		case key := <-a.queue:
+			if err := a.archive(key); err != nil {
+				a.failed.Add(1)
+				slog.Warn("archive failed", "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// archivePath 將 key 對應到歸檔目錄中的路徑，無法安全對應時返回 false
+func (a *archiver) archivePath(key string) (string, bool) {
+	if !strings.HasPrefix(key, "/") || isPrivateKey(key) {
+		return "", false
+	}
+	clean := path.Clean(key)
+	if clean == "/" || strings.HasSuffix(key, "/") {
+		clean = path.Join(clean, archiveIndexName)
+	}
+	return filepath.Join(a.dir, filepath.FromSlash(clean)), true
+}
+
+// archive 保存單一快取條目
+func (a *archiver) archive(key string) error {
+	target, ok := a.archivePath(key)
+	if !ok {
+		return nil
+	}
+	entry, ok := a.cache.Peek(key)
+	if !ok {
+		return nil // 歸檔前已被淘汰
+	}
+	file, release, err := a.cache.Open(context.Background(), entry)
+	if errors.Is(err, errEntryEvicted) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open cache file: %w", err)
+	}
+	defer release()
+
+	versions, err := archivedVersions(target)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		same, err := sameContent(version, entry)
+		if err != nil {
+			return err
+		}
+		if same {
+			a.existing.Add(1)
+			return nil
+		}
+	}
+	if len(versions) > 0 {
+		target = target + "." + strconv.FormatInt(time.Now().Unix(), 10)
+		a.versions.Add(1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("create archive directory: %w", err)
+	}
+	tmp := target + partFileSuffix
+	os.Remove(tmp)
+
+	if a.link(file, tmp) {
+		a.linked.Add(1)
+	} else if cloneToFile(file, tmp) == nil {
+		a.cloned.Add(1)
+	} else {
+		if err := copyToFile(file, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		a.copied.Add(1)
+	}
+
+	// 以 Link 提交，目標已存在時失敗，不覆寫既有歸檔
+	err = os.Link(tmp, target)
+	os.Remove(tmp)
+	if errors.Is(err, fs.ErrExist) {
+		a.existing.Add(1)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("commit archive file: %w", err)
+	}
+	slog.Debug("object archived", "key", key, "path", target)
+	return nil
+}
+
+// archivedVersions 返回 target 與其帶時間戳的版本中已存在的檔案
+func archivedVersions(target string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(target))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(target)
+	var versions []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		if name != base {
+			stamp, ok := strings.CutPrefix(name, base+".")
+			if _, err := strconv.ParseInt(stamp, 10, 64); !ok || err != nil {
+				continue
+			}
+		}
+		versions = append(versions, filepath.Join(filepath.Dir(target), name))
+	}
+	return versions, nil
+}
+
+// link 以硬連結共用快取檔案，確認連結到的正是已開啟的檔案
+func (a *archiver) link(file *os.File, tmp string) bool {
+	if err := os.Link(file.Name(), tmp); err != nil {
+		return false
+	}
+	opened, err1 := file.Stat()
+	linked, err2 := os.Stat(tmp)
+	if err1 != nil || err2 != nil || !os.SameFile(opened, linked) {
+		os.Remove(tmp) // 快取檔案已被替換
+		return false
+	}
+	return true
+}
+
+// copyToFile 將內容複製到新檔案
+func copyToFile(r io.Reader, dst string) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create archive file: %w", err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("copy archive file: %w", err)
+	}
+	return out.Close()
+}
+
+// sameContent 比對歸檔檔案與快取條目的大小與 SHA-256
+func sameContent(path string, entry *CacheEntry) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() != entry.Size {
+		return false, err
+	}
+	if entry.sum == (keyHash{}) {
+		return true, nil // 舊版索引沒有校驗和，大小相同即視為相同
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	var sum keyHash
+	h.Sum(sum[:0])
+	return sum == entry.sum, nil
+}
+
+// Stats 返回歸檔統計資訊
+func (a *archiver) Stats() map[string]any {
+	return map[string]any{
+		"dir":      a.dir,
+		"queued":   len(a.queue),
+		"linked":   a.linked.Load(),
+		"cloned":   a.cloned.Load(),
+		"copied":   a.copied.Load(),
+		"versions": a.versions.Load(),
+		"existing": a.existing.Load(),
+		"failed":   a.failed.Load(),
+		"dropped":  a.dropped.Load(),
+	}
+}
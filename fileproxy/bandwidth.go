@@ -0,0 +1,129 @@
+package fileproxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const minBandwidthBurst = 64 * 1024 // 令牌桶最小容量，至少容納一個複製區塊
+
+// byteBucket 以位元組計的令牌桶，允許預支後等待補回
+type byteBucket struct {
+	rate  float64 // 每秒補充的位元組數
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newByteBucket 建立令牌桶，rate <= 0 時返回 nil 表示不限制
+func newByteBucket(rate int64) *byteBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(float64(rate)/4, minBandwidthBurst)
+	return &byteBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve 扣除 n 個位元組，返回需要等待的時間
+func (b *byteBucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// bandwidthLimiter 限制寫給客戶端的下載速度
+//
+// 全域令牌桶由所有連線共用，避免少數大量下載佔滿網卡；
+// 每個回應另有自己的令牌桶，單一連線無法獨佔全域頻寬。
+type bandwidthLimiter struct {
+	global  *byteBucket
+	perConn int64
+
+	throttled atomic.Int64
+	waited    atomic.Int64 // 累計等待時間（毫秒）
+}
+
+// newBandwidthLimiter 建立頻寬限制器，未設定任何上限時返回 nil
+func newBandwidthLimiter(cfg *Config) *bandwidthLimiter {
+	if cfg.MaxBandwidth <= 0 && cfg.MaxConnBandwidth <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{
+		global:  newByteBucket(cfg.MaxBandwidth),
+		perConn: cfg.MaxConnBandwidth,
+	}
+}
+
+// Writer 包裝回應，每次寫入前依令牌桶等待
+func (bl *bandwidthLimiter) Writer(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	if bl == nil {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, bl: bl, conn: newByteBucket(bl.perConn)}
+}
+
+// Stats 返回頻寬限制統計資訊
+func (bl *bandwidthLimiter) Stats() map[string]any {
+	var global int64
+	if bl.global != nil {
+		global = int64(bl.global.rate)
+	}
+	return map[string]any{
+		"max_bytes_per_sec":      global,
+		"max_conn_bytes_per_sec": bl.perConn,
+		"throttled_writes":       bl.throttled.Load(),
+		"waited_ms":              bl.waited.Load(),
+	}
+}
+
+// throttledWriter 限速的回應寫入者
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	bl   *bandwidthLimiter
+	conn *byteBucket
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	wait := max(t.bl.global.reserve(len(p)), t.conn.reserve(len(p)))
+	if wait > 0 {
+		t.bl.throttled.Add(1)
+		t.bl.waited.Add(wait.Milliseconds())
+		timer := time.NewTimer(wait)
+		select {
+		case <-t.ctx.Done():
+			timer.Stop()
+			return 0, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+// Flush 轉交給底層回應，保持串流即時送出
+func (t *throttledWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 取得底層回應
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -1,47 +1,163 @@
 package fileproxy
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unique"
 
 	"github.com/hashicorp/golang-lru/v2/expirable"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const indexFileName = "index.json"
+const (
+	partFileSuffix    = ".part"          // 下載中檔案的後綴，完成後改名為正式檔案
+	retiredFileSuffix = ".retired-"      // 淘汰時仍有讀取者的檔案改名後綴，重啟時視為孤兒清除
+	sweepInterval     = 30 * time.Second // 過期條目回收間隔
+	sweepBatch        = 10000            // 每次回收最多檢查的條目數
+)
+
+var (
+	// errPendingExists 表示該 key 已有進行中的下載
+	errPendingExists = errors.New("download already in progress")
+	// errEntryEvicted 表示條目在開啟檔案前已被淘汰
+	errEntryEvicted = errors.New("cache entry evicted")
+	// errDownloadAborted 表示下載失敗或被放棄
+	errDownloadAborted = errors.New("download aborted")
+)
+
+// keyHash 快取 key 的 SHA-256，作為索引鍵並推導檔案路徑
+type keyHash [sha256.Size]byte
+
+func hashKey(key string) keyHash {
+	return sha256.Sum256([]byte(key))
+}
 
 // CacheEntry 快取條目
+//
+// 為支援千萬級條目，記憶體中不保存 key 與路徑字串：索引以 key 的雜湊為鍵，
+// 檔案路徑由雜湊推導，內容類型經過驅留（interning）共用。
 type CacheEntry struct {
-	Key         string    `json:"key"`
-	FilePath    string    `json:"file_path"`
-	Size        int64     `json:"size"`
-	ContentType string    `json:"content_type"`
-	CreatedAt   time.Time `json:"created_at"`
+	hash        keyHash
+	Size        int64
+	contentType unique.Handle[string]
+	createdAt   int64         // 建立時間（UnixNano）
+	TTL         time.Duration // 上游指定的固定存活時間，0 表示使用預設的滑動過期，immutableTTL 表示永不過期
+	sum         keyHash       // 檔案內容的 SHA-256，全零表示未知（舊版索引）
+	validators  *validators   // 上游驗證器，僅需重新驗證或啟用熱門刷新時保存
+
+	tags unique.Handle[string] // 以逗號分隔並排序的標籤，經過驅留共用，沒有標籤時為零值
+
+	expiresAt  atomic.Int64 // 過期時間（UnixNano），過期後僅供 stale-if-error 使用
+	hits       atomic.Int64 // 近期命中次數，由熱門條目刷新定期衰減
+	served     atomic.Int64 // 累計命中次數，移除時記錄到壽命指標
+	checkedAt  atomic.Int64 // 上次確認檔案存在且大小相符的時間（UnixNano）
+	prev, next *CacheEntry  // LRU 鏈結，由 entryIndex 管理
+	heapIdx    int32        // 在淘汰堆中的位置，以下三個欄位由 entryIndex 在鎖內管理
+	freq       uint32       // 命中次數，LFU 與 GDSF 使用
+	prio       float64      // 淘汰優先值，越小越先淘汰
+}
+
+// validators 上游回應的驗證器，用於條件請求
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// newValidators 由儲存的欄位建立驗證器，皆為空時返回 nil
+func newValidators(etag, lastModified string) *validators {
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+	return &validators{etag: etag, lastModified: lastModified}
+}
+
+// ContentType 返回內容類型
+func (e *CacheEntry) ContentType() string { return e.contentType.Value() }
+
+// CreatedAt 返回建立時間
+func (e *CacheEntry) CreatedAt() time.Time { return time.Unix(0, e.createdAt) }
+
+// fresh 檢查條目是否仍在 TTL 內
+func (e *CacheEntry) fresh(now time.Time) bool {
+	return now.UnixNano() < e.expiresAt.Load()
+}
+
+// immutable 條目是否不可變：不因存活時間過期，也不重新驗證
+func (e *CacheEntry) immutable() bool { return e.TTL == immutableTTL }
+
+// touch 以 ttl 延長過期時間
+func (e *CacheEntry) touch(ttl time.Duration) {
+	e.expiresAt.Store(time.Now().Add(ttl).UnixNano())
 }
 
-// cacheIndex 快取索引（用於持久化）
-type cacheIndex struct {
-	Entries []*CacheEntry `json:"entries"`
+// refresh 更新過期時間：固定 TTL 從建立時間起算，否則以預設 TTL 滑動延長
+func (c *Cache) refresh(e *CacheEntry) {
+	if e.immutable() {
+		e.expiresAt.Store(math.MaxInt64)
+		return
+	}
+	if e.TTL > 0 {
+		e.expiresAt.Store(e.createdAt + int64(e.TTL))
+		return
+	}
+	e.touch(c.config.DefaultCacheTTL)
+}
+
+// negativeEntry 負快取條目，記錄上游返回的錯誤狀態；404 與 410 命中時以 ttl 刷新過期時間
+type negativeEntry struct {
+	status    int
+	expiresAt time.Time
+	ttl       time.Duration
 }
 
 // Cache 檔案快取系統
 type Cache struct {
 	config        *Config
-	fileCache     *expirable.LRU[string, *CacheEntry]
-	notFoundCache *expirable.LRU[string, struct{}]
+	fileCache     *entryIndex
+	store         *indexStore
+	negativeCache *negativeLRU
+	headCache     *expirable.LRU[string, headEntry] // 只以 HEAD 請求過的路徑，依條目的 expiresAt 過期
 	totalSize     atomic.Int64
+	corrupted     atomic.Int64
+	failures      atomic.Int64 // 快取檔案建立、寫入、提交與開啟失敗的次數
+	diskFree      atomic.Int64 // 最近一次檢查的檔案系統剩餘空間，未啟用水位時為 0
+	diskEvictions atomic.Int64 // 因剩餘空間低於水位而淘汰的條目數
+	evictBlocked  atomic.Int64 // 超出容量上限但只剩未滿 EvictMinAge 或被釘選的條目而停止淘汰的次數
+	versionsKept  atomic.Int64 // 內容改變時保留為舊版本的條目數
+	stalled       atomic.Int64 // 看門狗中止的停滯下載數
+	filling       atomic.Int64 // 尚未提交或放棄的下載數，關閉時等待歸零
+	tagPins       tagPins
+	lifetimes     *lifetimeMetrics
+	shadows       *shadowPolicies // 影子策略分析，未啟用時為 nil
 
 	pending   map[string]*StreamingFile
 	pendingMu sync.RWMutex
 
+	readersMu  sync.Mutex
+	readers    map[*CacheEntry]int    // 開啟中的讀取者數
+	retired    map[*CacheEntry]string // 淘汰時仍有讀取者的條目，最後一個讀取者釋放後刪除
+	retiredSeq int
+	deferred   atomic.Int64
+
+	snapshotMu sync.Mutex // 同一時間只建立一個快照
+
 	closeCh chan struct{}
 	wg      sync.WaitGroup
 }
@@ -52,38 +168,57 @@ func NewCache(cfg *Config) (*Cache, error) {
 		return nil, fmt.Errorf("create cache directory: %w", err)
 	}
 
+	store, err := openIndexStore(filepath.Join(cfg.CacheDir, storeFileName), cfg.IndexLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Cache{
 		config:  cfg,
+		store:   store,
 		pending: make(map[string]*StreamingFile),
+		readers: make(map[*CacheEntry]int),
+		retired: make(map[*CacheEntry]string),
+		tagPins: tagPins{hashes: make(map[string][]keyHash)},
 		closeCh: make(chan struct{}),
 	}
 
-	c.fileCache = expirable.NewLRU[string, *CacheEntry](
-		0,
-		func(key string, entry *CacheEntry) {
-			if entry != nil && entry.FilePath != "" {
-				os.Remove(entry.FilePath)
-			}
-			if entry != nil {
-				c.totalSize.Add(-entry.Size)
-				slog.Debug("cache evicted", "key", key, "size", entry.Size)
-			}
-		},
-		cfg.DefaultCacheTTL,
-	)
+	c.lifetimes = newLifetimeMetrics()
+	c.fileCache = newEntryIndex(cfg.EvictionPolicy, func(entry *CacheEntry, reason string) {
+		c.lifetimes.observe(entry, reason, cfg.DefaultCacheTTL)
+		c.store.Delete(entry.hash)
+		c.retire(entry)
+		c.totalSize.Add(-entry.Size)
+		slog.Debug("cache evicted", "hash", hex.EncodeToString(entry.hash[:]), "size", entry.Size, "reason", reason)
+	})
 
-	c.notFoundCache = expirable.NewLRU[string, struct{}](
-		10000,
-		nil,
-		cfg.NotFoundCacheTTL,
-	)
+	c.negativeCache = newNegativeLRU(cfg.NegativeCacheSize, cfg.NegativeCacheMemory)
+	c.shadows = newShadowPolicies(cfg)
+	c.headCache = expirable.NewLRU[string, headEntry](10000, nil, 0)
 
 	if err := c.loadAndCleanup(); err != nil {
 		slog.Warn("load cache index failed", "error", err)
 	}
 
-	c.wg.Add(1)
-	go c.saveLoop()
+	c.wg.Add(2)
+	go c.flushLoop()
+	go c.sweepLoop()
+	if cfg.ScrubInterval > 0 {
+		c.wg.Add(1)
+		go c.scrubLoop()
+	}
+	if cfg.ExpiryReportAt != "" {
+		c.wg.Add(1)
+		go c.reportLoop()
+	}
+	if cfg.MinFreeDiskBytes > 0 || cfg.MinFreeDiskPercent > 0 {
+		c.wg.Add(1)
+		go c.diskWatchLoop()
+	}
+	if cfg.PendingStallTimeout > 0 {
+		c.wg.Add(1)
+		go c.pendingWatchLoop()
+	}
 
 	return c, nil
 }
@@ -92,45 +227,128 @@ func NewCache(cfg *Config) (*Cache, error) {
 func (c *Cache) Close() {
 	close(c.closeCh)
 	c.wg.Wait()
-	if err := c.saveIndex(); err != nil {
-		slog.Warn("save cache index failed", "error", err)
+	if err := c.store.Close(); err != nil {
+		slog.Warn("close index store failed", "error", err)
 	}
 }
 
 // loadAndCleanup 載入快取索引並清理孤立檔案
 func (c *Cache) loadAndCleanup() error {
-	// 載入索引
-	indexPath := filepath.Join(c.config.CacheDir, indexFileName)
-	validFiles := make(map[string]bool)
-
-	data, err := os.ReadFile(indexPath)
-	if err == nil {
-		var idx cacheIndex
-		if err := json.Unmarshal(data, &idx); err == nil {
-			loaded := 0
-			for _, entry := range idx.Entries {
-				info, err := os.Stat(entry.FilePath)
-				if err != nil || info.Size() != entry.Size {
-					os.Remove(entry.FilePath)
-					continue
-				}
-				c.fileCache.Add(entry.Key, entry)
-				c.totalSize.Add(entry.Size)
-				validFiles[entry.FilePath] = true
-				loaded++
-			}
-			slog.Info("cache index loaded", "entries", loaded)
+	if err := migrateLegacyIndex(c.config.CacheDir, c.store); err != nil {
+		slog.Warn("migrate legacy index failed", "error", err)
+	}
+
+	var stored []storedEntry
+	if err := c.store.Load(func(se storedEntry) { stored = append(stored, se) }); err != nil {
+		return err
+	}
+
+	// 依存取時間由舊到新加入，恢復 LRU 順序
+	sort.Slice(stored, func(i, j int) bool { return stored[i].accessedAt < stored[j].accessedAt })
+
+	validFiles := make(map[keyHash]struct{}, len(stored))
+	for i := range stored {
+		se := &stored[i]
+		path := c.pathFor(se.hash)
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != se.size {
+			os.Remove(path)
+			c.store.Delete(se.hash)
+			continue
 		}
+		entry := c.storedCacheEntry(se)
+		c.fileCache.Add(entry)
+		c.totalSize.Add(entry.Size)
+		c.shadows.seed(entry.hash, entry.Size)
+		validFiles[se.hash] = struct{}{}
+	}
+	slog.Info("cache index loaded", "entries", len(validFiles))
+
+	// 熱備節點的內容檔案可能在停機期間由外部同步到位，檔案完整的記錄併入索引
+	var adopted []storeOp
+	err := c.store.LoadStandby(func(se storedEntry) {
+		if _, ok := validFiles[se.hash]; ok {
+			return
+		}
+		if info, err := os.Stat(c.pathFor(se.hash)); err != nil || info.Size() != se.size {
+			return
+		}
+		entry := c.storedCacheEntry(&se)
+		c.fileCache.Add(entry)
+		c.store.Put(entry, se.key)
+		c.totalSize.Add(entry.Size)
+		validFiles[se.hash] = struct{}{}
+		adopted = append(adopted, storeOp{hash: se.hash})
+	})
+	if err != nil {
+		slog.Warn("load standby index failed", "error", err)
+	}
+	if len(adopted) > 0 {
+		if err := c.store.ApplyStandby(adopted, false); err != nil {
+			slog.Warn("clear adopted standby entries failed", "error", err)
+		}
+		slog.Info("standby entries adopted", "entries", len(adopted))
 	}
 
 	// 掃描並清理孤立檔案
 	return c.cleanupOrphanFiles(validFiles)
 }
 
+// storedCacheEntry 以索引記錄建立快取條目
+func (c *Cache) storedCacheEntry(se *storedEntry) *CacheEntry {
+	entry := &CacheEntry{
+		hash:        se.hash,
+		Size:        se.size,
+		contentType: unique.Make(se.contentType),
+		createdAt:   se.createdAt,
+		TTL:         se.ttl,
+		sum:         se.sum,
+		validators:  newValidators(se.etag, se.lastModified),
+		tags:        internTags(se.tags, nil),
+	}
+	c.refresh(entry)
+	return entry
+}
+
+// adoptStandby 以主節點的條目記錄更新熱備節點的索引，返回記錄是否已併入索引
+//
+// 本機已有相同內容時改用主節點的建立時間、存活時間、驗證器與標籤；本機沒有條目但內容檔案完整時加入索引。
+// 本機內容與記錄不符或沒有內容檔案時返回 false。
+func (c *Cache) adoptStandby(se *storedEntry) bool {
+	entry := c.storedCacheEntry(se)
+	if old, ok := c.fileCache.Peek(se.hash); ok {
+		if old.Size != se.size || (se.sum != keyHash{} && old.sum != keyHash{} && old.sum != se.sum) {
+			return false
+		}
+		entry.hits.Store(old.hits.Load())
+		entry.served.Store(old.served.Load())
+		if !c.fileCache.Replace(old, entry) {
+			return false
+		}
+		c.store.Put(entry, se.key)
+		return true
+	}
+	if _, pending := c.GetPending(se.key); pending {
+		return false
+	}
+	if info, err := os.Stat(c.pathFor(se.hash)); err != nil || info.Size() != se.size {
+		return false
+	}
+	c.evictIfNeeded(se.size)
+	if !c.fileCache.AddIfAbsent(entry) {
+		return false
+	}
+	c.store.Put(entry, se.key)
+	c.totalSize.Add(se.size)
+	return true
+}
+
 // cleanupOrphanFiles 清理不在快取清單中的檔案
-func (c *Cache) cleanupOrphanFiles(validFiles map[string]bool) error {
-	indexPath := filepath.Join(c.config.CacheDir, indexFileName)
-	tmpIndexPath := indexPath + ".tmp"
+func (c *Cache) cleanupOrphanFiles(validFiles map[keyHash]struct{}) error {
+	storePath := filepath.Join(c.config.CacheDir, storeFileName)
+	saltPath := filepath.Join(c.config.CacheDir, authSaltFile)
+	quotaPath := filepath.Join(c.config.CacheDir, quotaUsageFile)
+	spoolDir := filepath.Join(c.config.CacheDir, uploadSpoolDir)
 	removed := 0
 
 	err := filepath.WalkDir(c.config.CacheDir, func(path string, d os.DirEntry, err error) error {
@@ -138,14 +356,20 @@ func (c *Cache) cleanupOrphanFiles(validFiles map[string]bool) error {
 			return nil // 忽略錯誤繼續掃描
 		}
 		if d.IsDir() {
+			if path == spoolDir {
+				return filepath.SkipDir // 待上傳檔案由 uploader 管理
+			}
 			return nil
 		}
-		// 跳過索引檔案
-		if path == indexPath || path == tmpIndexPath {
+		// 跳過索引庫、憑證雜湊密鑰與 API Key 用量
+		if path == storePath || path == saltPath || path == quotaPath {
 			return nil
 		}
-		// 檢查是否為有效快取檔案
-		if !validFiles[path] {
+		// 檢查是否為有效快取檔案（檔名為 key 雜湊）
+		var hash keyHash
+		n, err := hex.Decode(hash[:], []byte(d.Name()))
+		_, valid := validFiles[hash]
+		if err != nil || n != len(hash) || !valid {
 			os.Remove(path)
 			removed++
 		}
@@ -180,40 +404,28 @@ func (c *Cache) cleanupEmptyDirs() {
 	}
 }
 
-// saveIndex 保存快取索引
-func (c *Cache) saveIndex() error {
-	keys := c.fileCache.Keys()
-	idx := cacheIndex{Entries: make([]*CacheEntry, 0, len(keys))}
+// flushLoop 定期寫回存取時間
+func (c *Cache) flushLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
 
-	for _, key := range keys {
-		if entry, ok := c.fileCache.Peek(key); ok {
-			idx.Entries = append(idx.Entries, entry)
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			if err := c.store.FlushAccess(); err != nil {
+				slog.Warn("flush access times failed", "error", err)
+			}
 		}
 	}
-
-	data, err := json.MarshalIndent(idx, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshal index: %w", err)
-	}
-
-	indexPath := filepath.Join(c.config.CacheDir, indexFileName)
-	tmpPath := indexPath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("write index: %w", err)
-	}
-	if err := os.Rename(tmpPath, indexPath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("rename index: %w", err)
-	}
-
-	slog.Debug("cache index saved", "entries", len(idx.Entries))
-	return nil
 }
 
-// saveLoop 定期保存索引
-func (c *Cache) saveLoop() {
+// sweepLoop 定期回收過期條目（保留 StaleIfErrorTTL 供 stale-if-error 使用）
+func (c *Cache) sweepLoop() {
 	defer c.wg.Done()
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(sweepInterval)
 	defer ticker.Stop()
 
 	for {
@@ -221,44 +433,275 @@ func (c *Cache) saveLoop() {
 		case <-c.closeCh:
 			return
 		case <-ticker.C:
-			if err := c.saveIndex(); err != nil {
-				slog.Warn("save cache index failed", "error", err)
+			now := time.Now()
+			if n := c.fileCache.sweep(now, c.config.StaleIfErrorTTL, sweepBatch); n > 0 {
+				slog.Debug("expired entries swept", "count", n)
 			}
+			c.negativeCache.sweep(now, sweepBatch)
 		}
 	}
 }
 
 // filePath 產生檔案路徑
 func (c *Cache) filePath(key string) string {
-	hash := sha256.Sum256([]byte(key))
+	return c.pathFor(hashKey(key))
+}
+
+// pathFor 由 key 雜湊推導檔案路徑
+func (c *Cache) pathFor(hash keyHash) string {
 	hashStr := hex.EncodeToString(hash[:])
 	return filepath.Join(c.config.CacheDir, hashStr[:2], hashStr)
 }
 
-// Get 取得快取條目
-func (c *Cache) Get(key string) (*CacheEntry, bool) {
-	if _, ok := c.notFoundCache.Get(key); ok {
-		c.notFoundCache.Add(key, struct{}{}) // 刷新 TTL
-		return nil, false                    // 返回 false 表示是 404 快取
+// FilePath 返回條目的快取檔案路徑
+func (c *Cache) FilePath(entry *CacheEntry) string {
+	return c.pathFor(entry.hash)
+}
+
+// Open 開啟條目的快取檔案並登記為讀取者，讀取結束後須呼叫返回的 release
+//
+// 讀取期間條目被淘汰或被新下載替換時，舊檔案改名保留到最後一個讀取者釋放，
+// 傳輸途中不會遇到檔案被刪除或內容被覆蓋。條目已被淘汰時返回 errEntryEvicted，ctx 已結束時返回 ctx 的錯誤。
+func (c *Cache) Open(ctx context.Context, entry *CacheEntry) (*os.File, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	_, span := startSpan(ctx, "fileproxy.cache.open", attribute.Int64("fileproxy.cache.size", entry.Size))
+	defer span.End()
+	file, release, err := c.open(entry)
+	if err != nil {
+		spanError(span, err)
+	}
+	return file, release, err
+}
+
+// open 開啟條目的快取檔案並登記為讀取者
+func (c *Cache) open(entry *CacheEntry) (*os.File, func(), error) {
+	// 先登記再確認條目仍在索引中，之後的淘汰一定會看到這個讀取者
+	c.readersMu.Lock()
+	c.readers[entry]++
+	c.readersMu.Unlock()
+
+	if current, ok := c.fileCache.Peek(entry.hash); !ok || current != entry {
+		c.release(entry)
+		return nil, nil, errEntryEvicted
+	}
+
+	file, err := os.Open(c.pathFor(entry.hash))
+
+	// 確認與開啟之間被淘汰時，路徑上可能已是新檔案，改開保留的舊檔案
+	c.readersMu.Lock()
+	retired, ok := c.retired[entry]
+	c.readersMu.Unlock()
+	if ok {
+		if err == nil {
+			file.Close()
+		}
+		file, err = os.Open(retired)
+	}
+	if err != nil {
+		c.release(entry)
+		return nil, nil, err
 	}
-	if entry, ok := c.fileCache.Get(key); ok {
-		c.fileCache.Add(key, entry) // 刷新 TTL
+
+	return file, sync.OnceFunc(func() {
+		file.Close()
+		c.release(entry)
+	}), nil
+}
+
+// release 釋放讀取者，條目已淘汰且沒有其他讀取者時刪除保留的檔案
+func (c *Cache) release(entry *CacheEntry) {
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	if c.readers[entry]--; c.readers[entry] > 0 {
+		return
+	}
+	delete(c.readers, entry)
+	if path, ok := c.retired[entry]; ok {
+		delete(c.retired, entry)
+		os.Remove(path)
+	}
+}
+
+// retire 刪除被淘汰條目的檔案，仍有讀取者時先改名，待讀取者釋放後刪除
+func (c *Cache) retire(entry *CacheEntry) {
+	path := c.pathFor(entry.hash)
+
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	if c.readers[entry] == 0 {
+		os.Remove(path)
+		return
+	}
+	c.retiredSeq++
+	retired := path + retiredFileSuffix + strconv.Itoa(c.retiredSeq)
+	if err := os.Rename(path, retired); err != nil {
+		slog.Warn("retire cache file failed", "path", path, "error", err)
+		os.Remove(path)
+		return
+	}
+	c.retired[entry] = retired
+	c.deferred.Add(1)
+}
+
+// Get 取得快取條目，命中時在 ctx 的 span 記錄條目大小
+func (c *Cache) Get(ctx context.Context, key string) (*CacheEntry, bool) {
+	if _, ok := c.Negative(key); ok {
+		return nil, false // 返回 false 表示是負快取
+	}
+	hash := hashKey(key)
+	now := time.Now()
+	if entry, ok := c.fileCache.Peek(hash); ok && entry.fresh(now) {
+		entry.hits.Add(1)
+		entry.served.Add(1)
+		c.refresh(entry)
+		c.fileCache.Get(hash) // 移到 LRU 最新端
+		c.store.Touch(hash, now)
+		c.shadows.observe(key, hash, entry.Size, shadowHit)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("fileproxy.cache.size", entry.Size))
 		return entry, true
 	}
 	return nil, false
 }
 
-// IsNotFound 檢查是否為 404 快取
-func (c *Cache) IsNotFound(key string) bool {
-	if _, ok := c.notFoundCache.Get(key); ok {
-		c.notFoundCache.Add(key, struct{}{})
-		return true
+// Renew 上游確認內容未變更時以新的存活時間重建條目，沿用同一個檔案
+//
+// 固定 TTL 由建立時間起算，因此以新的建立時間取代原條目；條目已被淘汰或替換時返回 false。
+func (c *Cache) Renew(key string, old *CacheEntry, ttl time.Duration) (*CacheEntry, bool) {
+	entry := &CacheEntry{
+		hash:        old.hash,
+		Size:        old.Size,
+		contentType: old.contentType,
+		createdAt:   time.Now().UnixNano(),
+		TTL:         ttl,
+		sum:         old.sum,
+		validators:  old.validators,
+		tags:        old.tags,
+	}
+	entry.hits.Store(old.hits.Load())
+	entry.served.Store(old.served.Load())
+	c.refresh(entry)
+	if !c.fileCache.Replace(old, entry) {
+		return nil, false
+	}
+	c.store.Put(entry, key)
+	return entry, true
+}
+
+// Peek 取得快取條目但不刷新 TTL，可能返回已過期的條目
+func (c *Cache) Peek(key string) (*CacheEntry, bool) {
+	return c.fileCache.Peek(hashKey(key))
+}
+
+// hasFresh 檢查雜湊對應的條目是否存在且未過期，不更新 LRU 順序
+func (c *Cache) hasFresh(hash keyHash) bool {
+	entry, ok := c.fileCache.Peek(hash)
+	return ok && entry.fresh(time.Now())
+}
+
+// GetStale 取得已過期但仍保留的條目，用於上游故障時回退
+func (c *Cache) GetStale(key string) (*CacheEntry, bool) {
+	entry, ok := c.fileCache.Peek(hashKey(key))
+	if !ok || entry.fresh(time.Now()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Put 直接寫入快取條目，size 為 -1 時不檢查大小，ttl 為 0 時使用預設的滑動過期
+//
+// ctx 結束時停止寫入並返回 ctx 的錯誤，不留下條目。
+func (c *Cache) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string, ttl time.Duration, tags []string) (*CacheEntry, error) {
+	ctx, span := startSpan(ctx, "fileproxy.cache.put")
+	defer span.End()
+	entry, err := c.put(ctx, key, r, size, contentType, ttl, tags)
+	if err != nil {
+		spanError(span, err)
+	}
+	return entry, err
+}
+
+func (c *Cache) put(ctx context.Context, key string, r io.Reader, size int64, contentType string, ttl time.Duration, tags []string) (*CacheEntry, error) {
+	if _, ok := c.GetPending(key); ok {
+		return nil, errPendingExists
+	}
+	c.negativeCache.Remove(key)
+
+	sf, isNew, err := c.GetOrCreatePending(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return nil, errPendingExists
+	}
+
+	n, err := io.Copy(sf, contextReader{ctx: ctx, r: r})
+	if err != nil {
+		c.FailPending(key, sf)
+		return nil, fmt.Errorf("write cache file: %w", err)
+	}
+	if size >= 0 && n != size {
+		c.FailPending(key, sf)
+		return nil, fmt.Errorf("size mismatch: expected %d, got %d", size, n)
+	}
+
+	entry := c.CompletePending(ctx, key, sf, n, contentType, ttl, tags)
+	if entry == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errPendingExists
 	}
-	return false
+	return entry, nil
 }
 
-// GetOrCreatePending 取得或建立待下載的串流檔案
-func (c *Cache) GetOrCreatePending(key string) (*StreamingFile, bool, error) {
+// contextReader ctx 結束後讀取返回 ctx 的錯誤
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// Pin 釘選 key，條目不會被容量淘汰、磁碟水位淘汰或過期回收，直到對應的 Unpin
+func (c *Cache) Pin(key string) { c.fileCache.Pin(hashKey(key)) }
+
+// Unpin 解除一次 Pin
+func (c *Cache) Unpin(key string) { c.fileCache.Unpin(hashKey(key)) }
+
+// Negative 返回負快取的上游狀態碼
+//
+// 404 與 410 表示內容不存在，命中時刷新 TTL；403 與 5xx 可能很快恢復，到期後一定重新回源。
+func (c *Cache) Negative(key string) (int, bool) {
+	ne, ok := c.negativeCache.Get(key)
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+	if !now.Before(ne.expiresAt) {
+		c.negativeCache.Remove(key)
+		return 0, false
+	}
+	if ne.status == http.StatusNotFound || ne.status == http.StatusGone {
+		ne.expiresAt = now.Add(ne.ttl) // 刷新 TTL
+		c.negativeCache.Add(key, ne)
+	}
+	return ne.status, true
+}
+
+// GetOrCreatePending 取得或建立待下載的串流檔案，ctx 已結束時返回 ctx 的錯誤
+func (c *Cache) GetOrCreatePending(ctx context.Context, key string) (*StreamingFile, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
 	c.pendingMu.Lock()
 	defer c.pendingMu.Unlock()
 
@@ -266,16 +709,23 @@ func (c *Cache) GetOrCreatePending(key string) (*StreamingFile, bool, error) {
 		return sf, false, nil
 	}
 
+	_, span := startSpan(ctx, "fileproxy.cache.create")
+	defer span.End()
 	filePath := c.filePath(key)
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		return nil, false, fmt.Errorf("create cache subdirectory: %w", err)
+		err = fmt.Errorf("create cache subdirectory: %w", err)
+		spanError(span, err)
+		return nil, false, err
 	}
 
 	sf, err := NewStreamingFile(filePath)
 	if err != nil {
+		spanError(span, err)
 		return nil, false, err
 	}
 
+	c.filling.Add(1)
+	sf.settle = sync.OnceFunc(func() { c.filling.Add(-1) })
 	c.pending[key] = sf
 	return sf, true, nil
 }
@@ -288,81 +738,152 @@ func (c *Cache) GetPending(key string) (*StreamingFile, bool) {
 	return sf, ok
 }
 
-// CompletePending 完成下載
-func (c *Cache) CompletePending(key string, size int64, contentType string) {
+// CompletePending 完成下載並返回新的快取條目，ttl 為 0 時使用預設的滑動過期
+//
+// tags 與被取代的舊條目的標籤合併，重新下載不會遺失先前加上的標籤。sf 已不是 key 進行中的下載
+// （例如被看門狗中止）或 ctx 已結束時放棄寫入並返回 nil。
+func (c *Cache) CompletePending(ctx context.Context, key string, sf *StreamingFile, size int64, contentType string, ttl time.Duration, tags []string) *CacheEntry {
+	if ctx.Err() != nil {
+		c.FailPending(key, sf)
+		return nil
+	}
+	defer sf.settle()
 	c.pendingMu.Lock()
-	sf, ok := c.pending[key]
+	ok := c.pending[key] == sf
 	if ok {
 		delete(c.pending, key)
 	}
 	c.pendingMu.Unlock()
 
 	if !ok {
-		return
+		return nil
 	}
 
-	sf.Complete()
+	_, span := startSpan(ctx, "fileproxy.cache.commit", attribute.Int64("fileproxy.cache.size", size))
+	defer span.End()
+
+	// 先移除舊條目（例如過期後重新下載），避免淘汰回調刪除新檔案
+	hash := hashKey(key)
+	var existing string
+	observed := shadowMiss
+	if old, ok := c.fileCache.Peek(hash); ok {
+		existing = old.tagList()
+		observed = shadowRefresh
+		c.keepVersion(key, old, sf.Sum())
+	}
+	c.fileCache.RemoveAs(hash, removeReplaced)
+	if err := sf.Complete(); err != nil {
+		c.failures.Add(1)
+		spanError(span, err)
+		slog.Warn("commit cache file failed", "key", key, "error", err)
+		return nil
+	}
 	c.evictIfNeeded(size)
 
 	entry := &CacheEntry{
-		Key:         key,
-		FilePath:    c.filePath(key),
+		hash:        hash,
 		Size:        size,
-		ContentType: contentType,
-		CreatedAt:   time.Now(),
+		contentType: unique.Make(contentType),
+		createdAt:   time.Now().UnixNano(),
+		TTL:         ttl,
+		sum:         sf.Sum(),
+		validators:  sf.Validators(),
+		tags:        internTags(existing, tags),
 	}
+	c.refresh(entry)
 
-	c.fileCache.Add(key, entry)
+	c.fileCache.Add(entry)
+	c.store.Put(entry, key)
 	c.totalSize.Add(size)
+	c.shadows.observe(key, hash, size, observed)
+	return entry
 }
 
-// evictIfNeeded 如果超出大小限制，淘汰最舊的條目
+// evictIfNeeded 如果超出大小限制，依淘汰策略淘汰條目
 func (c *Cache) evictIfNeeded(incoming int64) {
+	if c.totalSize.Load()+incoming <= c.config.MaxCacheSize {
+		return
+	}
+	// 剛下載的條目代價高，即使淘汰策略把它排在最前（例如串流加入後從未再命中）也不淘汰
+	cutoff := int64(math.MaxInt64)
+	if c.config.EvictMinAge > 0 {
+		cutoff = time.Now().Add(-c.config.EvictMinAge).UnixNano()
+	}
 	for c.totalSize.Load()+incoming > c.config.MaxCacheSize {
-		if _, _, ok := c.fileCache.RemoveOldest(); !ok {
+		if !c.fileCache.RemoveVictim(cutoff, removeCapacity) {
+			if c.fileCache.Len() > 0 {
+				c.evictBlocked.Add(1)
+			}
 			break
 		}
 	}
 }
 
-// FailPending 下載失敗
-func (c *Cache) FailPending(key string) {
+// FailPending 下載失敗，中止 sf；sf 仍是 key 進行中的下載時一併移除，不影響之後重新開始的下載
+func (c *Cache) FailPending(key string, sf *StreamingFile) {
 	c.pendingMu.Lock()
-	sf, ok := c.pending[key]
-	if ok {
+	if c.pending[key] == sf {
 		delete(c.pending, key)
 	}
 	c.pendingMu.Unlock()
-
-	if ok {
-		sf.Abort()
-	}
+	sf.Abort()
+	sf.settle()
 }
 
-// PutNotFound 快取未找到的結果
-func (c *Cache) PutNotFound(key string) {
-	c.notFoundCache.Add(key, struct{}{})
+// PutNegative 快取上游的錯誤狀態，保留 ttl
+func (c *Cache) PutNegative(key string, status int, ttl time.Duration) {
+	c.negativeCache.Add(key, negativeEntry{status: status, expiresAt: time.Now().Add(ttl), ttl: ttl})
 }
 
 // Remove 移除快取條目
 func (c *Cache) Remove(key string) {
-	c.fileCache.Remove(key)
-	c.notFoundCache.Remove(key)
+	c.fileCache.Remove(hashKey(key))
+	c.negativeCache.Remove(key)
+	c.headCache.Remove(key)
 }
 
-// Stats 返回快取統計資訊
-func (c *Cache) Stats() map[string]any {
+// PendingCount 返回進行中的下載數
+func (c *Cache) PendingCount() int {
 	c.pendingMu.RLock()
-	pending := len(c.pending)
-	c.pendingMu.RUnlock()
+	defer c.pendingMu.RUnlock()
+	return len(c.pending)
+}
 
-	return map[string]any{
-		"file_entries":     c.fileCache.Len(),
-		"notfound_entries": c.notFoundCache.Len(),
-		"total_size":       c.totalSize.Load(),
-		"max_size":         c.config.MaxCacheSize,
-		"usage_percent":    float64(c.totalSize.Load()) / float64(c.config.MaxCacheSize) * 100,
-		"pending":          pending,
+// recordFailure 記錄快取檔案的讀寫失敗
+func (c *Cache) recordFailure() { c.failures.Add(1) }
+
+// Failures 返回快取子系統的累計錯誤數（檔案讀寫、索引寫入與校驗不符）
+func (c *Cache) Failures() int64 {
+	return c.failures.Load() + c.store.failures.Load() + c.corrupted.Load()
+}
+
+// Stats 返回快取統計資訊
+func (c *Cache) Stats() CacheStats {
+	totalSize := c.totalSize.Load()
+	return CacheStats{
+		FileEntries:     c.fileCache.Len(),
+		NegativeEntries: c.negativeCache.Len(),
+		HeadEntries:     c.headCache.Len(),
+		TotalSize:       totalSize,
+		MaxSize:         c.config.MaxCacheSize,
+		UsagePercent:    float64(totalSize) / float64(c.config.MaxCacheSize) * 100,
+		Pending:         c.PendingCount(),
+		Pinned:          c.fileCache.PinnedCount(),
+		Corrupted:       c.corrupted.Load(),
+		Failures:        c.Failures(),
+		EvictBlocked:    c.evictBlocked.Load(),
+		VersionsKept:    c.versionsKept.Load(),
+		PendingStalled:  c.stalled.Load(),
+	}
+}
+
+// DiskStats 返回快取所在檔案系統的統計資訊
+func (c *Cache) DiskStats() DiskStats {
+	return DiskStats{
+		Free:            c.diskFree.Load(),
+		MinFree:         c.config.MinFreeDiskBytes,
+		Evictions:       c.diskEvictions.Load(),
+		DeferredDeletes: c.deferred.Load(),
 	}
 }
 
@@ -375,16 +896,27 @@ type StreamingFile struct {
 	size     int64
 	done     bool
 	err      error
+
+	contentType  string      // 上游回應的 Content-Type
+	expectedSize int64       // 上游宣告的長度，-1 表示未知
+	hasher       hash.Hash   // 寫入內容的 SHA-256
+	validators   *validators // 需重新驗證時記錄的上游驗證器
+	readers      int         // 尚未關閉的串流讀取者數
+	cancel       func()      // 中止發起者的上游請求，由看門狗呼叫
+	settle       func()      // 提交或放棄時呼叫一次，減少快取的進行中下載數
+
+	progressAt atomic.Int64 // 建立或最近一次寫入的時間（UnixNano）
 }
 
-// NewStreamingFile 建立串流檔案
+// NewStreamingFile 建立串流檔案，下載期間寫入 .part 暫存檔
 func NewStreamingFile(filePath string) (*StreamingFile, error) {
-	file, err := os.Create(filePath)
+	file, err := os.Create(filePath + partFileSuffix)
 	if err != nil {
 		return nil, fmt.Errorf("create cache file: %w", err)
 	}
-	sf := &StreamingFile{filePath: filePath, file: file}
+	sf := &StreamingFile{filePath: filePath, file: file, expectedSize: -1, hasher: sha256.New(), settle: func() {}}
 	sf.cond = sync.NewCond(&sf.mu)
+	sf.progressAt.Store(time.Now().UnixNano())
 	return sf, nil
 }
 
@@ -398,31 +930,94 @@ func (sf *StreamingFile) Write(p []byte) (int, error) {
 	}
 
 	n, err := sf.file.Write(p)
+	sf.hasher.Write(p[:n])
 	sf.size += int64(n)
+	if n > 0 {
+		sf.progressAt.Store(time.Now().UnixNano())
+	}
 	sf.cond.Broadcast()
 	return n, err
 }
 
-// Complete 完成寫入
-func (sf *StreamingFile) Complete() {
+// Complete 完成寫入並將暫存檔改名為正式檔案
+func (sf *StreamingFile) Complete() error {
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 	sf.done = true
 	sf.file.Close()
+	err := os.Rename(sf.filePath+partFileSuffix, sf.filePath)
+	if err != nil {
+		sf.err = fmt.Errorf("commit cache file: %w", err)
+		os.Remove(sf.filePath + partFileSuffix)
+	}
 	sf.cond.Broadcast()
+	return err
 }
 
 // Abort 中止寫入
 func (sf *StreamingFile) Abort() {
+	sf.abort(errDownloadAborted)
+}
+
+// abort 以 err 中止寫入並通知讀取者，已完成或已中止時不變
+func (sf *StreamingFile) abort(err error) {
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
+	if sf.done {
+		return
+	}
 	sf.done = true
-	sf.err = fmt.Errorf("download aborted")
+	sf.err = err
 	sf.file.Close()
-	os.Remove(sf.filePath)
+	os.Remove(sf.filePath + partFileSuffix)
 	sf.cond.Broadcast()
 }
 
+// SetCancel 設定中止上游請求的函式，看門狗中止停滯的下載時呼叫
+func (sf *StreamingFile) SetCancel(cancel func()) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.cancel = cancel
+}
+
+// SetMeta 記錄上游回應資訊，供串流讀取者設定回應頭
+func (sf *StreamingFile) SetMeta(contentType string, expectedSize int64) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.contentType = contentType
+	sf.expectedSize = expectedSize
+}
+
+// Meta 返回上游回應資訊
+func (sf *StreamingFile) Meta() (contentType string, expectedSize int64) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.contentType, sf.expectedSize
+}
+
+// SetValidators 記錄上游驗證器，完成時寫入條目
+func (sf *StreamingFile) SetValidators(etag, lastModified string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.validators = newValidators(etag, lastModified)
+}
+
+// Validators 返回上游驗證器
+func (sf *StreamingFile) Validators() *validators {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.validators
+}
+
+// Sum 返回已寫入內容的 SHA-256
+func (sf *StreamingFile) Sum() keyHash {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	var sum keyHash
+	sf.hasher.Sum(sum[:0])
+	return sum
+}
+
 // Size 返回當前大小
 func (sf *StreamingFile) Size() int64 {
 	sf.mu.RLock()
@@ -430,59 +1025,223 @@ func (sf *StreamingFile) Size() int64 {
 	return sf.size
 }
 
-// NewReader 建立新的讀取者
+// currentPath 返回讀取者應開啟的路徑
+func (sf *StreamingFile) currentPath() string {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	if sf.done {
+		return sf.filePath
+	}
+	return sf.filePath + partFileSuffix
+}
+
+// NewReader 建立新的讀取者，等待下載進度時不會被取消
 func (sf *StreamingFile) NewReader() *StreamingFileReader {
-	return &StreamingFileReader{sf: sf}
+	return sf.NewReaderContext(context.Background())
 }
 
-// StreamingFileReader 串流檔案讀取者
-type StreamingFileReader struct {
-	sf     *StreamingFile
-	offset int64
-	file   *os.File
+// NewReaderContext 建立新的讀取者，ctx 結束時等待中的讀取返回 ctx 的錯誤
+func (sf *StreamingFile) NewReaderContext(ctx context.Context) *StreamingFileReader {
+	r, _ := sf.TryNewReader(ctx, 0)
+	return r
 }
 
-// Read 讀取資料，若資料尚未準備好會等待
-func (r *StreamingFileReader) Read(p []byte) (int, error) {
-	if r.file == nil {
-		var err error
-		r.file, err = os.Open(r.sf.filePath)
-		if err != nil {
-			return 0, err
-		}
+// TryNewReader 在讀取者未達 limit 時建立新的讀取者，limit 為 0 表示不限
+func (sf *StreamingFile) TryNewReader(ctx context.Context, limit int) (*StreamingFileReader, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if limit > 0 && sf.readers >= limit {
+		return nil, false
 	}
+	sf.readers++
+	return &StreamingFileReader{sf: sf, ctx: ctx}, true
+}
 
-	r.sf.mu.Lock()
+// WaitFor 等待已寫入的大小達到 offset，返回當時已寫入的大小
+//
+// 下載在達到 offset 前完成時返回 io.EOF，下載失敗時返回失敗原因，ctx 結束時返回 ctx 的錯誤。
+func (sf *StreamingFile) WaitFor(ctx context.Context, offset int64) (int64, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.err == nil && sf.size < offset && !sf.done {
+		// 以廣播喚醒等待中的 cond，讓取消不必等到下一次寫入
+		stop := context.AfterFunc(ctx, func() {
+			sf.mu.Lock()
+			sf.cond.Broadcast()
+			sf.mu.Unlock()
+		})
+		defer stop()
+	}
 	for {
-		if r.sf.err != nil {
-			r.sf.mu.Unlock()
-			return 0, r.sf.err
+		switch {
+		case sf.err != nil:
+			return sf.size, sf.err
+		case sf.size >= offset:
+			return sf.size, nil
+		case sf.done:
+			return sf.size, io.EOF
+		case ctx.Err() != nil:
+			return sf.size, ctx.Err()
 		}
+		sf.cond.Wait()
+	}
+}
 
-		available := r.sf.size - r.offset
-		if available > 0 {
-			r.sf.mu.Unlock()
+// finalSize 返回下載完成後的大小：已完成時為實際大小，否則為上游宣告的長度
+func (sf *StreamingFile) finalSize() (int64, bool) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	if sf.done && sf.err == nil {
+		return sf.size, true
+	}
+	return sf.expectedSize, sf.expectedSize >= 0
+}
 
-			toRead := int64(len(p))
-			if toRead > available {
-				toRead = available
-			}
-			n, err := r.file.ReadAt(p[:toRead], r.offset)
-			r.offset += int64(n)
-			return n, err
+// Readers 返回尚未關閉的讀取者數
+func (sf *StreamingFile) Readers() int {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.readers
+}
+
+// StreamingFileReader 串流檔案讀取者
+//
+// Read 與 Seek 共用同一個位置，只供單一 goroutine 使用；ReadAt 不使用位置，可並發呼叫。
+// 讀取尚未寫入的範圍時等待下載進度。
+type StreamingFileReader struct {
+	sf      *StreamingFile
+	ctx     context.Context
+	offset  int64
+	closed  bool
+	stall   time.Duration // 等待下載進度的時間上限，0 表示一直等待
+	stalled atomic.Bool
+
+	mu   sync.Mutex // 保護 file 的延遲開啟
+	file *os.File
+}
+
+// errUnknownSize 表示上游未宣告長度且下載尚未完成，無法從結尾計算位置
+var errUnknownSize = errors.New("streaming file size unknown")
+
+// errStreamStalled 表示下載超過等待時間上限沒有進度
+var errStreamStalled = errors.New("streaming file stalled")
+
+// SetStallTimeout 設定等待下載進度的時間上限，期間沒有寫入任何內容時讀取返回 errStreamStalled
+func (r *StreamingFileReader) SetStallTimeout(d time.Duration) {
+	r.stall = d
+}
+
+// Stalled 返回讀取是否曾因下載沒有進度而中止
+func (r *StreamingFileReader) Stalled() bool {
+	return r.stalled.Load()
+}
+
+// wait 等待已寫入的大小達到 offset；設定時間上限時，每段等待期間有新內容寫入就繼續等待
+func (r *StreamingFileReader) wait(offset int64) (int64, error) {
+	if r.stall <= 0 {
+		return r.sf.WaitFor(r.ctx, offset)
+	}
+	last := r.sf.Size()
+	for {
+		ctx, cancel := context.WithTimeout(r.ctx, r.stall)
+		size, err := r.sf.WaitFor(ctx, offset)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || r.ctx.Err() != nil {
+			return size, err
+		}
+		if size == last {
+			r.stalled.Store(true)
+			return size, errStreamStalled
 		}
+		last = size
+	}
+}
 
-		if r.sf.done {
-			r.sf.mu.Unlock()
-			return 0, io.EOF
+// Read 讀取資料，若資料尚未準備好會等待
+func (r *StreamingFileReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size, err := r.wait(r.offset + 1)
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.readAt(p[:min(int64(len(p)), size-r.offset)], r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// ReadAt 讀取 off 起的 len(p) 位元組，資料尚未寫入時等待；下載在此之前完成時返回已讀取的部分與 io.EOF
+func (r *StreamingFileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("streaming file: negative offset")
+	}
+	size, err := r.wait(off + int64(len(p)))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	n, err := r.readAt(p[:min(int64(len(p)), size-off)], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek 設定 Read 的位置，不等待資料；io.SeekEnd 以上游宣告的長度計算，長度未知且下載未完成時返回錯誤
+func (r *StreamingFileReader) Seek(offset int64, whence int) (int64, error) {
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = r.offset
+	case io.SeekEnd:
+		size, ok := r.sf.finalSize()
+		if !ok {
+			return 0, errUnknownSize
 		}
+		base = size
+	default:
+		return 0, errors.New("streaming file: invalid whence")
+	}
+	if base+offset < 0 {
+		return 0, errors.New("streaming file: negative position")
+	}
+	r.offset = base + offset
+	return r.offset, nil
+}
 
-		r.sf.cond.Wait()
+// readAt 從檔案讀取已寫入的範圍，首次讀取時開啟檔案
+func (r *StreamingFileReader) readAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	if r.file == nil {
+		var err error
+		r.file, err = os.Open(r.sf.currentPath())
+		if errors.Is(err, fs.ErrNotExist) {
+			r.file, err = os.Open(r.sf.currentPath()) // 開啟前剛好完成改名
+		}
+		if err != nil {
+			r.mu.Unlock()
+			return 0, err
+		}
 	}
+	file := r.file
+	r.mu.Unlock()
+	return file.ReadAt(p, off)
 }
 
 // Close 關閉讀取者
 func (r *StreamingFileReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.sf.mu.Lock()
+		r.sf.readers--
+		r.sf.mu.Unlock()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.file != nil {
 		return r.file.Close()
 	}
@@ -0,0 +1,480 @@
+package fileproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCache 在暫存目錄建立快取，mutate 可調整配置
+func newTestCache(t testing.TB, mutate func(*Config)) *Cache {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.CacheDir = t.TempDir()
+	if mutate != nil {
+		mutate(cfg)
+	}
+	c, err := NewCache(cfg)
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// putBytes 寫入一個完整的條目
+func putBytes(t testing.TB, c *Cache, key string, body []byte) *CacheEntry {
+	t.Helper()
+	entry, err := c.Put(context.Background(), key, bytes.NewReader(body), int64(len(body)), "application/octet-stream", 0, nil)
+	if err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+	return entry
+}
+
+// retiredFiles 返回條目改名保留的舊檔案
+func retiredFiles(t testing.TB, c *Cache, key string) []string {
+	t.Helper()
+	files, err := filepath.Glob(c.filePath(key) + retiredFileSuffix + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// readRest 讀完檔案剩下的內容，與已讀取的部分合併
+func readRest(t testing.TB, file *os.File, head []byte) []byte {
+	t.Helper()
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("read after retire: %v", err)
+	}
+	return append(head, rest...)
+}
+
+func TestOpenSurvivesEviction(t *testing.T) {
+	const size = 256 << 10
+	c := newTestCache(t, func(cfg *Config) {
+		cfg.MaxCacheSize = size + size/2 // 只容得下一個條目
+		cfg.EvictMinAge = 0
+	})
+	body := bytes.Repeat([]byte("a"), size)
+	entry := putBytes(t, c, "/a", body)
+
+	// 兩個讀取者，都讀到一半
+	file1, release1, err := c.Open(context.Background(), entry)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	file2, release2, err := c.Open(context.Background(), entry)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	head1 := make([]byte, 4096)
+	if _, err := io.ReadFull(file1, head1); err != nil {
+		t.Fatal(err)
+	}
+	head2 := make([]byte, 8192)
+	if _, err := io.ReadFull(file2, head2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 容量不足，寫入新條目時淘汰 /a
+	putBytes(t, c, "/b", bytes.Repeat([]byte("b"), size))
+	if _, ok := c.fileCache.Peek(entry.hash); ok {
+		t.Fatal("/a still indexed after eviction")
+	}
+	if _, err := os.Stat(c.filePath("/a")); !os.IsNotExist(err) {
+		t.Fatalf("cache path of evicted entry still exists: %v", err)
+	}
+	if n := len(retiredFiles(t, c, "/a")); n != 1 {
+		t.Fatalf("retired files = %d, want 1", n)
+	}
+	if got := c.DiskStats().DeferredDeletes; got != 1 {
+		t.Fatalf("deferred deletes = %d, want 1", got)
+	}
+
+	if got := readRest(t, file1, head1); !bytes.Equal(got, body) {
+		t.Fatalf("reader 1 got %d bytes, want the full %d byte body", len(got), len(body))
+	}
+	if got := readRest(t, file2, head2); !bytes.Equal(got, body) {
+		t.Fatalf("reader 2 got %d bytes, want the full %d byte body", len(got), len(body))
+	}
+
+	release1()
+	if n := len(retiredFiles(t, c, "/a")); n != 1 {
+		t.Fatalf("retired file removed while a reader still holds it (files = %d)", n)
+	}
+	release2()
+	if n := len(retiredFiles(t, c, "/a")); n != 0 {
+		t.Fatalf("retired file kept after the last reader released it (files = %d)", n)
+	}
+
+	// 已淘汰的條目不能再開啟
+	if _, _, err := c.Open(context.Background(), entry); err != errEntryEvicted {
+		t.Fatalf("Open evicted entry: err = %v, want errEntryEvicted", err)
+	}
+}
+
+func TestOpenSurvivesReplacement(t *testing.T) {
+	c := newTestCache(t, nil)
+	oldBody := bytes.Repeat([]byte("old-"), 64<<10)
+	newBody := bytes.Repeat([]byte("new!"), 32<<10)
+	oldEntry := putBytes(t, c, "/f", oldBody)
+
+	file, release, err := c.Open(context.Background(), oldEntry)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	head := make([]byte, 1000)
+	if _, err := io.ReadFull(file, head); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新下載取代舊條目，新檔案寫在相同路徑
+	newEntry := putBytes(t, c, "/f", newBody)
+	if n := len(retiredFiles(t, c, "/f")); n != 1 {
+		t.Fatalf("retired files = %d, want 1", n)
+	}
+
+	if got := readRest(t, file, head); !bytes.Equal(got, oldBody) {
+		t.Fatalf("reader of the replaced entry got %d bytes, want the original %d byte body", len(got), len(oldBody))
+	}
+
+	// 新的讀取者看到新內容
+	newFile, newRelease, err := c.Open(context.Background(), newEntry)
+	if err != nil {
+		t.Fatalf("Open new entry: %v", err)
+	}
+	got, err := io.ReadAll(newFile)
+	newRelease()
+	if err != nil || !bytes.Equal(got, newBody) {
+		t.Fatalf("new entry read %d bytes (err %v), want the %d byte new body", len(got), err, len(newBody))
+	}
+
+	release()
+	if n := len(retiredFiles(t, c, "/f")); n != 0 {
+		t.Fatalf("retired file kept after the last reader released it (files = %d)", n)
+	}
+	if data, err := os.ReadFile(c.filePath("/f")); err != nil || !bytes.Equal(data, newBody) {
+		t.Fatalf("cache path after release: %d bytes (err %v), want the new body", len(data), err)
+	}
+}
+
+func TestOpenAfterReplacementRefusesStaleEntry(t *testing.T) {
+	c := newTestCache(t, nil)
+	oldEntry := putBytes(t, c, "/f", bytes.Repeat([]byte("o"), 64<<10))
+
+	// 查到舊條目後、開啟前被較小的新版本取代
+	newBody := bytes.Repeat([]byte("n"), 1<<10)
+	putBytes(t, c, "/f", newBody)
+
+	// 依路徑開啟會拿到新檔案，以舊條目的長度傳輸時在途中遇到 EOF
+	data, err := os.ReadFile(c.FilePath(oldEntry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) >= oldEntry.Size {
+		t.Fatalf("path holds %d bytes, expected the smaller replacement", len(data))
+	}
+
+	// Open 拒絕已被取代的條目，呼叫者改為重新下載
+	if _, _, err := c.Open(context.Background(), oldEntry); err != errEntryEvicted {
+		t.Fatalf("Open replaced entry: err = %v, want errEntryEvicted", err)
+	}
+	if n := len(retiredFiles(t, c, "/f")); n != 0 {
+		t.Fatalf("retired files = %d without readers, want 0", n)
+	}
+}
+
+// pattern 返回可依位置檢查內容的測試資料
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// fillSlowly 分段寫入 body，每段之間稍作停頓，讓讀取者在寫入途中等待
+func fillSlowly(t testing.TB, sf *StreamingFile, body []byte, chunk int) {
+	t.Helper()
+	for off := 0; off < len(body); off += chunk {
+		if _, err := sf.Write(body[off:min(off+chunk, len(body))]); err != nil {
+			t.Errorf("Write: %v", err)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamingReadAtWhileFilling(t *testing.T) {
+	body := pattern(512 << 10)
+	sf, err := NewStreamingFile(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.SetMeta("application/octet-stream", int64(len(body)))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func(seed uint64) {
+			defer wg.Done()
+			r := sf.NewReader()
+			defer r.Close()
+			rnd := rand.New(rand.NewPCG(seed, 0))
+			buf := make([]byte, 16<<10)
+			for range 50 {
+				off := rnd.IntN(len(body) - len(buf))
+				n, err := r.ReadAt(buf, int64(off))
+				if err != nil || n != len(buf) {
+					t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+					return
+				}
+				if !bytes.Equal(buf, body[off:off+n]) {
+					t.Errorf("ReadAt(%d) returned wrong content", off)
+					return
+				}
+			}
+		}(uint64(i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			size, err := sf.WaitFor(context.Background(), int64(len(body)))
+			if err != nil || size != int64(len(body)) {
+				t.Errorf("WaitFor(end) = %d, %v", size, err)
+			}
+		}()
+	}
+
+	fillSlowly(t, sf, body, 8<<10)
+	if err := sf.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if n := sf.Readers(); n != 0 {
+		t.Fatalf("readers = %d after all closed, want 0", n)
+	}
+}
+
+func TestStreamingWriterAborts(t *testing.T) {
+	body := pattern(64 << 10)
+	sf, err := NewStreamingFile(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(body) / 2
+	if _, err := sf.Write(body[:half]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 等待尚未寫入範圍的讀取者在中止時收到錯誤
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r := sf.NewReader()
+			defer r.Close()
+			_, err := r.ReadAt(make([]byte, 1024), int64(len(body)-1024))
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := sf.WaitFor(context.Background(), int64(len(body)))
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	sf.Abort()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, errDownloadAborted) {
+			t.Fatalf("waiting reader got %v, want errDownloadAborted", err)
+		}
+	}
+
+	// 中止後不能再寫入，也不會留下暫存檔
+	if _, err := sf.Write([]byte("x")); err == nil {
+		t.Fatal("Write after Abort succeeded")
+	}
+	if _, err := os.Stat(sf.filePath + partFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("part file left after Abort: %v", err)
+	}
+}
+
+func TestStreamingWaitCancelled(t *testing.T) {
+	sf, err := NewStreamingFile(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Abort()
+	if _, err := sf.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := sf.NewReaderContext(ctx)
+	defer r.Close()
+
+	results := make(chan error, 2)
+	go func() {
+		_, err := r.ReadAt(make([]byte, 10), 100)
+		results <- err
+	}()
+	go func() {
+		_, err := sf.WaitFor(ctx, 100)
+		results <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// 取消不必等到下一次寫入
+	for range 2 {
+		select {
+		case err := <-results:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("cancelled wait returned %v, want context.Canceled", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("wait did not return after the context was cancelled")
+		}
+	}
+
+	// 已寫入的範圍仍可讀取
+	r2 := sf.NewReader()
+	defer r2.Close()
+	buf := make([]byte, 7)
+	if n, err := r2.ReadAt(buf, 0); err != nil || string(buf[:n]) != "partial" {
+		t.Fatalf("ReadAt written range = %q, %v", buf[:n], err)
+	}
+}
+
+func TestStreamingSeekEnd(t *testing.T) {
+	body := pattern(4096)
+	sf, err := NewStreamingFile(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sf.NewReader()
+	defer r.Close()
+	if _, err := sf.Write(body[:1000]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 上游未宣告長度且尚未完成時無法從結尾計算
+	if _, err := r.Seek(-10, io.SeekEnd); !errors.Is(err, errUnknownSize) {
+		t.Fatalf("SeekEnd with unknown size: err = %v, want errUnknownSize", err)
+	}
+	if pos, err := r.Seek(100, io.SeekStart); err != nil || pos != 100 {
+		t.Fatalf("SeekStart = %d, %v", pos, err)
+	}
+
+	// 宣告長度後以宣告的長度計算，讀取等待內容寫入
+	sf.SetMeta("", int64(len(body)))
+	pos, err := r.Seek(-96, io.SeekEnd)
+	if err != nil || pos != int64(len(body)-96) {
+		t.Fatalf("SeekEnd with declared size = %d, %v", pos, err)
+	}
+	done := make(chan []byte)
+	go func() {
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("ReadAll: %v", err)
+		}
+		done <- got
+	}()
+	if _, err := sf.Write(body[1000:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; !bytes.Equal(got, body[len(body)-96:]) {
+		t.Fatalf("read after SeekEnd = %d bytes, want the last 96", len(got))
+	}
+
+	// 未宣告長度時，完成後以實際大小計算
+	sf2, err := NewStreamingFile(filepath.Join(t.TempDir(), "g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf2.Write(body[:300])
+	sf2.Complete()
+	r2 := sf2.NewReader()
+	defer r2.Close()
+	if pos, err := r2.Seek(0, io.SeekEnd); err != nil || pos != 300 {
+		t.Fatalf("SeekEnd after Complete = %d, %v, want 300", pos, err)
+	}
+}
+
+func TestStreamingReadPastEndAfterCompletePending(t *testing.T) {
+	c := newTestCache(t, nil)
+	ctx := context.Background()
+	sf, isNew, err := c.GetOrCreatePending(ctx, "/s")
+	if err != nil || !isNew {
+		t.Fatalf("GetOrCreatePending = %v, %v", isNew, err)
+	}
+	body := pattern(10000)
+	if _, err := sf.Write(body[:4000]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 完成前開始等待超過最終大小的讀取者，完成時收到已有的部分與 io.EOF
+	r := sf.NewReader()
+	defer r.Close()
+	type result struct {
+		n   int
+		err error
+	}
+	straddle := make(chan result, 1)
+	go func() {
+		n, err := r.ReadAt(make([]byte, 2000), 9000)
+		straddle <- result{n, err}
+	}()
+	beyond := make(chan result, 1)
+	go func() {
+		n, err := r.ReadAt(make([]byte, 100), 20000)
+		beyond <- result{n, err}
+	}()
+
+	if _, err := sf.Write(body[4000:]); err != nil {
+		t.Fatal(err)
+	}
+	if entry := c.CompletePending(ctx, "/s", sf, int64(len(body)), "", 0, nil); entry == nil {
+		t.Fatal("CompletePending returned nil")
+	}
+	for name, ch := range map[string]chan result{"straddling": straddle, "beyond": beyond} {
+		select {
+		case res := <-ch:
+			want := map[string]int{"straddling": 1000, "beyond": 0}[name]
+			if res.n != want || res.err != io.EOF {
+				t.Fatalf("%s read = %d, %v; want %d, io.EOF", name, res.n, res.err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s read still waiting after CompletePending", name)
+		}
+	}
+
+	// 完成後讀取超過結尾立即返回，讀取者改讀正式檔案
+	buf := make([]byte, 500)
+	if n, err := r.ReadAt(buf, 9800); n != 200 || err != io.EOF || !bytes.Equal(buf[:n], body[9800:]) {
+		t.Fatalf("ReadAt past end = %d, %v", n, err)
+	}
+	if n, err := r.ReadAt(buf, int64(len(body))); n != 0 || err != io.EOF {
+		t.Fatalf("ReadAt at end = %d, %v", n, err)
+	}
+	if _, err := os.Stat(c.filePath("/s") + partFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("part file left after CompletePending: %v", err)
+	}
+}
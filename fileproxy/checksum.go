@@ -0,0 +1,85 @@
+package fileproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// scrubPause 背景校驗每個檔案之間的間隔，避免佔滿磁碟 IO
+const scrubPause = 10 * time.Millisecond
+
+// Verify 重新計算檔案的 SHA-256 並與記錄比對，不符時淘汰條目
+//
+// 沒有記錄校驗和的條目（舊版索引）視為通過。
+func (c *Cache) Verify(entry *CacheEntry) bool {
+	if entry.sum == (keyHash{}) {
+		return true
+	}
+
+	file, release, err := c.open(entry)
+	if err != nil {
+		return false
+	}
+	defer release()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false
+	}
+	var sum keyHash
+	h.Sum(sum[:0])
+	if sum == entry.sum {
+		return true
+	}
+
+	c.corrupted.Add(1)
+	slog.Error("cache file corrupted, evicting",
+		"hash", hex.EncodeToString(entry.hash[:]),
+		"expected", hex.EncodeToString(entry.sum[:]),
+		"actual", hex.EncodeToString(sum[:]))
+	c.removeEntry(entry)
+	return false
+}
+
+// removeEntry 淘汰條目（僅當索引中仍是同一條目時）
+func (c *Cache) removeEntry(entry *CacheEntry) {
+	if current, ok := c.fileCache.Peek(entry.hash); ok && current == entry {
+		c.fileCache.Remove(entry.hash)
+	}
+}
+
+// scrubLoop 定期校驗所有快取檔案，淘汰損壞的檔案，下次請求時重新下載
+func (c *Cache) scrubLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.ScrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.scrub()
+		}
+	}
+}
+
+// scrub 校驗一輪所有條目
+func (c *Cache) scrub() {
+	start := time.Now()
+	checked, corrupted := 0, c.corrupted.Load()
+	for _, entry := range c.fileCache.Values() {
+		select {
+		case <-c.closeCh:
+			return
+		case <-time.After(scrubPause):
+		}
+		c.Verify(entry)
+		checked++
+	}
+	slog.Info("cache scrub finished", "checked", checked,
+		"corrupted", c.corrupted.Load()-corrupted, "duration", time.Since(start))
+}
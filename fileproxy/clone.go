@@ -0,0 +1,26 @@
+package fileproxy
+
+import (
+	"fmt"
+	"os"
+)
+
+// cloneToFile 以 reflink 建立 src 的副本 dst，dst 必須不存在
+//
+// XFS、Btrfs 等支援 reflink 的檔案系統上只複製中繼資料，耗時與檔案大小無關，副本與快取檔案各自獨立；
+// 與硬連結不同，Btrfs 同一檔案系統的不同子卷之間也可使用。不支援時返回錯誤且不留下 dst。
+func cloneToFile(src *os.File, dst string) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("create clone: %w", err)
+	}
+	err = cloneFile(out, src)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("clone file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package fileproxy
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile 以 FICLONE 讓 dst 共用 src 的資料區塊，只複製中繼資料
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
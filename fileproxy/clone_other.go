@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fileproxy
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile 非 Linux 平台不支援 reflink
+func cloneFile(dst, src *os.File) error {
+	return errors.New("reflink is only supported on linux")
+}
@@ -0,0 +1,221 @@
+package fileproxy
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// strictBody 嚴格模式測試使用的上游內容
+const strictBody = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// newStrictProxy 建立嚴格模式的代理並將 /file 寫入快取，返回代理地址與快取回應的 ETag 和 Last-Modified
+func newStrictProxy(t *testing.T) (base, etag string, lastModified time.Time) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", "Tue, 02 Jan 2024 03:04:05 GMT")
+		io.WriteString(w, strictBody)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := DefaultConfig()
+	cfg.UpstreamURL = upstream.URL
+	cfg.CacheDir = t.TempDir()
+	cfg.StrictHTTP = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// 之後的請求都從快取返回
+	resp, err = http.Get(srv.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Fatalf("second request X-Cache = %q, want HIT", got)
+	}
+	etag = resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("cached response has no ETag")
+	}
+	lastModified, err = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("cached response Last-Modified: %v", err)
+	}
+	return srv.URL, etag, lastModified
+}
+
+func TestStrictConformance(t *testing.T) {
+	base, etag, modified := newStrictProxy(t)
+	lastMod := modified.Format(http.TimeFormat)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+	size := len(strictBody)
+
+	tests := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		status       int
+		body         string // 為空時不檢查
+		contentRange string // 為空時不檢查
+	}{
+		{name: "if-none-match hit", header: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{name: "if-none-match list", header: map[string]string{"If-None-Match": `"other", ` + etag}, status: http.StatusNotModified},
+		{name: "if-none-match star", header: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{name: "if-none-match miss", header: map[string]string{"If-None-Match": `"other"`}, status: http.StatusOK, body: strictBody},
+		{name: "if-modified-since same", header: map[string]string{"If-Modified-Since": lastMod}, status: http.StatusNotModified},
+		{name: "if-modified-since before", header: map[string]string{"If-Modified-Since": before}, status: http.StatusOK, body: strictBody},
+		{name: "if-none-match wins over if-modified-since", header: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastMod}, status: http.StatusOK},
+
+		{name: "if-match hit", header: map[string]string{"If-Match": etag}, status: http.StatusOK, body: strictBody},
+		{name: "if-match miss", header: map[string]string{"If-Match": `"other"`}, status: http.StatusPreconditionFailed},
+		{name: "if-match weak", header: map[string]string{"If-Match": "W/" + etag}, status: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since after", header: map[string]string{"If-Unmodified-Since": after}, status: http.StatusOK},
+		{name: "if-unmodified-since before", header: map[string]string{"If-Unmodified-Since": before}, status: http.StatusPreconditionFailed},
+
+		{name: "range", header: map[string]string{"Range": "bytes=2-5"}, status: http.StatusPartialContent, body: "2345", contentRange: "bytes 2-5/36"},
+		{name: "suffix range", header: map[string]string{"Range": "bytes=-4"}, status: http.StatusPartialContent, body: "wxyz", contentRange: "bytes 32-35/36"},
+		{name: "open range", header: map[string]string{"Range": "bytes=30-"}, status: http.StatusPartialContent, body: "uvwxyz", contentRange: "bytes 30-35/36"},
+		{name: "unsatisfiable range", header: map[string]string{"Range": "bytes=100-200"}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */36"},
+		{name: "non-bytes range ignored", header: map[string]string{"Range": "items=0-1"}, status: http.StatusOK, body: strictBody},
+		{name: "range on head ignored", method: http.MethodHead, header: map[string]string{"Range": "bytes=0-1"}, status: http.StatusOK},
+
+		{name: "if-range etag match", header: map[string]string{"Range": "bytes=0-3", "If-Range": etag}, status: http.StatusPartialContent, body: "0123"},
+		{name: "if-range stale etag", header: map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`}, status: http.StatusOK, body: strictBody},
+		{name: "if-range date match", header: map[string]string{"Range": "bytes=0-3", "If-Range": lastMod}, status: http.StatusPartialContent, body: "0123"},
+		{name: "if-range stale date", header: map[string]string{"Range": "bytes=0-3", "If-Range": before}, status: http.StatusOK, body: strictBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, _ := http.NewRequest(method, base+"/file", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.body != "" && string(body) != tt.body {
+				t.Fatalf("body = %q, want %q", body, tt.body)
+			}
+			if tt.contentRange != "" && resp.Header.Get("Content-Range") != tt.contentRange {
+				t.Fatalf("Content-Range = %q, want %q", resp.Header.Get("Content-Range"), tt.contentRange)
+			}
+			if resp.StatusCode == http.StatusNotModified && len(body) != 0 {
+				t.Fatalf("304 response has a %d byte body", len(body))
+			}
+			if resp.StatusCode == http.StatusOK && method == http.MethodGet && len(body) != size {
+				t.Fatalf("200 response body = %d bytes, want %d", len(body), size)
+			}
+		})
+	}
+}
+
+func TestStrictMultipartRange(t *testing.T) {
+	base, _, _ := newStrictProxy(t)
+	req, _ := http.NewRequest(http.MethodGet, base+"/file", nil)
+	req.Header.Set("Range", "bytes=0-1,10-12,-2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", resp.Header.Get("Content-Type"))
+	}
+
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/36", "01"},
+		{"bytes 10-12/36", "abc"},
+		{"bytes 34-35/36", "yz"},
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != w.contentRange {
+			t.Fatalf("part %d Content-Range = %q, want %q", i, got, w.contentRange)
+		}
+		if got := part.Header.Get("Content-Type"); got != "text/plain" {
+			t.Fatalf("part %d Content-Type = %q, want text/plain", i, got)
+		}
+		if string(body) != w.body {
+			t.Fatalf("part %d body = %q, want %q", i, body, w.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("extra part after the requested ranges: %v", err)
+	}
+}
+
+func TestStrictHeadMatchesGet(t *testing.T) {
+	base, _, _ := newStrictProxy(t)
+	headers := func(method string) http.Header {
+		req, _ := http.NewRequest(method, base+"/file", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s status = %d", method, resp.StatusCode)
+		}
+		h := resp.Header.Clone()
+		h.Del("Date")
+		return h
+	}
+	get, head := headers(http.MethodGet), headers(http.MethodHead)
+	for k := range get {
+		if get.Get(k) != head.Get(k) {
+			t.Errorf("header %s: GET %q, HEAD %q", k, get.Get(k), head.Get(k))
+		}
+	}
+	for k := range head {
+		if _, ok := get[k]; !ok {
+			t.Errorf("header %s only on HEAD", k)
+		}
+	}
+	if get.Get("Content-Length") != "36" {
+		t.Errorf("Content-Length = %q, want 36", get.Get("Content-Length"))
+	}
+}
@@ -3,39 +3,272 @@ package fileproxy
 import (
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
 // Config 代理服務配置
 type Config struct {
-	ListenAddr       string        // 監聽地址
-	UpstreamURL      string        // 上游服務 URL
+	ListenAddr       string        // 監聽地址，unix:// 開頭時監聽 Unix domain socket
+	ShutdownTimeout  time.Duration // 關閉時等待進行中的下載完成並寫入快取的時間上限，到期時中止剩餘的下載
+	PIDFile          string        // 啟動時寫入程序 ID 的檔案，交接時由新程序覆寫，空字串表示不寫入
+	IndexLockTimeout time.Duration // 等待其他程序釋放快取索引鎖的時間上限，0 表示 1 秒，交接時自動延長
+	UpstreamURL      string        // 上游服務 URL，設定路由時可為空
+	Routes           []Route       // 依路徑前綴選擇上游
+	Rewrites         []Rewrite     // 組成上游 URL 前依序套用的路徑改寫，快取 key 不受影響
 	CacheDir         string        // 快取目錄
 	MaxCacheSize     int64         // 最大快取大小（位元組）
+	EvictMinAge      time.Duration // 建立未滿此時間的條目不因容量上限被淘汰，0 表示停用
+	KeepVersions     int           // 重新下載且內容改變時保留的舊版本數，以 ?version= 取得，0 表示不保留
+	EvictionPolicy   string        // 淘汰策略：lru、lfu、gdsf 或 fifo
+	ShadowPolicies   []string      // 以影子模擬比較的策略 POLICY[+second-hit][+max=SIZE]，目前的淘汰策略一律作為基準
 	DefaultCacheTTL  time.Duration // 預設快取過期時間
 	NotFoundCacheTTL time.Duration // 未找到快取過期時間
+	StaleIfErrorTTL  time.Duration // 過期後上游故障時仍可返回舊檔案的時間，0 表示停用
+	MinCacheTTL      time.Duration // 上游指定 TTL 的下限
+	MaxCacheTTL      time.Duration // 上游指定 TTL 的上限，0 表示不超過預設快取過期時間
+	TextContentTTL   time.Duration // JSON、XML 與文字內容的預設存活時間，0 表示使用預設快取過期時間
+	BinaryContentTTL time.Duration // 圖片、影音與壓縮檔等二進位內容的預設存活時間，0 表示使用預設快取過期時間
+	IgnoreNoStore    bool          // 忽略上游的 Cache-Control: no-store 與 private，照常快取
+	HonorImmutable   bool          // 上游以 Cache-Control: immutable 標記的回應不因存活時間過期
+	CacheRules       []CacheRule   // 依路徑覆寫快取行為，第一條匹配的規則生效
+	ExprRules        []ExprRule    // 以表達式比對請求屬性的規則，在 CacheRules 之後比對
+	MirrorProfiles   []string      // 套件鏡像設定檔（apt、yum、npm、pypi、huggingface），在所有規則之後比對
+	MirrorMetaTTL    time.Duration // 鏡像設定檔中套件索引的存活時間
+	PublicURL        string        // 客戶端存取代理的基底 URL，改寫索引中的上游連結時使用
+	Metalink         bool          // 上游返回 Metalink 時改從其中的鏡像下載，並校驗記載的大小與雜湊
+	CacheKeyQuery    []string      // 納入快取 key 並轉發上游的查詢參數，"*" 表示全部，為空時忽略查詢字串
+	CacheKeyStrip    []string      // 不納入快取 key 的追蹤參數，結尾為 * 時以前綴比對
+	KeyFoldCase      bool          // 快取 key 的路徑轉為小寫，適用不分大小寫的上游
+	KeyMergeSlashes  bool          // 快取 key 的路徑合併連續的 /
+	KeyTrimSlash     bool          // 快取 key 的路徑去掉結尾的 /（根路徑除外）
+
+	// 負快取配置（404 的快取時間為 NotFoundCacheTTL），連線錯誤一律不快取
+	ForbiddenCacheTTL   time.Duration // 上游返回 403 時的快取時間，0 表示不快取
+	GoneCacheTTL        time.Duration // 上游返回 410 時的快取時間，0 表示不快取
+	ServerErrorCacheTTL time.Duration // 上游返回 5xx 時的快取時間，0 表示不快取
+	NegativeCacheSize   int           // 負快取條目數上限，0 表示不限
+	NegativeCacheMemory int64         // 負快取估計記憶體用量上限（位元組），0 表示不限
+
+	// 磁碟水位配置
+	MinFreeDiskBytes   int64   // 快取所在檔案系統的最低剩餘空間（位元組），低於時淘汰最舊條目，0 表示停用
+	MinFreeDiskPercent float64 // 最低剩餘空間佔總容量的百分比，與 MinFreeDiskBytes 取較大者，0 表示停用
+
+	// 快取故障降級配置
+	CacheErrorBudget int           // 時間窗內容許的快取錯誤數，超過時改為純透傳，0 表示停用
+	CacheErrorWindow time.Duration // 統計快取錯誤的時間窗，也是降級後探測磁碟前的等待時間
+
+	// 熱門條目刷新配置
+	HotRefreshCount int           // 每輪在過期前刷新的最熱門條目數，0 表示停用
+	HotRefreshAhead time.Duration // 在過期前多久刷新熱門條目
+
+	// 客戶端斷線配置
+	CompleteOnDisconnect   bool    // 發起下載的客戶端斷線後在背景完成填充
+	DisconnectMaxRemaining int64   // 斷線時剩餘大小（位元組）超過此值則放棄填充，0 表示不限制
+	DisconnectMinPercent   float64 // 斷線時已下載比例低於此百分比則放棄填充，0 表示不限制
+
+	// 清除後回填配置
+	PurgeRefetchRate int           // 以 refetch 選項清除後每秒重新下載的條目數，0 表示停用該選項
+	PurgeStaleWindow time.Duration // 等待重新下載期間以舊內容回應的時間上限
+
+	// 完整性校驗配置
+	VerifyOnServe bool          // 從磁碟提供檔案前校驗 SHA-256
+	ScrubInterval time.Duration // 背景校驗所有檔案的間隔，0 表示停用
+	StatCacheTTL  time.Duration // 命中時沿用檔案存在檢查結果的時間，0 表示每次命中都 stat
+
+	// 來源記錄配置
+	RecordProvenance bool // 記錄每個條目的上游 URL、回應頭、下載時間與上游 IP，供稽核查詢
+
+	// 過期報表配置
+	ExpiryReportAt     string        // 每日產生報表的本地時間 HH:MM，為空時停用
+	ExpiryReportWindow time.Duration // 統計未來多久內過期的條目
+	ExpiryReportDepth  int           // 依 key 前幾層目錄分組
+
+	// 記憶體熱層配置
+	MemoryCacheSize        int64 // 記憶體快取大小（位元組），0 表示停用
+	MemoryCacheMaxFileSize int64 // 可放入記憶體的單檔大小上限（位元組）
+	RangeReadahead         int   // 連線上連續範圍請求的預讀大小（位元組），0 表示停用
 
 	// HTTP Client 配置
 	UpstreamTimeout     time.Duration // 上游請求超時
 	MaxIdleConns        int           // 最大空閒連接數
 	MaxIdleConnsPerHost int           // 每個 host 最大空閒連接數
+	OutboundAddrs       []string      // 上游連線綁定的本機 IP 或網路介面，多個時輪流使用
+	UpstreamSRV         string        // 以 DNS SRV 記錄決定上游主機的連線目標，例如 _https._tcp.files.example.com
+	UpstreamSRVInterval time.Duration // 重新解析 SRV 記錄的間隔
+	MaxRedirects        int           // 跟隨上游重新導向的次數上限，最終內容以原始 key 快取
+	PassRedirects       bool          // 不跟隨上游的 3xx，原樣返回客戶端且不快取
+	UpstreamRateHeaders bool          // 依上游 X-RateLimit-* 頭調節回源速度，避免收到 429
+	UpstreamRateReserve float64       // 剩餘預算低於上限的此比例時，將剩餘請求平均分配到重設前
+	UpstreamRateMaxWait time.Duration // 預算用盡時等待重設的上限，超過時返回 503，0 表示一直等待
+
+	// 上游能力探測配置
+	UpstreamProbeInterval time.Duration // 重新探測上游 HTTP/2 與 Range 支援的間隔，0 表示只在啟動時探測
+	FetchResumeAttempts   int           // 上游支援 Range 時，下載中斷後從中斷處續傳的次數上限，0 表示停用
+	ParallelFetchStreams  int           // 上游支援 HTTP/2 與 Range 時大檔案同時下載的分段數，0 或 1 表示停用
+	ParallelFetchMinSize  int64         // 並行分段下載的最小檔案大小（位元組）
+
+	// 限流配置
+	RateLimit      RateLimit       // 每個客戶端 IP 的全域限制
+	RateLimitRules []RateLimitRule // 依路徑前綴覆寫全域限制
+
+	// 掃描防護配置
+	ScanNotFoundLimit int           // 每個客戶端 IP 在 ScanWindow 內允許的 404 與 410 數，超過時暫時封鎖，0 表示停用
+	ScanWindow        time.Duration // 計算 404 數的時間窗
+	ScanBlockFor      time.Duration // 封鎖期間該 IP 的代理請求一律返回 429
+	ScanTarpit        time.Duration // 封鎖期間延遲多久才返回 429，0 表示立即返回
+
+	// 頻寬限制配置
+	MaxBandwidth     int64 // 全域下載速度上限（bytes/s），0 表示不限制
+	MaxConnBandwidth int64 // 每個連線的下載速度上限（bytes/s），0 表示不限制
+
+	// 優先級排程配置
+	MaxConcurrentFetches int           // 上游並發下載上限，0 表示不限制
+	MaxFetchQueue        int           // 等待下載名額的請求上限，超過時返回 503，0 表示不限制
+	FetchQueueTimeout    time.Duration // 等待下載名額的時間上限，逾時返回 503，0 表示一直等待
+	MaxStreamWaiters     int           // 附加到同一進行中下載的請求上限，超過時返回 503，0 表示不限制
+	StreamWaitTimeout    time.Duration // 附加的請求等待下載進度的時間上限，期間沒有新內容時中止，0 表示一直等待
+	PendingStallTimeout  time.Duration // 進行中的下載超過此時間沒有寫入任何內容時中止，之後的請求重新下載，0 表示停用；須大於客戶端背壓或低優先級讓出造成的暫停
+	LowPriorityPrefixes  []string      // 視為背景流量的路徑前綴
+	PrefixFetchLimits    []PrefixLimit // 依路徑前綴的上游並發下載上限，超過時排隊
+	PrefetchWorkers      int           // 預取任務的並發下載數，0 表示停用預取端點
+
+	// goroutine 防護配置
+	MaxStreamReaders  int           // 所有進行中下載的串流讀取者總數上限，超過時返回 503，0 表示不限制
+	MaxFetchers       int           // 上游下載（含等待下載名額）的總數上限，超過時返回 503，0 表示不限制
+	MaxBackgroundJobs int           // 預取、清除後回填等背景 goroutine 的上限，超過時改為依序執行，0 表示不限制
+	GoroutineLeakAge  time.Duration // 追蹤的 goroutine 執行超過此時間時記錄疑似洩漏的警告，0 表示停用
+
+	// 監聽 socket 配置（代理與管理監聽共用）
+	ListenReusePort   bool          // 設定 SO_REUSEPORT，允許多個程序監聽同一埠（僅 Linux）
+	ListenSocketMode  os.FileMode   // unix:// 監聽建立的 socket 檔案權限，0 表示依 umask
+	ListenSocketOwner string        // unix:// 監聽建立的 socket 檔案擁有者，格式 user[:group]，名稱或數字 ID
+	ListenNoDelay     bool          // 接受的連線開啟 TCP_NODELAY
+	ListenRecvBuffer  int           // 接受的連線的 SO_RCVBUF（位元組），0 表示系統預設
+	ListenSendBuffer  int           // 接受的連線的 SO_SNDBUF（位元組），0 表示系統預設
+	KeepAliveIdle     time.Duration // TCP keepalive 開始探測前的閒置時間，0 表示 15 秒，負數停用 keepalive
+	KeepAliveIntvl    time.Duration // keepalive 探測間隔，0 表示 15 秒
+	KeepAliveCount    int           // 判定連線中斷前的探測次數，0 表示 9 次
+
+	// HTTP 相容性配置
+	StrictHTTP bool // 嚴格遵循 RFC 9110/9111（驗證器、條件請求、Range、HEAD 一致性）
+
+	// 請求驗證配置
+	MaxHeaderBytes int // 請求頭大小上限（位元組）
+	MaxPathLength  int // 請求路徑長度上限，0 表示不限制
 
 	// TLS 配置
-	TLSCertFile string // TLS 憑證檔案路徑
-	TLSKeyFile  string // TLS 私鑰檔案路徑
+	TLSCertFile              string   // TLS 憑證檔案路徑
+	TLSKeyFile               string   // TLS 私鑰檔案路徑
+	TLSCurves                []string // 金鑰交換曲線偏好順序，空表示使用 Go 預設
+	TLSSessionTicketKeyFile  string   // session ticket 金鑰檔，多實例共用以跨實例恢復連線
+	TLSDisableSessionTickets bool     // 停用 session ticket 恢復
+
+	// 管理 API 配置
+	AdminToken      string // 管理端點的 Bearer Token，為空時停用管理 API
+	AdminListenAddr string // 健康檢查、統計與管理端點的獨立監聽地址，為空時與代理共用
+
+	// 客戶端驗證配置
+	APIKeys              []APIKey // 代理請求的 API Key，為空時不驗證
+	ForwardAuthorization bool     // 將客戶端 Authorization 轉發到上游，並依憑證隔離快取
+	SendForwarded        bool     // 回源時附加 RFC 7239 Forwarded 頭（客戶端 IP、Host 與協定）
+
+	// 中介層配置
+	Middleware      []Middleware // 代理請求的中介層鏈，第一個在最外層，為 nil 時使用 DefaultMiddleware()
+	AdminMiddleware []Middleware // 獨立管理監聽的自訂中介層，需設定 AdminListenAddr
+	CORSOrigins     []string     // 允許跨來源讀取的來源，"*" 表示全部，為空時不加 CORS 頭
+
+	// 跨區域複製配置
+	ReplicationPeers []string // 完成填充後推送的對等節點 URL
+
+	// 熱備節點配置
+	StandbyURL     string // 熱備節點 URL，索引更新持續串流到該節點，為空時停用
+	StandbyHotHits int64  // 命中次數達到此值的條目同時推送內容到熱備節點，0 表示只同步索引
+
+	// 兄弟節點查詢配置
+	PeerListenAddr     string        // 兄弟節點查詢的 UDP 監聽地址，為空時停用
+	PeerAddrs          []string      // 兄弟節點的 UDP 地址
+	PeerAdvertiseURL   string        // 命中時告知兄弟節點的本機 HTTP 地址
+	PeerTimeout        time.Duration // 等待兄弟節點回覆的時間
+	PeerDigestInterval time.Duration // 交換快取摘要的間隔，0 表示停用
+
+	// 寫後上傳配置
+	EnableUpload      bool // 接受 PUT 上傳並非同步推送到上游
+	PassthroughWrites bool // 將 POST、PUT、PATCH、DELETE 反向代理到上游（不快取），成功時使對應的快取失效
+
+	// 本機歸檔配置
+	ArchiveDir string // 永久鏡像目錄，下載完成的物件各保存一份且不參與淘汰，為空時停用
+
+	// 存取日誌配置
+	AccessLog     bool   // 每個請求寫一筆存取日誌
+	AccessLogFile string // 存取日誌檔案（JSON 逐行附加），設定時隱含 AccessLog，為空時寫入主日誌
+
+	// 追蹤配置
+	OTLPEndpoint     string  // OTLP/HTTP 追蹤匯出端點（host:port 或完整 URL），為空時只轉發 traceparent
+	OTLPInsecure     bool    // 以 HTTP 連線 host:port 形式的端點
+	TraceSampleRatio float64 // 沒有上游取樣決定時的取樣比例
+	TraceServiceName string  // 回報的服務名稱
+
+	// 離線模式配置
+	Offline bool // 啟動時即進入離線模式：不連線上游，只以快取回應（忽略過期），可由 /admin/offline 切換
+
+	// 快照配置
+	SnapshotDir string // 快照的上層目錄，須與快取目錄位於同一檔案系統，為空時停用快照端點
 }
 
 // DefaultConfig 返回預設配置
 func DefaultConfig() *Config {
 	return &Config{
-		ListenAddr:          ":8080",
-		CacheDir:            "./cache",
-		MaxCacheSize:        1 << 30, // 1GB
-		DefaultCacheTTL:     time.Hour,
-		NotFoundCacheTTL:    5 * time.Second,
-		UpstreamTimeout:     5 * time.Minute,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
+		ListenAddr:             ":8080",
+		CacheDir:               "./cache",
+		MaxCacheSize:           1 << 30, // 1GB
+		EvictionPolicy:         evictLRU,
+		DefaultCacheTTL:        time.Hour,
+		NotFoundCacheTTL:       5 * time.Second,
+		ForbiddenCacheTTL:      5 * time.Second,
+		GoneCacheTTL:           time.Hour,
+		NegativeCacheSize:      10000,
+		TextContentTTL:         5 * time.Minute,
+		CacheKeyStrip:          []string{"utm_*", "fbclid", "gclid", "msclkid", "mc_cid", "mc_eid", "_ga"},
+		HonorImmutable:         true,
+		MirrorMetaTTL:          30 * time.Second,
+		BinaryContentTTL:       7 * 24 * time.Hour,
+		MemoryCacheMaxFileSize: 64 << 10,  // 64KB
+		RangeReadahead:         256 << 10, // 256KB
+		UpstreamTimeout:        5 * time.Minute,
+		MaxIdleConns:           100,
+		MaxIdleConnsPerHost:    10,
+		UpstreamSRVInterval:    30 * time.Second,
+		MaxRedirects:           10,
+		UpstreamRateHeaders:    true,
+		UpstreamRateReserve:    0.2,
+		UpstreamRateMaxWait:    30 * time.Second,
+		UpstreamProbeInterval:  10 * time.Minute,
+		FetchResumeAttempts:    3,
+		ShutdownTimeout:        30 * time.Second,
+		GoroutineLeakAge:       time.Hour,
+		ParallelFetchStreams:   4,
+		ParallelFetchMinSize:   32 << 20, // 32MB
+		MaxHeaderBytes:         32 << 10, // 32KB
+		MaxPathLength:          4096,
+		ExpiryReportWindow:     24 * time.Hour,
+		ExpiryReportDepth:      2,
+		PeerTimeout:            50 * time.Millisecond,
+		PeerDigestInterval:     5 * time.Minute,
+		PrefetchWorkers:        4,
+		HotRefreshAhead:        time.Minute,
+		PurgeRefetchRate:       10,
+		PurgeStaleWindow:       time.Minute,
+		StatCacheTTL:           time.Second,
+		CacheErrorBudget:       50,
+		CacheErrorWindow:       time.Minute,
+		TraceSampleRatio:       1,
+		SendForwarded:          true,
+		CompleteOnDisconnect:   true,
+		ListenNoDelay:          true,
 	}
 }
 
@@ -44,14 +277,246 @@ func (c *Config) Validate() error {
 	if c.ListenAddr == "" {
 		return fmt.Errorf("listen_addr is required")
 	}
-	if c.UpstreamURL == "" {
-		return fmt.Errorf("upstream_url is required")
+	if c.UpstreamURL == "" && len(c.Routes) == 0 {
+		return fmt.Errorf("upstream_url or routes is required")
+	}
+	if c.UpstreamURL != "" {
+		if err := validateOriginURL(c.UpstreamURL); err != nil {
+			return fmt.Errorf("upstream_url: %w", err)
+		}
+	}
+	for i := range c.Routes {
+		if err := c.Routes[i].validate(); err != nil {
+			return err
+		}
 	}
-	if _, err := url.Parse(c.UpstreamURL); err != nil {
-		return fmt.Errorf("invalid upstream_url: %w", err)
+	for i := range c.Rewrites {
+		if err := c.Rewrites[i].validate(); err != nil {
+			return err
+		}
+	}
+	if c.AdminListenAddr != "" && c.AdminListenAddr == c.ListenAddr {
+		return fmt.Errorf("admin_listen_addr must differ from listen_addr")
 	}
 	if c.CacheDir == "" {
 		return fmt.Errorf("cache_dir is required")
 	}
+	for _, limit := range c.PrefixFetchLimits {
+		if !strings.HasPrefix(limit.Prefix, "/") || limit.Limit <= 0 {
+			return fmt.Errorf("invalid prefix fetch limit %q=%d", limit.Prefix, limit.Limit)
+		}
+	}
+	if c.ForbiddenCacheTTL < 0 || c.GoneCacheTTL < 0 || c.ServerErrorCacheTTL < 0 {
+		return fmt.Errorf("negative cache TTLs must not be below zero")
+	}
+	if c.NegativeCacheSize < 0 || c.NegativeCacheMemory < 0 {
+		return fmt.Errorf("negative cache limits must not be below zero")
+	}
+	if c.NegativeCacheSize == 0 && c.NegativeCacheMemory == 0 {
+		return fmt.Errorf("negative cache needs an entry or memory limit")
+	}
+	if c.HotRefreshCount < 0 || c.HotRefreshAhead < 0 {
+		return fmt.Errorf("hot refresh settings must not be negative")
+	}
+	if c.HotRefreshCount > 0 && c.HotRefreshAhead == 0 {
+		return fmt.Errorf("hot_refresh_ahead is required for hot refresh")
+	}
+	if c.DisconnectMaxRemaining < 0 || c.DisconnectMinPercent < 0 || c.DisconnectMinPercent > 100 {
+		return fmt.Errorf("disconnect_min_percent must be between 0 and 100 and disconnect_max_remaining must not be negative")
+	}
+	if c.PurgeRefetchRate < 0 || c.PurgeStaleWindow < 0 {
+		return fmt.Errorf("purge refetch settings must not be negative")
+	}
+	if c.PurgeRefetchRate > 0 && c.PurgeStaleWindow == 0 {
+		return fmt.Errorf("purge_stale_window is required for purge refetch")
+	}
+	if c.UpstreamProbeInterval < 0 || c.FetchResumeAttempts < 0 || c.ParallelFetchStreams < 0 || c.ParallelFetchMinSize < 0 {
+		return fmt.Errorf("upstream probe and range fetch settings must not be negative")
+	}
+	if c.MaxRedirects < 0 {
+		return fmt.Errorf("max_redirects must not be negative")
+	}
+	if c.UpstreamRateReserve < 0 || c.UpstreamRateReserve > 1 || c.UpstreamRateMaxWait < 0 {
+		return fmt.Errorf("upstream_rate_reserve must be between 0 and 1 and upstream_rate_max_wait must not be negative")
+	}
+	if c.UpstreamSRV != "" {
+		if u, _ := url.Parse(c.UpstreamURL); u == nil || u.Hostname() == "" {
+			return fmt.Errorf("upstream_srv requires upstream_url")
+		}
+		if c.UpstreamSRVInterval <= 0 {
+			return fmt.Errorf("upstream_srv_interval must be positive")
+		}
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return fmt.Errorf("trace_sample_ratio must be between 0 and 1")
+	}
+	if c.PrefetchWorkers < 0 {
+		return fmt.Errorf("prefetch_workers must not be negative")
+	}
+	if c.ListenRecvBuffer < 0 || c.ListenSendBuffer < 0 || c.KeepAliveIntvl < 0 || c.KeepAliveCount < 0 {
+		return fmt.Errorf("socket buffer and keepalive settings must not be negative")
+	}
+	for _, addr := range []string{c.ListenAddr, c.AdminListenAddr} {
+		if path, ok := unixSocketPath(addr); ok && path == "" {
+			return fmt.Errorf("listen address %q has no socket path", addr)
+		}
+	}
+	if c.ListenSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("listen_socket_mode must only contain permission bits")
+	}
+	if c.ListenSocketOwner != "" {
+		if _, _, err := parseSocketOwner(c.ListenSocketOwner); err != nil {
+			return fmt.Errorf("listen_socket_owner: %w", err)
+		}
+	}
+	if c.MinFreeDiskBytes < 0 || c.MinFreeDiskPercent < 0 || c.MinFreeDiskPercent >= 100 {
+		return fmt.Errorf("min_free_disk settings must be non-negative and below 100%%")
+	}
+	if c.CacheErrorBudget < 0 {
+		return fmt.Errorf("cache_error_budget must not be negative")
+	}
+	if c.CacheErrorBudget > 0 && c.CacheErrorWindow <= 0 {
+		return fmt.Errorf("cache_error_window must be positive")
+	}
+	if !validEvictionPolicy(c.EvictionPolicy) {
+		return fmt.Errorf("eviction_policy must be one of %s", strings.Join(evictionPolicies, ", "))
+	}
+	for _, policy := range c.ShadowPolicies {
+		if _, err := parseShadowPolicy(policy); err != nil {
+			return err
+		}
+	}
+	if c.EvictMinAge < 0 {
+		return fmt.Errorf("evict_min_age must not be negative")
+	}
+	if c.RangeReadahead < 0 {
+		return fmt.Errorf("range_readahead must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout must not be negative")
+	}
+	if c.PendingStallTimeout < 0 {
+		return fmt.Errorf("pending_stall_timeout must not be negative")
+	}
+	if c.MaxStreamReaders < 0 || c.MaxFetchers < 0 || c.MaxBackgroundJobs < 0 {
+		return fmt.Errorf("goroutine limits must not be negative")
+	}
+	if c.GoroutineLeakAge < 0 {
+		return fmt.Errorf("goroutine_leak_age must not be negative")
+	}
+	if c.KeepVersions < 0 {
+		return fmt.Errorf("keep_versions must not be negative")
+	}
+	if c.StatCacheTTL < 0 {
+		return fmt.Errorf("stat_cache_ttl must not be negative")
+	}
+	if c.TextContentTTL < 0 || c.BinaryContentTTL < 0 {
+		return fmt.Errorf("content ttl must not be negative")
+	}
+	if c.MaxCacheTTL > 0 && c.MinCacheTTL > c.MaxCacheTTL {
+		return fmt.Errorf("min_cache_ttl must not exceed max_cache_ttl")
+	}
+	if c.ExpiryReportAt != "" {
+		if _, err := time.Parse("15:04", c.ExpiryReportAt); err != nil {
+			return fmt.Errorf("invalid expiry_report_at %q: expected HH:MM", c.ExpiryReportAt)
+		}
+	}
+	for i := range c.CacheRules {
+		if err := c.CacheRules[i].validate(); err != nil {
+			return err
+		}
+	}
+	for _, name := range append(slices.Clone(c.CacheKeyQuery), c.CacheKeyStrip...) {
+		if strings.ContainsAny(name, "&=") || name == "" && slices.Contains(c.CacheKeyQuery, "") {
+			return fmt.Errorf("invalid cache key query parameter %q", name)
+		}
+	}
+	for i := range c.ExprRules {
+		if err := c.ExprRules[i].compile(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateMirrorProfiles(); err != nil {
+		return err
+	}
+	if err := c.validatePublicURL(); err != nil {
+		return err
+	}
+	if err := validateCORSOrigins(c.CORSOrigins); err != nil {
+		return err
+	}
+	if err := c.validateMiddleware(); err != nil {
+		return err
+	}
+	if c.RateLimit.Rate < 0 || c.RateLimit.Burst < 0 || c.RateLimit.MaxConcurrent < 0 {
+		return fmt.Errorf("rate_limit values must not be negative")
+	}
+	if c.ScanNotFoundLimit > 0 && (c.ScanWindow <= 0 || c.ScanBlockFor <= 0) {
+		return fmt.Errorf("scan protection needs a positive window and block duration")
+	}
+	if c.ScanTarpit < 0 {
+		return fmt.Errorf("scan_tarpit must not be negative")
+	}
+	if _, err := parseCurves(c.TLSCurves); err != nil {
+		return err
+	}
+	if c.MaxBandwidth < 0 || c.MaxConnBandwidth < 0 {
+		return fmt.Errorf("bandwidth limits must not be negative")
+	}
+	for _, key := range c.APIKeys {
+		if key.Key == "" {
+			return fmt.Errorf("api key %q is empty", key.Name)
+		}
+	}
+	if c.ArchiveDir != "" && withinDir(c.CacheDir, c.ArchiveDir) {
+		return fmt.Errorf("archive_dir must not be inside cache_dir") // 快取目錄中的未知檔案會在啟動時被清除
+	}
+	if c.SnapshotDir != "" && withinDir(c.CacheDir, c.SnapshotDir) {
+		return fmt.Errorf("snapshot_dir must not be inside cache_dir")
+	}
+	for _, peer := range c.ReplicationPeers {
+		if err := validateUpstreamURL(peer); err != nil {
+			return fmt.Errorf("replication peer: %w", err)
+		}
+	}
+	if len(c.ReplicationPeers) > 0 && c.AdminToken == "" {
+		return fmt.Errorf("admin_token is required for replication")
+	}
+	if c.StandbyURL != "" {
+		if err := validateUpstreamURL(c.StandbyURL); err != nil {
+			return fmt.Errorf("standby: %w", err)
+		}
+		if c.AdminToken == "" {
+			return fmt.Errorf("admin_token is required for standby replication")
+		}
+	}
+	if c.StandbyHotHits < 0 {
+		return fmt.Errorf("standby_hot_hits must not be negative")
+	}
+	if len(c.PeerAddrs) > 0 && c.PeerListenAddr == "" {
+		return fmt.Errorf("peer_listen_addr is required for cache peers")
+	}
+	if c.PeerListenAddr != "" && c.AdminToken == "" {
+		return fmt.Errorf("admin_token is required for cache peers")
+	}
+	if c.PeerAdvertiseURL != "" {
+		if err := validateUpstreamURL(c.PeerAdvertiseURL); err != nil {
+			return fmt.Errorf("peer_advertise_url: %w", err)
+		}
+	}
+	if c.EnableUpload && c.AdminToken == "" {
+		return fmt.Errorf("admin_token is required for upload")
+	}
 	return nil
 }
+
+// withinDir 判斷 path 是否為 dir 本身或位於其下
+func withinDir(dir, path string) bool {
+	absDir, err1 := filepath.Abs(dir)
+	absPath, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && filepath.IsLocal(rel)
+}
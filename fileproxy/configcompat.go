@@ -0,0 +1,119 @@
+package fileproxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigVersion 目前的配置結構版本，旗標或環境變數改名、移除時遞增
+//
+// 部署可以 --config-version 宣告其配置所依據的版本；舊版本的名稱在啟動時自動轉為新名稱並記錄警告，
+// 宣告的版本比目前新時拒絕啟動，避免較舊的版本默默忽略不認得的設定。
+const ConfigVersion = 2
+
+// Deprecation 一個已改名的旗標與其環境變數
+type Deprecation struct {
+	Since   int    // 改名生效的配置版本
+	Flag    string // 舊旗標名稱，不含 --
+	NewFlag string
+	Env     string // 舊環境變數名稱
+	NewEnv  string
+}
+
+// deprecations 所有已改名的設定，舊名稱在下一個主要版本前都會持續接受
+var deprecations = []Deprecation{
+	// 與兄弟節點的 --peer 容易混淆
+	{Since: 2, Flag: "replication-peer", NewFlag: "replicate-to", Env: "REPLICATION_PEERS", NewEnv: "REPLICATE_TO"},
+}
+
+// ConfigMigration 一次自動轉換的記錄，由呼叫方在日誌初始化後輸出警告
+type ConfigMigration struct {
+	Deprecation
+	Source  string // flag 或 env
+	Ignored bool   // 新名稱已設定，舊名稱的值被忽略
+}
+
+// Old 返回被轉換的舊名稱
+func (m ConfigMigration) Old() string {
+	if m.Source == "env" {
+		return m.Env
+	}
+	return "--" + m.Flag
+}
+
+// New 返回取代舊名稱的新名稱
+func (m ConfigMigration) New() string {
+	if m.Source == "env" {
+		return m.NewEnv
+	}
+	return "--" + m.NewFlag
+}
+
+// CheckConfigVersion 檢查部署宣告的配置版本，0 表示未宣告
+func CheckConfigVersion(version int) error {
+	if version < 0 || version > ConfigVersion {
+		return fmt.Errorf("config version %d is not supported, this build understands versions up to %d", version, ConfigVersion)
+	}
+	return nil
+}
+
+// MigrateArgs 將命令列中已改名的旗標轉為新名稱，-- 之後的參數不變
+//
+// 接受 --old、--old=VALUE 與布林旗標的 --no-old 形式。
+func MigrateArgs(args []string) ([]string, []ConfigMigration) {
+	var migrations []ConfigMigration
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		prefix := "--"
+		if strings.HasPrefix(name, "no-") {
+			prefix, name = "--no-", strings.TrimPrefix(name, "no-")
+		}
+		d, ok := deprecatedFlag(name)
+		if !ok || !strings.HasPrefix(arg, "--") {
+			out = append(out, arg)
+			continue
+		}
+		arg = prefix + d.NewFlag
+		if hasValue {
+			arg += "=" + value
+		}
+		out = append(out, arg)
+		migrations = append(migrations, ConfigMigration{Deprecation: d, Source: "flag"})
+	}
+	return out, migrations
+}
+
+// MigrateEnv 將已改名的環境變數複製到新名稱，新名稱已設定時保留新值
+func MigrateEnv() []ConfigMigration {
+	var migrations []ConfigMigration
+	for _, d := range deprecations {
+		value, ok := os.LookupEnv(d.Env)
+		if !ok {
+			continue
+		}
+		m := ConfigMigration{Deprecation: d, Source: "env"}
+		if _, set := os.LookupEnv(d.NewEnv); set {
+			m.Ignored = true
+		} else {
+			os.Setenv(d.NewEnv, value)
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations
+}
+
+// deprecatedFlag 查詢舊旗標名稱
+func deprecatedFlag(name string) (Deprecation, bool) {
+	for _, d := range deprecations {
+		if d.Flag == name {
+			return d, true
+		}
+	}
+	return Deprecation{}, false
+}
@@ -0,0 +1,94 @@
+package fileproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+const corsMaxAge = 24 * 60 * 60 // 預檢結果的快取秒數
+
+// corsExposed 允許瀏覽器腳本讀取的回應頭
+const corsExposed = "Content-Length, Content-Range, Accept-Ranges, ETag, Last-Modified, X-Cache, X-Request-ID"
+
+// corsPolicy 跨來源請求設定，只允許讀取
+type corsPolicy struct {
+	origins []string // 允許的來源，"*" 表示全部
+	any     bool
+}
+
+// newCORSPolicy 建立跨來源設定，未設定來源時返回 nil
+func newCORSPolicy(cfg *Config) *corsPolicy {
+	if len(cfg.CORSOrigins) == 0 {
+		return nil
+	}
+	return &corsPolicy{origins: cfg.CORSOrigins, any: slices.Contains(cfg.CORSOrigins, "*")}
+}
+
+// validateCORSOrigins 檢查來源格式：* 或 scheme://host[:port]
+func validateCORSOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("invalid cors origin %q: expected * or scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+// allowed 返回回應的 Access-Control-Allow-Origin 值，不允許時返回空字串
+func (c *corsPolicy) allowed(origin string) string {
+	switch {
+	case origin == "":
+		return ""
+	case c.any:
+		return "*"
+	case slices.Contains(c.origins, origin):
+		return origin
+	}
+	return ""
+}
+
+// middleware 為允許的來源加上 CORS 回應頭，並直接回應預檢請求
+//
+// 不允許的來源照常處理，只是沒有 CORS 頭，由瀏覽器阻擋；非 GET/HEAD 的預檢返回 403。
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allow := c.allowed(origin)
+		if !c.any {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allow == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allow)
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Header.Get("Access-Control-Request-Method") {
+		case http.MethodGet, http.MethodHead:
+		default:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
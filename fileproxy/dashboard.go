@@ -0,0 +1,118 @@
+package fileproxy
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dashboardInterval = 10 * time.Second // 取樣間隔
+	dashboardSamples  = 360              // 保留的樣本數（1 小時）
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// dashboard 內建監控面板，定期取樣統計以繪製趨勢
+type dashboard struct {
+	proxy *Proxy
+
+	mu      sync.Mutex
+	samples []dashboardSample
+	last    [3]int64 // 上次取樣的 hits、misses、streaming
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// dashboardSample 單次取樣結果
+type dashboardSample struct {
+	Time      time.Time `json:"time"`
+	Requests  int64     `json:"requests"`
+	HitRatio  float64   `json:"hit_ratio"`
+	TotalSize int64     `json:"total_size"`
+	Pending   int       `json:"pending"`
+}
+
+// newDashboard 建立監控面板並啟動取樣
+func newDashboard(p *Proxy) *dashboard {
+	d := &dashboard{proxy: p, closeCh: make(chan struct{})}
+	d.wg.Add(1)
+	go d.sampleLoop()
+	return d
+}
+
+// Close 停止取樣
+func (d *dashboard) Close() {
+	close(d.closeCh)
+	d.wg.Wait()
+}
+
+// sampleLoop 定期取樣
+func (d *dashboard) sampleLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			d.sample()
+		}
+	}
+}
+
+// sample 記錄一次取樣，命中率以區間增量計算
+func (d *dashboard) sample() {
+	st := &d.proxy.stats
+	cur := [3]int64{st.hits.Load(), st.misses.Load(), st.streaming.Load()}
+
+	pending := d.proxy.cache.PendingCount()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hits, misses, streaming := cur[0]-d.last[0], cur[1]-d.last[1], cur[2]-d.last[2]
+	d.last = cur
+
+	d.samples = append(d.samples, dashboardSample{
+		Time:      time.Now(),
+		Requests:  hits + misses + streaming,
+		HitRatio:  hitRatio(hits, misses, streaming),
+		TotalSize: d.proxy.cache.totalSize.Load(),
+		Pending:   pending,
+	})
+	if len(d.samples) > dashboardSamples {
+		d.samples = d.samples[len(d.samples)-dashboardSamples:]
+	}
+}
+
+// history 返回取樣歷史
+func (d *dashboard) history() []dashboardSample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]dashboardSample(nil), d.samples...)
+}
+
+// assetHandler 提供內嵌的靜態檔案
+func (d *dashboard) assetHandler() http.Handler {
+	sub, _ := fs.Sub(dashboardAssets, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(sub)))
+}
+
+// handleData 返回面板所需的統計、歷史與最近錯誤
+func (d *dashboard) handleData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"stats":   d.proxy.Stats(),
+		"history": d.history(),
+		"errors":  d.proxy.stats.recentErrors(),
+	})
+}
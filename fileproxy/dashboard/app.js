@@ -0,0 +1,110 @@
+"use strict";
+
+const REFRESH_MS = 5000;
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+}
+
+function percent(v) {
+  return (v * 100).toFixed(1) + "%";
+}
+
+function drawChart(canvas, history) {
+  const ctx = canvas.getContext("2d");
+  const w = (canvas.width = canvas.clientWidth);
+  const h = canvas.height;
+  ctx.clearRect(0, 0, w, h);
+
+  ctx.strokeStyle = "#eee";
+  for (let y = 0; y <= 4; y++) {
+    ctx.beginPath();
+    ctx.moveTo(0, (h * y) / 4);
+    ctx.lineTo(w, (h * y) / 4);
+    ctx.stroke();
+  }
+
+  const points = history.filter((s) => s.requests > 0);
+  if (points.length < 2) {
+    return;
+  }
+
+  const t0 = new Date(points[0].time).getTime();
+  const t1 = new Date(points[points.length - 1].time).getTime();
+  const span = Math.max(t1 - t0, 1);
+
+  ctx.strokeStyle = "#3b82f6";
+  ctx.lineWidth = 2;
+  ctx.beginPath();
+  points.forEach((s, i) => {
+    const x = ((new Date(s.time).getTime() - t0) / span) * w;
+    const y = h - s.hit_ratio * h;
+    if (i === 0) {
+      ctx.moveTo(x, y);
+    } else {
+      ctx.lineTo(x, y);
+    }
+  });
+  ctx.stroke();
+}
+
+function renderErrors(tbody, errors) {
+  tbody.replaceChildren();
+  for (const e of errors) {
+    const tr = document.createElement("tr");
+    for (const text of [new Date(e.time).toLocaleString(), e.key, e.error]) {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.appendChild(td);
+    }
+    tbody.appendChild(tr);
+  }
+}
+
+// 獨立管理監聽且設定 Token 時，以 /dashboard/#token=... 開啟面板
+function authHeaders() {
+  const token = new URLSearchParams(location.hash.slice(1)).get("token");
+  return token ? { Authorization: "Bearer " + token } : {};
+}
+
+async function refresh() {
+  const resp = await fetch("data", { cache: "no-store", headers: authHeaders() });
+  if (!resp.ok) {
+    throw new Error("status " + resp.status);
+  }
+  const data = await resp.json();
+  const cache = data.stats.cache;
+  const req = data.stats.http;
+
+  document.getElementById("hit-ratio").textContent = percent(req.hit_ratio);
+  document.getElementById("requests").textContent =
+    req.hits + req.misses + req.streaming;
+  document.getElementById("entries").textContent = cache.file_entries;
+  document.getElementById("pending").textContent = cache.pending;
+  document.getElementById("usage-bar").style.width =
+    Math.min(cache.usage_percent, 100) + "%";
+  document.getElementById("usage").textContent =
+    formatBytes(cache.total_size) + " / " + formatBytes(cache.max_size) +
+    " (" + cache.usage_percent.toFixed(1) + "%)";
+
+  drawChart(document.getElementById("hit-chart"), data.history);
+  renderErrors(document.getElementById("errors"), data.errors);
+  document.getElementById("updated").textContent =
+    new Date().toLocaleTimeString();
+}
+
+function loop() {
+  refresh()
+    .catch((err) => {
+      document.getElementById("updated").textContent = "更新失敗: " + err.message;
+    })
+    .finally(() => setTimeout(loop, REFRESH_MS));
+}
+
+loop();
@@ -0,0 +1,176 @@
+package fileproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	guardTick      = time.Second
+	guardProbeFile = ".probe" // 探測磁碟是否恢復時寫入的暫存檔
+)
+
+// cacheGuard 快取子系統的錯誤預算
+//
+// 磁碟寫入、提交、開啟與索引寫入失敗都計入 Cache 的錯誤數。時間窗內的錯誤超過預算時
+// 改為純透傳：請求直接回源、不讀寫快取，客戶端下載不受本機磁碟故障影響，/health
+// 返回 degraded。降級一個時間窗後在快取目錄寫入探測檔，成功才恢復快取。
+type cacheGuard struct {
+	cache  *Cache
+	budget int64
+	window time.Duration
+
+	degraded atomic.Bool
+	trips    atomic.Int64
+
+	mu      sync.Mutex
+	buckets []int64 // 每個 guardTick 的錯誤數，環狀使用
+	pos     int
+	last    int64     // 上次取樣時 Cache 的累計錯誤數
+	since   time.Time // 進入降級的時間
+	reason  string
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newCacheGuard 建立錯誤預算監控，預算為 0 時返回 nil
+func newCacheGuard(cfg *Config, cache *Cache) *cacheGuard {
+	if cfg.CacheErrorBudget <= 0 {
+		return nil
+	}
+	g := &cacheGuard{
+		cache:   cache,
+		budget:  int64(cfg.CacheErrorBudget),
+		window:  cfg.CacheErrorWindow,
+		buckets: make([]int64, max(1, int(cfg.CacheErrorWindow/guardTick))),
+		last:    cache.Failures(),
+		closeCh: make(chan struct{}),
+	}
+	g.wg.Add(1)
+	go g.loop()
+	return g
+}
+
+// Close 停止監控
+func (g *cacheGuard) Close() {
+	close(g.closeCh)
+	g.wg.Wait()
+}
+
+// Degraded 是否處於純透傳模式，nil 時返回 false
+func (g *cacheGuard) Degraded() bool {
+	return g != nil && g.degraded.Load()
+}
+
+// loop 定期取樣錯誤數並決定是否降級或恢復
+func (g *cacheGuard) loop() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(guardTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.closeCh:
+			return
+		case <-ticker.C:
+			g.check()
+		}
+	}
+}
+
+// check 記錄這一輪的新錯誤，超出預算時降級，降級滿一個時間窗後探測磁碟
+func (g *cacheGuard) check() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	failures := g.cache.Failures()
+	g.pos = (g.pos + 1) % len(g.buckets)
+	g.buckets[g.pos] = failures - g.last
+	g.last = failures
+
+	if g.degraded.Load() {
+		if time.Since(g.since) < g.window {
+			return
+		}
+		if err := g.probe(); err != nil {
+			g.since = time.Now()
+			g.reason = err.Error()
+			slog.Warn("cache still failing, staying in passthrough mode", "error", err)
+			return
+		}
+		clear(g.buckets)
+		g.degraded.Store(false)
+		slog.Info("cache recovered, leaving passthrough mode", "degraded_for", time.Since(g.since).Round(time.Second))
+		return
+	}
+
+	var total int64
+	for _, n := range g.buckets {
+		total += n
+	}
+	if total > g.budget {
+		g.since = time.Now()
+		g.reason = fmt.Sprintf("%d cache errors within %s", total, g.window)
+		g.trips.Add(1)
+		g.degraded.Store(true)
+		slog.Error("cache error budget exhausted, switching to passthrough mode", "errors", total, "window", g.window)
+	}
+}
+
+// probe 在快取目錄寫入並刪除探測檔，確認磁碟可寫
+func (g *cacheGuard) probe() error {
+	path := filepath.Join(g.cache.config.CacheDir, guardProbeFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+	_, err = f.Write([]byte("probe"))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("write probe file: %w", err)
+	}
+	return nil
+}
+
+// Health 返回降級狀態與原因，未降級時 reason 為空
+func (g *cacheGuard) Health() (bool, string) {
+	if !g.Degraded() {
+		return false, ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return true, g.reason
+}
+
+// Stats 返回錯誤預算狀態
+func (g *cacheGuard) Stats() map[string]any {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var recent int64
+	for _, n := range g.buckets {
+		recent += n
+	}
+	stats := map[string]any{
+		"degraded": g.degraded.Load(),
+		"budget":   g.budget,
+		"window":   g.window.String(),
+		"recent":   recent,
+		"trips":    g.trips.Load(),
+	}
+	if g.degraded.Load() {
+		stats["since"] = g.since
+		stats["reason"] = g.reason
+	}
+	return stats
+}
@@ -0,0 +1,210 @@
+package fileproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	digestPath        = "/admin/digest" // 快取摘要端點
+	digestMagic       = "FPD1"
+	digestBitsPerKey  = 10       // 約 1% 誤判率
+	digestHashes      = 7        // 每個 key 設定的位元數
+	digestMinBits     = 1 << 13  // 最小 8Kbit，避免空快取的摘要過小
+	digestMaxSize     = 64 << 20 // 接收摘要的大小上限
+	digestHeaderSize  = 4 + 1 + 8
+	peerTypeAnnounce  = 3 // 摘要更新通知，payload 為摘要所在的 HTTP 地址
+	digestStaleFactor = 2 // 摘要超過 2 個週期未更新時不再使用
+)
+
+// bloomFilter 以 key 的 SHA-256 建立的 Bloom filter
+//
+// 雜湊本身已均勻分佈，以前 16 位元組做雙重雜湊即可得到 k 個位置。
+type bloomFilter struct {
+	bits []uint64
+	k    uint8
+}
+
+// newBloomFilter 依預期條目數建立 Bloom filter
+func newBloomFilter(n int) *bloomFilter {
+	m := max(n*digestBitsPerKey, digestMinBits)
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), k: digestHashes}
+}
+
+// locate 對 hash 的每個位置呼叫 fn，fn 返回 false 時停止
+func (b *bloomFilter) locate(hash keyHash, fn func(word int, mask uint64) bool) {
+	m := uint64(len(b.bits)) * 64
+	h1 := binary.LittleEndian.Uint64(hash[0:])
+	h2 := binary.LittleEndian.Uint64(hash[8:]) | 1
+	for i := range uint64(b.k) {
+		pos := (h1 + i*h2) % m
+		if !fn(int(pos/64), 1<<(pos%64)) {
+			return
+		}
+	}
+}
+
+// add 加入 key 雜湊
+func (b *bloomFilter) add(hash keyHash) {
+	b.locate(hash, func(word int, mask uint64) bool {
+		b.bits[word] |= mask
+		return true
+	})
+}
+
+// mayContain 返回 false 表示一定不存在
+func (b *bloomFilter) mayContain(hash keyHash) bool {
+	found := true
+	b.locate(hash, func(word int, mask uint64) bool {
+		found = b.bits[word]&mask != 0
+		return found
+	})
+	return found
+}
+
+// encode 序列化：magic(4) | k(1) | 字數(8) | 位元陣列
+func (b *bloomFilter) encode() []byte {
+	buf := make([]byte, 0, digestHeaderSize+len(b.bits)*8)
+	buf = append(buf, digestMagic...)
+	buf = append(buf, b.k)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(b.bits)))
+	for _, w := range b.bits {
+		buf = binary.BigEndian.AppendUint64(buf, w)
+	}
+	return buf
+}
+
+// decodeBloomFilter 反序列化 Bloom filter
+func decodeBloomFilter(data []byte) (*bloomFilter, error) {
+	if len(data) < digestHeaderSize || string(data[:4]) != digestMagic {
+		return nil, errors.New("invalid digest header")
+	}
+	k := data[4]
+	words := binary.BigEndian.Uint64(data[5:])
+	if k == 0 || words == 0 || uint64(len(data)-digestHeaderSize) != words*8 {
+		return nil, errors.New("invalid digest size")
+	}
+	b := &bloomFilter{bits: make([]uint64, words), k: k}
+	for i := range b.bits {
+		b.bits[i] = binary.BigEndian.Uint64(data[digestHeaderSize+i*8:])
+	}
+	return b, nil
+}
+
+// Digest 建立目前所有快取 key 的 Bloom filter
+func (c *Cache) Digest() *bloomFilter {
+	b := newBloomFilter(c.fileCache.Len())
+	c.fileCache.forEach(func(e *CacheEntry) { b.add(e.hash) })
+	return b
+}
+
+// peerDigest 兄弟節點的快取摘要
+type peerDigest struct {
+	filter    *bloomFilter
+	updatedAt time.Time
+}
+
+// digestLoop 定期重建本機摘要並通知兄弟節點
+func (pl *peerLookup) digestLoop() {
+	defer pl.wg.Done()
+	ticker := time.NewTicker(pl.digestInterval)
+	defer ticker.Stop()
+
+	for {
+		pl.rebuildDigest()
+		select {
+		case <-pl.closeCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebuildDigest 重建本機摘要並向兄弟節點發送更新通知
+func (pl *peerLookup) rebuildDigest() {
+	start := time.Now()
+	data := pl.cache.Digest().encode()
+	pl.digest.Store(&data)
+	slog.Debug("cache digest rebuilt", "bytes", len(data), "duration", time.Since(start))
+
+	if pl.advertise == "" {
+		return
+	}
+	pkt := pl.sign(packet(peerTypeAnnounce, 0, keyHash{}, pl.advertise))
+	for _, peer := range pl.peers {
+		if _, err := pl.conn.WriteToUDP(pkt, peer); err != nil {
+			slog.Debug("digest announce failed", "peer", peer, "error", err)
+		}
+	}
+}
+
+// fetchDigest 下載兄弟節點的摘要
+func (pl *peerLookup) fetchDigest(peer, base string) {
+	defer pl.wg.Done()
+	if err := pl.loadDigest(peer, base); err != nil {
+		slog.Warn("peer digest fetch failed", "peer", peer, "error", err)
+		return
+	}
+	pl.digestsFetched.Add(1)
+}
+
+// loadDigest 下載並解析兄弟節點的摘要
+func (pl *peerLookup) loadDigest(peer, base string) error {
+	req, err := http.NewRequest(http.MethodGet, buildUpstreamURL(base, digestPath), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(pl.secret))
+	resp, err := pl.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("digest: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, digestMaxSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > digestMaxSize {
+		return errors.New("digest too large")
+	}
+	filter, err := decodeBloomFilter(data)
+	if err != nil {
+		return err
+	}
+
+	pl.mu.Lock()
+	pl.digests[peer] = &peerDigest{filter: filter, updatedAt: time.Now()}
+	pl.mu.Unlock()
+	return nil
+}
+
+// mayHave 依摘要判斷兄弟節點是否可能有 key，沒有有效摘要時返回 true
+func (pl *peerLookup) mayHave(peer string, hash keyHash, now time.Time) bool {
+	pl.mu.Lock()
+	d, ok := pl.digests[peer]
+	pl.mu.Unlock()
+	if !ok || now.Sub(d.updatedAt) > digestStaleFactor*pl.digestInterval {
+		return true
+	}
+	return d.filter.mayContain(hash)
+}
+
+// handleDigest 返回本機快取摘要
+func (p *Proxy) handleDigest(w http.ResponseWriter, r *http.Request) {
+	data := p.peers.digest.Load()
+	if data == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(*data)
+}
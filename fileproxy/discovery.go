@@ -0,0 +1,274 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errNoSRVTargets 表示 SRV 記錄尚未解析成功或沒有可用目標
+var errNoSRVTargets = errors.New("no upstream srv targets")
+
+// srvTarget SRV 記錄中的單一目標
+type srvTarget struct {
+	addr     string // host:port
+	priority uint16
+	weight   uint16
+	dials    atomic.Int64
+	failures atomic.Int64
+	retired  atomic.Bool // 已從記錄中移除，連線在下次使用前關閉
+}
+
+// srvDiscovery 以 DNS SRV 記錄決定上游主機的連線目標
+//
+// 上游 URL 的主機名稱不變（Host 頭與 TLS SNI 照舊），只有撥號時改連 SRV 目標：
+// 最低 priority 的目標依 weight 隨機選擇，撥號失敗時依序改用其他目標。記錄定期
+// 重新解析，目標被移除時關閉閒置連線，使用中的連線在請求結束後不再重用，新連線
+// 依最新記錄重新分配。
+type srvDiscovery struct {
+	name     string // SRV 名稱，例如 _https._tcp.files.example.com
+	host     string // 上游 URL 的主機名稱
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	resolver *net.Resolver
+	interval time.Duration
+
+	mu        sync.RWMutex
+	targets   []*srvTarget // 依 priority 排序
+	resolved  time.Time
+	lastError string
+
+	transport *http.Transport // 記錄變更時關閉閒置連線
+	changes   atomic.Int64
+	errors    atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newSRVDiscovery 建立 SRV 探索，未設定 SRV 名稱時返回 nil
+//
+// 首次解析失敗時只記錄警告，背景持續重試，期間的回源請求返回錯誤。
+func newSRVDiscovery(cfg *Config, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *srvDiscovery {
+	if cfg.UpstreamSRV == "" {
+		return nil
+	}
+	u, _ := url.Parse(cfg.UpstreamURL)
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	d := &srvDiscovery{
+		name:     cfg.UpstreamSRV,
+		host:     u.Hostname(),
+		dial:     dial,
+		resolver: net.DefaultResolver,
+		interval: cfg.UpstreamSRVInterval,
+		closeCh:  make(chan struct{}),
+	}
+	if err := d.resolve(); err != nil {
+		slog.Warn("resolve upstream srv failed", "name", d.name, "error", err)
+	}
+	d.wg.Add(1)
+	go d.loop()
+	return d
+}
+
+// Close 停止重新解析
+func (d *srvDiscovery) Close() {
+	close(d.closeCh)
+	d.wg.Wait()
+}
+
+// loop 定期重新解析 SRV 記錄
+func (d *srvDiscovery) loop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			if err := d.resolve(); err != nil {
+				slog.Warn("resolve upstream srv failed, keeping previous targets", "name", d.name, "error", err)
+			}
+		}
+	}
+}
+
+// resolve 查詢 SRV 記錄並更新目標，失敗時保留原有目標
+func (d *srvDiscovery) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err == nil && len(records) == 0 {
+		err = errNoSRVTargets
+	}
+	if err != nil {
+		d.errors.Add(1)
+		d.mu.Lock()
+		d.lastError = err.Error()
+		d.mu.Unlock()
+		return err
+	}
+
+	d.mu.Lock()
+	old := d.targets
+	targets := make([]*srvTarget, 0, len(records))
+	for _, rec := range records {
+		addr := net.JoinHostPort(trimDot(rec.Target), strconv.Itoa(int(rec.Port)))
+		t := &srvTarget{addr: addr, priority: rec.Priority, weight: rec.Weight}
+		// 沿用既有目標以保留計數；priority 或 weight 改變時一併更新
+		if i := slices.IndexFunc(old, func(o *srvTarget) bool { return o.addr == addr }); i >= 0 {
+			t = old[i]
+			t.priority, t.weight = rec.Priority, rec.Weight
+		}
+		targets = append(targets, t)
+	}
+	slices.SortStableFunc(targets, func(a, b *srvTarget) int { return int(a.priority) - int(b.priority) })
+
+	var removed []string
+	for _, o := range old {
+		if !slices.Contains(targets, o) {
+			o.retired.Store(true)
+			removed = append(removed, o.addr)
+		}
+	}
+	added := len(targets) - (len(old) - len(removed))
+	d.targets = targets
+	d.resolved = time.Now()
+	d.lastError = ""
+	d.mu.Unlock()
+
+	if old != nil && (len(removed) > 0 || added > 0) {
+		d.changes.Add(1)
+		slog.Info("upstream srv targets changed", "name", d.name, "targets", len(targets), "added", added, "removed", removed)
+		// 閒置連線可能集中在舊目標上，關閉後新連線依最新記錄重新分配
+		if d.transport != nil {
+			d.transport.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// trimDot 去掉 DNS 名稱結尾的點
+func trimDot(name string) string {
+	if n := len(name); n > 0 && name[n-1] == '.' {
+		return name[:n-1]
+	}
+	return name
+}
+
+// order 返回本次撥號嘗試的目標順序：最低 priority 群組依 weight 隨機選出第一個，
+// 其餘目標依 priority 排在後面作為備援
+func (d *srvDiscovery) order() []*srvTarget {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	targets := slices.Clone(d.targets)
+	if len(targets) < 2 {
+		return targets
+	}
+
+	group := 1
+	for group < len(targets) && targets[group].priority == targets[0].priority {
+		group++
+	}
+	total := 0
+	for _, t := range targets[:group] {
+		total += int(t.weight)
+	}
+	pick := rand.IntN(group)
+	if total > 0 {
+		n := rand.IntN(total)
+		for i, t := range targets[:group] {
+			if n -= int(t.weight); n < 0 {
+				pick = i
+				break
+			}
+		}
+	}
+	targets[0], targets[pick] = targets[pick], targets[0]
+	return targets
+}
+
+// DialContext 連往上游主機時改連 SRV 目標，其他地址照常撥號
+func (d *srvDiscovery) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host != d.host {
+		return d.dial(ctx, network, addr)
+	}
+
+	targets := d.order()
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("dial %s: %w", d.name, errNoSRVTargets)
+	}
+	var lastErr error
+	for _, t := range targets {
+		conn, err := d.dial(ctx, network, t.addr)
+		if err == nil {
+			t.dials.Add(1)
+			return &srvConn{Conn: conn, target: t}, nil
+		}
+		t.failures.Add(1)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		slog.Debug("dial upstream srv target failed", "target", t.addr, "error", err)
+	}
+	return nil, lastErr
+}
+
+// srvConn 目標被移除後，連線在下一次寫入（即下一個請求）前關閉
+//
+// Transport 對重用連線上尚未送出的 GET 請求會自動改用新連線重試，因此不影響請求。
+type srvConn struct {
+	net.Conn
+	target *srvTarget
+}
+
+func (c *srvConn) Write(b []byte) (int, error) {
+	if c.target.retired.Load() {
+		c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(b)
+}
+
+// Stats 返回 SRV 目標與解析狀態
+func (d *srvDiscovery) Stats() map[string]any {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	targets := make([]map[string]any, 0, len(d.targets))
+	for _, t := range d.targets {
+		targets = append(targets, map[string]any{
+			"addr":     t.addr,
+			"priority": t.priority,
+			"weight":   t.weight,
+			"dials":    t.dials.Load(),
+			"failures": t.failures.Load(),
+		})
+	}
+	stats := map[string]any{
+		"name":    d.name,
+		"targets": targets,
+		"changes": d.changes.Load(),
+		"errors":  d.errors.Load(),
+	}
+	if !d.resolved.IsZero() {
+		stats["resolved_at"] = d.resolved
+	}
+	if d.lastError != "" {
+		stats["last_error"] = d.lastError
+	}
+	return stats
+}
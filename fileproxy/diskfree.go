@@ -0,0 +1,71 @@
+package fileproxy
+
+import (
+	"log/slog"
+	"math"
+	"time"
+)
+
+const diskWatchInterval = 5 * time.Second // 檢查快取檔案系統剩餘空間的間隔
+
+// diskWatermark 依配置計算最低剩餘空間（位元組），兩者都設定時取較大者
+func (c *Cache) diskWatermark(total uint64) uint64 {
+	mark := uint64(max(c.config.MinFreeDiskBytes, 0))
+	if pct := c.config.MinFreeDiskPercent; pct > 0 {
+		mark = max(mark, uint64(float64(total)*pct/100))
+	}
+	return mark
+}
+
+// diskWatchLoop 定期檢查快取所在檔案系統，剩餘空間低於水位時依淘汰策略淘汰條目
+//
+// 與 MaxCacheSize 無關：共用磁碟上的其他程式也會佔用空間，只看快取自身大小仍可能寫滿磁碟。
+func (c *Cache) diskWatchLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(diskWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.enforceDiskWatermark()
+		}
+	}
+}
+
+// enforceDiskWatermark 依淘汰策略淘汰條目直到釋放的空間補足水位差額
+//
+// 仍在讀取中的檔案延後刪除，釋放的空間在下一輪才反映，因此只淘汰差額所需的量。
+func (c *Cache) enforceDiskWatermark() {
+	free, total, err := diskSpace(c.config.CacheDir)
+	if err != nil {
+		slog.Warn("check cache disk space failed", "error", err)
+		return
+	}
+	c.diskFree.Store(int64(free))
+	mark := c.diskWatermark(total)
+	if free >= mark {
+		return
+	}
+
+	need := int64(mark - free)
+	var freed int64
+	evicted := 0
+	for freed < need {
+		before := c.totalSize.Load()
+		if !c.fileCache.RemoveVictim(math.MaxInt64, removeDisk) {
+			break
+		}
+		freed += before - c.totalSize.Load()
+		evicted++
+	}
+	if evicted == 0 {
+		slog.Debug("cache disk below free space watermark, nothing left to evict", "free", free, "watermark", mark)
+		return
+	}
+	c.diskEvictions.Add(int64(evicted))
+	slog.Warn("cache disk below free space watermark, evicted entries",
+		"free", free, "watermark", mark, "evicted", evicted, "freed", freed)
+}
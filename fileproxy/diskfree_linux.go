@@ -0,0 +1,12 @@
+package fileproxy
+
+import "golang.org/x/sys/unix"
+
+// diskSpace 返回 dir 所在檔案系統的可用空間與總容量（位元組）
+func diskSpace(dir string) (free, total uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fileproxy
+
+import "errors"
+
+// diskSpace 非 Linux 平台不支援查詢剩餘空間
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk free space watermark is only supported on linux")
+}
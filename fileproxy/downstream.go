@@ -0,0 +1,67 @@
+package fileproxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setCacheHeaders 為快取回應輸出下游快取使用的回應頭
+//
+// max-age 為條目的年齡加上剩餘存活時間，下游以 max-age 減去 Age 計算新鮮度，
+// 因此恰好在本地條目過期時過期；不可變條目以一年的 max-age 加上 immutable 回應。STALE 回應以 no-cache 要求下游每次重新驗證；
+// 轉發憑證的請求標記為 private，不進入共享快取。
+func (p *Proxy) setCacheHeaders(w http.ResponseWriter, r *http.Request, entry *CacheEntry, status string) {
+	now := time.Now()
+	age := max(now.UnixNano()-entry.createdAt, 0) / int64(time.Second)
+	remaining := max(entry.expiresAt.Load()-now.UnixNano(), 0) / int64(time.Second)
+
+	directive := "public"
+	if p.config.ForwardAuthorization && r.Header.Get("Authorization") != "" {
+		directive = "private"
+	}
+	switch {
+	case status == "STALE" || status == "VERSION" || remaining == 0: // 舊版本隨之後的更新改變，下游須重新驗證
+		directive += ", no-cache"
+	case entry.immutable():
+		directive += ", max-age=" + strconv.Itoa(immutableMaxAge) + ", immutable"
+	default:
+		directive += ", max-age=" + strconv.FormatInt(age+remaining, 10)
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", directive)
+	h.Set("Age", strconv.FormatInt(age, 10))
+	h.Set("ETag", entryETag(entry))
+	h.Set("Last-Modified", entryLastModified(entry).UTC().Format(http.TimeFormat))
+}
+
+// notModified 依 If-None-Match 與 If-Modified-Since 判斷是否返回 304（RFC 9110 13.2.2）
+//
+// 有 If-None-Match 時忽略 If-Modified-Since；If-None-Match 使用弱比較。
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// etagListMatches 檢查 If-None-Match 清單是否以弱比較匹配 etag
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
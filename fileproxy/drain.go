@@ -0,0 +1,74 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// errShuttingDown 表示關閉期限已到，進行中的下載被中止
+var errShuttingDown = errors.New("server shutting down")
+
+const (
+	drainPollInterval = 100 * time.Millisecond
+	shutdownGrace     = 5 * time.Second // 中止剩餘的下載後等待請求結束的時間
+)
+
+// Drain 開始關閉，之後發起者斷線的下載一律在背景完成，不依斷線策略放棄
+func (p *Proxy) Drain() {
+	p.draining.Store(true)
+}
+
+// WaitFills 等待進行中的下載完成並寫入快取索引，ctx 結束時中止剩餘的下載，返回中止的數量
+//
+// 中止後再等待最多 shutdownGrace 讓發起者清理暫存檔，之後才能關閉索引。
+func (c *Cache) WaitFills(ctx context.Context) int {
+	if n := c.filling.Load(); n > 0 {
+		slog.Info("waiting for in-flight downloads", "downloads", n)
+	}
+	if c.pollFills(ctx) {
+		return 0
+	}
+
+	aborted := c.abortPending(errShuttingDown)
+	if aborted > 0 {
+		slog.Warn("shutdown deadline reached, aborting downloads", "downloads", aborted)
+	}
+	grace, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if !c.pollFills(grace) {
+		slog.Warn("downloads still running after abort", "downloads", c.filling.Load())
+	}
+	return aborted
+}
+
+// pollFills 等待進行中的下載數歸零，ctx 先結束時返回 false
+func (c *Cache) pollFills(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for c.filling.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// abortPending 以 err 中止所有進行中的下載並移出進行中的下載，返回中止的數量
+func (c *Cache) abortPending(err error) int {
+	c.pendingMu.Lock()
+	files := make([]*StreamingFile, 0, len(c.pending))
+	for key, sf := range c.pending {
+		delete(c.pending, key)
+		files = append(files, sf)
+	}
+	c.pendingMu.Unlock()
+
+	for _, sf := range files {
+		sf.interrupt(err)
+	}
+	return len(files)
+}
@@ -0,0 +1,221 @@
+package fileproxy
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cacheEntriesPath  = "/admin/cache/entries" // 快取條目列表端點
+	defaultEntryLimit = 100
+	maxEntryLimit     = 1000
+)
+
+// errInvalidEntryQuery 表示排序欄位或游標無效
+var errInvalidEntryQuery = errors.New("invalid entry query")
+
+// entrySorts 支援的排序欄位，size、hits 與 age 由大到小，key 與 expiry 由小到大
+var entrySorts = []string{"key", "size", "hits", "age", "expiry"}
+
+// EntryInfo 單一快取條目
+type EntryInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	Age         string    `json:"age"`
+	Hits        int64     `json:"hits"` // 近期命中次數，啟用熱門條目刷新時定期衰減
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	Immutable   bool      `json:"immutable,omitempty"` // 不可變條目沒有 expires_at
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+// EntryList 條目列表的一頁
+type EntryList struct {
+	Entries    []EntryInfo `json:"entries"`
+	Total      int         `json:"total"`                 // 符合篩選條件的條目數
+	NextCursor string      `json:"next_cursor,omitempty"` // 為空表示已是最後一頁
+}
+
+// EntryQuery 條目列表的篩選與分頁參數
+type EntryQuery struct {
+	Prefix string
+	Tag    string
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// entryCursor 上一頁最後一筆的排序值與 key，下一頁從其後開始，條目增減時不會重複或跳過
+type entryCursor struct {
+	Value int64  `json:"v"`
+	Key   string `json:"k"`
+}
+
+// listedEntry 解析 key 後的條目與排序值
+type listedEntry struct {
+	key   string
+	entry *CacheEntry
+	value int64
+}
+
+// ListEntries 列出 key 以 prefix 開頭（且帶有 tag）的條目，舊版索引沒有記錄 key 的條目不列出
+func (c *Cache) ListEntries(q EntryQuery) (*EntryList, error) {
+	if q.Sort == "" {
+		q.Sort = "key"
+	}
+	if !slices.Contains(entrySorts, q.Sort) {
+		return nil, fmt.Errorf("%w: unknown sort %q", errInvalidEntryQuery, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultEntryLimit
+	}
+	q.Limit = min(q.Limit, maxEntryLimit)
+	var cursor *entryCursor
+	if q.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		cursor = &entryCursor{}
+		if err != nil || json.Unmarshal(raw, cursor) != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", errInvalidEntryQuery)
+		}
+	}
+
+	entries := make(map[keyHash]*CacheEntry)
+	c.fileCache.forEach(func(e *CacheEntry) {
+		if q.Tag == "" || e.hasTag(q.Tag) {
+			entries[e.hash] = e
+		}
+	})
+	hashes := make([]keyHash, 0, len(entries))
+	for hash := range entries {
+		hashes = append(hashes, hash)
+	}
+	slices.SortFunc(hashes, func(a, b keyHash) int { return bytes.Compare(a[:], b[:]) })
+
+	now := time.Now()
+	var listed []listedEntry
+	err := c.store.Keys(hashes, func(hash keyHash, key string) {
+		if strings.HasPrefix(key, q.Prefix) {
+			e := entries[hash]
+			listed = append(listed, listedEntry{key: key, entry: e, value: sortValue(q.Sort, e)})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	compare := func(av int64, ak string, bv int64, bk string) int {
+		switch q.Sort {
+		case "key":
+			return strings.Compare(ak, bk)
+		case "expiry":
+			return cmp.Or(cmp.Compare(av, bv), strings.Compare(ak, bk))
+		default:
+			return cmp.Or(cmp.Compare(bv, av), strings.Compare(ak, bk))
+		}
+	}
+	slices.SortFunc(listed, func(a, b listedEntry) int { return compare(a.value, a.key, b.value, b.key) })
+
+	list := &EntryList{Total: len(listed), Entries: []EntryInfo{}}
+	start := 0
+	if cursor != nil {
+		start, _ = slices.BinarySearchFunc(listed, cursor, func(e listedEntry, c *entryCursor) int {
+			if compare(e.value, e.key, c.Value, c.Key) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	page := listed[start:min(start+q.Limit, len(listed))]
+	for _, l := range page {
+		list.Entries = append(list.Entries, entryInfo(l.key, l.entry, now))
+	}
+	if start+len(page) < len(listed) {
+		last := page[len(page)-1]
+		raw, _ := json.Marshal(entryCursor{Value: last.value, Key: last.key})
+		list.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return list, nil
+}
+
+// entryInfo 返回條目的列表資訊
+func entryInfo(key string, e *CacheEntry, now time.Time) EntryInfo {
+	created := time.Unix(0, e.createdAt)
+	info := EntryInfo{
+		Key:         key,
+		Size:        e.Size,
+		ContentType: e.ContentType(),
+		CreatedAt:   created,
+		Age:         now.Sub(created).Round(time.Second).String(),
+		Hits:        e.hits.Load(),
+		Tags:        e.Tags(),
+	}
+	if e.immutable() {
+		info.Immutable = true
+	} else {
+		info.ExpiresAt = time.Unix(0, e.expiresAt.Load())
+	}
+	return info
+}
+
+// sortValue 返回條目在排序欄位上的值，key 排序不使用
+func sortValue(sort string, e *CacheEntry) int64 {
+	switch sort {
+	case "size":
+		return e.Size
+	case "hits":
+		return e.hits.Load()
+	case "age":
+		return -e.createdAt // 越早建立越大
+	case "expiry":
+		return e.expiresAt.Load()
+	}
+	return 0
+}
+
+// handleCacheEntries 分頁列出快取條目，支援 ?prefix=&tag=&sort=&limit=&cursor=
+func (p *Proxy) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := EntryQuery{
+		Prefix: query.Get("prefix"),
+		Tag:    query.Get("tag"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	list, err := p.cache.ListEntries(q)
+	if errors.Is(err, errInvalidEntryQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		slog.Error("list cache entries failed", "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
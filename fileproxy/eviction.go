@@ -0,0 +1,119 @@
+package fileproxy
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+)
+
+// 淘汰策略
+const (
+	evictLRU  = "lru"  // 最久未使用
+	evictLFU  = "lfu"  // 最少使用，含動態老化
+	evictGDSF = "gdsf" // 依大小加權的最少使用，優先淘汰大而冷的檔案
+	evictFIFO = "fifo" // 最早加入，命中不改變順序
+)
+
+// evictionPolicies 支援的淘汰策略
+var evictionPolicies = []string{evictLRU, evictLFU, evictGDSF, evictFIFO}
+
+// validEvictionPolicy 檢查淘汰策略名稱
+func validEvictionPolicy(policy string) bool {
+	return slices.Contains(evictionPolicies, policy)
+}
+
+// evictionHeap 依優先值排列的最小堆，LFU 與 GDSF 從堆頂淘汰
+type evictionHeap []*CacheEntry
+
+func (h evictionHeap) Len() int           { return len(h) }
+func (h evictionHeap) Less(i, j int) bool { return h[i].prio < h[j].prio }
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIdx = int32(i)
+	h[j].heapIdx = int32(j)
+}
+
+func (h *evictionHeap) Push(x any) {
+	e := x.(*CacheEntry)
+	e.heapIdx = int32(len(*h))
+	*h = append(*h, e)
+}
+
+func (h *evictionHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.heapIdx = -1
+	*h = old[:len(old)-1]
+	return e
+}
+
+// usesHeap 策略是否以優先值而非鏈結順序決定淘汰對象
+func (idx *entryIndex) usesHeap() bool {
+	return idx.policy == evictLFU || idx.policy == evictGDSF
+}
+
+// priority 計算條目的優先值：老化基準加上命中次數，GDSF 再除以大小
+//
+// 老化基準為上一個被淘汰條目的優先值，過去熱門但已不再命中的條目最終會被新條目超越。
+func (idx *entryIndex) priority(e *CacheEntry) float64 {
+	weight := float64(e.freq)
+	if idx.policy == evictGDSF {
+		weight /= float64(max(e.Size, 1))
+	}
+	return idx.inflation + weight
+}
+
+// track 將新加入的條目放入堆
+func (idx *entryIndex) track(e *CacheEntry) {
+	if !idx.usesHeap() {
+		return
+	}
+	e.freq = max(e.freq, 1)
+	e.prio = idx.priority(e)
+	heap.Push(&idx.heap, e)
+}
+
+// touch 命中時提高條目的優先值
+func (idx *entryIndex) touch(e *CacheEntry) {
+	if !idx.usesHeap() || e.heapIdx < 0 {
+		return
+	}
+	if e.freq < math.MaxUint32 {
+		e.freq++
+	}
+	e.prio = idx.priority(e)
+	heap.Fix(&idx.heap, int(e.heapIdx))
+}
+
+// untrack 將條目移出堆
+func (idx *entryIndex) untrack(e *CacheEntry) {
+	if !idx.usesHeap() || e.heapIdx < 0 {
+		return
+	}
+	heap.Remove(&idx.heap, int(e.heapIdx))
+}
+
+// victim 返回下一個淘汰對象，略過 cutoff（UnixNano）之後建立與被釘選的條目
+func (idx *entryIndex) victim(cutoff int64) *CacheEntry {
+	if !idx.usesHeap() {
+		for e := idx.root.prev; e != &idx.root; e = e.prev {
+			if idx.evictable(e, cutoff) {
+				return e
+			}
+		}
+		return nil
+	}
+	if len(idx.heap) > 0 && idx.evictable(idx.heap[0], cutoff) {
+		return idx.heap[0]
+	}
+	// 堆頂是受保護的條目時才線性搜尋
+	var best *CacheEntry
+	for _, e := range idx.heap {
+		if idx.evictable(e, cutoff) && (best == nil || e.prio < best.prio) {
+			best = e
+		}
+	}
+	return best
+}
@@ -0,0 +1,142 @@
+package fileproxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ExprRule 以表達式比對請求屬性的規則，用於 glob 無法描述的情況
+//
+// 表達式使用 expr 語法（https://expr-lang.org），必須返回布林值，可用的變數見 ruleEnv。
+// 例如 `ext == ".json" && header["accept"] contains "application/vnd.npm"`。
+type ExprRule struct {
+	Expr string // 條件表達式
+	CacheRule
+
+	program *vm.Program
+}
+
+// ruleEnv 表達式可用的請求屬性
+type ruleEnv struct {
+	Path     string            `expr:"path"`      // 請求路徑
+	Ext      string            `expr:"ext"`       // 副檔名，含 .
+	Method   string            `expr:"method"`    // 請求方法
+	Host     string            `expr:"host"`      // Host 頭
+	Query    map[string]string `expr:"query"`     // 查詢參數（同名取第一個）
+	Header   map[string]string `expr:"header"`    // 請求頭，鍵為小寫
+	RemoteIP string            `expr:"remote_ip"` // 客戶端 IP
+}
+
+// newRuleEnv 由請求建立表達式環境
+func newRuleEnv(r *http.Request, key string) *ruleEnv {
+	env := &ruleEnv{
+		Path:   key,
+		Ext:    path.Ext(key),
+		Method: r.Method,
+		Host:   r.Host,
+		Query:  make(map[string]string),
+		Header: make(map[string]string, len(r.Header)),
+	}
+	for name, values := range r.URL.Query() {
+		env.Query[name] = values[0]
+	}
+	for name, values := range r.Header {
+		env.Header[strings.ToLower(name)] = values[0]
+	}
+	env.RemoteIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	return env
+}
+
+// compile 編譯表達式，啟動時即可發現語法與型別錯誤
+func (r *ExprRule) compile() error {
+	program, err := expr.Compile(r.Expr, expr.Env(ruleEnv{}), expr.AsBool())
+	if err != nil {
+		return fmt.Errorf("expr rule %q: %w", r.Expr, err)
+	}
+	r.program = program
+	return r.CacheRule.validateActions()
+}
+
+// match 對請求求值，執行錯誤視為不匹配
+func (r *ExprRule) match(env *ruleEnv) bool {
+	out, err := expr.Run(r.program, env)
+	if err != nil {
+		slog.Warn("expr rule evaluation failed", "expr", r.Expr, "error", err)
+		return false
+	}
+	return out.(bool)
+}
+
+// ParseExprRule 解析命令列格式的規則：EXPR => OPTION[,OPTION...]
+//
+// 選項與 ParseCacheRule 相同，例如
+// `method == "GET" && query["v"] != "" => ttl=720h,header=X-Immutable:1`。
+func ParseExprRule(s string) (ExprRule, error) {
+	condition, opts, found := strings.Cut(s, "=>")
+	if !found || strings.TrimSpace(opts) == "" {
+		return ExprRule{}, fmt.Errorf("expr rule %q: expected EXPR => OPTIONS", s)
+	}
+
+	rule := ExprRule{Expr: strings.TrimSpace(condition)}
+	rule.Pattern = rule.Expr
+	if err := rule.parseOptions(strings.TrimSpace(opts)); err != nil {
+		return ExprRule{}, fmt.Errorf("expr rule %q: %w", s, err)
+	}
+	return rule, rule.compile()
+}
+
+// ruleFor 返回請求生效的規則：glob 規則優先，其次依序比對表達式規則，最後是鏡像設定檔
+func (p *Proxy) ruleFor(r *http.Request, key string) *CacheRule {
+	if rule := p.config.CacheRuleFor(key); rule != nil {
+		return rule
+	}
+	if len(p.config.ExprRules) > 0 {
+		env := newRuleEnv(r, key)
+		for i := range p.config.ExprRules {
+			if p.config.ExprRules[i].match(env) {
+				return &p.config.ExprRules[i].CacheRule
+			}
+		}
+	}
+	return p.profileRuleFor(key)
+}
+
+// ruleCtxKey 請求 context 中保存生效規則的鍵
+type ruleCtxKey struct{}
+
+// withRule 將生效規則附加到 context
+func withRule(ctx context.Context, rule *CacheRule) context.Context {
+	return context.WithValue(ctx, ruleCtxKey{}, rule)
+}
+
+// tagsCtxKey 請求 context 中保存額外標籤的鍵
+type tagsCtxKey struct{}
+
+// withTags 在 context 中附加寫入快取時加上的標籤，例如預取任務指定的標籤
+func withTags(ctx context.Context, tags []string) context.Context {
+	return context.WithValue(ctx, tagsCtxKey{}, tags)
+}
+
+// fillTags 返回寫入快取時要加上的標籤：規則的標籤與 context 中的額外標籤
+func fillTags(ctx context.Context, rule *CacheRule) []string {
+	extra, _ := ctx.Value(tagsCtxKey{}).([]string)
+	if rule == nil {
+		return extra
+	}
+	return append(slices.Clip(rule.Tags), extra...)
+}
+
+// ruleFrom 取得 context 中的生效規則，無規則時返回 nil
+func ruleFrom(ctx context.Context) *CacheRule {
+	rule, _ := ctx.Value(ruleCtxKey{}).(*CacheRule)
+	return rule
+}
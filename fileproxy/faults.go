@@ -0,0 +1,158 @@
+//go:build chaos
+
+package fileproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// faultsPath 故障注入端點（僅 chaos 建置）
+const faultsPath = "/admin/faults"
+
+// faultConfig 注入中的故障
+type faultConfig struct {
+	UpstreamDown   bool    `json:"upstream_down"`    // 上游請求一律失敗
+	DiskDelayMs    int     `json:"disk_delay_ms"`    // 每次讀寫快取檔案前的延遲
+	DiskFull       bool    `json:"disk_full"`        // 寫入快取檔案返回 ENOSPC
+	DropClientRate float64 `json:"drop_client_rate"` // 中斷客戶端連線的機率（0~1）
+}
+
+// faults 故障注入器，用於混沌測試快取與故障轉移路徑
+type faults struct {
+	mu  sync.RWMutex
+	cfg faultConfig
+}
+
+func (f *faults) get() faultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cfg
+}
+
+// transport 包裝上游傳輸層，模擬上游故障
+func (f *faults) transport(rt http.RoundTripper) http.RoundTripper {
+	return faultTransport{f: f, rt: rt}
+}
+
+type faultTransport struct {
+	f  *faults
+	rt http.RoundTripper
+}
+
+func (t faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.f.get().UpstreamDown {
+		return nil, errors.New("fault injected: upstream down")
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// diskRead 模擬慢速磁碟讀取
+func (f *faults) diskRead() {
+	if d := f.get().DiskDelayMs; d > 0 {
+		time.Sleep(time.Duration(d) * time.Millisecond)
+	}
+}
+
+// diskWrite 模擬慢速或已滿的磁碟寫入
+func (f *faults) diskWrite() error {
+	cfg := f.get()
+	if cfg.DiskDelayMs > 0 {
+		time.Sleep(time.Duration(cfg.DiskDelayMs) * time.Millisecond)
+	}
+	if cfg.DiskFull {
+		return syscall.ENOSPC
+	}
+	return nil
+}
+
+// client 依機率包裝回應，第一次寫入後中斷客戶端連線
+//
+// 返回的函數須在處理結束後呼叫，被選中的連線會以 http.ErrAbortHandler 中斷。
+func (f *faults) client(w http.ResponseWriter) (http.ResponseWriter, func()) {
+	rate := f.get().DropClientRate
+	if rate <= 0 || rand.Float64() >= rate {
+		return w, func() {}
+	}
+	dw := &dropWriter{ResponseWriter: w}
+	return dw, func() {
+		if dw.dropped {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// dropWriter 寫入一個區塊後模擬客戶端斷線
+type dropWriter struct {
+	http.ResponseWriter
+	dropped bool
+}
+
+func (d *dropWriter) Write(p []byte) (int, error) {
+	if d.dropped {
+		return 0, errors.New("fault injected: client dropped")
+	}
+	d.dropped = true
+	n, _ := d.ResponseWriter.Write(p[:len(p)/2])
+	return n, errors.New("fault injected: client dropped")
+}
+
+// randomize 依機率隨機注入磁碟與客戶端故障，供 soak 測試在每輪負載前呼叫
+func (f *faults) randomize(rate float64) {
+	cfg := faultConfig{DropClientRate: rate / 2}
+	if rand.Float64() < rate*10 {
+		cfg.DiskDelayMs = rand.IntN(5) + 1
+	}
+	if rand.Float64() < rate {
+		cfg.DiskFull = true
+	}
+	f.set(cfg)
+}
+
+// clear 清除注入中的故障
+func (f *faults) clear() {
+	f.set(faultConfig{})
+}
+
+func (f *faults) set(cfg faultConfig) {
+	f.mu.Lock()
+	f.cfg = cfg
+	f.mu.Unlock()
+}
+
+// registerFaultRoutes 註冊故障注入端點
+func (s *Server) registerFaultRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(faultsPath, s.requireAdmin(s.proxy.handleFaults))
+	slog.Warn("fault injection enabled", "endpoint", faultsPath)
+}
+
+// handleFaults GET 查詢、PUT 設定、DELETE 清除注入中的故障
+func (p *Proxy) handleFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var cfg faultConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		p.faults.set(cfg)
+		slog.Warn("faults injected", "upstream_down", cfg.UpstreamDown, "disk_delay_ms", cfg.DiskDelayMs,
+			"disk_full", cfg.DiskFull, "drop_client_rate", cfg.DropClientRate)
+	case http.MethodDelete:
+		p.faults.clear()
+		slog.Info("faults cleared")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.faults.get())
+}
@@ -0,0 +1,20 @@
+//go:build !chaos
+
+package fileproxy
+
+import "net/http"
+
+// faults 正式建置不含故障注入，所有方法皆為空操作
+type faults struct{}
+
+func (*faults) transport(rt http.RoundTripper) http.RoundTripper { return rt }
+func (*faults) diskRead()                                        {}
+func (*faults) diskWrite() error                                 { return nil }
+func (*faults) randomize(rate float64)                           {}
+func (*faults) clear()                                           {}
+
+func (*faults) client(w http.ResponseWriter) (http.ResponseWriter, func()) {
+	return w, func() {}
+}
+
+func (s *Server) registerFaultRoutes(mux *http.ServeMux) {}
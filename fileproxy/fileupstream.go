@@ -0,0 +1,220 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// validateOriginURL 檢查可作為回源目標的 URL：http(s) 上游或 file:// 本機目錄
+func validateOriginURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "file" {
+		return validateUpstreamURL(s)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return fmt.Errorf("invalid upstream %q: file upstreams must be local, use file:///path", s)
+	}
+	if !path.IsAbs(u.Path) {
+		return fmt.Errorf("invalid upstream %q: file upstream path must be absolute", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid upstream %q: file upstreams take no query or fragment", s)
+	}
+	return nil
+}
+
+// fileRoots 返回 file:// 上游、路由與規則上游的目錄
+func (c *Config) fileRoots() []string {
+	upstreams := []string{c.UpstreamURL}
+	for _, route := range c.Routes {
+		upstreams = append(upstreams, route.Upstream)
+	}
+	for _, rule := range c.CacheRules {
+		upstreams = append(upstreams, rule.Upstream)
+	}
+	var roots []string
+	for _, s := range upstreams {
+		if u, err := url.Parse(s); err == nil && u.Scheme == "file" {
+			roots = append(roots, path.Clean(u.Path))
+		}
+	}
+	return roots
+}
+
+// fileTransport 以本機目錄（例如 NFS 或 CIFS 掛載）作為上游
+//
+// 只接受 GET 與 HEAD，Range 與條件請求的語意與 HTTP 上游相同。檔案不存在或為目錄時返回 404、
+// 沒有權限時返回 403；其他錯誤（例如掛載中斷）以連線錯誤返回，不會被負快取，stale-if-error 照常生效。
+// 開啟檔案受請求 context 限制，掛載沒有回應時請求不會一直卡住。
+type fileTransport struct {
+	roots []string
+}
+
+// newFileTransport 建立本機目錄上游，沒有 file:// 上游時返回 nil
+func newFileTransport(cfg *Config) *fileTransport {
+	roots := cfg.fileRoots()
+	if len(roots) == 0 {
+		return nil
+	}
+	return &fileTransport{roots: roots}
+}
+
+// allowed 檢查路徑是否位於某個上游目錄內
+func (t *fileTransport) allowed(name string) bool {
+	for _, root := range t.roots {
+		if root == "/" || name == root || strings.HasPrefix(name, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return fileStatus(req, http.StatusMethodNotAllowed), nil
+	}
+	name := path.Clean(req.URL.Path)
+	if !t.allowed(name) {
+		return fileStatus(req, http.StatusNotFound), nil
+	}
+
+	f, info, err := openFile(req.Context(), name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fileStatus(req, http.StatusNotFound), nil
+	case errors.Is(err, fs.ErrPermission):
+		return fileStatus(req, http.StatusForbidden), nil
+	case err != nil:
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return fileStatus(req, http.StatusNotFound), nil
+	}
+	return serveFile(req, f, info), nil
+}
+
+// openFile 開啟並 stat 檔案，context 結束時不再等待，稍後開啟成功的檔案在背景關閉
+func openFile(ctx context.Context, name string) (*os.File, fs.FileInfo, error) {
+	type opened struct {
+		f    *os.File
+		info fs.FileInfo
+		err  error
+	}
+	ch := make(chan opened, 1)
+	go func() {
+		f, err := os.Open(name)
+		var info fs.FileInfo
+		if err == nil {
+			if info, err = f.Stat(); err != nil {
+				f.Close()
+				f = nil
+			}
+		}
+		ch <- opened{f, info, err}
+	}()
+	select {
+	case o := <-ch:
+		return o.f, o.info, o.err
+	case <-ctx.Done():
+		go func() {
+			if o := <-ch; o.f != nil {
+				o.f.Close()
+			}
+		}()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// fileStatus 返回沒有本體的狀態回應
+func fileStatus(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// serveFile 以 http.ServeContent 處理 Range 與條件請求，本體經由 pipe 串流
+func serveFile(req *http.Request, f *os.File, info fs.FileInfo) *http.Response {
+	pr, pw := io.Pipe()
+	w := &fileResponseWriter{
+		header: make(http.Header),
+		body:   pw,
+		resp:   fileStatus(req, http.StatusOK),
+		ready:  make(chan struct{}),
+	}
+	w.resp.Body = pr
+	go func() {
+		defer f.Close()
+		src := &trackedFile{File: f}
+		http.ServeContent(w, req, info.Name(), info.ModTime(), src)
+		w.WriteHeader(http.StatusOK)
+		// 讀取中途失敗（例如掛載中斷）時以錯誤結束本體，避免被當成完整的內容
+		pw.CloseWithError(src.err)
+	}()
+	<-w.ready
+	return w.resp
+}
+
+// trackedFile 記錄讀取錯誤，http.ServeContent 不會回報複製途中的錯誤
+type trackedFile struct {
+	*os.File
+	err error
+}
+
+func (t *trackedFile) Read(p []byte) (int, error) {
+	n, err := t.File.Read(p)
+	if err != nil && err != io.EOF && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// fileResponseWriter 將 http.ServeContent 的輸出轉為 *http.Response
+type fileResponseWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+	resp   *http.Response
+	ready  chan struct{}
+	sent   bool
+}
+
+func (w *fileResponseWriter) Header() http.Header { return w.header }
+
+func (w *fileResponseWriter) WriteHeader(status int) {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	w.resp.StatusCode = status
+	w.resp.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	w.resp.Header = w.header.Clone()
+	w.resp.ContentLength = -1
+	if n, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil {
+		w.resp.ContentLength = n
+	}
+	close(w.ready)
+}
+
+func (w *fileResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
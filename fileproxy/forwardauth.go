@@ -0,0 +1,77 @@
+package fileproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	authSaltFile  = "auth.salt" // 憑證雜湊的密鑰，位於快取目錄下
+	privateKeySep = "\x00"      // 私有 key 的分隔符，請求路徑不可能包含 NUL（見 hardenRequest）
+)
+
+// loadAuthSalt 讀取或建立憑證雜湊的密鑰，重啟後保持不變以沿用既有快取
+func loadAuthSalt(dir string) ([]byte, error) {
+	path := filepath.Join(dir, authSaltFile)
+	salt, err := os.ReadFile(path)
+	if err == nil && len(salt) == sha256.Size {
+		return salt, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read auth salt: %w", err)
+	}
+
+	salt = make([]byte, sha256.Size)
+	rand.Read(salt)
+	if err := os.WriteFile(path, salt, 0600); err != nil {
+		return nil, fmt.Errorf("write auth salt: %w", err)
+	}
+	return salt, nil
+}
+
+// cacheKey 返回請求的快取 key
+//
+// 路徑依 Key* 設定正規化；設定 CacheKeyQuery 時路徑後附加正規化的查詢字串，例如 /app.js?version=2。
+// 轉發 Authorization 時，帶憑證的請求在路徑後附加憑證的 HMAC，不同使用者的快取互相隔離；
+// 憑證本身不會寫入索引。
+func (p *Proxy) cacheKey(r *http.Request) string {
+	key := p.config.normalizePath(r.URL.Path) + p.config.keyQuery(r)
+	if !p.config.ForwardAuthorization {
+		return key
+	}
+	credential := r.Header.Get("Authorization")
+	if credential == "" {
+		return key
+	}
+	mac := hmac.New(sha256.New, p.authSalt)
+	mac.Write([]byte(credential))
+	return key + privateKeySep + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// isPrivateKey 檢查 key 是否屬於特定使用者，私有條目不向兄弟節點查詢也不複製
+func isPrivateKey(key string) bool {
+	return strings.Contains(key, privateKeySep)
+}
+
+// forwardCredentials 將客戶端的 Authorization 複製到上游請求，已解析的下載位址依其設定決定
+func (p *Proxy) forwardCredentials(dst, src *http.Request) {
+	forward := p.config.ForwardAuthorization
+	if origin, ok := originFrom(src.Context()); ok {
+		forward = origin.credentials
+	}
+	if !forward {
+		return
+	}
+	if credential := src.Header.Get("Authorization"); credential != "" {
+		dst.Header.Set("Authorization", credential)
+	}
+}
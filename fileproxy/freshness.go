@@ -0,0 +1,191 @@
+package fileproxy
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minUpstreamTTL 上游指定 TTL 的最小值，避免條目一建立就過期
+const minUpstreamTTL = time.Second
+
+// immutableTTL 不可變條目的 TTL 標記：永不因存活時間過期，只在容量壓力下淘汰，也不重新驗證
+const immutableTTL time.Duration = -1
+
+// immutableMaxAge 不可變條目回應給下游的 max-age（一年，RFC 9111 5.2.2.1 建議的上限）
+const immutableMaxAge = 365 * 24 * 60 * 60
+
+// binaryContentTypes 視為長期不變的二進位內容類型（image/、video/、audio/、font/ 以外）
+var binaryContentTypes = map[string]bool{
+	"application/octet-stream":              true,
+	"application/zip":                       true,
+	"application/gzip":                      true,
+	"application/x-gzip":                    true,
+	"application/x-tar":                     true,
+	"application/x-xz":                      true,
+	"application/x-bzip2":                   true,
+	"application/zstd":                      true,
+	"application/java-archive":              true,
+	"application/vnd.debian.binary-package": true,
+	"application/x-rpm":                     true,
+	"application/wasm":                      true,
+	"application/pdf":                       true,
+}
+
+// entryTTL 決定新條目的存活時間：規則優先，其次為上游回應頭，最後為內容類別的預設值
+//
+// 規則的 immutable 或上游的 Cache-Control: immutable（HonorImmutable）返回 immutableTTL。
+func (p *Proxy) entryTTL(rule *CacheRule, h http.Header, now time.Time) time.Duration {
+	if rule != nil && rule.Immutable {
+		return immutableTTL
+	}
+	if rule != nil && rule.TTL > 0 {
+		return rule.TTL
+	}
+	if p.config.HonorImmutable && hasDirective(h, "immutable") {
+		return immutableTTL
+	}
+	if ttl := p.upstreamTTL(h, now); ttl > 0 {
+		return ttl
+	}
+	return p.contentClassTTL(h.Get("Content-Type"))
+}
+
+// renewTTL 決定上游以 304 確認後條目的存活時間：規則優先，其次為 304 回應頭，否則沿用原設定
+func (p *Proxy) renewTTL(rule *CacheRule, h http.Header, cached *CacheEntry) time.Duration {
+	if rule != nil && rule.Immutable {
+		return immutableTTL
+	}
+	if rule != nil && rule.TTL > 0 {
+		return rule.TTL
+	}
+	if p.config.HonorImmutable && hasDirective(h, "immutable") {
+		return immutableTTL
+	}
+	if ttl := p.upstreamTTL(h, time.Now()); ttl > 0 {
+		return ttl
+	}
+	return cached.TTL
+}
+
+// contentClassTTL 依內容類別返回預設存活時間，未知類別返回 0（使用預設 TTL）
+//
+// 圖片、影音、字型與壓縮檔等二進位內容通常以版本化路徑發佈，使用 BinaryContentTTL；
+// JSON、XML 與文字內容多為會變動的中繼資料，使用 TextContentTTL。
+func (p *Proxy) contentClassTTL(contentType string) time.Duration {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0
+	}
+	major, sub, _ := strings.Cut(mediaType, "/")
+	switch {
+	case major == "text", sub == "json", sub == "xml",
+		strings.HasSuffix(sub, "+json"), strings.HasSuffix(sub, "+xml"):
+		return p.config.TextContentTTL
+	case major == "image", major == "video", major == "audio", major == "font",
+		binaryContentTypes[mediaType]:
+		return p.config.BinaryContentTTL
+	}
+	return 0
+}
+
+// upstreamTTL 由上游回應頭計算條目的存活時間，未指定時返回 0（使用預設 TTL）
+//
+// 優先使用 Cache-Control: s-maxage，其次為 Expires 減去 Date，
+// 結果限制在 [MinCacheTTL, MaxCacheTTL] 之間。
+func (p *Proxy) upstreamTTL(h http.Header, now time.Time) time.Duration {
+	ttl, ok := parseSharedMaxAge(h.Get("Cache-Control"))
+	if !ok {
+		ttl, ok = parseExpires(h, now)
+	}
+	if !ok {
+		return 0
+	}
+
+	maxTTL := p.config.MaxCacheTTL
+	if maxTTL <= 0 {
+		maxTTL = p.config.DefaultCacheTTL
+	}
+	return min(max(ttl, p.config.MinCacheTTL, minUpstreamTTL), maxTTL)
+}
+
+// storeAllowed 檢查上游回應是否允許寫入共享快取
+//
+// 上游以 Cache-Control: no-store 或 private 標記的回應（例如帶簽名的個人化內容）
+// 只透傳給客戶端；IgnoreNoStore 或規則的 ignore-no-store 可覆寫。
+func (p *Proxy) storeAllowed(rule *CacheRule, h http.Header) bool {
+	if p.config.IgnoreNoStore || (rule != nil && rule.IgnoreNoStore) {
+		return true
+	}
+	for _, cc := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(cc, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			// private="Field" 只限制指定的欄位，內容本身仍可共享
+			if strings.EqualFold(name, "no-store") || (strings.EqualFold(name, "private") && value == "") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasDirective 檢查 Cache-Control 是否包含指定的指令
+func hasDirective(h http.Header, directive string) bool {
+	for _, cc := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(cc, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setConditional 以條目的上游驗證器設定條件請求頭，沒有驗證器時上游會返回完整內容
+func setConditional(req *http.Request, entry *CacheEntry) {
+	v := entry.validators
+	if v == nil {
+		return
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+}
+
+// parseSharedMaxAge 解析 Cache-Control 的 s-maxage 指令
+func parseSharedMaxAge(cc string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cc, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "s-maxage") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+		if err != nil || secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	return 0, false
+}
+
+// parseExpires 由 Expires 與 Date 計算剩餘時間，無效的 Expires 視為已過期
+func parseExpires(h http.Header, now time.Time) (time.Duration, bool) {
+	expires := h.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	exp, err := http.ParseTime(expires)
+	if err != nil {
+		return 0, true
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date // 以上游時鐘為準，避免時鐘偏差
+	}
+	return exp.Sub(now), true
+}
@@ -0,0 +1,282 @@
+package fileproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 交接監聽 socket 時傳給新程序的環境變數
+const (
+	handoffFDsEnv   = "FILEPROXY_LISTEN_FDS" // 依序列出從 fd 3 起繼承的監聽名稱，以逗號分隔
+	handoffReadyEnv = "FILEPROXY_READY_FD"   // 新程序準備接手後寫入一個位元組的管道
+)
+
+// 監聽名稱，同時用於 systemd 的 FileDescriptorName=
+const (
+	listenerProxy = "proxy"
+	listenerAdmin = "admin"
+)
+
+const (
+	listenFDsStart      = 3                // 繼承的 fd 從 3 開始（systemd 與 ExtraFiles 相同）
+	handoffReadyTimeout = 30 * time.Second // 等待新程序準備接手的時間上限
+	handoffIndexWait    = time.Minute      // 新程序在舊程序關閉時間之外，額外等待快取索引鎖的時間
+)
+
+// inheritedListeners 從父程序或 systemd 繼承的監聽 socket
+type inheritedListeners struct {
+	listeners map[string]net.Listener
+	fromPeer  bool     // 由舊程序交接而來，而非 systemd 啟動
+	ready     *os.File // 交接時通知舊程序的管道，通知後為 nil
+}
+
+// inheritListeners 取得繼承的監聽 socket，沒有時返回 nil
+//
+// 支援 systemd socket activation（LISTEN_FDS、LISTEN_PID 與 LISTEN_FDNAMES，未命名時依序為 proxy、admin）
+// 與舊程序收到 SIGUSR2 後的交接。讀取後清除環境變數，之後啟動的子程序不會誤用。
+func inheritListeners() (*inheritedListeners, error) {
+	var names []string
+	var ready *os.File
+	if list := os.Getenv(handoffFDsEnv); list != "" {
+		names = strings.Split(list, ",")
+		fd, err := strconv.Atoi(os.Getenv(handoffReadyEnv))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", handoffReadyEnv, err)
+		}
+		ready = os.NewFile(uintptr(fd), "handoff-ready")
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+		}
+		names = make([]string, n)
+		fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := range names {
+			switch {
+			case i < len(fdNames) && (fdNames[i] == listenerProxy || fdNames[i] == listenerAdmin):
+				names[i] = fdNames[i]
+			case i == 0:
+				names[i] = listenerProxy
+			case i == 1:
+				names[i] = listenerAdmin
+			}
+		}
+	}
+	for _, env := range []string{handoffFDsEnv, handoffReadyEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+	if names == nil {
+		return nil, nil
+	}
+
+	inherited := &inheritedListeners{listeners: make(map[string]net.Listener, len(names)), fromPeer: ready != nil, ready: ready}
+	for i, name := range names {
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		if name == "" {
+			file.Close() // 不認得的 socket
+			continue
+		}
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			inherited.Close()
+			return nil, fmt.Errorf("inherit %s listener: %w", name, err)
+		}
+		inherited.listeners[name] = ln
+	}
+	return inherited, nil
+}
+
+// take 取出名稱對應且地址與 addr 相符的監聽；地址改變時關閉繼承的 socket，改為重新監聽
+func (in *inheritedListeners) take(name, addr string) (net.Listener, bool) {
+	if in == nil {
+		return nil, false
+	}
+	ln, ok := in.listeners[name]
+	if !ok {
+		return nil, false
+	}
+	delete(in.listeners, name)
+	if !sameListenAddr(ln.Addr(), addr) {
+		slog.Info("listen address changed, not reusing inherited socket", "listener", name, "inherited", ln.Addr(), "addr", addr)
+		ln.Close()
+		return nil, false
+	}
+	// 交接而來的 Unix socket 由本程序負責在關閉時刪除檔案；systemd 的 socket 檔案由 systemd 管理
+	if unix, ok := ln.(*net.UnixListener); ok && in.handoff() {
+		unix.SetUnlinkOnClose(true)
+	}
+	return ln, true
+}
+
+// handoff 是否由舊程序交接而來
+func (in *inheritedListeners) handoff() bool {
+	return in != nil && in.fromPeer
+}
+
+// Ready 通知舊程序已接手監聽 socket，舊程序隨即開始關閉
+func (in *inheritedListeners) Ready() {
+	if in == nil || in.ready == nil {
+		return
+	}
+	in.ready.Write([]byte{1})
+	in.ready.Close()
+	in.ready = nil
+}
+
+// Close 關閉尚未取用的監聽
+func (in *inheritedListeners) Close() {
+	if in == nil {
+		return
+	}
+	for name, ln := range in.listeners {
+		ln.Close()
+		delete(in.listeners, name)
+	}
+}
+
+// sameListenAddr 檢查繼承的地址是否與設定的監聽地址相同，未指定主機時視為任意地址
+func sameListenAddr(got net.Addr, addr string) bool {
+	path, isUnix := unixSocketPath(addr)
+	if unix, ok := got.(*net.UnixAddr); ok {
+		return isUnix && filepath.Clean(unix.Name) == filepath.Clean(path)
+	}
+	if isUnix {
+		return false
+	}
+	tcp, ok := got.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || want.Port != tcp.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return tcp.IP.IsUnspecified()
+	}
+	return want.IP.Equal(tcp.IP)
+}
+
+// baseListener 返回 tuneListener 包裝前的監聽
+func baseListener(ln net.Listener) net.Listener {
+	if tuned, ok := ln.(*tunedListener); ok {
+		return tuned.Listener
+	}
+	return ln
+}
+
+// listenerFile 返回監聽 socket 的檔案副本，供新程序繼承
+func listenerFile(ln net.Listener) (*os.File, error) {
+	filer, ok := baseListener(ln).(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be handed off", ln)
+	}
+	return filer.File()
+}
+
+// upgrade 以相同的參數啟動新的執行檔並交出監聽 socket，等到新程序準備接手後返回新程序的 PID
+//
+// 新程序驗證設定並取得 socket 後才通知；啟動失敗、提前結束或逾時時返回錯誤，舊程序繼續服務。
+func (s *Server) upgrade() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("locate executable: %w", err)
+	}
+
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range []string{listenerProxy, listenerAdmin} {
+		ln, ok := s.listeners[name]
+		if !ok {
+			continue
+		}
+		file, err := listenerFile(ln)
+		if err != nil {
+			return 0, err
+		}
+		names = append(names, name)
+		files = append(files, file)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("create handoff pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	// 看門狗 PID 屬於本程序，新程序改以 MAINPID= 接手後自行送出看門狗訊號
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool { return strings.HasPrefix(kv, watchdogPIDEnv+"=") })
+	cmd.Env = append(env,
+		handoffFDsEnv+"="+strings.Join(names, ","),
+		handoffReadyEnv+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, fmt.Errorf("start new process: %w", err)
+	}
+	slog.Info("started new process for handoff", "pid", cmd.Process.Pid, "executable", exe)
+
+	// 新程序準備好前結束時管道關閉，讀取返回 EOF
+	readyCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := io.ReadFull(readyR, buf)
+		readyCh <- err
+	}()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	timer := time.NewTimer(handoffReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-readyCh:
+		if err == nil {
+			// Unix socket 檔案交給新程序，本程序關閉監聽時不刪除
+			for _, ln := range s.listeners {
+				if unix, ok := baseListener(ln).(*net.UnixListener); ok {
+					unix.SetUnlinkOnClose(false)
+				}
+			}
+			return cmd.Process.Pid, nil
+		}
+		return 0, errors.Join(errors.New("new process exited before taking over"), <-exited)
+	case <-timer.C:
+		cmd.Process.Kill()
+		return 0, errors.New("new process did not take over in time")
+	}
+}
+
+// writePIDFile 寫入目前的程序 ID，交接時由新程序覆寫
+func writePIDFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("write pid file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write pid file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package fileproxy
+
+import "os"
+
+// upgradeSignal 非 Unix 平台不支援交接監聽 socket
+var upgradeSignal os.Signal
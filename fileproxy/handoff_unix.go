@@ -0,0 +1,11 @@
+//go:build unix
+
+package fileproxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal 觸發交接監聽 socket 的訊號
+var upgradeSignal os.Signal = syscall.SIGUSR2
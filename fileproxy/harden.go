@@ -0,0 +1,76 @@
+package fileproxy
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// hardenRequest 在推導快取 key 之前驗證請求，防範請求走私與異常路徑
+//
+// net/http 已拒絕重複或無效的 Content-Length 與不支援的 Transfer-Encoding，
+// 並在 chunked 時移除 Content-Length；但同時帶有兩者的請求在此已無法分辨，
+// 因此帶 Transfer-Encoding 的請求一律在回應後關閉連線（RFC 9112 6.3）。
+func (s *Server) hardenRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason, status := s.checkRequest(r); status != 0 {
+			slog.Warn("request rejected", "reason", reason, "remote", r.RemoteAddr, "method", r.Method)
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if len(r.TransferEncoding) > 0 {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkRequest 返回拒絕原因與狀態碼，status 為 0 表示通過
+func (s *Server) checkRequest(r *http.Request) (string, int) {
+	// 只接受 origin-form，避免 absolute-form 的 Host 與快取 key 不一致
+	if !strings.HasPrefix(r.RequestURI, "/") {
+		return "non origin-form request target", http.StatusBadRequest
+	}
+	if max := s.config.MaxPathLength; max > 0 && (len(r.RequestURI) > max || len(r.URL.Path) > max) {
+		return "path too long", http.StatusRequestURITooLong
+	}
+	if reason := pathViolation(r.URL.Path); reason != "" {
+		return reason, http.StatusBadRequest
+	}
+	// GET/HEAD 不應帶本體，帶 Transfer-Encoding 的多半是走私嘗試
+	if len(r.TransferEncoding) > 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return "transfer-encoding on bodiless method", http.StatusBadRequest
+	}
+	return "", 0
+}
+
+// pathViolation 返回路徑不能轉發上游的原因，安全時為空字串
+//
+// 路徑已由 net/http 解碼一次。含控制字元（NUL 等）、.. 區段（/ 或 \ 分隔），
+// 或解碼後仍含編碼的 .、/、\ 的路徑一律拒絕：後者在上游再解碼一次時可能變成目錄穿越。
+func pathViolation(path string) string {
+	if strings.ContainsFunc(path, isControlRune) {
+		return "control character in path"
+	}
+	for segment := range strings.FieldsFuncSeq(path, isPathSeparator) {
+		if segment == ".." {
+			return "path traversal"
+		}
+	}
+	lower := strings.ToLower(path)
+	for _, encoded := range []string{"%2e", "%2f", "%5c"} {
+		if strings.Contains(lower, encoded) {
+			return "encoded traversal sequence in path"
+		}
+	}
+	return ""
+}
+
+// isPathSeparator 上游可能視為路徑分隔符的字元
+func isPathSeparator(r rune) bool { return r == '/' || r == '\\' }
+
+// isControlRune 檢查是否為控制字元（含 NUL）
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
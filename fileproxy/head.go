@@ -0,0 +1,138 @@
+package fileproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headEntry 以上游 HEAD 取得的中繼資料，檔案本身尚未快取
+type headEntry struct {
+	size        int64 // -1 表示上游未提供長度
+	contentType string
+	expiresAt   time.Time
+}
+
+// Head 返回未過期的中繼資料
+func (c *Cache) Head(key string) (headEntry, bool) {
+	he, ok := c.headCache.Get(key)
+	if !ok {
+		return headEntry{}, false
+	}
+	if !time.Now().Before(he.expiresAt) {
+		c.headCache.Remove(key)
+		return headEntry{}, false
+	}
+	return he, true
+}
+
+// PutHead 快取上游 HEAD 的中繼資料
+func (c *Cache) PutHead(key string, he headEntry) { c.headCache.Add(key, he) }
+
+// serveHead 以上游 HEAD 回應未快取的 HEAD 請求，不下載內容
+//
+// 可快取的路徑記錄長度與類型，存活時間與同一回應的 GET 相同，重複的 HEAD 不再回源；
+// 檔案正在下載時沿用下載流的回應頭。上游不支援 HEAD（405、501）時改以 GET 處理。
+func (p *Proxy) serveHead(w http.ResponseWriter, r *http.Request, key string) error {
+	rule := ruleFrom(r.Context())
+	cacheable := rule.cacheable() && !p.guard.Degraded()
+	if cacheable {
+		if sf, ok := p.cache.GetPending(key); ok {
+			p.stats.streaming.Add(1)
+			return p.serveFromStreaming(w, r, sf)
+		}
+		if he, ok := p.cache.Head(key); ok {
+			p.stats.hits.Add(1)
+			writeHeadHeaders(w, he, "HIT")
+			return nil
+		}
+	}
+
+	upstreamURL, ok := p.upstreamURLFor(r, rule)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodHead, upstreamURL, nil)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return fmt.Errorf("create request: %w", err)
+	}
+	p.forwardCredentials(req, r)
+	p.setProxyHeaders(req, r)
+	p.tracing.inject(r.Context(), req.Header)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		if rejectRateLimited(w, err) {
+			return nil
+		}
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return fmt.Errorf("upstream head request: %w", err)
+	}
+	resp.Body.Close()
+	removeHopHeaders(resp.Header)
+
+	if p.isPassedRedirect(resp.StatusCode) {
+		return p.passRedirect(w, r, resp)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		slog.Debug("upstream does not support HEAD, fetching with GET", "key", key)
+		if cacheable {
+			return p.fetchAndServe(r.Context(), w, r, key, nil)
+		}
+		return p.doFetchAndServe(r.Context(), w, r, key, newFetchLock(), nil)
+	default:
+		if p.config.ForwardAuthorization && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			http.Error(w, http.StatusText(resp.StatusCode), resp.StatusCode)
+			return nil
+		}
+		if ttl := p.config.negativeTTL(rule, resp.StatusCode); cacheable && ttl > 0 {
+			p.cache.PutNegative(key, resp.StatusCode, ttl)
+		}
+		status := negativeStatus(resp.StatusCode)
+		if status == http.StatusNotFound {
+			p.stats.notFound.Add(1)
+		}
+		http.Error(w, http.StatusText(status), status)
+		if status == http.StatusBadGateway {
+			return fmt.Errorf("upstream error: %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	p.stats.misses.Add(1)
+	he := headEntry{size: resp.ContentLength, contentType: resp.Header.Get("Content-Type")}
+	if he.contentType == "" {
+		he.contentType = "application/octet-stream"
+	}
+	status := "BYPASS"
+	if cacheable && p.storeAllowed(rule, resp.Header) {
+		ttl := p.entryTTL(rule, resp.Header, time.Now())
+		if ttl <= 0 {
+			ttl = p.config.DefaultCacheTTL
+		}
+		he.expiresAt = time.Now().Add(ttl)
+		p.cache.PutHead(key, he)
+		status = "MISS"
+	}
+	writeHeadHeaders(w, he, status)
+	return nil
+}
+
+// writeHeadHeaders 寫出中繼資料的回應頭
+func writeHeadHeaders(w http.ResponseWriter, he headEntry, status string) {
+	w.Header().Set("Content-Type", he.contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if he.size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(he.size, 10))
+	}
+	w.Header().Set("X-Cache", status)
+}
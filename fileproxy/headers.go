@@ -0,0 +1,111 @@
+package fileproxy
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// viaPseudonym Via 頭中代理的名稱（RFC 9110 7.6.3 允許以假名代替主機名稱）
+const viaPseudonym = "fileproxy"
+
+// hopHeaders 只在單一連線上有意義、代理不得轉發的頭（RFC 9110 7.6.1）
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // 非標準，但舊客戶端仍會送出
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// isHopHeader 檢查是否為逐跳頭
+func isHopHeader(name string) bool {
+	return slices.Contains(hopHeaders, http.CanonicalHeaderKey(name))
+}
+
+// removeHopHeaders 移除逐跳頭，以及 Connection 中列出的頭
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// viaValue 返回本代理的 Via 元素，例如 "1.1 fileproxy"
+func viaValue(major, minor int) string {
+	if major >= 2 {
+		return strconv.Itoa(major) + " " + viaPseudonym
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor) + " " + viaPseudonym
+}
+
+// setProxyHeaders 在回源請求附加 Via 與 Forwarded（RFC 7239），保留客戶端帶來的鏈
+func (p *Proxy) setProxyHeaders(req, r *http.Request) {
+	removeHopHeaders(req.Header)
+
+	for _, via := range r.Header.Values("Via") {
+		req.Header.Add("Via", via)
+	}
+	req.Header.Add("Via", viaValue(r.ProtoMajor, r.ProtoMinor))
+
+	if !p.config.SendForwarded {
+		return
+	}
+	for _, fwd := range r.Header.Values("Forwarded") {
+		req.Header.Add("Forwarded", fwd)
+	}
+	req.Header.Add("Forwarded", forwardedElement(r))
+}
+
+// forwardedElement 產生描述客戶端的 Forwarded 元素：for、host 與 proto
+func forwardedElement(r *http.Request) string {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	parts := []string{"for=" + forwardedNode(r.RemoteAddr)}
+	if r.Host != "" {
+		parts = append(parts, "host="+quoteForwarded(r.Host))
+	}
+	parts = append(parts, "proto="+proto)
+	return strings.Join(parts, ";")
+}
+
+// forwardedNode 將客戶端地址轉為 Forwarded 的 node 格式，IPv6 以方括號包住並加引號
+func forwardedNode(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.To4() == nil:
+		return `"[` + ip.String() + `]"`
+	default:
+		return ip.String()
+	}
+}
+
+// quoteForwarded 值含 token 以外的字元（例如 host 的冒號）時加引號
+func quoteForwarded(v string) string {
+	if strings.ContainsFunc(v, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+	}) {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,338 @@
+package fileproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hfProfile         = "huggingface"                   // 啟用 Hub 解析的鏡像設定檔名稱
+	hfBlobPrefix      = "/.huggingface" + privateKeySep // 內容條目的 key 前綴，請求路徑無法構成
+	hfMaxResolutions  = 10000                           // 記憶體中保留的解析結果上限
+	hfResolveMaxHops  = 5                               // Hub 內部重新導向（例如改名的倉庫）的上限
+	hfPrefillWaitTime = 30 * time.Second                // Range 請求等待背景下載開始的上限
+)
+
+// hfResolvePattern Hub 的檔案下載路徑：[datasets/|spaces/]{repo}/resolve/{revision}/{file}，可帶路由前綴
+var hfResolvePattern = regexp.MustCompile(`^(?:/[^/]+)+/resolve/[^/]+/[^/].*$`)
+
+// hfHeaders 轉交客戶端的 Hub 回應頭，huggingface_hub 依此取得版本、內容雜湊與大小
+var hfHeaders = []string{"X-Repo-Commit", "X-Linked-Etag", "X-Linked-Size", "ETag", "X-Error-Code", "X-Error-Message"}
+
+// hfBlobRule 內容條目的規則：以內容雜湊為 key，不會改變
+var hfBlobRule = CacheRule{Immutable: true}
+
+// errNoETag Hub 的回應沒有可作為 key 的 ETag
+var errNoETag = errors.New("hub response has no etag")
+
+// hfResolution 一次 resolve 的結果
+type hfResolution struct {
+	etag     string      // 內容雜湊：LFS 檔案為 SHA-256，其他為 git blob 雜湊
+	size     int64       // 未知時為 -1
+	location string      // 下載內容的 URL，LFS 檔案為 CDN 的簽名 URL
+	onHub    bool        // location 位於 Hub 本身，下載時需帶客戶端的憑證
+	header   http.Header // 轉交客戶端的回應頭
+	status   int         // Hub 拒絕時的狀態碼，0 表示成功
+	expires  time.Time
+}
+
+// hfResolver 處理 Hugging Face Hub 的 resolve 請求
+//
+// 每個請求先以客戶端的 token 向 Hub 發出 HEAD，確認存取權並取得內容雜湊與 CDN 位址，
+// 結果依路徑與憑證保留 MirrorMetaTTL。內容以雜湊為 key 快取，不同分支名稱、版本與倉庫的相同檔案
+// 共用一份；從 CDN 下載時不帶 token，中斷後依上游能力以 Range 續傳。
+type hfResolver struct {
+	proxy  *Proxy
+	client *http.Client // 不跟隨重新導向，由 resolve 自行處理
+	ttl    time.Duration
+
+	mu          sync.Mutex
+	resolutions map[string]*hfResolution // 路徑與憑證雜湊
+
+	resolves  atomic.Int64 // 向 Hub 發出的 resolve
+	reused    atomic.Int64 // 沿用記憶體中的解析結果
+	denied    atomic.Int64 // Hub 拒絕或找不到
+	prefilled atomic.Int64 // 為 Range 請求在背景開始的下載
+}
+
+// newHFResolver 建立 Hub 解析器，未啟用 huggingface 設定檔時返回 nil
+func newHFResolver(p *Proxy, cfg *Config) *hfResolver {
+	if !slices.Contains(cfg.MirrorProfiles, hfProfile) {
+		return nil
+	}
+	client := *p.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &hfResolver{
+		proxy:       p,
+		client:      &client,
+		ttl:         cfg.MirrorMetaTTL,
+		resolutions: make(map[string]*hfResolution),
+	}
+}
+
+// Match 檢查請求是否為 Hub 的檔案下載
+func (h *hfResolver) Match(r *http.Request) bool {
+	return h != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) && hfResolvePattern.MatchString(r.URL.Path)
+}
+
+// hfBlobKey 返回內容條目的 key
+func hfBlobKey(etag string) string {
+	return hfBlobPrefix + etag
+}
+
+// Serve 解析請求的檔案後從內容條目提供
+func (h *hfResolver) Serve(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.resolve(r)
+	if err != nil {
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return fmt.Errorf("resolve hub file: %w", err)
+	}
+	for name, values := range res.header {
+		w.Header()[name] = values
+	}
+	if res.status != 0 {
+		http.Error(w, http.StatusText(res.status), res.status)
+		return nil
+	}
+
+	key := hfBlobKey(res.etag)
+	ctx := withOrigin(withRule(r.Context(), &hfBlobRule), resolvedOrigin{url: res.location, credentials: res.onHub})
+	r = r.WithContext(ctx)
+
+	// huggingface_hub 以 HEAD 取得版本與大小，解析結果已足夠回應
+	if r.Method == http.MethodHead {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if res.size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(res.size, 10))
+		}
+		if _, ok := h.proxy.cache.Peek(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		return nil
+	}
+	if r.Header.Get("Range") != "" {
+		h.prefill(r, key)
+	}
+	return h.proxy.handleRequest(w, r, key)
+}
+
+// resolve 返回請求路徑在客戶端憑證下的解析結果
+func (h *hfResolver) resolve(r *http.Request) (*hfResolution, error) {
+	credential := r.Header.Get("Authorization")
+	id := r.URL.Path + privateKeySep
+	if credential != "" {
+		sum := sha256.Sum256([]byte(credential))
+		id += string(sum[:])
+	}
+	now := time.Now()
+	h.mu.Lock()
+	res, ok := h.resolutions[id]
+	h.mu.Unlock()
+	if ok && now.Before(res.expires) {
+		h.reused.Add(1)
+		return res, nil
+	}
+
+	target, ok := h.proxy.upstreamURLFor(r, ruleFrom(r.Context()))
+	if !ok {
+		return &hfResolution{status: http.StatusNotFound, expires: now.Add(h.ttl)}, nil
+	}
+	res, err := h.lookup(r, target, credential)
+	if err != nil {
+		return nil, err
+	}
+	res.expires = now.Add(h.ttl)
+	if res.status != 0 {
+		h.denied.Add(1)
+	}
+
+	h.mu.Lock()
+	if len(h.resolutions) >= hfMaxResolutions {
+		for k, v := range h.resolutions {
+			if !now.Before(v.expires) {
+				delete(h.resolutions, k)
+			}
+		}
+		if len(h.resolutions) >= hfMaxResolutions {
+			clear(h.resolutions)
+		}
+	}
+	h.resolutions[id] = res
+	h.mu.Unlock()
+	return res, nil
+}
+
+// lookup 以 HEAD 向 Hub 查詢檔案，跟隨 Hub 內部的重新導向，指向其他主機的導向即為 CDN 位址
+func (h *hfResolver) lookup(r *http.Request, target, credential string) (*hfResolution, error) {
+	hub, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	for range hfResolveMaxHops {
+		h.resolves.Add(1)
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodHead, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		h.proxy.setProxyHeaders(req, r)
+		req.Header.Set("Accept-Encoding", "identity")
+		if credential != "" {
+			req.Header.Set("Authorization", credential)
+		}
+		resp, err := h.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("hub request: %w", err)
+		}
+		resp.Body.Close()
+
+		res := &hfResolution{size: -1, header: make(http.Header)}
+		for _, name := range hfHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				res.header[name] = values
+			}
+		}
+		switch {
+		case resp.StatusCode >= 300 && resp.StatusCode < 400:
+			location, err := resp.Location()
+			if err != nil {
+				return nil, fmt.Errorf("hub redirect: %w", err)
+			}
+			if location.Host == hub.Host && resp.Header.Get("X-Linked-Etag") == "" {
+				target = location.String()
+				continue
+			}
+			res.location = location.String()
+		case resp.StatusCode == http.StatusOK:
+			res.location, res.onHub = target, true
+		default:
+			res.status = resp.StatusCode
+			if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+				res.header.Set("WWW-Authenticate", challenge)
+			}
+			return res, nil
+		}
+
+		res.etag = hfETag(resp.Header)
+		if res.etag == "" {
+			return nil, errNoETag
+		}
+		res.size = resp.ContentLength
+		if size, err := strconv.ParseInt(resp.Header.Get("X-Linked-Size"), 10, 64); err == nil {
+			res.size = size
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("hub redirected more than %d times", hfResolveMaxHops)
+}
+
+// hfETag 返回去掉弱標記與引號的內容雜湊，LFS 檔案的 X-Linked-Etag 優先
+func hfETag(h http.Header) string {
+	etag := h.Get("X-Linked-Etag")
+	if etag == "" {
+		etag = h.Get("ETag")
+	}
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// prefill 在背景開始下載整個檔案，等到內容開始寫入後返回，Range 請求改從串流讀取
+//
+// 續傳的客戶端以 Range 請求未快取的大檔案時，不必等待另一個客戶端觸發完整下載。
+func (h *hfResolver) prefill(r *http.Request, key string) {
+	p := h.proxy
+	if _, ok := p.cache.Peek(key); ok {
+		return
+	}
+	if _, ok := p.cache.GetPending(key); ok {
+		return
+	}
+
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+	w := &prefillWriter{header: make(http.Header), started: make(chan struct{})}
+	// 背景 goroutine 達到上限時不預先下載，Range 請求照常回源
+	started := p.routines.Go(routineBackground, "hub prefill "+key, func() {
+		defer w.start()
+		if err := p.handleRequest(w, req, key); err != nil {
+			slog.Warn("hub prefill failed", "key", r.URL.Path, "error", err)
+		}
+	})
+	if !started {
+		return
+	}
+	h.prefilled.Add(1)
+
+	timer := time.NewTimer(hfPrefillWaitTime)
+	defer timer.Stop()
+	select {
+	case <-w.started:
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+// prefillWriter 丟棄回應本體，第一次寫入時通知等待的 Range 請求
+type prefillWriter struct {
+	header  http.Header
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *prefillWriter) Header() http.Header { return w.header }
+
+func (w *prefillWriter) WriteHeader(int) { w.start() }
+
+func (w *prefillWriter) Write(b []byte) (int, error) {
+	w.start()
+	return len(b), nil
+}
+
+func (w *prefillWriter) start() { w.once.Do(func() { close(w.started) }) }
+
+// Stats 返回 Hub 解析統計資訊
+func (h *hfResolver) Stats() map[string]any {
+	h.mu.Lock()
+	cached := len(h.resolutions)
+	h.mu.Unlock()
+	return map[string]any{
+		"resolves":    h.resolves.Load(),
+		"reused":      h.reused.Load(),
+		"denied":      h.denied.Load(),
+		"prefilled":   h.prefilled.Load(),
+		"resolutions": cached,
+	}
+}
+
+// resolvedOrigin 已解析的下載位址，取代依路由組成的上游 URL
+type resolvedOrigin struct {
+	url         string
+	credentials bool // 轉發客戶端的 Authorization
+}
+
+// originCtxKey 請求 context 中保存已解析位址的鍵
+type originCtxKey struct{}
+
+// withOrigin 在 context 中附加已解析的下載位址
+func withOrigin(ctx context.Context, origin resolvedOrigin) context.Context {
+	return context.WithValue(ctx, originCtxKey{}, origin)
+}
+
+// originFrom 取得 context 中已解析的下載位址
+func originFrom(ctx context.Context) (resolvedOrigin, bool) {
+	origin, ok := ctx.Value(originCtxKey{}).(resolvedOrigin)
+	return origin, ok
+}
@@ -0,0 +1,242 @@
+package fileproxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// entryIndex 以 key 雜湊為鍵的 LRU 索引
+//
+// 使用侵入式雙向鏈結串列（指標存於 CacheEntry），每個條目只有一次配置，
+// 雜湊只在 map 鍵與條目中各存一份。過期條目由 sweep 從最舊端回收。
+// 容量淘汰依 policy 選擇對象：LRU 與 FIFO 取鏈結最舊端，LFU 與 GDSF 取堆頂。
+type entryIndex struct {
+	mu      sync.Mutex
+	items   map[keyHash]*CacheEntry
+	root    CacheEntry // 哨兵：root.next 為最新，root.prev 為最舊
+	onEvict func(e *CacheEntry, reason string)
+
+	policy    string
+	heap      evictionHeap // 僅 LFU 與 GDSF 使用
+	inflation float64      // 上一個被淘汰條目的優先值，作為新優先值的基準
+	pinned    map[keyHash]int
+}
+
+// 條目被移除的原因
+const (
+	removeCapacity = "capacity" // 超出容量上限
+	removeDisk     = "disk"     // 檔案系統剩餘空間低於水位
+	removeExpired  = "expired"  // 過期後被回收
+	removeExplicit = "removed"  // 清除、校驗失敗或上游禁止快取等明確移除
+	removeReplaced = "replaced" // 重新下載的內容取代舊條目
+)
+
+// newEntryIndex 建立索引，onEvict 在條目被移除時於鎖內呼叫
+func newEntryIndex(policy string, onEvict func(e *CacheEntry, reason string)) *entryIndex {
+	idx := &entryIndex{
+		items:   make(map[keyHash]*CacheEntry),
+		onEvict: onEvict,
+		policy:  policy,
+		pinned:  make(map[keyHash]int),
+	}
+	idx.root.next = &idx.root
+	idx.root.prev = &idx.root
+	return idx
+}
+
+func (idx *entryIndex) unlink(e *CacheEntry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next = nil, nil
+}
+
+func (idx *entryIndex) pushFront(e *CacheEntry) {
+	e.prev = &idx.root
+	e.next = idx.root.next
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// Get 取得條目並記錄命中：移到最新端（FIFO 除外），LFU 與 GDSF 提高優先值
+func (idx *entryIndex) Get(hash keyHash) (*CacheEntry, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e, ok := idx.items[hash]
+	if ok {
+		if idx.policy != evictFIFO {
+			idx.unlink(e)
+			idx.pushFront(e)
+		}
+		idx.touch(e)
+	}
+	return e, ok
+}
+
+// Peek 取得條目，不改變順序
+func (idx *entryIndex) Peek(hash keyHash) (*CacheEntry, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e, ok := idx.items[hash]
+	return e, ok
+}
+
+// Add 加入條目並放在最新端，同一雜湊的舊條目被直接替換（不觸發 onEvict）
+func (idx *entryIndex) Add(e *CacheEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if old, ok := idx.items[e.hash]; ok {
+		idx.unlink(old)
+		idx.untrack(old)
+	}
+	idx.items[e.hash] = e
+	idx.pushFront(e)
+	idx.track(e)
+}
+
+// AddIfAbsent 索引中沒有同一雜湊時加入條目並放在最新端，返回是否已加入
+func (idx *entryIndex) AddIfAbsent(e *CacheEntry) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.items[e.hash]; ok {
+		return false
+	}
+	idx.items[e.hash] = e
+	idx.pushFront(e)
+	idx.track(e)
+	return true
+}
+
+// Replace 以 e 替換仍在索引中的 old 並放在最新端（不觸發 onEvict），沿用命中次數，
+// old 已不在索引中時返回 false
+func (idx *entryIndex) Replace(old, e *CacheEntry) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.items[old.hash] != old {
+		return false
+	}
+	idx.unlink(old)
+	idx.untrack(old)
+	e.freq = old.freq
+	idx.items[e.hash] = e
+	idx.pushFront(e)
+	idx.track(e)
+	return true
+}
+
+// Remove 移除條目
+func (idx *entryIndex) Remove(hash keyHash) bool {
+	return idx.RemoveAs(hash, removeExplicit)
+}
+
+// RemoveAs 以指定原因移除條目
+func (idx *entryIndex) RemoveAs(hash keyHash, reason string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e, ok := idx.items[hash]
+	if ok {
+		idx.evict(e, reason)
+	}
+	return ok
+}
+
+// RemoveVictim 依淘汰策略移除一個在 cutoff（UnixNano）之前建立且未被釘選的條目，
+// 其他條目被略過；沒有可移除的條目時返回 false
+func (idx *entryIndex) RemoveVictim(cutoff int64, reason string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e := idx.victim(cutoff)
+	if e == nil {
+		return false
+	}
+	if idx.usesHeap() {
+		idx.inflation = e.prio
+	}
+	idx.evict(e, reason)
+	return true
+}
+
+// Pin 釘選雜湊，釘選的條目不會被容量淘汰或過期回收；以計數記錄，可重複釘選
+//
+// 釘選以雜湊記錄，條目被替換或重新下載後仍然有效，也可以在條目寫入前釘選。
+func (idx *entryIndex) Pin(hash keyHash) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.pinned[hash]++
+}
+
+// Unpin 解除一次釘選
+func (idx *entryIndex) Unpin(hash keyHash) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.pinned[hash] <= 1 {
+		delete(idx.pinned, hash)
+		return
+	}
+	idx.pinned[hash]--
+}
+
+// PinnedCount 返回被釘選的雜湊數
+func (idx *entryIndex) PinnedCount() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.pinned)
+}
+
+// evictable 條目是否可被淘汰：在 cutoff（UnixNano）之前建立且未被釘選，須持有鎖
+func (idx *entryIndex) evictable(e *CacheEntry, cutoff int64) bool {
+	return e.createdAt < cutoff && (len(idx.pinned) == 0 || idx.pinned[e.hash] == 0)
+}
+
+func (idx *entryIndex) evict(e *CacheEntry, reason string) {
+	delete(idx.items, e.hash)
+	idx.unlink(e)
+	idx.untrack(e)
+	if idx.onEvict != nil {
+		idx.onEvict(e, reason)
+	}
+}
+
+// Len 返回條目數
+func (idx *entryIndex) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.items)
+}
+
+// Values 返回所有條目，由最舊到最新
+func (idx *entryIndex) Values() []*CacheEntry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	out := make([]*CacheEntry, 0, len(idx.items))
+	for e := idx.root.prev; e != &idx.root; e = e.prev {
+		out = append(out, e)
+	}
+	return out
+}
+
+// forEach 在持有鎖的情況下走訪所有條目，fn 不可呼叫索引的其他方法
+func (idx *entryIndex) forEach(fn func(*CacheEntry)) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, e := range idx.items {
+		fn(e)
+	}
+}
+
+// sweep 從最舊端檢查最多 limit 個條目，移除過期超過 grace 且未被釘選的條目
+func (idx *entryIndex) sweep(now time.Time, grace time.Duration, limit int) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	deadline := now.Add(-grace).UnixNano()
+	removed := 0
+	for e := idx.root.prev; e != &idx.root && limit > 0; limit-- {
+		prev := e.prev
+		if e.expiresAt.Load() <= deadline && idx.evictable(e, math.MaxInt64) {
+			idx.evict(e, removeExpired)
+			removed++
+		}
+		e = prev
+	}
+	return removed
+}
@@ -0,0 +1,96 @@
+package fileproxy
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unique"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// pathEntry 改用雜湊索引前的條目結構，作為記憶體比較的基準
+type pathEntry struct {
+	Key         string
+	FilePath    string
+	Size        int64
+	ContentType string
+	CreatedAt   time.Time
+	TTL         time.Duration
+	expiresAt   atomic.Int64
+}
+
+// benchKey 產生長度接近真實套件路徑的 key
+func benchKey(i int) string {
+	return fmt.Sprintf("/debian/pool/main/p/package-%07d/package-%07d_1.2.3-4_amd64.deb", i, i)
+}
+
+// heapPerEntry 量測 fill 建立的結構在 n 個條目下平均每個條目佔用的堆記憶體
+func heapPerEntry(n int, fill func() any) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	return float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)) / float64(n)
+}
+
+// BenchmarkEntryIndex 量測每個條目的常駐記憶體（bytes/entry），
+// path-keyed 為以完整 key 與路徑字串為鍵的舊版索引
+func BenchmarkEntryIndex(b *testing.B) {
+	contentType := "application/vnd.debian.binary-package"
+	for _, n := range []int{100_000, 1_000_000} {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = benchKey(i)
+		}
+		for _, policy := range evictionPolicies {
+			b.Run(fmt.Sprintf("%s/%d", policy, n), func(b *testing.B) {
+				var perEntry float64
+				for b.Loop() {
+					perEntry = heapPerEntry(n, func() any {
+						idx := newEntryIndex(policy, func(*CacheEntry, string) {})
+						now := time.Now().UnixNano()
+						for i, key := range keys {
+							idx.Add(&CacheEntry{
+								hash:        hashKey(key),
+								Size:        int64(i),
+								contentType: unique.Make(contentType),
+								createdAt:   now,
+							})
+						}
+						return idx
+					})
+				}
+				b.ReportMetric(perEntry, "bytes/entry")
+			})
+		}
+		b.Run(fmt.Sprintf("path-keyed/%d", n), func(b *testing.B) {
+			var perEntry float64
+			for b.Loop() {
+				perEntry = heapPerEntry(n, func() any {
+					lru := expirable.NewLRU[string, *pathEntry](0, nil, 0)
+					now := time.Now()
+					for i, key := range keys {
+						// 複製字串，與讀取索引檔時各自配置的情況相同
+						k := string([]byte(key))
+						lru.Add(k, &pathEntry{
+							Key:         k,
+							FilePath:    filepath.Join("/var/cache/fileproxy", key[1:]),
+							Size:        int64(i),
+							ContentType: string([]byte(contentType)),
+							CreatedAt:   now,
+						})
+					}
+					return lru
+				})
+			}
+			b.ReportMetric(perEntry, "bytes/entry")
+		})
+	}
+}
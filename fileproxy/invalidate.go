@@ -0,0 +1,248 @@
+package fileproxy
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	invalidatePath     = "/admin/invalidate" // 接收上游變更通知的端點
+	invalidateMaxBody  = 8 << 20             // 通知本體大小上限
+	invalidateMaxPaths = 10000               // 單一通知最多的路徑數
+	invalidateWorkers  = 4                   // 重新驗證的並發數
+)
+
+// 失效的處理方式
+const (
+	invalidatePurge      = "purge"      // 移除快取，下一個請求重新回源
+	invalidateRefetch    = "refetch"    // 移除並在背景重新下載，完成前舊內容以 STALE 回應
+	invalidateRevalidate = "revalidate" // 立即以條件請求向上游確認，未變更時保留快取
+)
+
+// invalidateRequest POST /admin/invalidate 的請求本體：一般 webhook 的 paths 與 tags，或 S3 事件通知
+type invalidateRequest struct {
+	Paths   []string        `json:"paths"`
+	Tags    []string        `json:"tags"`
+	Action  string          `json:"action"`
+	Records []s3EventRecord `json:"Records"`
+}
+
+// s3EventRecord S3 事件通知中的單一記錄，物件 key 以表單編碼
+type s3EventRecord struct {
+	EventName string `json:"eventName"`
+	S3        struct {
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// InvalidatedPath 單一路徑的失效結果
+type InvalidatedPath struct {
+	Key    string `json:"key"`
+	Cached bool   `json:"cached"` // false 表示原本就沒有快取
+	Result string `json:"result"` // purged、refetching、revalidated（未變更）、refreshed（已更新）或 failed
+	Error  string `json:"error,omitempty"`
+}
+
+// InvalidateResult 失效通知的處理結果
+type InvalidateResult struct {
+	Action string            `json:"action"`
+	Paths  []InvalidatedPath `json:"paths"`
+	Tags   []TagResult       `json:"tags,omitempty"`
+}
+
+// paths 返回通知涉及的路徑，S3 事件的物件 key 解碼後加上 prefix
+func (req *invalidateRequest) paths(prefix string) ([]string, error) {
+	paths := req.Paths
+	for _, record := range req.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("invalid s3 object key %q", record.S3.Object.Key)
+		}
+		paths = append(paths, prefix+"/"+strings.TrimPrefix(key, "/"))
+	}
+	return paths, nil
+}
+
+// handleInvalidate 接收上游或發佈流程的變更通知，清除、重新下載或重新驗證受影響的條目
+//
+// 本體為 {"paths": [...], "tags": [...], "action": "..."} 或 S3 事件通知（Records[].s3.object.key），
+// S3 物件 key 加上 ?prefix= 後成為路徑。action 也可由 ?action= 指定，預設為 purge。
+// 重新驗證在回應前完成，其餘方式立即返回。
+func (p *Proxy) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req invalidateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, invalidateMaxBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	action := cmp.Or(req.Action, r.URL.Query().Get("action"), invalidatePurge)
+	switch action {
+	case invalidatePurge, invalidateRevalidate:
+	case invalidateRefetch:
+		if p.refetcher == nil {
+			http.Error(w, "refetch is disabled", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+	prefix := strings.TrimSuffix(r.URL.Query().Get("prefix"), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		http.Error(w, "prefix must start with /", http.StatusBadRequest)
+		return
+	}
+	paths, err := req.paths(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(paths) == 0 && len(req.Tags) == 0 {
+		http.Error(w, "no paths or tags to invalidate", http.StatusBadRequest)
+		return
+	}
+	if len(paths) > invalidateMaxPaths {
+		http.Error(w, fmt.Sprintf("at most %d paths per notification", invalidateMaxPaths), http.StatusBadRequest)
+		return
+	}
+
+	keys := make([]string, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		key, ok := p.purgeKey(path)
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid path %q", path), http.StatusBadRequest)
+			return
+		}
+		if _, dup := seen[key]; !dup {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	for _, tag := range req.Tags {
+		if !validTag(tag) {
+			http.Error(w, fmt.Sprintf("invalid tag %q", tag), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := InvalidateResult{Action: action, Paths: make([]InvalidatedPath, 0, len(keys))}
+	if action == invalidateRevalidate {
+		keys = append(keys, p.taggedKeys(req.Tags, seen)...)
+		result.Paths = p.revalidateKeys(r, keys)
+	} else {
+		for _, key := range keys {
+			purged := p.purge(key, action == invalidateRefetch)
+			status := "purged"
+			if purged.Refetching {
+				status = "refetching"
+			}
+			result.Paths = append(result.Paths, InvalidatedPath{Key: key, Cached: purged.Purged, Result: status})
+		}
+		for _, tag := range req.Tags {
+			if action == invalidateRefetch {
+				result.Tags = append(result.Tags, p.refetchTag(tag))
+			} else {
+				result.Tags = append(result.Tags, p.cache.PurgeTag(tag))
+			}
+		}
+	}
+	slog.Info("cache invalidated", "action", action, "paths", len(result.Paths), "tags", len(req.Tags))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// taggedKeys 返回帶有標籤的條目 key，略過 seen 中已有的
+func (p *Proxy) taggedKeys(tags []string, seen map[string]struct{}) []string {
+	var hashes []keyHash
+	for _, tag := range tags {
+		for _, e := range p.cache.tagged(tag) {
+			hashes = append(hashes, e.hash)
+		}
+	}
+	var keys []string
+	err := p.cache.store.Keys(hashes, func(_ keyHash, key string) {
+		if _, dup := seen[key]; !dup {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		slog.Warn("tagged key lookup failed", "error", err)
+	}
+	return keys
+}
+
+// revalidateKeys 並發地以條件請求確認已快取的條目；負快取與 HEAD 快取一律移除，
+// 沒有快取或屬於特定使用者的條目無法代為請求，改為直接移除
+func (p *Proxy) revalidateKeys(r *http.Request, keys []string) []InvalidatedPath {
+	results := make([]InvalidatedPath, len(keys))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	workers := 0
+	for range min(invalidateWorkers, len(keys)) {
+		wg.Add(1)
+		started := p.routines.Go(routineBackground, "revalidate", func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = p.revalidateKey(r, keys[i])
+			}
+		})
+		if !started {
+			wg.Done()
+			break
+		}
+		workers++
+	}
+	// 背景 goroutine 達到上限時在請求中依序驗證
+	for i := range keys {
+		if workers == 0 {
+			results[i] = p.revalidateKey(r, keys[i])
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// revalidateKey 重新驗證單一 key
+func (p *Proxy) revalidateKey(r *http.Request, key string) InvalidatedPath {
+	result := InvalidatedPath{Key: key, Result: "purged"}
+	entry, cached := p.cache.Peek(key)
+	result.Cached = cached
+	if !cached || isPrivateKey(key) {
+		p.cache.Remove(key)
+		return result
+	}
+	p.cache.negativeCache.Remove(key)
+	p.cache.headCache.Remove(key)
+
+	status, err := p.revalidate(r.Context(), key, entry)
+	switch {
+	case err != nil:
+		result.Result, result.Error = "failed", err.Error()
+		slog.Warn("revalidation failed", "key", key, "error", err)
+	case status == "REVALIDATED":
+		result.Result = "revalidated"
+	case status == "":
+		p.cache.Remove(key) // 路徑規則已改為不快取
+	default:
+		result.Result = "refreshed"
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package fileproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// linkRewriter 將回應內容中上游的絕對 URL 改寫為代理的公開位址
+//
+// npm 與 PyPI 的索引以絕對 URL 指向套件檔案，不改寫時客戶端直接向上游下載，繞過快取。
+// UpstreamURL 對應 PublicURL，每個路由對應 PublicURL 加上路由前綴。
+type linkRewriter struct {
+	pairs  []linkPair // 依 from 長度遞減排列，同一位置匹配時較長者優先
+	maxLen int
+}
+
+// linkPair 一組改寫：from 與 to 都以 / 結尾
+type linkPair struct {
+	from, to []byte
+}
+
+// newLinkRewriter 建立改寫器，未設定 PublicURL 時返回 nil
+func newLinkRewriter(cfg *Config) *linkRewriter {
+	if cfg.PublicURL == "" {
+		return nil
+	}
+	public := strings.TrimSuffix(cfg.PublicURL, "/")
+	lr := &linkRewriter{}
+	add := func(upstream, prefix string) {
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		from := u.Scheme + "://" + u.Host + strings.TrimSuffix(u.EscapedPath(), "/") + "/"
+		lr.pairs = append(lr.pairs, linkPair{from: []byte(from), to: []byte(public + prefix + "/")})
+		lr.maxLen = max(lr.maxLen, len(from))
+	}
+	if cfg.UpstreamURL != "" {
+		add(cfg.UpstreamURL, "")
+	}
+	for _, r := range cfg.Routes {
+		add(r.Upstream, r.Prefix)
+	}
+	slices.SortStableFunc(lr.pairs, func(a, b linkPair) int { return len(b.from) - len(a.from) })
+	return lr
+}
+
+// validatePublicURL 檢查 PublicURL，並確認有規則改寫連結時已設定
+func (c *Config) validatePublicURL() error {
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid public_url %q: expected http(s)://host[:port][/path]", c.PublicURL)
+		}
+		return nil
+	}
+	rewrites := slices.ContainsFunc(c.CacheRules, func(r CacheRule) bool { return r.RewriteLinks }) ||
+		slices.ContainsFunc(c.ExprRules, func(r ExprRule) bool { return r.RewriteLinks }) ||
+		slices.ContainsFunc(c.mirrorRules(), func(r CacheRule) bool { return r.RewriteLinks })
+	if rewrites {
+		return fmt.Errorf("public_url is required when a rule or mirror profile rewrites links")
+	}
+	return nil
+}
+
+// reader 以改寫後的內容包裝 body
+func (lr *linkRewriter) reader(body io.ReadCloser) io.ReadCloser {
+	return &linkReader{rewriter: lr, body: body, buf: make([]byte, 32*1024)}
+}
+
+// linkReader 串流改寫，保留結尾可能是部分匹配的位元組直到讀到更多內容
+type linkReader struct {
+	rewriter *linkRewriter
+	body     io.ReadCloser
+	buf      []byte
+	in       []byte // 尚未處理的輸入
+	out      []byte // 已改寫、等待讀取的輸出
+	err      error
+}
+
+func (r *linkReader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.body.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		r.err = err
+		r.process(err != nil)
+	}
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *linkReader) Close() error { return r.body.Close() }
+
+// process 改寫輸入中所有完整的匹配；final 為 false 時保留最後 maxLen-1 個位元組
+func (r *linkReader) process(final bool) {
+	for {
+		at, pair := r.rewriter.next(r.in)
+		if pair == nil {
+			break
+		}
+		r.out = append(r.out, r.in[:at]...)
+		r.out = append(r.out, pair.to...)
+		r.in = r.in[at+len(pair.from):]
+	}
+	keep := 0
+	if !final {
+		keep = min(len(r.in), max(r.rewriter.maxLen-1, 0))
+	}
+	r.out = append(r.out, r.in[:len(r.in)-keep]...)
+	r.in = append(r.in[:0], r.in[len(r.in)-keep:]...)
+}
+
+// next 返回最早出現的匹配位置，無匹配時 pair 為 nil
+func (lr *linkRewriter) next(data []byte) (int, *linkPair) {
+	best, found := -1, (*linkPair)(nil)
+	for i := range lr.pairs {
+		if at := bytes.Index(data, lr.pairs[i].from); at >= 0 && (best < 0 || at < best) {
+			best, found = at, &lr.pairs[i]
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,185 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixSocketPrefix 監聽地址以此開頭時改為監聽 Unix domain socket，例如 unix:///run/fileproxy.sock
+const unixSocketPrefix = "unix://"
+
+// unixSocketPath 返回 Unix domain socket 監聽地址的路徑
+func unixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixSocketPrefix)
+}
+
+// listen 依 socket 配置建立 TCP 或 Unix domain socket 監聽
+//
+// 監聽佇列長度由系統決定（Linux 為 net.core.somaxconn），Go 不提供設定方式。
+func (s *Server) listen(addr string) (net.Listener, error) {
+	if path, ok := unixSocketPath(addr); ok {
+		return s.listenUnix(path)
+	}
+	cfg := s.config
+	lc := net.ListenConfig{
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   cfg.KeepAliveIdle >= 0,
+			Idle:     cfg.KeepAliveIdle,
+			Interval: cfg.KeepAliveIntvl,
+			Count:    cfg.KeepAliveCount,
+		},
+	}
+	if cfg.KeepAliveIdle < 0 {
+		lc.KeepAlive = -1
+	}
+	if cfg.ListenReusePort {
+		lc.Control = reusePortControl
+	}
+
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.tuneListener(ln), nil
+}
+
+// listenUnix 建立 Unix domain socket 監聽並套用權限與擁有者
+//
+// 先前程序未清除的 socket 檔案在無人監聽時移除；關閉監聽時刪除 socket 檔案。
+// 設定權限或擁有者時以 umask 0177 建立，套用完成前只有本程序的使用者能連線。
+// umask 是整個程序共用的，期間其他 goroutine 建立的檔案權限也會較嚴格，只影響其他使用者的讀取。
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	mode, owner := s.config.ListenSocketMode, s.config.ListenSocketOwner
+	if mode == 0 && owner == "" {
+		return net.Listen("unix", path)
+	}
+
+	umask := setUmask(0o177)
+	ln, err := net.Listen("unix", path)
+	setUmask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = 0o777 &^ os.FileMode(umask) // 只變更擁有者時維持 umask 決定的權限
+	}
+	if owner != "" {
+		uid, gid, err := parseSocketOwner(owner)
+		if err == nil {
+			err = os.Chown(path, uid, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("chown socket: %w", err)
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket 移除無人監聽的舊 socket 檔案；路徑不是 socket 或仍有程序監聽時返回錯誤
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	slog.Info("removing stale socket", "path", path)
+	return os.Remove(path)
+}
+
+// parseSocketOwner 解析 user[:group]，可使用名稱或數字 ID；省略的部分返回 -1 表示不變更
+func parseSocketOwner(spec string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(spec, ":")
+	uid, gid = -1, -1
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, lookupErr := user.Lookup(userName)
+			if lookupErr != nil {
+				return 0, 0, lookupErr
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return 0, 0, lookupErr
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// tuneListener 需要調整接受的連線時包裝監聽
+//
+// 繼承的監聽也經過這裡；keepalive 由建立 socket 的程序決定，繼承時使用 Go 預設。
+func (s *Server) tuneListener(ln net.Listener) net.Listener {
+	cfg := s.config
+	if cfg.ListenNoDelay && cfg.ListenRecvBuffer == 0 && cfg.ListenSendBuffer == 0 {
+		return ln // 全部使用 Go 預設（已開啟 TCP_NODELAY）
+	}
+	return &tunedListener{Listener: ln, config: cfg}
+}
+
+// tunedListener 為每條接受的連線套用 TCP_NODELAY 與緩衝區大小
+type tunedListener struct {
+	net.Listener
+	config *Config
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := l.tune(tcp); err != nil {
+			slog.Debug("tune accepted connection failed", "remote", conn.RemoteAddr(), "error", err)
+		}
+	}
+	return conn, nil
+}
+
+func (l *tunedListener) tune(conn *net.TCPConn) error {
+	if err := conn.SetNoDelay(l.config.ListenNoDelay); err != nil {
+		return fmt.Errorf("set TCP_NODELAY: %w", err)
+	}
+	if size := l.config.ListenRecvBuffer; size > 0 {
+		if err := conn.SetReadBuffer(size); err != nil {
+			return fmt.Errorf("set SO_RCVBUF: %w", err)
+		}
+	}
+	if size := l.config.ListenSendBuffer; size > 0 {
+		if err := conn.SetWriteBuffer(size); err != nil {
+			return fmt.Errorf("set SO_SNDBUF: %w", err)
+		}
+	}
+	return nil
+}
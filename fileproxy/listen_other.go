@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fileproxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl 非 Linux 平台不支援 SO_REUSEPORT
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on linux")
+}
@@ -0,0 +1,98 @@
+package fileproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// LogOptions 日誌輸出配置
+type LogOptions struct {
+	Level   slog.Level
+	Format  string             // text 或 json
+	Samples map[string]float64 // 依訊息取樣的保留比例，例如 "cache hit" 只保留 0.01
+	Fields  map[string]string  // 每筆日誌附加的固定欄位
+	Rename  map[string]string  // 重新命名頂層欄位，例如 msg=message、time=@timestamp
+}
+
+// ParseLogSample 解析取樣規則：MESSAGE=RATE，RATE 介於 0 與 1
+func ParseLogSample(s string) (string, float64, error) {
+	msg, value, found := strings.Cut(s, "=")
+	if !found || msg == "" {
+		return "", 0, fmt.Errorf("log sample %q: expected MESSAGE=RATE", s)
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return "", 0, fmt.Errorf("log sample %q: rate must be between 0 and 1", s)
+	}
+	return msg, rate, nil
+}
+
+// ParseLogPair 解析 NAME=VALUE 形式的欄位設定
+func ParseLogPair(s string) (string, string, error) {
+	name, value, found := strings.Cut(s, "=")
+	if !found || name == "" {
+		return "", "", fmt.Errorf("log field %q: expected NAME=VALUE", s)
+	}
+	return name, value, nil
+}
+
+// NewLogHandler 依配置建立 slog handler
+func NewLogHandler(w io.Writer, opts LogOptions) (slog.Handler, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	if len(opts.Rename) > 0 {
+		handlerOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if name, ok := opts.Rename[a.Key]; ok && len(groups) == 0 {
+				a.Key = name
+			}
+			return a
+		}
+	}
+
+	var h slog.Handler
+	switch opts.Format {
+	case "", "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	if len(opts.Fields) > 0 {
+		attrs := make([]slog.Attr, 0, len(opts.Fields))
+		for name, value := range opts.Fields {
+			attrs = append(attrs, slog.String(name, value))
+		}
+		h = h.WithAttrs(attrs)
+	}
+	if len(opts.Samples) > 0 {
+		h = &samplingHandler{Handler: h, rates: opts.Samples}
+	}
+	return h, nil
+}
+
+// samplingHandler 依訊息內容隨機丟棄部分日誌，未列出的訊息全部保留
+type samplingHandler struct {
+	slog.Handler
+	rates map[string]float64
+}
+
+func (s *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if rate, ok := s.rates[r.Message]; ok && rand.Float64() >= rate {
+		return nil
+	}
+	return s.Handler.Handle(ctx, r)
+}
+
+func (s *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: s.Handler.WithAttrs(attrs), rates: s.rates}
+}
+
+func (s *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: s.Handler.WithGroup(name), rates: s.rates}
+}
@@ -0,0 +1,104 @@
+package fileproxy
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// memoryCache 磁碟快取前的記憶體熱層，僅存放小檔案
+//
+// 條目以磁碟快取的 *CacheEntry 作為版本：磁碟條目被淘汰或替換後，
+// 記憶體中的舊資料會在下次查詢時自動失效。
+type memoryCache struct {
+	maxSize     int64
+	maxFileSize int64
+
+	mu    sync.Mutex
+	lru   *simplelru.LRU[keyHash, *memoryEntry]
+	size  int64
+	hits  atomic.Int64
+	loads atomic.Int64
+}
+
+// memoryEntry 記憶體快取條目
+type memoryEntry struct {
+	entry *CacheEntry
+	data  []byte
+}
+
+// newMemoryCache 建立記憶體快取，maxSize 為 0 時返回 nil（停用）
+func newMemoryCache(maxSize, maxFileSize int64) *memoryCache {
+	if maxSize <= 0 || maxFileSize <= 0 {
+		return nil
+	}
+	m := &memoryCache{maxSize: maxSize, maxFileSize: maxFileSize}
+	m.lru, _ = simplelru.NewLRU[keyHash, *memoryEntry](math.MaxInt32, func(_ keyHash, me *memoryEntry) {
+		m.size -= int64(len(me.data))
+	})
+	return m
+}
+
+// admits 判斷檔案是否適合放入記憶體
+func (m *memoryCache) admits(size int64) bool {
+	return m != nil && size <= m.maxFileSize && size <= m.maxSize
+}
+
+// Get 取得與磁碟條目版本一致的記憶體資料
+func (m *memoryCache) Get(entry *CacheEntry) ([]byte, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	me, ok := m.lru.Get(entry.hash)
+	if !ok {
+		return nil, false
+	}
+	if me.entry != entry {
+		m.lru.Remove(entry.hash) // 磁碟條目已替換
+		return nil, false
+	}
+	m.hits.Add(1)
+	return me.data, true
+}
+
+// Add 放入記憶體並淘汰最久未使用的條目
+func (m *memoryCache) Add(entry *CacheEntry, data []byte) {
+	if !m.admits(int64(len(data))) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lru.Remove(entry.hash)
+	for m.size+int64(len(data)) > m.maxSize {
+		if _, _, ok := m.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+	m.lru.Add(entry.hash, &memoryEntry{entry: entry, data: data})
+	m.size += int64(len(data))
+	m.loads.Add(1)
+}
+
+// Stats 返回記憶體快取統計資訊
+func (m *memoryCache) Stats() map[string]any {
+	m.mu.Lock()
+	entries, size := m.lru.Len(), m.size
+	m.mu.Unlock()
+
+	return map[string]any{
+		"entries":       entries,
+		"size":          size,
+		"max_size":      m.maxSize,
+		"max_file_size": m.maxFileSize,
+		"hits":          m.hits.Load(),
+		"loads":         m.loads.Load(),
+	}
+}
@@ -0,0 +1,403 @@
+package fileproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metalinkMediaType       = "application/metalink4+xml"
+	metalinkNamespace       = "urn:ietf:params:xml:ns:metalink"
+	metalinkMaxSize         = 1 << 20         // Metalink 文件的大小上限
+	metalinkDefaultPriority = 999999          // 未指定 priority 的鏡像排在最後（RFC 5854）
+	metalinkPenalty         = 5 * time.Minute // 失敗的鏡像在此期間排到最後
+	metalinkMaxFailed       = 1000            // 記錄失敗時間的鏡像上限
+)
+
+// metalinkHashes 可校驗的雜湊演算法，依強度排列，文件列出多種時使用第一個
+var metalinkHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha-512", sha512.New},
+	{"sha-384", sha512.New384},
+	{"sha-256", sha256.New},
+	{"sha-1", sha1.New},
+	{"md5", md5.New},
+}
+
+// errNoMirror Metalink 沒有可用的 HTTP 鏡像
+var errNoMirror = errors.New("metalink lists no http mirror")
+
+// errMetalinkMismatch 下載內容與 Metalink 記載的大小或雜湊不符
+var errMetalinkMismatch = errors.New("content does not match metalink")
+
+// metalinkDocument Metalink 4 文件中用到的部分
+type metalinkDocument struct {
+	XMLName xml.Name       `xml:"metalink"`
+	Files   []metalinkFile `xml:"file"`
+}
+
+type metalinkFile struct {
+	Name   string         `xml:"name,attr"`
+	Size   string         `xml:"size"`
+	Hashes []metalinkHash `xml:"hash"` // 只取整個檔案的雜湊，pieces 內的分段雜湊不在此列
+	URLs   []metalinkURL  `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority string `xml:"priority,attr"`
+	URL      string `xml:",chardata"`
+}
+
+// metalinkTarget 從 Metalink 取得的下載資訊
+type metalinkTarget struct {
+	size    int64 // 未記載時為 -1
+	algo    string
+	newHash func() hash.Hash // 未記載可用的雜湊時為 nil
+	sum     []byte
+	mirrors []metalinkURL
+}
+
+// metalinkFetcher 處理上游返回的 Metalink（RFC 5854）
+//
+// 回源請求以 Accept 表明接受 Metalink；上游（如 MirrorBrain）返回 Metalink 時，依 priority 從其中的鏡像下載，
+// 最近失敗的鏡像排到最後。鏡像無法連線或返回錯誤時換下一個，下載途中中斷時以 Range 從下一個鏡像續傳。
+// 完成時比對 Metalink 記載的大小與雜湊，不符時不寫入快取。條目的驗證器與存活時間沿用 Metalink 的回應。
+type metalinkFetcher struct {
+	proxy *Proxy
+
+	mu     sync.Mutex
+	failed map[string]time.Time // 依鏡像來源，最近失敗的時間
+
+	documents  atomic.Int64 // 解析的 Metalink
+	failures   atomic.Int64 // 鏡像失敗而換下一個的次數
+	mismatches atomic.Int64 // 內容與 Metalink 不符
+	exhausted  atomic.Int64 // 所有鏡像都失敗
+}
+
+// newMetalinkFetcher 建立 Metalink 處理器，未啟用時返回 nil
+func newMetalinkFetcher(p *Proxy, cfg *Config) *metalinkFetcher {
+	if !cfg.Metalink {
+		return nil
+	}
+	return &metalinkFetcher{proxy: p, failed: make(map[string]time.Time)}
+}
+
+// accept 在回源請求中表明接受 Metalink，請求 .meta4 檔案本身時不變
+func (m *metalinkFetcher) accept(req *http.Request, key string) {
+	if m == nil || m.isDocumentKey(key) {
+		return
+	}
+	req.Header.Set("Accept", metalinkMediaType+", */*;q=0.1")
+}
+
+// Match 檢查上游的回應是否為需要展開的 Metalink
+func (m *metalinkFetcher) Match(key string, resp *http.Response) bool {
+	if m == nil || m.isDocumentKey(key) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == metalinkMediaType
+}
+
+// isDocumentKey 檢查請求的是否為 .meta4 檔案本身
+func (m *metalinkFetcher) isDocumentKey(key string) bool {
+	keyPath, _ := m.proxy.config.splitKey(key)
+	return strings.HasSuffix(keyPath, ".meta4")
+}
+
+// Open 解析上游的 Metalink 並開始從鏡像下載
+//
+// 返回的回應帶有鏡像的回應頭，但驗證器與快取指示來自 Metalink 的回應；內容以返回的 reader 讀取，
+// 讀到結尾時校驗大小與雜湊。
+func (m *metalinkFetcher) Open(ctx context.Context, r *http.Request, key string, resp *http.Response) (*http.Response, io.ReadCloser, error) {
+	m.documents.Add(1)
+	keyPath, _ := m.proxy.config.splitKey(key)
+	target, err := parseMetalink(io.LimitReader(resp.Body, metalinkMaxSize), path.Base(keyPath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse metalink: %w", err)
+	}
+
+	mr := &metalinkReader{fetcher: m, ctx: ctx, client: r, key: key, size: target.size, sum: target.sum, mirrors: m.rank(target.mirrors)}
+	if target.newHash != nil {
+		mr.hash = target.newHash()
+	}
+	mresp, err := mr.next(0)
+	if err != nil {
+		m.exhausted.Add(1)
+		return nil, nil, err
+	}
+
+	for _, name := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires"} {
+		mresp.Header.Del(name)
+		if values := resp.Header.Values(name); len(values) > 0 {
+			mresp.Header[name] = values
+		}
+	}
+	if target.size >= 0 {
+		mresp.ContentLength = target.size
+	}
+	slog.Debug("fetching from metalink mirror", "key", key, "mirror", mr.current, "hash", target.algo)
+	return mresp, mr, nil
+}
+
+// parseMetalink 解析 Metalink 4 文件，文件列出多個檔案時選擇名稱為 name 的一個
+func parseMetalink(body io.Reader, name string) (*metalinkTarget, error) {
+	var doc metalinkDocument
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Space != metalinkNamespace {
+		return nil, fmt.Errorf("unsupported metalink namespace %q", doc.XMLName.Space)
+	}
+	var file *metalinkFile
+	for i := range doc.Files {
+		if len(doc.Files) == 1 || doc.Files[i].Name == name {
+			file = &doc.Files[i]
+			break
+		}
+	}
+	if file == nil {
+		return nil, fmt.Errorf("metalink has no file named %q", name)
+	}
+
+	target := &metalinkTarget{size: -1}
+	if s := strings.TrimSpace(file.Size); s != "" {
+		size, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid metalink size %q", s)
+		}
+		target.size = size
+	}
+	for _, algo := range metalinkHashes {
+		i := slices.IndexFunc(file.Hashes, func(h metalinkHash) bool { return strings.EqualFold(h.Type, algo.name) })
+		if i < 0 {
+			continue
+		}
+		sum, err := hex.DecodeString(strings.TrimSpace(file.Hashes[i].Value))
+		if err != nil || len(sum) != algo.new().Size() {
+			return nil, fmt.Errorf("invalid metalink %s hash", algo.name)
+		}
+		target.algo, target.newHash, target.sum = algo.name, algo.new, sum
+		break
+	}
+	for _, u := range file.URLs {
+		u.URL = strings.TrimSpace(u.URL)
+		if strings.HasPrefix(u.URL, "http://") || strings.HasPrefix(u.URL, "https://") {
+			target.mirrors = append(target.mirrors, u)
+		}
+	}
+	if len(target.mirrors) == 0 {
+		return nil, errNoMirror
+	}
+	return target, nil
+}
+
+// rank 依 priority 排列鏡像，最近失敗的鏡像排到最後
+func (m *metalinkFetcher) rank(mirrors []metalinkURL) []string {
+	priority := func(u metalinkURL) int {
+		if p, err := strconv.Atoi(u.Priority); err == nil && p > 0 {
+			return p
+		}
+		return metalinkDefaultPriority
+	}
+	sorted := slices.Clone(mirrors)
+	slices.SortStableFunc(sorted, func(a, b metalinkURL) int { return priority(a) - priority(b) })
+
+	now := time.Now()
+	var ready, penalized []string
+	m.mu.Lock()
+	for _, u := range sorted {
+		if failedAt, ok := m.failed[upstreamOrigin(u.URL)]; ok && now.Sub(failedAt) < metalinkPenalty {
+			penalized = append(penalized, u.URL)
+		} else {
+			ready = append(ready, u.URL)
+		}
+	}
+	m.mu.Unlock()
+	return append(ready, penalized...)
+}
+
+// fail 記錄鏡像失敗，之後的下載在一段時間內最後才嘗試它
+func (m *metalinkFetcher) fail(mirror string) {
+	m.failures.Add(1)
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.failed) >= metalinkMaxFailed {
+		for origin, failedAt := range m.failed {
+			if now.Sub(failedAt) >= metalinkPenalty {
+				delete(m.failed, origin)
+			}
+		}
+		if len(m.failed) >= metalinkMaxFailed {
+			clear(m.failed)
+		}
+	}
+	m.failed[upstreamOrigin(mirror)] = now
+}
+
+// get 從鏡像下載 offset 之後的內容，回應與 Metalink 記載的大小不符時視為失敗
+func (m *metalinkFetcher) get(ctx context.Context, r *http.Request, mirror string, offset, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mirror, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.proxy.setProxyHeaders(req, r)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := m.proxy.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mirror request: %w", err)
+	}
+	switch {
+	case offset == 0 && resp.StatusCode == http.StatusOK:
+		if size < 0 || resp.ContentLength < 0 || resp.ContentLength == size {
+			removeHopHeaders(resp.Header)
+			return resp, nil
+		}
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if contentRangeMatches(resp.Header.Get("Content-Range"), offset, size, size) {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("mirror %s: status %d, content length %d", upstreamOrigin(mirror), resp.StatusCode, resp.ContentLength)
+}
+
+// Stats 返回 Metalink 統計資訊
+func (m *metalinkFetcher) Stats() map[string]any {
+	now := time.Now()
+	m.mu.Lock()
+	penalized := 0
+	for _, failedAt := range m.failed {
+		if now.Sub(failedAt) < metalinkPenalty {
+			penalized++
+		}
+	}
+	m.mu.Unlock()
+	return map[string]any{
+		"documents":       m.documents.Load(),
+		"mirror_failures": m.failures.Load(),
+		"mismatches":      m.mismatches.Load(),
+		"exhausted":       m.exhausted.Load(),
+		"penalized":       penalized,
+	}
+}
+
+// metalinkReader 依序從鏡像讀取內容並計算雜湊，讀到結尾時與 Metalink 比對
+type metalinkReader struct {
+	fetcher *metalinkFetcher
+	ctx     context.Context
+	client  *http.Request // 客戶端請求，轉交 Via 與 Forwarded
+	key     string
+	size    int64
+	hash    hash.Hash
+	sum     []byte
+	mirrors []string // 尚未嘗試的鏡像
+
+	current string
+	body    io.ReadCloser
+	offset  int64
+}
+
+// next 依序嘗試剩下的鏡像，從 offset 開始下載
+func (mr *metalinkReader) next(offset int64) (*http.Response, error) {
+	err := errNoMirror
+	for len(mr.mirrors) > 0 {
+		mirror := mr.mirrors[0]
+		mr.mirrors = mr.mirrors[1:]
+		resp, gerr := mr.fetcher.get(mr.ctx, mr.client, mirror, offset, mr.size)
+		if gerr == nil {
+			mr.current, mr.body = mirror, resp.Body
+			return resp, nil
+		}
+		if mr.ctx.Err() != nil {
+			return nil, mr.ctx.Err()
+		}
+		mr.fetcher.fail(mirror)
+		slog.Warn("metalink mirror failed", "key", mr.key, "mirror", upstreamOrigin(mirror), "error", gerr)
+		err = gerr
+	}
+	return nil, err
+}
+
+func (mr *metalinkReader) Read(b []byte) (int, error) {
+	n, err := mr.body.Read(b)
+	mr.offset += int64(n)
+	if mr.hash != nil {
+		mr.hash.Write(b[:n])
+	}
+	if err == io.EOF && mr.size >= 0 && mr.offset < mr.size {
+		err = io.ErrUnexpectedEOF
+	}
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		err = mr.verify()
+	default:
+		err = mr.failover(err)
+	}
+	return n, err
+}
+
+// failover 鏡像中斷時從下一個鏡像續傳，長度未知或沒有鏡像可用時返回原錯誤
+func (mr *metalinkReader) failover(cause error) error {
+	mr.body.Close()
+	mr.fetcher.fail(mr.current)
+	if mr.size < 0 || mr.ctx.Err() != nil {
+		return cause
+	}
+	slog.Warn("metalink mirror interrupted, resuming from next mirror", "key", mr.key, "mirror", upstreamOrigin(mr.current), "offset", mr.offset, "error", cause)
+	if _, err := mr.next(mr.offset); err != nil {
+		mr.fetcher.exhausted.Add(1)
+		return cause
+	}
+	return nil
+}
+
+// verify 比對讀到的大小與雜湊，不符時將目前的鏡像記為失敗
+func (mr *metalinkReader) verify() error {
+	var err error
+	if mr.size >= 0 && mr.offset != mr.size {
+		err = fmt.Errorf("%w: size %d, want %d", errMetalinkMismatch, mr.offset, mr.size)
+	} else if mr.hash != nil && !bytes.Equal(mr.hash.Sum(nil), mr.sum) {
+		err = fmt.Errorf("%w: hash %x, want %x", errMetalinkMismatch, mr.hash.Sum(nil), mr.sum)
+	}
+	if err == nil {
+		return io.EOF
+	}
+	mr.fetcher.mismatches.Add(1)
+	mr.fetcher.fail(mr.current)
+	slog.Warn("metalink content mismatch", "key", mr.key, "mirror", upstreamOrigin(mr.current), "error", err)
+	return err
+}
+
+func (mr *metalinkReader) Close() error { return mr.body.Close() }
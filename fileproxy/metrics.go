@@ -0,0 +1,206 @@
+package fileproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const metricsPath = "/metrics"
+
+// 直方圖的 bucket 上界
+var (
+	latencyBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}          // 秒
+	ageBuckets      = []float64{60, 300, 900, 1800, 3600, 10800, 21600, 43200, 86400, 259200, 604800, 2592000} // 秒，1 分鐘到 30 天
+	ttlRatioBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 5, 10, 100}
+	hitBuckets      = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 1000, 10000}
+)
+
+// metrics 以 Prometheus/OpenMetrics 格式輸出的延遲直方圖
+//
+// 取樣到的追蹤會成為所在 bucket 的 exemplar，Grafana 上 MISS 的 p99 突增時可直接
+// 點進對應的慢速回源追蹤。每個 bucket 只保留最近一個 exemplar。
+type metrics struct {
+	requests *histogram // 客戶端請求，依 X-Cache 結果分組
+	fetches  *histogram // 回源下載（含本體傳輸），依狀態碼類別分組
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: newHistogram("fileproxy_request_duration_seconds", "Client request latency by cache result.", "cache", latencyBuckets),
+		fetches:  newHistogram("fileproxy_upstream_fetch_duration_seconds", "Upstream fetch latency including the body transfer.", "status", latencyBuckets),
+	}
+}
+
+// lifetimeMetrics 條目離開快取時的壽命分佈，依移除原因分組
+//
+// 年齡與 TTL 的比值接近 0 表示條目在到期前很久就被擠出（容量不足），
+// 大量零命中的條目表示快取了只請求一次的內容。
+type lifetimeMetrics struct {
+	age      *histogram // 建立到移除的時間
+	ttlRatio *histogram // 年齡除以存活時間，滑動過期的條目以預設 TTL 計算
+	hits     *histogram // 移除前的累計命中次數
+}
+
+func newLifetimeMetrics() *lifetimeMetrics {
+	return &lifetimeMetrics{
+		age:      newHistogram("fileproxy_cache_entry_age_seconds", "Age of cache entries when they leave the cache.", "reason", ageBuckets),
+		ttlRatio: newHistogram("fileproxy_cache_entry_ttl_ratio", "Age of cache entries when they leave the cache divided by their TTL.", "reason", ttlRatioBuckets),
+		hits:     newHistogram("fileproxy_cache_entry_hits", "Hits served by cache entries before they leave the cache.", "reason", hitBuckets),
+	}
+}
+
+// observe 記錄一個被移除的條目
+func (m *lifetimeMetrics) observe(e *CacheEntry, reason string, defaultTTL time.Duration) {
+	age := max(time.Duration(time.Now().UnixNano()-e.createdAt), 0)
+	ttl := e.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	m.age.add(reason, age.Seconds(), nil)
+	if ttl > 0 {
+		m.ttlRatio.add(reason, age.Seconds()/ttl.Seconds(), nil)
+	}
+	m.hits.add(reason, float64(e.served.Load()), nil)
+}
+
+// write 輸出壽命直方圖
+func (m *lifetimeMetrics) write(w io.Writer, openMetrics bool) {
+	m.age.write(w, openMetrics)
+	m.ttlRatio.write(w, openMetrics)
+	m.hits.write(w, openMetrics)
+}
+
+// exemplar 連結到單一追蹤的觀測值
+type exemplar struct {
+	traceID string
+	spanID  string
+	value   float64
+	at      time.Time
+}
+
+// histogramSeries 單一標籤值的直方圖
+type histogramSeries struct {
+	counts    []uint64 // 各 bucket 的非累計計數，最後一個為 +Inf
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+// histogram 依單一標籤分組的直方圖
+type histogram struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// observe 記錄一次延遲觀測，span 已取樣且正在記錄時保留為 exemplar
+func (h *histogram) observe(value string, d time.Duration, span trace.Span) {
+	seconds := d.Seconds()
+	var ex *exemplar
+	if sc := span.SpanContext(); span.IsRecording() && sc.IsSampled() {
+		ex = &exemplar{traceID: sc.TraceID().String(), spanID: sc.SpanID().String(), value: seconds, at: time.Now()}
+	}
+	h.add(value, seconds, ex)
+}
+
+// add 記錄一次觀測，ex 不為 nil 時成為所在 bucket 的 exemplar
+func (h *histogram) add(value string, v float64, ex *exemplar) {
+	idx, _ := slices.BinarySearch(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[value]
+	if !ok {
+		s = &histogramSeries{
+			counts:    make([]uint64, len(h.buckets)+1),
+			exemplars: make([]*exemplar, len(h.buckets)+1),
+		}
+		h.series[value] = s
+	}
+	s.counts[idx]++
+	s.sum += v
+	s.count++
+	if ex != nil {
+		s.exemplars[idx] = ex
+	}
+}
+
+// write 輸出直方圖，openMetrics 為 true 時附帶 exemplar
+func (h *histogram) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	values := make([]string, 0, len(h.series))
+	for v := range h.series {
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	for _, v := range values {
+		s := h.series[v]
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = strconv.FormatFloat(h.buckets[i], 'f', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=%q} %d", h.name, h.label, v, le, cumulative)
+			if ex := s.exemplars[i]; openMetrics && ex != nil {
+				fmt.Fprintf(w, " # {trace_id=%q,span_id=%q} %s %.3f", ex.traceID, ex.spanID,
+					strconv.FormatFloat(ex.value, 'g', -1, 64), float64(ex.at.UnixMilli())/1000)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s_sum{%s=%q} %s\n", h.name, h.label, v, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", h.name, h.label, v, s.count)
+	}
+}
+
+// statusClass 將狀態碼歸類為 2xx、3xx 等，0 表示連線失敗
+func statusClass(status int) string {
+	if status <= 0 {
+		return "error"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// cacheLabel 將 X-Cache 結果轉為標籤值，沒有結果（例如被拒絕的請求）時為 none
+func cacheLabel(result string) string {
+	if result == "" {
+		return "none"
+	}
+	return strings.ToLower(result)
+}
+
+// handleMetrics 輸出延遲與條目壽命直方圖；抓取端接受 OpenMetrics 時附帶追蹤 exemplar
+func (p *Proxy) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	p.metrics.requests.write(w, openMetrics)
+	p.metrics.fetches.write(w, openMetrics)
+	p.cache.lifetimes.write(w, openMetrics)
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
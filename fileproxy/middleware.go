@@ -0,0 +1,181 @@
+package fileproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// 內建中介層名稱
+const (
+	MiddlewareLogging   = "logging"    // 存取日誌，未啟用存取日誌時不作用
+	MiddlewareCORS      = "cors"       // 跨來源請求與預檢
+	MiddlewareScanGuard = "scan_guard" // 封鎖列舉不存在路徑的客戶端
+	MiddlewareRateLimit = "rate_limit" // 每個客戶端 IP 的請求速率與並發限制
+	MiddlewareAuth      = "auth"       // API Key 驗證與下載額度
+)
+
+// Middleware 代理請求處理前的一層中介層
+//
+// Handler 為 nil 時以 Name 選擇內建中介層；否則為嵌入方的自訂中介層，Name 只用於日誌與錯誤訊息。
+// Config.Middleware 只包裝代理請求，管理端點與請求驗證不經過，兩者的存取日誌固定在最外層；
+// Config.AdminMiddleware 包裝獨立管理監聽上的所有端點。
+//
+// 路徑改寫（Config.Rewrites）不是中介層：它只決定回源路徑，快取 key 仍是公開路徑，
+// 而且交易式預取與寫後上傳等不經過中介層鏈的回源同樣需要套用。
+type Middleware struct {
+	Name    string
+	Handler func(next http.Handler) http.Handler
+}
+
+// DefaultMiddleware 返回預設的中介層鏈：存取日誌、CORS、掃描防護、限流、驗證
+//
+// 嵌入方可在其中插入自訂中介層，例如在 auth 之後加入自己的授權檢查：
+//
+//	chain := fileproxy.DefaultMiddleware()
+//	cfg.Middleware = append(chain, fileproxy.Middleware{Name: "tenant", Handler: tenantCheck})
+func DefaultMiddleware() []Middleware {
+	return []Middleware{{Name: MiddlewareLogging}, {Name: MiddlewareCORS}, {Name: MiddlewareScanGuard}, {Name: MiddlewareRateLimit}, {Name: MiddlewareAuth}}
+}
+
+// middlewareChain 返回生效的中介層鏈，未設定時使用預設值
+func (c *Config) middlewareChain() []Middleware {
+	if c.Middleware == nil {
+		return DefaultMiddleware()
+	}
+	return c.Middleware
+}
+
+// validateMiddleware 檢查中介層名稱，並確認已啟用的功能對應的內建中介層在鏈中
+func (c *Config) validateMiddleware() error {
+	builtin := make(map[string]bool)
+	for _, m := range c.middlewareChain() {
+		if m.Handler != nil {
+			continue
+		}
+		switch m.Name {
+		case MiddlewareLogging, MiddlewareCORS, MiddlewareScanGuard, MiddlewareRateLimit, MiddlewareAuth:
+		default:
+			return fmt.Errorf("unknown middleware %q", m.Name)
+		}
+		if builtin[m.Name] {
+			return fmt.Errorf("middleware %q listed twice", m.Name)
+		}
+		builtin[m.Name] = true
+	}
+	for _, m := range c.AdminMiddleware {
+		if m.Handler == nil {
+			return fmt.Errorf("admin middleware %q: only custom middleware is supported on the admin listener", m.Name)
+		}
+	}
+	if len(c.AdminMiddleware) > 0 && c.AdminListenAddr == "" {
+		return fmt.Errorf("admin middleware requires admin_listen_addr")
+	}
+	required := []struct {
+		name    string
+		enabled bool
+		feature string
+	}{
+		{MiddlewareLogging, c.AccessLog || c.AccessLogFile != "", "access_log"},
+		{MiddlewareCORS, len(c.CORSOrigins) > 0, "cors_origins"},
+		{MiddlewareScanGuard, c.ScanNotFoundLimit > 0, "scan_notfound_limit"},
+		{MiddlewareRateLimit, c.rateLimited(), "rate_limit"},
+		{MiddlewareAuth, len(c.APIKeys) > 0, "api_keys"},
+	}
+	for _, r := range required {
+		if r.enabled && !builtin[r.name] {
+			return fmt.Errorf("%s is set but the %q middleware is not in the chain", r.feature, r.name)
+		}
+	}
+	return nil
+}
+
+// applyMiddleware 依順序以中介層包裝 next，第一個中介層在最外層
+func applyMiddleware(chain []Middleware, next http.Handler) http.Handler {
+	for i := len(chain) - 1; i >= 0; i-- {
+		next = chain[i].Handler(next)
+	}
+	return next
+}
+
+// buildHandler 以設定的中介層鏈包裝 next，內建中介層換成對應的實作
+func (p *Proxy) buildHandler(next http.Handler) http.Handler {
+	chain := slices.Clone(p.config.middlewareChain())
+	for i, m := range chain {
+		if m.Handler != nil {
+			continue
+		}
+		switch m.Name {
+		case MiddlewareLogging:
+			chain[i].Handler = p.accessLogMiddleware
+		case MiddlewareCORS:
+			chain[i].Handler = p.cors.middleware
+		case MiddlewareScanGuard:
+			chain[i].Handler = p.scanGuardMiddleware
+		case MiddlewareRateLimit:
+			chain[i].Handler = p.rateLimitMiddleware
+		case MiddlewareAuth:
+			chain[i].Handler = p.authMiddleware
+		}
+	}
+	return applyMiddleware(chain, next)
+}
+
+// scanGuardMiddleware 拒絕封鎖中的客戶端，並計入回應的 404
+func (p *Proxy) scanGuardMiddleware(next http.Handler) http.Handler {
+	if p.scans == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.scans.Reject(w, r) {
+			return
+		}
+		next.ServeHTTP(p.scans.Writer(w, r), r)
+	})
+}
+
+// rateLimitMiddleware 超出限制時返回 429
+func (p *Proxy) rateLimitMiddleware(next http.Handler) http.Handler {
+	if p.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, retryAfter, ok := p.limiter.Acquire(r, r.URL.Path)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authMiddleware 驗證 API Key 與下載額度，並計量傳輸的位元組
+func (p *Proxy) authMiddleware(next http.Handler) http.Handler {
+	if p.auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		apiKey, status := p.auth.authorize(r, path)
+		if status != 0 {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fileproxy"`)
+			}
+			slog.Debug("request denied", "key", path, "remote", r.RemoteAddr, "status", status)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if retry, over := p.auth.rejectOverQuota(apiKey); over {
+			slog.Debug("request over quota", "key", path, "api_key", apiKey.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Round(time.Second).Seconds())))
+			http.Error(w, "Quota Exceeded", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(p.auth.meter(r.Context(), w, apiKey), r)
+	})
+}
@@ -0,0 +1,115 @@
+package fileproxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mirrorProfiles 套件鏡像設定檔，依名稱產生快取規則
+//
+// 索引（Release、Packages、repomd.xml）隨時會更新，只快取 metaTTL；套件與以內容雜湊命名的檔案
+// 不會改變，設為不可變。以雜湊命名的檔案完成下載時比對 SHA-256，不符時不寫入快取。
+// npm 與 PyPI 的索引以絕對 URL 指向套件檔案，改寫為 PublicURL 下的路徑，讓套件檔案也經過快取。
+// huggingface 的檔案下載由 hfResolver 處理，規則只涵蓋 API。
+var mirrorProfiles = map[string]func(metaTTL time.Duration) []CacheRule{
+	"apt": func(metaTTL time.Duration) []CacheRule {
+		meta := CacheRule{TTL: metaTTL, NotFoundTTL: metaTTL, Tags: []string{"apt-metadata"}}
+		rules := []CacheRule{{Pattern: "/**/by-hash/SHA256/*", Immutable: true, VerifySum: true}}
+		for _, pattern := range []string{"InRelease", "Release", "Release.gpg", "Packages*", "Sources*", "Contents-*", "Translation-*", "Components-*", "Index"} {
+			rule := meta
+			rule.Pattern = "/**/" + pattern
+			rules = append(rules, rule)
+		}
+		for _, pattern := range []string{"*.deb", "*.udeb", "*.ddeb", "*.dsc", "*.tar.*", "*.diff.gz"} {
+			rules = append(rules, CacheRule{Pattern: "/**/" + pattern, Immutable: true})
+		}
+		return rules
+	},
+	"yum": func(metaTTL time.Duration) []CacheRule {
+		return []CacheRule{
+			{Pattern: "/**/repodata/repomd.xml*", TTL: metaTTL, NotFoundTTL: metaTTL, Tags: []string{"yum-metadata"}},
+			// createrepo 預設以內容雜湊作為檔名前綴，repomd.xml 更新後指向新的檔名
+			{Pattern: "/**/repodata/*", Immutable: true, VerifySum: true},
+			{Pattern: "/**/*.rpm", Immutable: true},
+			{Pattern: "/**/*.drpm", Immutable: true},
+		}
+	},
+	"npm": func(metaTTL time.Duration) []CacheRule {
+		return []CacheRule{
+			{Pattern: "/**/-/*.tgz", Immutable: true},
+			// 其餘路徑都是套件文件，匹配所有 key，須列在其他設定檔之後
+			{Pattern: "/**/*", TTL: metaTTL, NotFoundTTL: metaTTL, RewriteLinks: true, Tags: []string{"npm-metadata"}},
+		}
+	},
+	hfProfile: func(metaTTL time.Duration) []CacheRule {
+		return []CacheRule{{Pattern: "/api/**", TTL: metaTTL, NotFoundTTL: metaTTL, Tags: []string{"hf-metadata"}}}
+	},
+	"pypi": func(metaTTL time.Duration) []CacheRule {
+		meta := CacheRule{TTL: metaTTL, NotFoundTTL: metaTTL, RewriteLinks: true, Tags: []string{"pypi-metadata"}}
+		var rules []CacheRule
+		// simple 索引（PEP 503/691）與 JSON API
+		for _, pattern := range []string{"simple/", "simple/*/", "simple/*", "pypi/*/json", "pypi/*/*/json"} {
+			rule := meta
+			rule.Pattern = "/**/" + pattern
+			rules = append(rules, rule)
+		}
+		for _, pattern := range []string{"*.whl", "*.whl.metadata", "*.tar.gz", "*.tar.gz.metadata", "*.zip", "*.tar.bz2", "*.egg"} {
+			rules = append(rules, CacheRule{Pattern: "/**/" + pattern, Immutable: true})
+		}
+		return rules
+	},
+}
+
+// validateMirrorProfiles 驗證設定檔名稱
+func (c *Config) validateMirrorProfiles() error {
+	for _, name := range c.MirrorProfiles {
+		if _, ok := mirrorProfiles[name]; !ok {
+			return fmt.Errorf("unknown mirror profile %q, expected one of %s", name, strings.Join(slices.Sorted(maps.Keys(mirrorProfiles)), ", "))
+		}
+	}
+	if len(c.MirrorProfiles) > 0 && c.MirrorMetaTTL <= 0 {
+		return fmt.Errorf("mirror_meta_ttl must be positive")
+	}
+	return nil
+}
+
+// mirrorRules 展開設定檔的快取規則，依設定檔的順序排列
+func (c *Config) mirrorRules() []CacheRule {
+	var rules []CacheRule
+	for _, name := range c.MirrorProfiles {
+		rules = append(rules, mirrorProfiles[name](c.MirrorMetaTTL)...)
+	}
+	return rules
+}
+
+// profileRuleFor 返回第一條匹配 key 的設定檔規則，無匹配時返回 nil
+func (p *Proxy) profileRuleFor(key string) *CacheRule {
+	for i := range p.profiles {
+		if p.profiles[i].Match(key) {
+			return &p.profiles[i]
+		}
+	}
+	return nil
+}
+
+// nameSum 從檔名取得內容的 SHA-256：整個檔名或以 - 分隔的前綴為 64 位十六進位時返回
+func nameSum(key string) (keyHash, bool) {
+	var sum keyHash
+	name := path.Base(key)
+	if len(name) < hex.EncodedLen(len(sum)) {
+		return sum, false
+	}
+	prefix, rest := name[:hex.EncodedLen(len(sum))], name[hex.EncodedLen(len(sum)):]
+	if rest != "" && rest[0] != '-' {
+		return sum, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(prefix)); err != nil {
+		return sum, false
+	}
+	return sum, true
+}
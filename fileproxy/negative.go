@@ -0,0 +1,138 @@
+package fileproxy
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// negativeEntryOverhead 每個負快取條目除 key 以外的估計記憶體用量（map 槽、鏈結節點與條目本身）
+const negativeEntryOverhead = 160
+
+// negativeItem 負快取鏈結中的條目
+type negativeItem struct {
+	key   string
+	entry negativeEntry
+}
+
+// negativeLRU 負快取，以條目數與估計的記憶體用量為上限，超出任一上限時淘汰最久未使用的條目
+//
+// 爬蟲產生的大量相異 404 key 長度差異很大，以記憶體用量為上限時長 key 不會撐大用量，
+// 短 key 也不會因條目數上限過早被淘汰。過期的條目在查詢時移除，並由背景回收從鏈結尾端清除。
+type negativeLRU struct {
+	maxItems int   // 0 表示不限條目數
+	maxBytes int64 // 0 表示不限記憶體用量
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // 前端為最近使用
+	bytes int64
+
+	evicted atomic.Int64 // 因超出上限而淘汰的未過期條目數
+}
+
+// newNegativeLRU 建立負快取
+func newNegativeLRU(maxItems int, maxBytes int64) *negativeLRU {
+	return &negativeLRU{
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// negativeCost 返回條目的估計記憶體用量
+func negativeCost(key string) int64 { return int64(len(key)) + negativeEntryOverhead }
+
+// Get 取得條目並標記為最近使用，過期與否由呼叫方判斷
+func (n *negativeLRU) Get(key string) (negativeEntry, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	el, ok := n.items[key]
+	if !ok {
+		return negativeEntry{}, false
+	}
+	n.order.MoveToFront(el)
+	return el.Value.(*negativeItem).entry, true
+}
+
+// Add 新增或更新條目，超出上限時從最久未使用的條目開始淘汰
+func (n *negativeLRU) Add(key string, entry negativeEntry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.items[key]; ok {
+		el.Value.(*negativeItem).entry = entry
+		n.order.MoveToFront(el)
+		return
+	}
+	n.items[key] = n.order.PushFront(&negativeItem{key: key, entry: entry})
+	n.bytes += negativeCost(key)
+
+	now := time.Now()
+	for n.order.Len() > 1 && n.over() {
+		oldest := n.order.Back()
+		if now.Before(oldest.Value.(*negativeItem).entry.expiresAt) {
+			n.evicted.Add(1)
+		}
+		n.remove(oldest)
+	}
+}
+
+// over 是否超出任一上限，需持有 mu
+func (n *negativeLRU) over() bool {
+	return n.maxItems > 0 && n.order.Len() > n.maxItems || n.maxBytes > 0 && n.bytes > n.maxBytes
+}
+
+// Remove 移除條目
+func (n *negativeLRU) Remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.items[key]; ok {
+		n.remove(el)
+	}
+}
+
+// remove 移除鏈結節點，需持有 mu
+func (n *negativeLRU) remove(el *list.Element) {
+	item := n.order.Remove(el).(*negativeItem)
+	delete(n.items, item.key)
+	n.bytes -= negativeCost(item.key)
+}
+
+// Len 返回條目數
+func (n *negativeLRU) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.order.Len()
+}
+
+// sweep 從最久未使用的一端檢查至多 limit 個條目並移除已過期的，返回移除數
+func (n *negativeLRU) sweep(now time.Time, limit int) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	removed := 0
+	for el := n.order.Back(); el != nil && limit > 0; limit-- {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*negativeItem).entry.expiresAt) {
+			n.remove(el)
+			removed++
+		}
+		el = prev
+	}
+	return removed
+}
+
+// Stats 返回用量與淘汰統計
+func (n *negativeLRU) Stats() map[string]any {
+	n.mu.Lock()
+	entries, bytes := n.order.Len(), n.bytes
+	n.mu.Unlock()
+	return map[string]any{
+		"entries":     entries,
+		"bytes":       bytes,
+		"max_entries": n.maxItems,
+		"max_bytes":   n.maxBytes,
+		"evicted":     n.evicted.Load(),
+	}
+}
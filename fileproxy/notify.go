@@ -0,0 +1,108 @@
+package fileproxy
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// systemd 通知協定使用的環境變數
+const (
+	notifySocketEnv = "NOTIFY_SOCKET"
+	watchdogUsecEnv = "WATCHDOG_USEC"
+	watchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// systemdNotifier 以 sd_notify 協定回報啟動、停止與交接，並定期送出看門狗訊號
+//
+// 未設定 NOTIFY_SOCKET 時為 nil，所有方法皆為 no-op。NOTIFY_SOCKET 保留給交接啟動的新程序，
+// 新程序以 MAINPID= 接手；單元需設定 NotifyAccess=all。
+type systemdNotifier struct {
+	conn     *net.UnixConn
+	watchdog time.Duration // 看門狗訊號間隔，0 表示未啟用
+
+	mu      sync.Mutex
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newSystemdNotifier 依環境變數建立通知，未由 systemd 以 Type=notify 啟動時返回 nil
+func newSystemdNotifier() *systemdNotifier {
+	addr := os.Getenv(notifySocketEnv)
+	if addr == "" {
+		return nil
+	}
+	// 開頭的 @ 由 net 套件轉為 Linux 抽象命名空間
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("connect systemd notify socket failed", "socket", addr, "error", err)
+		return nil
+	}
+	n := &systemdNotifier{conn: conn, closeCh: make(chan struct{})}
+
+	usec, _ := strconv.ParseInt(os.Getenv(watchdogUsecEnv), 10, 64)
+	pid, _ := strconv.Atoi(os.Getenv(watchdogPIDEnv))
+	if usec > 0 && (pid == 0 || pid == os.Getpid()) {
+		n.watchdog = time.Duration(usec) * time.Microsecond / 2
+		n.wg.Add(1)
+		go n.watchdogLoop()
+	}
+	return n
+}
+
+// notify 送出一則狀態，多個欄位以換行分隔
+func (n *systemdNotifier) notify(fields ...string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, err := n.conn.Write([]byte(strings.Join(fields, "\n"))); err != nil {
+		slog.Debug("systemd notify failed", "state", fields, "error", err)
+	}
+}
+
+// Ready 回報已開始服務，交接而來時同時宣告自己是主程序
+func (n *systemdNotifier) Ready(status string) {
+	n.notify("READY=1", "MAINPID="+strconv.Itoa(os.Getpid()), "STATUS="+status)
+}
+
+// Stopping 回報開始關閉
+func (n *systemdNotifier) Stopping() {
+	n.notify("STOPPING=1", "STATUS=draining")
+}
+
+// HandOff 交接成功後將主程序改為新程序；本程序仍會關閉，但不回報停止
+func (n *systemdNotifier) HandOff(pid int) {
+	n.notify("MAINPID="+strconv.Itoa(pid), "STATUS=handing off to pid "+strconv.Itoa(pid))
+}
+
+// watchdogLoop 以看門狗逾時的一半為間隔送出 WATCHDOG=1，直到關閉
+func (n *systemdNotifier) watchdogLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.watchdog)
+	defer ticker.Stop()
+	n.notify("WATCHDOG=1")
+	for {
+		select {
+		case <-n.closeCh:
+			return
+		case <-ticker.C:
+			n.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// Close 停止看門狗訊號並關閉連線
+func (n *systemdNotifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.closeCh)
+	n.wg.Wait()
+	n.conn.Close()
+}
@@ -0,0 +1,92 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const objectsPrefix = "/admin/objects" // 直接寫入與刪除快取內容的端點前綴
+
+// handleObjects 不經上游直接發佈或刪除快取內容，供建置系統將產物推送到邊緣節點
+//
+// PUT 以請求本體寫入，Content-Type 為內容類型，?ttl= 指定固定存活時間（immutable 表示永不過期，
+// 省略時使用預設的滑動過期），?tag= 可重複指定標籤。DELETE 等同清除端點。路徑以與請求相同的方式正規化。
+func (p *Proxy) handleObjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := p.purgeKey(strings.TrimPrefix(r.URL.Path, objectsPrefix))
+	if !ok || strings.HasSuffix(key, "/") {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.purge(key, false))
+		return
+	}
+
+	query := r.URL.Query()
+	ttl, err := parseObjectTTL(query.Get("ttl"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags := query["tag"]
+	for _, tag := range tags {
+		if !validTag(tag) {
+			http.Error(w, fmt.Sprintf("invalid tag %q", tag), http.StatusBadRequest)
+			return
+		}
+	}
+	if p.guard.Degraded() {
+		http.Error(w, "cache is degraded", http.StatusServiceUnavailable)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	entry, err := p.cache.Put(r.Context(), key, r.Body, r.ContentLength, contentType, ttl, tags)
+	if err == errPendingExists {
+		http.Error(w, "Conflict", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Warn("object publish failed", "key", key, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if p.replicator != nil {
+		p.replicator.Enqueue(key)
+	}
+	if p.archiver != nil {
+		p.archiver.Enqueue(key)
+	}
+	slog.Info("object published", "key", key, "size", entry.Size, "ttl", ttl)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entryInfo(key, entry, time.Now()))
+}
+
+// parseObjectTTL 解析發佈內容的存活時間，空值表示預設的滑動過期
+func parseObjectTTL(v string) (time.Duration, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "immutable":
+		return immutableTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q", v)
+	}
+	return ttl, nil
+}
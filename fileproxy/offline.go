@@ -0,0 +1,123 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// offlinePath 離線模式的查詢與切換端點
+const offlinePath = "/admin/offline"
+
+// errOffline 表示離線模式期間拒絕連線上游
+var errOffline = errors.New("offline mode: upstream requests are disabled")
+
+// offlineState 離線模式狀態
+//
+// 離線期間代理不連線上游：請求只以快取回應，過期條目照常返回（X-Cache: STALE），
+// 未快取的路徑返回 504；熱門條目刷新與清除後回填暫停，寫後上傳保留在佇列中等待恢復。
+// 所有回源都經過 offlineTransport，背景元件的請求在送出前即失敗。
+type offlineState struct {
+	enabled atomic.Bool
+	since   atomic.Int64 // 進入離線模式的時間（UnixNano）
+	misses  atomic.Int64 // 離線期間因未快取而返回 504 的請求
+}
+
+// offlineTransport 離線模式期間拒絕所有回源請求
+type offlineTransport struct {
+	next    http.RoundTripper
+	enabled *atomic.Bool
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.enabled.Load() {
+		return nil, errOffline
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Offline 是否處於離線模式
+func (p *Proxy) Offline() bool { return p.offline.enabled.Load() }
+
+// SetOffline 切換離線模式
+func (p *Proxy) SetOffline(on bool) {
+	if p.offline.enabled.Swap(on) == on {
+		return
+	}
+	if on {
+		p.offline.since.Store(time.Now().UnixNano())
+		slog.Warn("offline mode enabled, serving cached content only")
+		return
+	}
+	slog.Info("offline mode disabled", "duration", time.Since(time.Unix(0, p.offline.since.Load())).Round(time.Second))
+}
+
+// serveOffline 離線模式下以快取回應，不論條目是否過期
+func (p *Proxy) serveOffline(w http.ResponseWriter, r *http.Request, key string) error {
+	if sf, ok := p.cache.GetPending(key); ok {
+		p.stats.streaming.Add(1)
+		return p.serveFromStreaming(w, r, sf)
+	}
+	if status, ok := p.cache.Negative(key); ok {
+		p.stats.negative.Add(1)
+		w.Header().Set("X-Cache", "NEGATIVE")
+		http.Error(w, http.StatusText(negativeStatus(status)), negativeStatus(status))
+		return nil
+	}
+
+	entry, ok := p.cache.Get(r.Context(), key)
+	status := "HIT"
+	if !ok {
+		entry, ok = p.cache.GetStale(key)
+		status = "STALE"
+	}
+	if ok && p.validateCacheFile(entry) {
+		err := p.serveFromCache(w, r, entry, status)
+		if status == "HIT" {
+			p.stats.hits.Add(1)
+		} else {
+			p.stats.stale.Add(1)
+		}
+		return err
+	}
+
+	p.offline.misses.Add(1)
+	w.Header().Set("X-Cache", "MISS")
+	http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+	return nil
+}
+
+// offlineStatus 離線模式狀態的 JSON 表示
+type offlineStatus struct {
+	Offline bool      `json:"offline"`
+	Since   time.Time `json:"since,omitzero"`
+	Misses  int64     `json:"misses"` // 離線期間未快取而返回 504 的請求數（累計）
+}
+
+// offlineSnapshot 返回離線模式狀態
+func (p *Proxy) offlineSnapshot() offlineStatus {
+	st := offlineStatus{Offline: p.Offline(), Misses: p.offline.misses.Load()}
+	if st.Offline {
+		st.Since = time.Unix(0, p.offline.since.Load())
+	}
+	return st
+}
+
+// handleOffline GET /admin/offline 查詢離線模式，PUT 進入、DELETE 離開
+func (p *Proxy) handleOffline(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		p.SetOffline(true)
+	case http.MethodDelete:
+		p.SetOffline(false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.offlineSnapshot())
+}
@@ -0,0 +1,109 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// outboundDialer 將上游連線綁定到指定的本機地址，多個地址時輪流使用
+type outboundDialer struct {
+	dialers []*net.Dialer
+	next    atomic.Uint64
+	dials   []atomic.Int64 // 各地址建立的連線數
+}
+
+// newOutboundDialer 解析本機 IP 或網路介面名稱，未設定時返回 nil（使用系統路由）
+func newOutboundDialer(addrs []string) (*outboundDialer, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		candidates := []net.IP{net.ParseIP(addr)}
+		if candidates[0] == nil {
+			var err error
+			if candidates, err = interfaceIPs(addr); err != nil {
+				return nil, err
+			}
+		}
+		for _, ip := range candidates {
+			if !slices.ContainsFunc(ips, ip.Equal) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	od := &outboundDialer{dials: make([]atomic.Int64, len(ips))}
+	for _, ip := range ips {
+		od.dialers = append(od.dialers, &net.Dialer{
+			LocalAddr: &net.TCPAddr{IP: ip},
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		})
+	}
+	return od, nil
+}
+
+// interfaceIPs 返回網路介面上可用於綁定的地址（略過需要 zone 的鏈路本地地址）
+func interfaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("outbound address %q is neither an IP nor an interface: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("list addresses of %s: %w", name, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() && !ipnet.IP.IsUnspecified() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return ips, nil
+}
+
+// DialContext 以下一個本機地址建立連線
+//
+// 本機地址與上游地址族不符時（例如 IPv4 綁定但上游只有 IPv6），改用下一個地址。
+func (od *outboundDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := od.next.Add(1)
+	var lastErr error
+	for i := range od.dialers {
+		idx := int((start + uint64(i)) % uint64(len(od.dialers)))
+		conn, err := od.dialers[idx].DialContext(ctx, network, addr)
+		if err == nil {
+			od.dials[idx].Add(1)
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !isAddrFamilyError(err) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// isAddrFamilyError 檢查是否為地址族不符導致的錯誤
+func isAddrFamilyError(err error) bool {
+	var addrErr *net.AddrError
+	return errors.As(err, &addrErr)
+}
+
+// Stats 返回各本機地址的連線數
+func (od *outboundDialer) Stats() map[string]any {
+	stats := make(map[string]any, len(od.dialers))
+	for i, d := range od.dialers {
+		stats[d.LocalAddr.(*net.TCPAddr).IP.String()] = od.dials[i].Load()
+	}
+	return stats
+}
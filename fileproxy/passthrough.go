@@ -0,0 +1,72 @@
+package fileproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// isWriteMethod 是否為透傳模式轉發到上游的寫入方法
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// passWrite 將寫入請求反向代理到上游，不快取回應，上游返回 2xx 時使 key 的快取失效
+//
+// 請求頭與請求體原樣轉發（Authorization 只在 ForwardAuthorization 時轉發），查詢字串不經正規化，
+// 簽名 URL 因此保持有效；上游的重新導向原樣返回客戶端，不會把寫入改為 GET 跟隨。
+func (p *Proxy) passWrite(w http.ResponseWriter, r *http.Request, key string) error {
+	base, ok := p.upstreamBaseFor(r, ruleFrom(r.Context()))
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, withQuery(base, r.URL.RawQuery), r.Body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = r.ContentLength
+	req.Header = r.Header.Clone()
+	req.Header.Del("Authorization")
+	p.forwardCredentials(req, r)
+	p.setProxyHeaders(req, r)
+	p.tracing.inject(r.Context(), req.Header)
+
+	client := *p.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOffline):
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		case !rejectRateLimited(w, err):
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return fmt.Errorf("upstream %s request: %w", r.Method, err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		p.cache.Remove(key)
+		slog.Debug("cache invalidated by write", "key", key, "method", r.Method, "status", resp.StatusCode)
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", "BYPASS")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("copy %s response: %w", r.Method, err)
+	}
+	return nil
+}
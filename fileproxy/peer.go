@@ -0,0 +1,304 @@
+package fileproxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 兄弟節點查詢協定（類似 ICP）
+//
+// 封包格式：magic(4) | type(1) | id(4) | key 雜湊(32) | [回覆：HTTP 地址] | HMAC(16)
+// 回覆的 HTTP 地址為空表示未命中。HMAC 以 AdminToken 計算，防止偽造的命中
+// 將請求導向不受信任的地址。
+const (
+	peerMagic      = "FPQ1"
+	peerTypeQuery  = 1
+	peerTypeReply  = 2
+	peerHeaderSize = 4 + 1 + 4 + sha256.Size
+	peerMACSize    = 16
+	peerMaxPacket  = 1500
+)
+
+// peerLookup 透過 UDP 向兄弟節點查詢快取
+type peerLookup struct {
+	conn      *net.UDPConn
+	peers     []*net.UDPAddr
+	secret    []byte
+	advertise string
+	timeout   time.Duration
+	cache     *Cache
+	client    *http.Client
+
+	nextID  atomic.Uint32
+	mu      sync.Mutex
+	waiting map[uint32]chan string
+	digests map[string]*peerDigest // 兄弟節點的快取摘要，以 UDP 地址為鍵
+
+	digestInterval time.Duration
+	digest         atomic.Pointer[[]byte] // 本機摘要（已序列化）
+
+	queries  atomic.Int64
+	hits     atomic.Int64
+	served   atomic.Int64
+	invalid  atomic.Int64
+	fallback atomic.Int64 // 命中後下載失敗而回源的次數
+	skipped  atomic.Int64 // 依摘要略過的查詢數
+
+	digestsFetched atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newPeerLookup 監聽 UDP 並解析兄弟節點地址
+func newPeerLookup(cfg *Config, cache *Cache, client *http.Client) (*peerLookup, error) {
+	laddr, err := net.ResolveUDPAddr("udp", cfg.PeerListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := &peerLookup{
+		conn:      conn,
+		secret:    []byte(cfg.AdminToken),
+		advertise: cfg.PeerAdvertiseURL,
+		timeout:   cfg.PeerTimeout,
+		cache:     cache,
+		client:    client,
+		waiting:   make(map[uint32]chan string),
+		digests:   make(map[string]*peerDigest),
+		closeCh:   make(chan struct{}),
+
+		digestInterval: cfg.PeerDigestInterval,
+	}
+	pl.nextID.Store(rand.Uint32())
+	for _, peer := range cfg.PeerAddrs {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		pl.peers = append(pl.peers, addr)
+	}
+
+	pl.wg.Add(1)
+	go pl.readLoop()
+	if pl.digestInterval > 0 {
+		pl.wg.Add(1)
+		go pl.digestLoop()
+	}
+	slog.Info("peer lookup listening", "addr", conn.LocalAddr(), "peers", len(pl.peers))
+	return pl, nil
+}
+
+// Close 關閉 UDP 連線
+func (pl *peerLookup) Close() {
+	close(pl.closeCh)
+	pl.conn.Close()
+	pl.wg.Wait()
+}
+
+// sign 附加 HMAC
+func (pl *peerLookup) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, pl.secret)
+	mac.Write(body)
+	return mac.Sum(body)[:len(body)+peerMACSize]
+}
+
+// verify 驗證 HMAC 並返回封包本體
+func (pl *peerLookup) verify(pkt []byte) ([]byte, bool) {
+	if len(pkt) < peerHeaderSize+peerMACSize || string(pkt[:4]) != peerMagic {
+		return nil, false
+	}
+	body := pkt[:len(pkt)-peerMACSize]
+	mac := hmac.New(sha256.New, pl.secret)
+	mac.Write(body)
+	return body, hmac.Equal(mac.Sum(nil)[:peerMACSize], pkt[len(body):])
+}
+
+// packet 組裝封包
+func packet(typ byte, id uint32, hash keyHash, payload string) []byte {
+	buf := make([]byte, 0, peerHeaderSize+len(payload)+sha256.Size)
+	buf = append(buf, peerMagic...)
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint32(buf, id)
+	buf = append(buf, hash[:]...)
+	return append(buf, payload...)
+}
+
+// Lookup 查詢兄弟節點，返回第一個命中節點的 HTTP 地址
+//
+// 所有節點回覆未命中或逾時時返回 false。
+func (pl *peerLookup) Lookup(ctx context.Context, key string) (string, bool) {
+	hash := hashKey(key)
+	now := time.Now()
+	peers := make([]*net.UDPAddr, 0, len(pl.peers))
+	for _, peer := range pl.peers {
+		if pl.mayHave(peer.String(), hash, now) {
+			peers = append(peers, peer)
+		}
+	}
+	if len(peers) < len(pl.peers) {
+		pl.skipped.Add(int64(len(pl.peers) - len(peers)))
+	}
+	if len(peers) == 0 {
+		return "", false
+	}
+	pl.queries.Add(1)
+
+	id := pl.nextID.Add(1)
+	ch := make(chan string, len(peers))
+	pl.mu.Lock()
+	pl.waiting[id] = ch
+	pl.mu.Unlock()
+	defer func() {
+		pl.mu.Lock()
+		delete(pl.waiting, id)
+		pl.mu.Unlock()
+	}()
+
+	pkt := pl.sign(packet(peerTypeQuery, id, hash, ""))
+	for _, peer := range peers {
+		if _, err := pl.conn.WriteToUDP(pkt, peer); err != nil {
+			slog.Debug("peer query failed", "peer", peer, "error", err)
+		}
+	}
+
+	timer := time.NewTimer(pl.timeout)
+	defer timer.Stop()
+	for range peers {
+		select {
+		case url := <-ch:
+			if url != "" {
+				pl.hits.Add(1)
+				return url, true
+			}
+		case <-timer.C:
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// readLoop 處理查詢與回覆
+func (pl *peerLookup) readLoop() {
+	defer pl.wg.Done()
+	buf := make([]byte, peerMaxPacket)
+	for {
+		n, addr, err := pl.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		body, ok := pl.verify(buf[:n])
+		if !ok {
+			pl.invalid.Add(1)
+			continue
+		}
+
+		id := binary.BigEndian.Uint32(body[5:9])
+		var hash keyHash
+		copy(hash[:], body[9:peerHeaderSize])
+
+		switch body[4] {
+		case peerTypeQuery:
+			pl.served.Add(1)
+			url := ""
+			if pl.advertise != "" && pl.cache.hasFresh(hash) {
+				url = pl.advertise
+			}
+			pl.conn.WriteToUDP(pl.sign(packet(peerTypeReply, id, hash, url)), addr)
+		case peerTypeReply:
+			pl.mu.Lock()
+			ch, ok := pl.waiting[id]
+			pl.mu.Unlock()
+			if ok {
+				select {
+				case ch <- string(body[peerHeaderSize:]):
+				default:
+				}
+			}
+		case peerTypeAnnounce:
+			pl.wg.Add(1)
+			go pl.fetchDigest(addr.String(), string(body[peerHeaderSize:]))
+		}
+	}
+}
+
+// Stats 返回兄弟節點查詢統計資訊
+func (pl *peerLookup) Stats() map[string]any {
+	return map[string]any{
+		"peers":    len(pl.peers),
+		"queries":  pl.queries.Load(),
+		"hits":     pl.hits.Load(),
+		"served":   pl.served.Load(),
+		"invalid":  pl.invalid.Load(),
+		"fallback": pl.fallback.Load(),
+		"skipped":  pl.skipped.Load(),
+		"digests":  pl.digestsFetched.Load(),
+	}
+}
+
+// onlyIfCached 檢查請求是否帶有 Cache-Control: only-if-cached
+func onlyIfCached(r *http.Request) bool {
+	for _, v := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "only-if-cached") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchFromPeer 向持有 key 的兄弟節點下載，無命中或下載失敗時返回 nil 以回源上游
+func (p *Proxy) fetchFromPeer(ctx context.Context, key string) *http.Response {
+	if p.peers == nil || isPrivateKey(key) || !ruleFrom(ctx).cacheable() {
+		return nil
+	}
+	base, ok := p.peers.Lookup(ctx, key)
+	if !ok {
+		return nil
+	}
+
+	path, query := p.config.splitKey(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(buildUpstreamURL(base, path), query), nil)
+	if err != nil {
+		p.peers.fallback.Add(1)
+		return nil
+	}
+	req.Header.Set("Cache-Control", "only-if-cached")
+	req.Header.Set("X-API-Key", p.config.AdminToken)
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		p.peers.fallback.Add(1)
+		slog.Debug("peer fetch failed", "peer", base, "key", key, "error", err)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		p.peers.fallback.Add(1)
+		slog.Debug("peer fetch failed", "peer", base, "key", key, "status", resp.StatusCode)
+		return nil
+	}
+	slog.Debug("fetched from peer", "peer", base, "key", key)
+	return resp
+}
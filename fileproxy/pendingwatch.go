@@ -0,0 +1,66 @@
+package fileproxy
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// errDownloadStalled 表示下載超過 PendingStallTimeout 沒有進度，被看門狗中止
+var errDownloadStalled = errors.New("download stalled")
+
+// pendingWatchLoop 定期中止停滯的下載
+//
+// 上游連線卡住時讀取不會返回，key 會一直停在進行中，之後的請求都附加到這個永遠不會完成的下載。
+// 超過 PendingStallTimeout 沒有寫入任何內容的下載被移出進行中的下載並中止上游請求，
+// 等待中的讀取者收到 errDownloadStalled，之後的請求重新下載。
+func (c *Cache) pendingWatchLoop() {
+	defer c.wg.Done()
+	timeout := c.config.PendingStallTimeout
+	ticker := time.NewTicker(min(max(timeout/4, time.Second), 30*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case now := <-ticker.C:
+			c.abortStalled(now.Add(-timeout))
+		}
+	}
+}
+
+// abortStalled 中止 cutoff 之後沒有寫入的下載
+func (c *Cache) abortStalled(cutoff time.Time) {
+	type stalledFile struct {
+		key string
+		sf  *StreamingFile
+	}
+	var stalled []stalledFile
+	c.pendingMu.Lock()
+	for key, sf := range c.pending {
+		if sf.progressAt.Load() < cutoff.UnixNano() {
+			delete(c.pending, key)
+			stalled = append(stalled, stalledFile{key, sf})
+		}
+	}
+	c.pendingMu.Unlock()
+
+	for _, s := range stalled {
+		c.stalled.Add(1)
+		slog.Warn("pending download stalled, aborting", "key", s.key, "written", s.sf.Size(),
+			"idle", time.Since(time.Unix(0, s.sf.progressAt.Load())).Round(time.Second))
+		s.sf.interrupt(errDownloadStalled)
+	}
+}
+
+// interrupt 以 err 中止寫入並中止發起者的上游請求
+func (sf *StreamingFile) interrupt(err error) {
+	sf.abort(err)
+	sf.mu.RLock()
+	cancel := sf.cancel
+	sf.mu.RUnlock()
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -0,0 +1,529 @@
+package fileproxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	prefetchPath        = "/admin/prefetch" // 預取端點
+	prefetchQueueSize   = 64                // 等待執行的任務數上限
+	prefetchHistorySize = 100               // 保留的已結束任務數
+	prefetchMaxPaths    = 1 << 20           // 單一任務最多的路徑數
+	prefetchMaxBody     = 64 << 20          // 請求本體與清單的最大位元組數
+	prefetchMaxErrors   = 20                // 每個任務保留的失敗明細數
+)
+
+// 預取任務狀態
+const (
+	prefetchStateQueued  = "queued"
+	prefetchStateRunning = "running"
+	prefetchStateDone    = "done"
+)
+
+var errPrefetchQueueFull = errors.New("prefetch queue full")
+
+// prefetchRequest POST /admin/prefetch 的請求本體，paths 與 manifest 擇一
+type prefetchRequest struct {
+	Paths    []string `json:"paths"`
+	Manifest string   `json:"manifest"` // 清單 URL，內容為每行一個路徑或 JSON 字串陣列
+	Atomic   bool     `json:"atomic"`   // 全部下載成功才一併寫入快取並釘選，否則不寫入任何路徑
+	Tags     []string `json:"tags"`     // 寫入快取時加上的標籤
+}
+
+// prefetchError 單一路徑的失敗原因
+type prefetchError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// prefetchJob 預取任務與進度
+type prefetchJob struct {
+	ID         string          `json:"id"`
+	Source     string          `json:"source"` // 清單 URL，直接提交路徑時為 "paths"
+	State      string          `json:"state"`
+	Total      int             `json:"total"`
+	Completed  int             `json:"completed"`
+	Cached     int             `json:"cached"`  // 已在快取中，未回源
+	Fetched    int             `json:"fetched"` // 下載到快取
+	Failed     int             `json:"failed"`
+	Bytes      int64           `json:"bytes"` // 下載的位元組數
+	Errors     []prefetchError `json:"errors,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Atomic     bool            `json:"atomic,omitempty"`
+	Committed  bool            `json:"committed,omitempty"` // 交易式任務的所有路徑已寫入快取
+	Pinned     bool            `json:"pinned,omitempty"`    // 交易式任務的路徑仍被釘選
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  time.Time       `json:"started_at,omitzero"`
+	FinishedAt time.Time       `json:"finished_at,omitzero"`
+
+	seq   int
+	paths []string
+	pins  []string // 提交後保持釘選的 key，解除時清空
+}
+
+// prefetcher 在背景將路徑清單下載到快取
+//
+// 任務依提交順序逐一執行，任務內的路徑由 workers 個 worker 並發下載；
+// 下載走一般的請求路徑（規則、合併下載、兄弟節點），並以低優先級排程，不搶佔互動式下載。
+type prefetcher struct {
+	proxy   *Proxy
+	workers int
+	queue   chan *prefetchJob
+
+	mu      sync.Mutex
+	seq     int
+	jobs    map[string]*prefetchJob // 未結束的任務
+	history []*prefetchJob          // 最近結束的任務
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newPrefetcher 建立預取器，workers 為 0 時返回 nil
+func newPrefetcher(p *Proxy, workers int) *prefetcher {
+	if workers <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pf := &prefetcher{
+		proxy:   p,
+		workers: workers,
+		queue:   make(chan *prefetchJob, prefetchQueueSize),
+		jobs:    make(map[string]*prefetchJob),
+		ctx:     ctx,
+		cancel:  cancel,
+		closeCh: make(chan struct{}),
+	}
+	pf.wg.Add(1)
+	go pf.dispatch()
+	return pf
+}
+
+// Close 停止預取，等待進行中的下載結束，未執行的任務被丟棄
+func (pf *prefetcher) Close() {
+	pf.cancel()
+	close(pf.closeCh)
+	pf.wg.Wait()
+}
+
+// Enqueue 建立任務並排入佇列，返回任務狀態快照；atomic 為 true 時建立交易式任務，
+// 下載的條目加上 tags
+func (pf *prefetcher) Enqueue(source string, paths []string, atomic bool, tags []string) (prefetchJob, error) {
+	pf.mu.Lock()
+	pf.seq++
+	job := &prefetchJob{
+		ID:        strconv.Itoa(pf.seq),
+		Source:    source,
+		State:     prefetchStateQueued,
+		Total:     len(paths),
+		Tags:      tags,
+		Atomic:    atomic,
+		CreatedAt: time.Now(),
+		seq:       pf.seq,
+		paths:     paths,
+	}
+	pf.jobs[job.ID] = job
+	snapshot := *job
+	pf.mu.Unlock()
+
+	select {
+	case pf.queue <- job:
+		return snapshot, nil
+	default:
+		pf.mu.Lock()
+		delete(pf.jobs, job.ID)
+		pf.mu.Unlock()
+		return prefetchJob{}, errPrefetchQueueFull
+	}
+}
+
+// dispatch 依序執行任務
+func (pf *prefetcher) dispatch() {
+	defer pf.wg.Done()
+	for {
+		select {
+		case <-pf.closeCh:
+			return
+		case job := <-pf.queue:
+			pf.run(job)
+		}
+	}
+}
+
+// run 以 worker 並發下載任務中的路徑
+func (pf *prefetcher) run(job *prefetchJob) {
+	pf.mu.Lock()
+	job.State = prefetchStateRunning
+	job.StartedAt = time.Now()
+	pf.mu.Unlock()
+	slog.Info("prefetch started", "id", job.ID, "source", job.Source, "paths", job.Total, "atomic", job.Atomic)
+
+	fetch := pf.fetch
+	var st *prefetchStage
+	if job.Atomic {
+		var err error
+		if st, err = newPrefetchStage(pf.proxy.config.CacheDir, job.ID); err != nil {
+			slog.Warn("atomic prefetch failed", "id", job.ID, "error", err)
+			pf.mu.Lock()
+			job.Errors = append(job.Errors, prefetchError{Error: err.Error()})
+			job.paths = nil
+			pf.mu.Unlock()
+		} else {
+			fetch = func(job *prefetchJob, key string) { pf.stage(job, st, key) }
+		}
+	}
+
+	// 背景 goroutine 達到上限時少開 worker，一個都無法開啟時在 dispatch 中依序下載
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	workers := 0
+	for range min(pf.workers, len(job.paths)) {
+		wg.Add(1)
+		started := pf.proxy.routines.Go(routineBackground, "prefetch "+job.ID, func() {
+			defer wg.Done()
+			for key := range keys {
+				fetch(job, key)
+			}
+		})
+		if !started {
+			wg.Done()
+			break
+		}
+		workers++
+	}
+feed:
+	for _, key := range job.paths {
+		if workers == 0 {
+			select {
+			case <-pf.closeCh:
+				break feed
+			default:
+			}
+			fetch(job, key)
+			continue
+		}
+		select {
+		case keys <- key:
+		case <-pf.closeCh:
+			break feed
+		}
+	}
+	close(keys)
+	wg.Wait()
+
+	committed := st != nil && pf.commit(job, st)
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	job.State = prefetchStateDone
+	job.Committed, job.Pinned = committed, committed
+	job.FinishedAt = time.Now()
+	job.paths = nil
+	delete(pf.jobs, job.ID)
+	pf.history = append(pf.history, job)
+	pf.trimHistory()
+	slog.Info("prefetch finished", "id", job.ID, "cached", job.Cached, "fetched", job.Fetched,
+		"failed", job.Failed, "bytes", job.Bytes, "duration", job.FinishedAt.Sub(job.StartedAt).Round(time.Millisecond))
+}
+
+// trimHistory 只保留最近 prefetchHistorySize 個已結束任務，仍有釘選的任務保留到解除為止，須持有鎖
+func (pf *prefetcher) trimHistory() {
+	excess := len(pf.history) - prefetchHistorySize
+	if excess <= 0 {
+		return
+	}
+	pf.history = slices.DeleteFunc(pf.history, func(job *prefetchJob) bool {
+		if excess > 0 && !job.Pinned {
+			excess--
+			return true
+		}
+		return false
+	})
+}
+
+// fetch 下載單一路徑，已有新鮮快取時略過
+func (pf *prefetcher) fetch(job *prefetchJob, key string) {
+	p := pf.proxy
+	if entry, ok := p.cache.Peek(key); ok && entry.fresh(time.Now()) {
+		if len(job.Tags) > 0 {
+			p.cache.AddTags(key, job.Tags)
+		}
+		pf.record(job, key, "HIT", 0, nil)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(pf.ctx, http.MethodGet, key, nil)
+	if err != nil {
+		pf.record(job, key, "", 0, err)
+		return
+	}
+	req.Header.Set(priorityHeader, PriorityLow.String())
+	req = req.WithContext(withTags(withRule(req.Context(), p.ruleFor(req, req.URL.Path)), job.Tags))
+
+	w := &prefetchWriter{header: make(http.Header), status: http.StatusOK}
+	err = p.handleRequest(w, req, key)
+	if err == nil && w.status >= 400 {
+		err = fmt.Errorf("status %d", w.status)
+	}
+	pf.record(job, key, w.header.Get("X-Cache"), w.written, err)
+}
+
+// record 更新任務進度
+func (pf *prefetcher) record(job *prefetchJob, key, cacheStatus string, written int64, err error) {
+	if err != nil {
+		slog.Warn("prefetch failed", "id", job.ID, "key", key, "error", err)
+	}
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	job.Completed++
+	switch {
+	case err != nil:
+		job.Failed++
+		if len(job.Errors) < prefetchMaxErrors {
+			job.Errors = append(job.Errors, prefetchError{Path: key, Error: err.Error()})
+		}
+	case cacheStatus == "HIT":
+		job.Cached++
+	default:
+		job.Fetched++
+		job.Bytes += written
+	}
+}
+
+// snapshot 返回任務狀態，id 為空時返回所有任務（新的在前）
+func (pf *prefetcher) snapshot(id string) []prefetchJob {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	var out []prefetchJob
+	for i := len(pf.history) - 1; i >= 0; i-- {
+		if id == "" || pf.history[i].ID == id {
+			out = append(out, *pf.history[i])
+		}
+	}
+	for _, job := range pf.jobs {
+		if id == "" || job.ID == id {
+			out = append(out, *job)
+		}
+	}
+	slices.SortFunc(out, func(a, b prefetchJob) int { return cmp.Compare(b.seq, a.seq) })
+	return out
+}
+
+// Stats 返回預取統計資訊
+func (pf *prefetcher) Stats() map[string]any {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	remaining := 0
+	for _, job := range pf.jobs {
+		remaining += job.Total - job.Completed
+	}
+	return map[string]any{
+		"workers":         pf.workers,
+		"jobs":            len(pf.jobs),
+		"remaining_paths": remaining,
+		"recent_history":  len(pf.history),
+	}
+}
+
+// prefetchWriter 丟棄回應本體，只記錄狀態、回應頭與位元組數
+type prefetchWriter struct {
+	header  http.Header
+	status  int
+	written int64
+}
+
+func (w *prefetchWriter) Header() http.Header { return w.header }
+
+func (w *prefetchWriter) WriteHeader(status int) { w.status = status }
+
+func (w *prefetchWriter) Write(b []byte) (int, error) {
+	w.written += int64(len(b))
+	return len(b), nil
+}
+
+// prefetchKey 檢查路徑並返回快取 key：origin-form、沒有控制字元與目錄穿越；
+// 啟用查詢 key 時查詢字串正規化後納入 key，否則不接受查詢字串
+func (p *Proxy) prefetchKey(path string) (string, bool) {
+	if !strings.HasPrefix(path, "/") || strings.Contains(path, "#") {
+		return "", false
+	}
+	key, query, hasQuery := strings.Cut(path, "?")
+	if pathViolation(key) != "" || strings.ContainsFunc(query, isControlRune) {
+		return "", false
+	}
+	key = p.config.normalizePath(key)
+	if hasQuery {
+		if len(p.config.CacheKeyQuery) == 0 {
+			return "", false
+		}
+		if q := p.config.normalizeQuery(query); q != "" {
+			key += "?" + q
+		}
+	}
+	return key, p.config.MaxPathLength <= 0 || len(key) <= p.config.MaxPathLength
+}
+
+// fetchManifest 下載清單並解析路徑
+func (p *Proxy) fetchManifest(ctx context.Context, manifest string) ([]string, error) {
+	u, err := url.Parse(manifest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid manifest url %q", manifest)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch manifest: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, prefetchMaxBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if len(data) > prefetchMaxBody {
+		return nil, fmt.Errorf("manifest exceeds %d bytes", prefetchMaxBody)
+	}
+	return parseManifest(data)
+}
+
+// parseManifest 解析 JSON 字串陣列，或每行一個路徑（忽略空行與 # 開頭的註解）
+func parseManifest(data []byte) ([]string, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var paths []string
+		if err := json.Unmarshal(trimmed, &paths); err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+		return paths, nil
+	}
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// handlePrefetch POST 提交預取任務，GET 查詢任務進度（/admin/prefetch/{id} 查詢單一任務），
+// DELETE /admin/prefetch/{id} 解除交易式任務的釘選
+func (p *Proxy) handlePrefetch(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefetchPath), "/")
+	switch {
+	case r.Method == http.MethodGet:
+		jobs := p.prefetcher.snapshot(id)
+		if id != "" && len(jobs) == 0 {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if id != "" {
+			json.NewEncoder(w).Encode(jobs[0])
+			return
+		}
+		if jobs == nil {
+			jobs = []prefetchJob{}
+		}
+		json.NewEncoder(w).Encode(jobs)
+	case r.Method == http.MethodPost && id == "":
+		p.submitPrefetch(w, r)
+	case r.Method == http.MethodDelete && id != "":
+		job, ok := p.prefetcher.release(id)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// submitPrefetch 解析請求、下載清單並建立任務
+func (p *Proxy) submitPrefetch(w http.ResponseWriter, r *http.Request) {
+	var req prefetchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, prefetchMaxBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (len(req.Paths) == 0) == (req.Manifest == "") {
+		http.Error(w, "exactly one of paths or manifest is required", http.StatusBadRequest)
+		return
+	}
+
+	source, paths := "paths", req.Paths
+	if req.Manifest != "" {
+		var err error
+		if paths, err = p.fetchManifest(r.Context(), req.Manifest); err != nil {
+			slog.Warn("prefetch manifest failed", "manifest", req.Manifest, "error", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		source = req.Manifest
+	}
+
+	// 去除重複並驗證路徑
+	seen := make(map[string]struct{}, len(paths))
+	unique := paths[:0]
+	for _, path := range paths {
+		key, ok := p.prefetchKey(path)
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid path %q", path), http.StatusBadRequest)
+			return
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			unique = append(unique, key)
+		}
+	}
+	if len(unique) == 0 || len(unique) > prefetchMaxPaths {
+		http.Error(w, fmt.Sprintf("path count must be between 1 and %d", prefetchMaxPaths), http.StatusBadRequest)
+		return
+	}
+
+	for _, tag := range req.Tags {
+		if !validTag(tag) {
+			http.Error(w, fmt.Sprintf("invalid tag %q", tag), http.StatusBadRequest)
+			return
+		}
+	}
+
+	job, err := p.prefetcher.Enqueue(source, unique, req.Atomic, req.Tags)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("prefetch queued", "id", job.ID, "source", source, "paths", job.Total, "atomic", job.Atomic)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
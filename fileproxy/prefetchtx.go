@@ -0,0 +1,240 @@
+package fileproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// prefetchStagingDir 交易式預取的暫存目錄（位於快取目錄下），程序崩潰留下的檔案在啟動時被當作孤立檔案清理
+const prefetchStagingDir = ".prefetch-staging"
+
+// prefetchStage 交易式預取的暫存狀態
+//
+// 需要下載的路徑先寫入暫存目錄，全部成功後才一次寫入快取；已在快取中的路徑立即釘選，
+// 避免在其他路徑下載期間被淘汰。任何一個路徑失敗時整批放棄，快取中不會出現不完整的集合。
+type prefetchStage struct {
+	dir string
+
+	mu     sync.Mutex
+	staged []stagedFile
+	pinned []string // 已釘選的 key
+}
+
+// stagedFile 已下載到暫存目錄、等待提交的檔案
+type stagedFile struct {
+	key         string
+	path        string
+	size        int64
+	contentType string
+	ttl         time.Duration
+	tags        []string
+}
+
+// newPrefetchStage 建立任務的暫存目錄
+func newPrefetchStage(cacheDir, id string) (*prefetchStage, error) {
+	dir := filepath.Join(cacheDir, prefetchStagingDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create staging dir: %w", err)
+	}
+	return &prefetchStage{dir: dir}, nil
+}
+
+// stage 將單一路徑下載到暫存目錄，已有新鮮快取時直接釘選
+func (pf *prefetcher) stage(job *prefetchJob, st *prefetchStage, key string) {
+	p := pf.proxy
+	p.cache.Pin(key)
+	st.mu.Lock()
+	st.pinned = append(st.pinned, key)
+	st.mu.Unlock()
+
+	if entry, ok := p.cache.Peek(key); ok && entry.fresh(time.Now()) {
+		pf.record(job, key, "HIT", 0, nil)
+		return
+	}
+	file, err := pf.download(job, st, key)
+	if err != nil {
+		pf.record(job, key, "", 0, err)
+		return
+	}
+	st.mu.Lock()
+	st.staged = append(st.staged, file)
+	st.mu.Unlock()
+	pf.record(job, key, "MISS", file.size, nil)
+}
+
+// download 以低優先級回源下載到暫存檔，不經過快取與兄弟節點
+func (pf *prefetcher) download(job *prefetchJob, st *prefetchStage, key string) (stagedFile, error) {
+	p := pf.proxy
+	req, err := http.NewRequestWithContext(pf.ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return stagedFile{}, err
+	}
+	rule := p.ruleFor(req, req.URL.Path)
+	if !rule.cacheable() {
+		return stagedFile{}, errors.New("path is not cacheable")
+	}
+	upstreamURL, ok := p.upstreamURLFor(req, rule)
+	if !ok {
+		return stagedFile{}, errors.New("no route")
+	}
+
+	releaseSlot, err := p.fetchSlots.Acquire(pf.ctx, key)
+	if err != nil {
+		return stagedFile{}, err
+	}
+	defer releaseSlot()
+	if err := p.scheduler.Acquire(pf.ctx, PriorityLow); err != nil {
+		return stagedFile{}, err
+	}
+	defer p.scheduler.Release()
+	defer p.scheduler.Begin(PriorityLow)()
+
+	upReq, err := http.NewRequestWithContext(pf.ctx, http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		return stagedFile{}, err
+	}
+	upReq.Header.Set("Via", viaValue(1, 1))
+	resp, err := p.httpClient.Do(upReq)
+	if err != nil {
+		return stagedFile{}, fmt.Errorf("upstream request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stagedFile{}, fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	if !p.storeAllowed(rule, resp.Header) {
+		return stagedFile{}, errors.New("upstream forbids storing")
+	}
+
+	f, err := os.CreateTemp(st.dir, "stage-*")
+	if err != nil {
+		return stagedFile{}, fmt.Errorf("create staging file: %w", err)
+	}
+	n, err := io.Copy(f, p.scheduler.Reader(pf.ctx, PriorityLow, resp.Body))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = fmt.Errorf("size mismatch: expected %d, got %d", resp.ContentLength, n)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return stagedFile{}, fmt.Errorf("write staging file: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return stagedFile{
+		key:         key,
+		path:        f.Name(),
+		size:        n,
+		contentType: contentType,
+		ttl:         p.entryTTL(rule, resp.Header, time.Now()),
+		tags:        fillTags(withTags(pf.ctx, job.Tags), rule),
+	}, nil
+}
+
+// commit 所有路徑都成功時將暫存檔寫入快取並保留釘選，否則整批放棄，返回是否提交
+func (pf *prefetcher) commit(job *prefetchJob, st *prefetchStage) bool {
+	p := pf.proxy
+	defer os.RemoveAll(st.dir)
+
+	pf.mu.Lock()
+	complete := job.Failed == 0 && job.Completed == job.Total
+	pf.mu.Unlock()
+
+	var err error
+	var committed []string
+	if !complete {
+		err = errors.New("not every path was fetched")
+	}
+	for _, file := range st.staged {
+		if err != nil {
+			break
+		}
+		err = pf.put(file)
+		if err == nil {
+			committed = append(committed, file.key)
+		}
+	}
+	// 暫存期間已快取的路徑可能被清除，提交前再確認整個集合都在快取中
+	if err == nil {
+		for _, key := range st.pinned {
+			if _, ok := p.cache.Peek(key); !ok {
+				err = fmt.Errorf("%s left the cache before commit", key)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		for _, key := range committed {
+			p.cache.Remove(key)
+		}
+		for _, key := range st.pinned {
+			p.cache.Unpin(key)
+		}
+		slog.Warn("atomic prefetch rolled back", "id", job.ID, "error", err)
+		pf.mu.Lock()
+		if len(job.Errors) < prefetchMaxErrors && complete {
+			job.Errors = append(job.Errors, prefetchError{Error: err.Error()})
+		}
+		pf.mu.Unlock()
+		return false
+	}
+
+	// 已在快取中的路徑在提交時才加上標籤，放棄時不留下痕跡
+	for _, key := range st.pinned {
+		if len(job.Tags) > 0 {
+			p.cache.AddTags(key, job.Tags)
+		}
+	}
+	pf.mu.Lock()
+	job.pins = st.pinned
+	pf.mu.Unlock()
+	slog.Info("atomic prefetch committed", "id", job.ID, "paths", len(st.pinned), "staged", len(st.staged))
+	return true
+}
+
+// put 將單一暫存檔寫入快取
+func (pf *prefetcher) put(file stagedFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return fmt.Errorf("open staging file: %w", err)
+	}
+	defer f.Close()
+	if _, err := pf.proxy.cache.Put(pf.ctx, file.key, f, file.size, file.contentType, file.ttl, file.tags); err != nil {
+		return fmt.Errorf("commit %s: %w", file.key, err)
+	}
+	return nil
+}
+
+// release 解除交易式預取任務的釘選，返回任務快照
+func (pf *prefetcher) release(id string) (prefetchJob, bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	for _, job := range pf.history {
+		if job.ID != id {
+			continue
+		}
+		for _, key := range job.pins {
+			pf.proxy.cache.Unpin(key)
+		}
+		if job.Pinned {
+			slog.Info("atomic prefetch released", "id", job.ID, "paths", len(job.pins))
+		}
+		job.pins = nil
+		job.Pinned = false
+		return *job, true
+	}
+	return prefetchJob{}, false
+}
@@ -0,0 +1,126 @@
+package fileproxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PrefixLimit 單一路徑前綴的上游並發下載上限
+type PrefixLimit struct {
+	Prefix string // 路徑前綴，最長者優先
+	Limit  int
+}
+
+// ParsePrefixLimit 解析命令列格式的限制：PREFIX=N，例如 /repos/npm=4
+func ParsePrefixLimit(s string) (PrefixLimit, error) {
+	prefix, value, found := strings.Cut(s, "=")
+	if !found || !strings.HasPrefix(prefix, "/") || prefix == "/" {
+		return PrefixLimit{}, fmt.Errorf("prefix fetch limit %q: expected PREFIX=N", s)
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return PrefixLimit{}, fmt.Errorf("prefix fetch limit %q: limit must be a positive integer", s)
+	}
+	return PrefixLimit{Prefix: strings.TrimSuffix(prefix, "/"), Limit: limit}, nil
+}
+
+// prefixSlots 單一前綴的下載名額
+type prefixSlots struct {
+	PrefixLimit
+	sem chan struct{}
+
+	waiting  atomic.Int64
+	queued   atomic.Int64 // 曾經需要排隊的下載數
+	timedOut atomic.Int64
+}
+
+// prefixLimiter 依路徑前綴限制上游並發下載
+//
+// 上游常以單一倉庫為單位限流，超過名額的未命中在本地排隊，而不是同時回源後被上游以 429 拒絕。
+// 前綴名額先於全域名額取得，排隊中的請求不佔用其他前綴可用的全域名額。
+type prefixLimiter struct {
+	slots        []*prefixSlots
+	queueTimeout time.Duration // 0 表示一直等待
+}
+
+// newPrefixLimiter 建立前綴限制器，沒有設定限制時返回 nil
+func newPrefixLimiter(limits []PrefixLimit, queueTimeout time.Duration) *prefixLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	l := &prefixLimiter{queueTimeout: queueTimeout}
+	for _, limit := range limits {
+		l.slots = append(l.slots, &prefixSlots{PrefixLimit: limit, sem: make(chan struct{}, limit.Limit)})
+	}
+	return l
+}
+
+// slotsFor 返回 key 適用的前綴名額，沒有匹配時返回 nil
+func (l *prefixLimiter) slotsFor(key string) *prefixSlots {
+	var best *prefixSlots
+	for _, s := range l.slots {
+		if (key == s.Prefix || strings.HasPrefix(key, s.Prefix+"/")) && (best == nil || len(s.Prefix) > len(best.Prefix)) {
+			best = s
+		}
+	}
+	return best
+}
+
+// Acquire 取得 key 所屬前綴的下載名額，返回釋放函式
+//
+// ctx 取消或等待超過 queueTimeout 時返回錯誤。
+func (l *prefixLimiter) Acquire(ctx context.Context, key string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	s := l.slotsFor(key)
+	if s == nil {
+		return func() {}, nil
+	}
+	release := func() { <-s.sem }
+
+	select {
+	case s.sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	s.queued.Add(1)
+	s.waiting.Add(1)
+	defer s.waiting.Add(-1)
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s.sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		s.timedOut.Add(1)
+		return nil, errFetchQueueTimeout
+	}
+}
+
+// Stats 返回各前綴的名額使用情況
+func (l *prefixLimiter) Stats() map[string]any {
+	stats := make(map[string]any, len(l.slots))
+	for _, s := range l.slots {
+		stats[s.Prefix] = map[string]any{
+			"limit":          s.Limit,
+			"active":         len(s.sem),
+			"waiting":        s.waiting.Load(),
+			"queued":         s.queued.Load(),
+			"queue_timeouts": s.timedOut.Load(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,224 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	priorityHeader      = "X-Fileproxy-Priority" // 客戶端優先級提示
+	lowPriorityMaxPause = 50 * time.Millisecond  // 低優先級每個區塊最長讓出時間
+	lowPriorityPoll     = 5 * time.Millisecond   // 讓出期間的檢查間隔
+)
+
+var (
+	errFetchQueueFull    = errors.New("fetch queue full")
+	errFetchQueueTimeout = errors.New("timed out waiting for fetch slot")
+)
+
+// Priority 請求優先級
+type Priority int
+
+const (
+	PriorityHigh Priority = iota // 互動式客戶端下載
+	PriorityLow                  // 背景流量（預取、複製）
+)
+
+// String 返回優先級名稱
+func (p Priority) String() string {
+	if p == PriorityLow {
+		return "low"
+	}
+	return "high"
+}
+
+// fetchScheduler 優先級感知的上游下載排程器
+//
+// 上游並發數受 limit 限制，空出的名額優先分配給高優先級等待者；
+// 等待者超過 maxQueue 或等待超過 queueTimeout 時放棄，避免冷啟動時請求無限堆積。
+// 高優先級傳輸進行中時，低優先級傳輸在每個區塊之間讓出頻寬與磁碟 IO。
+type fetchScheduler struct {
+	limit        int           // 0 表示不限制
+	maxQueue     int           // 0 表示不限制
+	queueTimeout time.Duration // 0 表示一直等待
+
+	mu      sync.Mutex
+	active  int
+	waiters [2][]chan struct{} // 依優先級排列的 FIFO 等待佇列
+
+	activeHigh atomic.Int64
+	yielded    atomic.Int64
+	queueFull  atomic.Int64
+	timedOut   atomic.Int64
+}
+
+// newFetchScheduler 建立排程器
+func newFetchScheduler(limit, maxQueue int, queueTimeout time.Duration) *fetchScheduler {
+	return &fetchScheduler{limit: limit, maxQueue: maxQueue, queueTimeout: queueTimeout}
+}
+
+// Acquire 取得上游下載名額
+//
+// ctx 取消、佇列已滿或等待逾時時返回錯誤。
+func (s *fetchScheduler) Acquire(ctx context.Context, prio Priority) error {
+	s.mu.Lock()
+	if s.limit <= 0 || (s.active < s.limit && !s.hasWaitersAhead(prio)) {
+		s.active++
+		s.mu.Unlock()
+		return nil
+	}
+	if s.maxQueue > 0 && len(s.waiters[PriorityHigh])+len(s.waiters[PriorityLow]) >= s.maxQueue {
+		s.mu.Unlock()
+		s.queueFull.Add(1)
+		return errFetchQueueFull
+	}
+	ch := make(chan struct{})
+	s.waiters[prio] = append(s.waiters[prio], ch)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.queueTimeout > 0 {
+		timer := time.NewTimer(s.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		if !s.leave(prio, ch) {
+			s.Release() // 名額已轉交，需歸還
+		}
+		return ctx.Err()
+	case <-timeout:
+		if !s.leave(prio, ch) {
+			return nil // 逾時的同時取得名額
+		}
+		s.timedOut.Add(1)
+		return errFetchQueueTimeout
+	}
+}
+
+// leave 將等待者移出佇列，返回 false 表示名額已轉交給它
+func (s *fetchScheduler) leave(prio Priority, ch chan struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := slices.Index(s.waiters[prio], ch)
+	if idx < 0 {
+		return false
+	}
+	s.waiters[prio] = slices.Delete(s.waiters[prio], idx, idx+1)
+	return true
+}
+
+// hasWaitersAhead 檢查是否有同級或更高優先級的等待者
+func (s *fetchScheduler) hasWaitersAhead(prio Priority) bool {
+	for p := PriorityHigh; p <= prio; p++ {
+		if len(s.waiters[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Release 歸還名額，直接轉交給優先級最高的等待者
+func (s *fetchScheduler) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := PriorityHigh; p <= PriorityLow; p++ {
+		if len(s.waiters[p]) > 0 {
+			ch := s.waiters[p][0]
+			s.waiters[p] = s.waiters[p][1:]
+			close(ch)
+			return
+		}
+	}
+	s.active--
+}
+
+// Begin 標記一個傳輸開始，返回結束函數
+func (s *fetchScheduler) Begin(prio Priority) func() {
+	if prio != PriorityHigh {
+		return func() {}
+	}
+	s.activeHigh.Add(1)
+	return func() { s.activeHigh.Add(-1) }
+}
+
+// Yield 低優先級傳輸在高優先級傳輸進行中時暫停
+func (s *fetchScheduler) Yield(ctx context.Context, prio Priority) {
+	if prio != PriorityLow || s.activeHigh.Load() == 0 {
+		return
+	}
+	s.yielded.Add(1)
+
+	deadline := time.Now().Add(lowPriorityMaxPause)
+	for s.activeHigh.Load() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lowPriorityPoll):
+		}
+	}
+}
+
+// Reader 包裝讀取者，低優先級時每次讀取前讓出
+func (s *fetchScheduler) Reader(ctx context.Context, prio Priority, r io.Reader) io.Reader {
+	if prio != PriorityLow {
+		return r
+	}
+	return &yieldReader{ctx: ctx, s: s, r: r}
+}
+
+// yieldReader 每次讀取前讓出給高優先級傳輸
+type yieldReader struct {
+	ctx context.Context
+	s   *fetchScheduler
+	r   io.Reader
+}
+
+func (y *yieldReader) Read(p []byte) (int, error) {
+	y.s.Yield(y.ctx, PriorityLow)
+	return y.r.Read(p)
+}
+
+// Stats 返回排程統計資訊
+func (s *fetchScheduler) Stats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]any{
+		"limit":          s.limit,
+		"active":         s.active,
+		"active_high":    s.activeHigh.Load(),
+		"waiting_high":   len(s.waiters[PriorityHigh]),
+		"waiting_low":    len(s.waiters[PriorityLow]),
+		"yielded":        s.yielded.Load(),
+		"queue_full":     s.queueFull.Load(),
+		"queue_timeouts": s.timedOut.Load(),
+	}
+}
+
+// requestPriority 由請求頭或路徑前綴決定優先級
+func (p *Proxy) requestPriority(r *http.Request) Priority {
+	switch strings.ToLower(r.Header.Get(priorityHeader)) {
+	case "low", "background":
+		return PriorityLow
+	case "high", "interactive":
+		return PriorityHigh
+	}
+	for _, prefix := range p.config.LowPriorityPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return PriorityLow
+		}
+	}
+	return PriorityHigh
+}
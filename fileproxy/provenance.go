@@ -0,0 +1,132 @@
+package fileproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const provenancePrefix = "/admin/provenance" // 查詢條目來源記錄的端點前綴
+
+// Provenance 條目下載時的來源記錄，供稽核可疑檔案的出處
+type Provenance struct {
+	Key        string      `json:"key"`
+	URL        string      `json:"url"`                   // 請求的上游 URL，帳密已遮蔽
+	FinalURL   string      `json:"final_url,omitempty"`   // 跟隨重新導向後的 URL，與 url 相同時省略
+	Source     string      `json:"source"`                // upstream 或 peer（由兄弟節點取得）
+	RemoteAddr string      `json:"remote_addr,omitempty"` // 最後一個連線的對端 IP 與埠
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"` // 上游回應頭，已移除逐跳頭
+	FetchedAt  time.Time   `json:"fetched_at"`
+	Size       int64       `json:"size"`
+	SHA256     string      `json:"sha256,omitempty"`
+}
+
+// ProvenanceResult /admin/provenance 的回應
+type ProvenanceResult struct {
+	*Provenance
+	Cached  bool `json:"cached"`  // 條目目前仍在快取中
+	Current bool `json:"current"` // 記錄的內容雜湊與目前的條目相符；不符表示條目已由其他途徑取代
+}
+
+// provenanceTrace 記錄上游請求實際連線的對端位址
+type provenanceTrace struct {
+	mu   sync.Mutex
+	addr string
+}
+
+// traceProvenance 在 ctx 掛上連線追蹤，重新導向時記錄最後一個連線
+func traceProvenance(ctx context.Context) (context.Context, *provenanceTrace) {
+	t := &provenanceTrace{}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.addr = info.Conn.RemoteAddr().String()
+			t.mu.Unlock()
+		},
+	}), t
+}
+
+// remoteAddr 返回最後一個連線的對端位址
+func (t *provenanceTrace) remoteAddr() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.addr
+}
+
+// recordProvenance 將完成下載的條目來源寫入索引庫
+func (p *Proxy) recordProvenance(key string, entry *CacheEntry, upstreamURL string, resp *http.Response, fromPeer bool, trace *provenanceTrace) {
+	prov := &Provenance{
+		Key:        key,
+		URL:        redactURL(upstreamURL),
+		Source:     "upstream",
+		RemoteAddr: trace.remoteAddr(),
+		Status:     resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		FetchedAt:  entry.CreatedAt(),
+		Size:       entry.Size,
+	}
+	if fromPeer {
+		prov.Source = "peer"
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		if final := resp.Request.URL.Redacted(); final != prov.URL {
+			prov.FinalURL = final
+		}
+	}
+	if entry.sum != (keyHash{}) {
+		prov.SHA256 = hex.EncodeToString(entry.sum[:])
+	}
+	data, err := json.Marshal(prov)
+	if err != nil {
+		slog.Warn("encode provenance failed", "key", key, "error", err)
+		return
+	}
+	p.cache.store.PutProvenance(entry.hash, data)
+}
+
+// redactURL 遮蔽 URL 中的密碼，無法解析時原樣返回
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// handleProvenance 返回單一路徑最近一次下載的來源記錄
+//
+// 路徑以與請求相同的方式正規化。條目被淘汰時記錄一併刪除。
+func (p *Proxy) handleProvenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := p.purgeKey(strings.TrimPrefix(r.URL.Path, provenancePrefix))
+	if !ok {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	entry, cached := p.cache.Peek(key)
+	data, ok := p.cache.store.Provenance(hashKey(key))
+	if !ok {
+		http.Error(w, "no provenance recorded", http.StatusNotFound)
+		return
+	}
+	result := ProvenanceResult{Provenance: &Provenance{}, Cached: cached}
+	if err := json.Unmarshal(data, result.Provenance); err != nil {
+		http.Error(w, "corrupt provenance record", http.StatusInternalServerError)
+		return
+	}
+	result.Current = cached && result.SHA256 == hex.EncodeToString(entry.sum[:])
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -1,25 +1,112 @@
 package fileproxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Proxy 檔案代理服務
 type Proxy struct {
 	config     *Config
 	cache      *Cache
+	profiles   []CacheRule // 鏡像設定檔展開的規則
+	links      *linkRewriter
+	hf         *hfResolver
+	metalink   *metalinkFetcher
 	httpClient *http.Client
 	fetchLocks sync.Map
 	bufferPool sync.Pool
+	memCache   *memoryCache
+	readahead  *rangeReadahead // 連續範圍請求的預讀，停用時為 nil
+	scheduler  *fetchScheduler
+	routines   *routineTracker
+	draining   atomic.Bool // 關閉中，發起者斷線的下載一律完成
+	fetchSlots *prefixLimiter
+	replicator *replicator
+	standby    *standbyStreamer
+	standbyRx  standbyReceiver
+	archiver   *archiver
+	uploader   *uploader
+	prefetcher *prefetcher
+	refresher  *hotRefresher
+	refetcher  *purgeRefetcher
+	guard      *cacheGuard
+	peers      *peerLookup
+	auth       *keyring
+	limiter    *rateLimiter
+	scans      *scanGuard
+	cors       *corsPolicy
+	handler    http.Handler // 中介層鏈包裝的 serve
+	bandwidth  *bandwidthLimiter
+	outbound   *outboundDialer
+	discovery  *srvDiscovery
+	upstreams  *capabilityProber
+	budget     *upstreamBudget
+	authSalt   []byte
+	dashboard  *dashboard
+	tracing    *tracing
+	metrics    *metrics
+	faults     faults
+	offline    offlineState
+	stats      requestStats
+}
+
+// errUncacheable 表示上游回應禁止快取，等待同一下載的請求需自行回源
+var errUncacheable = errors.New("upstream response not cacheable")
+
+// errNoRoute 表示路徑沒有對應的上游
+var errNoRoute = errors.New("no route")
+
+// errChecksumMismatch 表示下載內容與檔名中的雜湊不符
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// errFillAbandoned 表示發起者斷線後依策略放棄填充
+var errFillAbandoned = errors.New("client disconnected, fill abandoned")
+
+// errFetchPanicked 表示下載途中發生 panic
+var errFetchPanicked = errors.New("fetch panicked")
+
+// upstreamStatusError 上游返回非 200 狀態，等待同一下載的請求以相同方式回應
+type upstreamStatusError int
+
+func (e upstreamStatusError) Error() string { return fmt.Sprintf("upstream: %d", int(e)) }
+
+// negativeStatus 返回上游錯誤狀態對應的客戶端狀態碼：404、403 與 410 原樣返回，其餘為 502
+func negativeStatus(status int) int {
+	switch status {
+	case http.StatusNotFound, http.StatusForbidden, http.StatusGone:
+		return status
+	}
+	return http.StatusBadGateway
+}
+
+// failedFetchStatus 返回下載失敗時等待中請求的狀態碼，連線錯誤等非狀態碼錯誤為 502
+func failedFetchStatus(err error) int {
+	var status upstreamStatusError
+	switch {
+	case errors.As(err, &status):
+		return negativeStatus(int(status))
+	case errors.Is(err, errNoRoute):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
 }
 
 // fetchLock 用於協調同一檔案的並發下載
@@ -38,21 +125,46 @@ func newFetchLock() *fetchLock {
 
 // NewProxy 建立代理實例
 func NewProxy(cfg *Config) (*Proxy, error) {
+	outbound, err := newOutboundDialer(cfg.OutboundAddrs)
+	if err != nil {
+		return nil, err
+	}
+
 	cache, err := NewCache(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Proxy{
-		config: cfg,
-		cache:  cache,
+	transport := &http.Transport{
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		// 自訂 DialContext 時預設不嘗試 HTTP/2，明確開啟後 https 上游以 ALPN 協商
+		ForceAttemptHTTP2: true,
+	}
+	if outbound != nil {
+		transport.DialContext = outbound.DialContext
+	}
+	if files := newFileTransport(cfg); files != nil {
+		transport.RegisterProtocol("file", files)
+	}
+	discovery := newSRVDiscovery(cfg, transport.DialContext)
+	if discovery != nil {
+		discovery.transport = transport
+		transport.DialContext = discovery.DialContext
+	}
+
+	p := &Proxy{
+		config:    cfg,
+		cache:     cache,
+		outbound:  outbound,
+		discovery: discovery,
+		profiles:  cfg.mirrorRules(),
+		metrics:   newMetrics(),
 		httpClient: &http.Client{
-			Timeout: cfg.UpstreamTimeout,
-			Transport: &http.Transport{
-				MaxIdleConns:        cfg.MaxIdleConns,
-				MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
-				IdleConnTimeout:     90 * time.Second,
-			},
+			Timeout:       cfg.UpstreamTimeout,
+			Transport:     transport,
+			CheckRedirect: redirectPolicy(cfg),
 		},
 		bufferPool: sync.Pool{
 			New: func() any {
@@ -60,73 +172,353 @@ func NewProxy(cfg *Config) (*Proxy, error) {
 				return &buf
 			},
 		},
-	}, nil
+	}
+
+	p.httpClient.Transport = p.faults.transport(p.httpClient.Transport)
+	if p.budget = newUpstreamBudget(cfg, p.httpClient.Transport); p.budget != nil {
+		p.httpClient.Transport = p.budget
+	}
+	p.httpClient.Transport = &offlineTransport{next: p.httpClient.Transport, enabled: &p.offline.enabled}
+	p.SetOffline(cfg.Offline)
+	if p.tracing, err = newTracing(cfg); err != nil {
+		cache.Close()
+		return nil, err
+	}
+	if cfg.ForwardAuthorization {
+		if p.authSalt, err = loadAuthSalt(cfg.CacheDir); err != nil {
+			cache.Close()
+			return nil, err
+		}
+	}
+	p.auth = newKeyring(cfg)
+	p.limiter = newRateLimiter(cfg)
+	p.scans = newScanGuard(cfg)
+	p.bandwidth = newBandwidthLimiter(cfg)
+	p.memCache = newMemoryCache(cfg.MemoryCacheSize, cfg.MemoryCacheMaxFileSize)
+	p.readahead = newRangeReadahead(cfg, cache)
+	p.scheduler = newFetchScheduler(cfg.MaxConcurrentFetches, cfg.MaxFetchQueue, cfg.FetchQueueTimeout)
+	p.routines = newRoutineTracker(cfg)
+	p.fetchSlots = newPrefixLimiter(cfg.PrefixFetchLimits, cfg.FetchQueueTimeout)
+	if cfg.ArchiveDir != "" {
+		if p.archiver, err = newArchiver(cfg, cache); err != nil {
+			cache.Close()
+			return nil, err
+		}
+	}
+	if len(cfg.ReplicationPeers) > 0 {
+		p.replicator = newReplicator(cfg, cfg.ReplicationPeers, cache, p.scheduler)
+	}
+	p.standby = newStandbyStreamer(cfg, cache, p.scheduler)
+	if cfg.EnableUpload {
+		if p.uploader, err = newUploader(cfg, cache, p.httpClient); err != nil {
+			cache.Close()
+			return nil, err
+		}
+	}
+	if cfg.PeerListenAddr != "" {
+		if p.peers, err = newPeerLookup(cfg, cache, p.httpClient); err != nil {
+			if p.uploader != nil {
+				p.uploader.Close()
+			}
+			cache.Close()
+			return nil, fmt.Errorf("start peer lookup: %w", err)
+		}
+	}
+	p.prefetcher = newPrefetcher(p, cfg.PrefetchWorkers)
+	p.refresher = newHotRefresher(p, cfg.HotRefreshCount, cfg.HotRefreshAhead)
+	p.refetcher = newPurgeRefetcher(p, cfg.PurgeRefetchRate, cfg.PurgeStaleWindow)
+	p.guard = newCacheGuard(cfg, cache)
+	p.upstreams = newCapabilityProber(cfg, p.httpClient)
+	p.dashboard = newDashboard(p)
+	p.cors = newCORSPolicy(cfg)
+	p.links = newLinkRewriter(cfg)
+	p.hf = newHFResolver(p, cfg)
+	p.metalink = newMetalinkFetcher(p, cfg)
+	p.handler = p.buildHandler(http.HandlerFunc(p.serve))
+
+	return p, nil
 }
 
 // Close 關閉代理
 func (p *Proxy) Close() error {
+	p.dashboard.Close()
+	p.limiter.Close()
+	p.scans.Close()
+	if p.prefetcher != nil {
+		p.prefetcher.Close()
+	}
+	if p.refresher != nil {
+		p.refresher.Close()
+	}
+	if p.refetcher != nil {
+		p.refetcher.Close()
+	}
+	if p.guard != nil {
+		p.guard.Close()
+	}
+	if p.replicator != nil {
+		p.replicator.Close()
+	}
+	if p.standby != nil {
+		p.standby.Close()
+	}
+	if p.archiver != nil {
+		p.archiver.Close()
+	}
+	if p.uploader != nil {
+		p.uploader.Close()
+	}
+	if p.peers != nil {
+		p.peers.Close()
+	}
+	if p.auth != nil {
+		p.auth.Close()
+	}
+	p.upstreams.Close()
+	if p.discovery != nil {
+		p.discovery.Close()
+	}
+	p.readahead.Close()
 	p.cache.Close()
+	p.routines.Close()
+	if err := p.tracing.Close(); err != nil {
+		slog.Warn("flush traces failed", "error", err)
+	}
 	return nil
 }
 
 func (p *Proxy) getBuffer() []byte    { return *p.bufferPool.Get().(*[]byte) }
 func (p *Proxy) putBuffer(buf []byte) { p.bufferPool.Put(&buf) }
 
-// ServeHTTP 處理 HTTP 請求
+// ServeHTTP 處理 HTTP 請求，依序經過設定的中介層
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+	w, abort := p.faults.client(w)
+	defer abort()
+	w.Header().Add("Via", viaValue(1, 1))
+
+	start := time.Now()
+	ctx, span := p.tracing.startRequest(r)
+	defer span.End()
+	defer func() {
+		p.metrics.requests.observe(cacheLabel(w.Header().Get("X-Cache")), time.Since(start), span)
+	}()
+	r = r.WithContext(ctx)
+	if span.IsRecording() {
+		sw := &spanWriter{ResponseWriter: w}
+		defer sw.finish(span)
+		w = sw
+	}
+	p.handler.ServeHTTP(w, r)
+}
+
+// serve 通過中介層之後處理代理請求
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !(p.config.PassthroughWrites && isWriteMethod(r.Method)) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	key := r.URL.Path
-	if err := p.handleRequest(w, r, key); err != nil {
-		slog.Error("request failed", "key", key, "error", err)
+	path := r.URL.Path
+	rule := p.ruleFor(r, path)
+	if rule != nil {
+		for name, value := range rule.Headers {
+			w.Header().Set(name, value)
+		}
+	}
+	r = r.WithContext(withRule(r.Context(), rule))
+	w = p.bandwidth.Writer(r.Context(), w)
+
+	var err error
+	if spec := r.URL.Query().Get("version"); spec != "" && p.config.KeepVersions > 0 && !isWriteMethod(r.Method) {
+		err = p.serveVersion(w, r, spec)
+	} else if p.hf.Match(r) {
+		err = p.hf.Serve(w, r)
+	} else {
+		err = p.handleRequest(w, r, p.cacheKey(r))
+	}
+	if err != nil {
+		spanError(trace.SpanFromContext(r.Context()), err)
+		p.stats.recordError(path, err)
+		slog.Error("request failed", "key", path, "error", err)
 	}
 }
 
 // handleRequest 處理具體請求
 func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request, key string) error {
-	// 檢查 404 快取
-	if p.cache.IsNotFound(key) {
-		http.Error(w, "Not Found", http.StatusNotFound)
+	if isWriteMethod(r.Method) {
+		return p.passWrite(w, r, key)
+	}
+	if p.Offline() {
+		return p.serveOffline(w, r, key)
+	}
+
+	// 規則指定不快取的路徑與快取故障降級期間直接透傳，不參與下載合併
+	if !ruleFrom(r.Context()).cacheable() || p.guard.Degraded() {
+		if onlyIfCached(r) {
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			return nil
+		}
+		if r.Method == http.MethodHead {
+			return p.serveHead(w, r, key)
+		}
+		p.stats.misses.Add(1)
+		return p.doFetchAndServe(r.Context(), w, r, key, newFetchLock(), nil)
+	}
+
+	// 以 refetch 選項清除的條目在重新下載完成前返回舊內容；開啟前被淘汰時尚未寫出任何內容，改走一般流程
+	if entry, ok := p.refetcher.Stale(key); ok && p.validateCacheFile(entry) {
+		err := p.serveFromCache(w, r, entry, "STALE")
+		if !errors.Is(err, errEntryEvicted) && !errors.Is(err, fs.ErrNotExist) {
+			p.stats.stale.Add(1)
+			return err
+		}
+	}
+
+	// 檢查負快取，5xx 期間有過期快取時優先返回
+	lookupCtx, lookup := p.tracing.tracer.Start(r.Context(), "fileproxy.cache_lookup")
+	if status, ok := p.cache.Negative(key); ok {
+		lookup.SetAttributes(attribute.String("fileproxy.cache.result", "negative"), attribute.Int("fileproxy.cache.negative_status", status))
+		lookup.End()
+		if status >= 500 {
+			if served, serr := p.serveStale(w, r, key, nil); served {
+				return serr
+			}
+		}
+		if status == http.StatusNotFound {
+			p.stats.notFound.Add(1)
+		}
+		p.stats.negative.Add(1)
+		w.Header().Set("X-Cache", "NEGATIVE")
+		http.Error(w, http.StatusText(negativeStatus(status)), negativeStatus(status))
 		return nil
 	}
 
 	// 檢查檔案快取
-	if entry, ok := p.cache.Get(key); ok {
+	entry, ok := p.cache.Get(lookupCtx, key)
+	lookup.SetAttributes(attribute.Bool("fileproxy.cache.hit", ok))
+	lookup.End()
+	if ok {
+		// 強一致路徑每次向上游確認，304 時才使用快取；不可變條目不需確認
+		if ruleFrom(r.Context()).revalidate() && !entry.immutable() && p.validateCacheFile(entry) {
+			return p.fetchAndServe(r.Context(), w, r, key, entry)
+		}
+		// 記憶體熱層命中時不觸碰檔案系統
+		if data, ok := p.memCache.Get(entry); ok {
+			p.stats.hits.Add(1)
+			slog.Debug("cache hit", "key", key, "tier", "memory")
+			return p.serveContent(w, r, entry, bytes.NewReader(data), "HIT")
+		}
 		if p.validateCacheFile(entry) {
-			return p.serveFromCache(w, r, entry)
+			// 驗證後、開啟前被淘汰時尚未寫出任何內容，改為重新下載
+			err := p.serveFromCache(w, r, entry, "HIT")
+			switch {
+			case errors.Is(err, errEntryEvicted):
+				slog.Debug("cache entry evicted before serving, re-fetching", "key", key)
+			case errors.Is(err, fs.ErrNotExist):
+				// 沿用的檢查結果已過時，檔案在外部被刪除
+				slog.Debug("cache file missing, re-fetching", "key", key)
+				entry.checkedAt.Store(0)
+				p.cache.Remove(key)
+			default:
+				p.stats.hits.Add(1)
+				slog.Debug("cache hit", "key", key, "tier", "disk")
+				return err
+			}
+		} else {
+			slog.Debug("cache file invalid, re-fetching", "key", key)
+			p.cache.Remove(key)
 		}
-		slog.Debug("cache file invalid, re-fetching", "key", key)
-		p.cache.Remove(key)
 	}
 
-	return p.fetchAndServe(r.Context(), w, r, key)
+	// 兄弟節點的查詢只返回已快取的檔案，避免觸發回源
+	if onlyIfCached(r) {
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return nil
+	}
+
+	// 未快取的 HEAD 只向上游請求 HEAD，不下載內容
+	if r.Method == http.MethodHead {
+		return p.serveHead(w, r, key)
+	}
+	return p.fetchAndServe(r.Context(), w, r, key, nil)
 }
 
-// validateCacheFile 驗證快取檔案
+// validateCacheFile 驗證快取檔案，啟用 VerifyOnServe 時一併校驗內容
+//
+// 檢查結果在 StatCacheTTL 內沿用，命中路徑不必每次 stat；期間檔案若在外部被刪除，
+// 開啟失敗時由呼叫端改為重新下載。
 func (p *Proxy) validateCacheFile(entry *CacheEntry) bool {
-	info, err := os.Stat(entry.FilePath)
-	return err == nil && info.Size() == entry.Size
+	now := time.Now().UnixNano()
+	if ttl := p.config.StatCacheTTL; ttl <= 0 || now-entry.checkedAt.Load() >= int64(ttl) {
+		info, err := os.Stat(p.cache.FilePath(entry))
+		if err != nil || info.Size() != entry.Size {
+			entry.checkedAt.Store(0)
+			return false
+		}
+		entry.checkedAt.Store(now)
+	}
+	return !p.config.VerifyOnServe || p.cache.Verify(entry)
 }
 
 // serveFromCache 從快取提供檔案（支援 Range）
-func (p *Proxy) serveFromCache(w http.ResponseWriter, r *http.Request, entry *CacheEntry) error {
-	file, err := os.Open(entry.FilePath)
+func (p *Proxy) serveFromCache(w http.ResponseWriter, r *http.Request, entry *CacheEntry, status string) error {
+	p.faults.diskRead()
+	// 連續的範圍請求沿用連線保留的檔案與預讀緩衝
+	if !p.memCache.admits(entry.Size) {
+		if content, done, ok := p.readahead.open(r, entry); ok {
+			defer done()
+			return p.serveContent(w, r, entry, content, status)
+		}
+	}
+	file, release, err := p.cache.Open(r.Context(), entry)
 	if err != nil {
+		if !errors.Is(err, errEntryEvicted) {
+			p.cache.recordFailure()
+		}
 		return fmt.Errorf("open cache file: %w", err)
 	}
-	defer file.Close()
+	defer release()
+
+	// 小檔案載入記憶體熱層，之後的命中不再讀取磁碟
+	if p.memCache.admits(entry.Size) {
+		data := make([]byte, entry.Size)
+		if _, err := io.ReadFull(file, data); err == nil {
+			p.memCache.Add(entry, data)
+			return p.serveContent(w, r, entry, bytes.NewReader(data), status)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return p.serveContent(w, r, entry, file, status)
+}
 
-	w.Header().Set("Content-Type", entry.ContentType)
+// serveContent 寫出快取內容（支援 Range）
+func (p *Proxy) serveContent(w http.ResponseWriter, r *http.Request, entry *CacheEntry, content io.ReadSeeker, status string) error {
+	w.Header().Set("Content-Type", entry.ContentType())
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("X-Cache", status)
+	p.setCacheHeaders(w, r, entry, status)
+
+	prio := p.requestPriority(r)
+	defer p.scheduler.Begin(prio)()
+
+	if p.config.StrictHTTP {
+		return p.serveStrict(w, r, prio, entry, content)
+	}
+
+	// 下游快取的條件請求，驗證器相符時不傳送內容
+	if notModified(r, w.Header().Get("ETag"), entryLastModified(entry)) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
 
 	// 處理 Range 請求
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" {
-		return p.serveRangeFromFile(w, file, entry.Size, rangeHeader)
+		return p.serveRange(w, r, prio, content, entry.Size, rangeHeader)
 	}
 
 	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
@@ -137,12 +529,12 @@ func (p *Proxy) serveFromCache(w http.ResponseWriter, r *http.Request, entry *Ca
 
 	buf := p.getBuffer()
 	defer p.putBuffer(buf)
-	_, err = io.CopyBuffer(w, file, buf)
+	_, err := io.CopyBuffer(w, p.scheduler.Reader(r.Context(), prio, content), buf)
 	return err
 }
 
-// serveRangeFromFile 處理 Range 請求
-func (p *Proxy) serveRangeFromFile(w http.ResponseWriter, file *os.File, totalSize int64, rangeHeader string) error {
+// serveRange 處理 Range 請求
+func (p *Proxy) serveRange(w http.ResponseWriter, r *http.Request, prio Priority, content io.ReadSeeker, totalSize int64, rangeHeader string) error {
 	start, end, ok := parseRange(rangeHeader, totalSize)
 	if !ok {
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", totalSize))
@@ -155,13 +547,13 @@ func (p *Proxy) serveRangeFromFile(w http.ResponseWriter, file *os.File, totalSi
 	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
 	w.WriteHeader(http.StatusPartialContent)
 
-	if _, err := file.Seek(start, io.SeekStart); err != nil {
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
 		return err
 	}
 
 	buf := p.getBuffer()
 	defer p.putBuffer(buf)
-	_, err := io.CopyBuffer(w, io.LimitReader(file, length), buf)
+	_, err := io.CopyBuffer(w, p.scheduler.Reader(r.Context(), prio, io.LimitReader(content, length)), buf)
 	return err
 }
 
@@ -215,9 +607,9 @@ func parseRange(rangeHeader string, totalSize int64) (start, end int64, ok bool)
 	return start, end, true
 }
 
-// fetchAndServe 從上游獲取並提供檔案
-func (p *Proxy) fetchAndServe(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) error {
-	lockI, _ := p.fetchLocks.LoadOrStore(key, newFetchLock())
+// fetchAndServe 從上游獲取並提供檔案，cached 不為 nil 時以條件請求重新驗證該條目
+func (p *Proxy) fetchAndServe(ctx context.Context, w http.ResponseWriter, r *http.Request, key string, cached *CacheEntry) error {
+	lockI, loaded := p.fetchLocks.LoadOrStore(key, newFetchLock())
 	lock := lockI.(*fetchLock)
 
 	lock.mu.Lock()
@@ -227,82 +619,308 @@ func (p *Proxy) fetchAndServe(ctx context.Context, w http.ResponseWriter, r *htt
 		err := lock.err
 		lock.mu.Unlock()
 
+		// 上一次回應不可快取，自行向上游請求
+		if err == errUncacheable {
+			p.stats.misses.Add(1)
+			return p.doFetchAndServe(ctx, w, r, key, newFetchLock(), cached)
+		}
 		if err != nil {
-			http.Error(w, "Not Found", http.StatusNotFound)
+			if served, serr := p.serveStale(w, r, key, cached); served {
+				return serr
+			}
+			status := failedFetchStatus(err)
+			http.Error(w, http.StatusText(status), status)
 			return nil
 		}
+		p.stats.hits.Add(1)
 		return p.serveFromCacheOrError(w, r, key)
 	}
 
 	// 檢查是否有其他請求正在下載（pending 存在）
 	if sf, exists := p.cache.GetPending(key); exists {
 		lock.mu.Unlock()
+		// 剛建立的鎖不會交給 doFetchAndServe 移除
+		if !loaded {
+			p.fetchLocks.CompareAndDelete(key, lock)
+		}
+		p.stats.streaming.Add(1)
 		return p.serveFromStreaming(w, r, sf)
 	}
 
 	lock.mu.Unlock()
-	return p.doFetchAndServe(ctx, w, r, key, lock)
+	if cached == nil {
+		p.stats.misses.Add(1)
+	}
+	return p.doFetchAndServe(ctx, w, r, key, lock, cached)
 }
 
 // serveFromCacheOrError 從快取服務或返回錯誤
 func (p *Proxy) serveFromCacheOrError(w http.ResponseWriter, r *http.Request, key string) error {
-	if p.cache.IsNotFound(key) {
-		http.Error(w, "Not Found", http.StatusNotFound)
+	if status, ok := p.cache.Negative(key); ok {
+		http.Error(w, http.StatusText(negativeStatus(status)), negativeStatus(status))
 		return nil
 	}
-	if entry, ok := p.cache.Get(key); ok && p.validateCacheFile(entry) {
-		return p.serveFromCache(w, r, entry)
+	if entry, ok := p.cache.Get(r.Context(), key); ok && p.validateCacheFile(entry) {
+		return p.serveFromCache(w, r, entry, "HIT")
 	}
 	p.cache.Remove(key)
 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	return fmt.Errorf("cache entry invalid after download")
 }
 
+// serveStale 上游故障時返回已過期的快取檔案（stale-if-error）
+//
+// cached 為重新驗證中的條目，不論是否過期都可作為回退。
+func (p *Proxy) serveStale(w http.ResponseWriter, r *http.Request, key string, cached *CacheEntry) (bool, error) {
+	if p.config.StaleIfErrorTTL <= 0 {
+		return false, nil
+	}
+	entry, ok := cached, cached != nil
+	if !ok {
+		entry, ok = p.cache.GetStale(key)
+	}
+	if !ok || !p.validateCacheFile(entry) {
+		return false, nil
+	}
+	p.stats.stale.Add(1)
+	return true, p.serveFromCache(w, r, entry, "STALE")
+}
+
 // doFetchAndServe 執行實際的下載和回應
-func (p *Proxy) doFetchAndServe(ctx context.Context, w http.ResponseWriter, r *http.Request, key string, lock *fetchLock) error {
-	defer p.fetchLocks.Delete(key)
+func (p *Proxy) doFetchAndServe(ctx context.Context, w http.ResponseWriter, r *http.Request, key string, lock *fetchLock, cached *CacheEntry) error {
+	defer p.fetchLocks.CompareAndDelete(key, lock)
+
+	// 下載途中 panic 時仍通知等待者並清理暫存檔，之後的請求不會附加到永遠不會完成的下載
+	var sf *StreamingFile
+	var isNew bool
+	defer func() {
+		if v := recover(); v != nil {
+			if isNew {
+				p.cache.FailPending(key, sf)
+			}
+			p.finishLock(lock, errFetchPanicked)
+			panic(v)
+		}
+	}()
+
+	prio := p.requestPriority(r)
+	var releaseSlot func()
+	releaseRoutine, err := p.routines.Acquire(routineFetchers, key)
+	if err == nil {
+		defer releaseRoutine()
+		releaseSlot, err = p.fetchSlots.Acquire(ctx, key)
+	}
+	if err == nil {
+		if err = p.scheduler.Acquire(ctx, prio); err != nil {
+			releaseSlot()
+		}
+	}
+	if err != nil {
+		p.finishLock(lock, err)
+		if ctx.Err() == nil {
+			if served, serr := p.serveStale(w, r, key, cached); served {
+				slog.Warn("fetch queue saturated, served stale", "key", key, "error", err)
+				return serr
+			}
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return fmt.Errorf("acquire fetch slot: %w", err)
+	}
+	defer releaseSlot()
+	defer p.scheduler.Release()
+	defer p.scheduler.Begin(prio)()
+
+	rule := ruleFrom(ctx)
+	cacheable := rule.cacheable()
 
-	upstreamURL := strings.TrimSuffix(p.config.UpstreamURL, "/") + key
+	// 可快取的下載與發起的客戶端脫鉤，客戶端中途斷線時仍完成寫入快取
+	fetchCtx := ctx
+	if cacheable {
+		fetchCtx = context.WithoutCancel(ctx)
+	}
+	// 看門狗中止停滯的下載時一併中止上游請求
+	fetchCtx, cancelFetch := context.WithCancel(fetchCtx)
+	defer cancelFetch()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil)
+	upstreamURL, ok := p.upstreamURLFor(r, rule)
+	if !ok {
+		p.finishLock(lock, errNoRoute)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	fetchCtx, fetchSpan := p.tracing.startFetch(fetchCtx, upstreamURL)
+	defer fetchSpan.End()
+	fetchStart, fetchStatus := time.Now(), 0
+	defer func() {
+		p.metrics.fetches.observe(statusClass(fetchStatus), time.Since(fetchStart), fetchSpan)
+	}()
+	var trace *provenanceTrace
+	if cacheable && p.config.RecordProvenance {
+		fetchCtx, trace = traceProvenance(fetchCtx)
+	}
+	req, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, upstreamURL, nil)
 	if err != nil {
 		p.finishLock(lock, err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return fmt.Errorf("create request: %w", err)
 	}
-
-	resp, err := p.httpClient.Do(req)
+	p.forwardCredentials(req, r)
+	p.setProxyHeaders(req, r)
+	p.metalink.accept(req, key)
+	p.tracing.inject(fetchCtx, req.Header)
+
+	// 重新驗證必須詢問上游，不使用兄弟節點
+	var resp *http.Response
+	if cached != nil {
+		setConditional(req, cached)
+	} else {
+		resp = p.fetchFromPeer(fetchCtx, key)
+	}
+	fromPeer := resp != nil
+	if resp == nil {
+		if resp, err = p.httpClient.Do(req); err == nil {
+			p.upstreams.observe(upstreamURL, resp)
+		}
+	} else {
+		fetchSpan.SetAttributes(attribute.String("fileproxy.source", "peer"))
+	}
 	if err != nil {
+		spanError(fetchSpan, err)
 		p.finishLock(lock, err)
+		if served, serr := p.serveStale(w, r, key, cached); served {
+			slog.Warn("upstream unreachable, served stale", "key", key, "error", err)
+			return serr
+		}
+		if rejectRateLimited(w, err) {
+			return nil
+		}
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return fmt.Errorf("upstream request: %w", err)
 	}
 	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	fetchStatus = resp.StatusCode
+	fetchSpan.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	if cached != nil {
+		if resp.StatusCode == http.StatusNotModified {
+			p.finishLock(lock, nil)
+			p.stats.revalidated.Add(1)
+			if renewed, ok := p.cache.Renew(key, cached, p.renewTTL(rule, resp.Header, cached)); ok {
+				cached = renewed
+			}
+			if data, ok := p.memCache.Get(cached); ok {
+				return p.serveContent(w, r, cached, bytes.NewReader(data), "REVALIDATED")
+			}
+			return p.serveFromCache(w, r, cached, "REVALIDATED")
+		}
+		p.stats.misses.Add(1)
+	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		p.finishLock(lock, fmt.Errorf("not found"))
-		p.cache.PutNotFound(key)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return nil
+	// 原樣轉交的 3xx 不快取，等待中的請求各自回源
+	if p.isPassedRedirect(resp.StatusCode) {
+		p.finishLock(lock, errUncacheable)
+		return p.passRedirect(w, r, resp)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		p.finishLock(lock, fmt.Errorf("upstream: %d", resp.StatusCode))
+		p.finishLock(lock, upstreamStatusError(resp.StatusCode))
+		// 轉發憑證時上游的驗證挑戰需返回客戶端
+		if p.config.ForwardAuthorization && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			http.Error(w, http.StatusText(resp.StatusCode), resp.StatusCode)
+			return nil
+		}
+		// 已解析位址的失敗屬於該位址（例如過期的簽名 URL），不記錄在以內容雜湊為 key 的條目上
+		if _, resolved := originFrom(ctx); !resolved {
+			if ttl := p.config.negativeTTL(rule, resp.StatusCode); cacheable && ttl > 0 {
+				p.cache.PutNegative(key, resp.StatusCode, ttl)
+			}
+		}
+		if status := negativeStatus(resp.StatusCode); status != http.StatusBadGateway {
+			if status == http.StatusNotFound {
+				p.stats.notFound.Add(1)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return nil
+		}
+		if resp.StatusCode >= 500 {
+			if served, serr := p.serveStale(w, r, key, cached); served {
+				slog.Warn("upstream error, served stale", "key", key, "status", resp.StatusCode)
+				return serr
+			}
+		}
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return fmt.Errorf("upstream error: %d", resp.StatusCode)
 	}
 
+	// 上游返回 Metalink 時改從其中的鏡像下載
+	var mirrored io.ReadCloser
+	if p.metalink.Match(key, resp) {
+		var mresp *http.Response
+		if mresp, mirrored, err = p.metalink.Open(fetchCtx, r, key, resp); err != nil {
+			p.finishLock(lock, err)
+			if served, serr := p.serveStale(w, r, key, cached); served {
+				slog.Warn("metalink mirrors failed, served stale", "key", key, "error", err)
+				return serr
+			}
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return fmt.Errorf("metalink: %w", err)
+		}
+		defer mirrored.Close()
+		resp = mresp
+	}
+
+	// 改寫連結後長度改變，以串流方式返回
+	rewrite := rule.rewriteLinks() && p.links != nil
 	expectedSize := resp.ContentLength
+	if rewrite {
+		expectedSize = -1
+	}
 	contentType := resp.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 
-	sf, isNew, err := p.cache.GetOrCreatePending(key)
-	if err != nil {
-		p.finishLock(lock, err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return fmt.Errorf("create cache file: %w", err)
+	// 降級期間開始的下載不寫入快取，等待中的請求各自回源
+	var doneErr error
+	if cacheable && p.guard.Degraded() {
+		cacheable = false
+		doneErr = errUncacheable
+	}
+
+	// 上游禁止共享快取的回應只透傳，並移除重新驗證中的舊條目
+	if cacheable && !p.storeAllowed(rule, resp.Header) {
+		cacheable = false
+		doneErr = errUncacheable
+		if cached != nil {
+			p.cache.Remove(key)
+		}
+		slog.Debug("upstream forbids storing, bypassing cache", "key", key)
+	}
+
+	cacheStatus := "BYPASS"
+	if cacheable {
+		cacheStatus = "MISS"
+		sf, isNew, err = p.cache.GetOrCreatePending(fetchCtx, key)
+		if err != nil {
+			p.cache.recordFailure()
+			p.finishLock(lock, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return fmt.Errorf("create cache file: %w", err)
+		}
+	}
+
+	if isNew {
+		sf.SetCancel(cancelFetch)
+		sf.SetMeta(contentType, expectedSize)
+		// 熱門條目刷新時也以驗證器發出條件請求
+		if rule.revalidate() || p.refresher != nil {
+			sf.SetValidators(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+		}
 	}
 
 	w.Header().Set("Content-Type", contentType)
@@ -310,41 +928,80 @@ func (p *Proxy) doFetchAndServe(ctx context.Context, w http.ResponseWriter, r *h
 	if expectedSize >= 0 {
 		w.Header().Set("Content-Length", strconv.FormatInt(expectedSize, 10))
 	}
-	w.Header().Set("X-Cache", "MISS")
+	w.Header().Set("X-Cache", cacheStatus)
 
 	if r.Method == http.MethodHead {
 		if isNew {
-			p.cache.FailPending(key)
+			p.cache.FailPending(key, sf)
 		}
-		p.finishLock(lock, nil)
+		p.finishLock(lock, doneErr)
 		return nil
 	}
 
+	body := resp.Body
+	if mirrored != nil {
+		body = mirrored
+	} else if !fromPeer {
+		body = p.upstreamBody(fetchCtx, req, resp)
+		defer body.Close()
+	}
+	if rewrite {
+		body = p.links.reader(body)
+	}
+
 	buf := p.getBuffer()
 	defer p.putBuffer(buf)
 
 	var totalWritten int64
 	var downloadErr error
+	clientGone, finishing := false, false
 
 	for {
-		n, readErr := resp.Body.Read(buf)
+		p.scheduler.Yield(fetchCtx, prio)
+		n, readErr := body.Read(buf)
 		if n > 0 {
 			if isNew {
-				if _, writeErr := sf.Write(buf[:n]); writeErr != nil {
+				writeErr := p.faults.diskWrite()
+				if writeErr == nil {
+					_, writeErr = sf.Write(buf[:n])
+				}
+				if writeErr != nil {
+					p.cache.recordFailure()
 					slog.Warn("cache write failed", "key", key, "error", writeErr)
-					isNew = false // 停止寫入快取
+					p.cache.FailPending(key, sf) // 通知串流讀取者並清理暫存檔
+					isNew = false                // 停止寫入快取
+					if clientGone {
+						downloadErr = fmt.Errorf("write cache file: %w", writeErr)
+						break
+					}
 				}
 			}
 
-			written, writeErr := w.Write(buf[:n])
-			totalWritten += int64(written)
-			if writeErr != nil {
-				downloadErr = fmt.Errorf("write response: %w", writeErr)
-				break
+			totalWritten += int64(n)
+			if !clientGone {
+				if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+					if !isNew {
+						downloadErr = fmt.Errorf("write response: %w", writeErr)
+						break
+					}
+					clientGone = true
+				} else if flusher, ok := w.(http.Flusher); ok {
+					flusher.Flush()
+				}
 			}
 
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
+			// 發起者斷線後依策略決定是否在背景完成；仍有其他客戶端串流讀取時繼續，讀取者都離開後再判斷
+			if clientGone && isNew && !finishing {
+				if p.keepFilling(totalWritten, expectedSize) {
+					finishing = true
+					p.stats.detached.Add(1)
+					slog.Debug("client disconnected, finishing fill in background", "key", key)
+				} else if sf.Readers() == 0 {
+					p.stats.abandoned.Add(1)
+					slog.Debug("client disconnected, abandoning fill", "key", key, "written", totalWritten, "expected", expectedSize)
+					downloadErr = errFillAbandoned
+					break
+				}
 			}
 		}
 
@@ -358,7 +1015,7 @@ func (p *Proxy) doFetchAndServe(ctx context.Context, w http.ResponseWriter, r *h
 
 	if downloadErr != nil {
 		if isNew {
-			p.cache.FailPending(key)
+			p.cache.FailPending(key, sf)
 		}
 		p.finishLock(lock, downloadErr)
 		return downloadErr
@@ -367,20 +1024,64 @@ func (p *Proxy) doFetchAndServe(ctx context.Context, w http.ResponseWriter, r *h
 	if expectedSize >= 0 && totalWritten != expectedSize {
 		slog.Warn("size mismatch", "key", key, "expected", expectedSize, "got", totalWritten)
 		if isNew {
-			p.cache.FailPending(key)
+			p.cache.FailPending(key, sf)
 		}
 		p.finishLock(lock, fmt.Errorf("size mismatch"))
 		return fmt.Errorf("size mismatch: expected %d, got %d", expectedSize, totalWritten)
 	}
 
+	// 以內容雜湊命名的檔案與檔名不符時不寫入快取，避免上游或傳輸損壞的內容被永久保存
+	if isNew && rule.verifySum() {
+		if want, ok := nameSum(key); ok && sf.Sum() != want {
+			slog.Warn("checksum mismatch", "key", key, "expected", hex.EncodeToString(want[:]))
+			p.cache.FailPending(key, sf)
+			p.finishLock(lock, errChecksumMismatch)
+			return errChecksumMismatch
+		}
+	}
+
 	if isNew {
-		p.cache.CompletePending(key, totalWritten, contentType)
+		isNew = false // 已提交，panic 時不再清理
+		entry := p.cache.CompletePending(fetchCtx, key, sf, totalWritten, contentType, p.entryTTL(rule, resp.Header, time.Now()), fillTags(ctx, rule))
+		if entry != nil && trace != nil {
+			p.recordProvenance(key, entry, upstreamURL, resp, fromPeer, trace)
+		}
+		if p.replicator != nil && !isPrivateKey(key) {
+			p.replicator.Enqueue(key)
+		}
+		if p.archiver != nil && !isPrivateKey(key) {
+			p.archiver.Enqueue(key)
+		}
 	}
 
-	p.finishLock(lock, nil)
+	p.finishLock(lock, doneErr)
 	return nil
 }
 
+// keepFilling 依斷線策略判斷發起者斷線後是否在背景完成填充
+//
+// 兩個門檻都設定時須同時符合；未知長度的下載無法評估門檻，設定任一門檻時不繼續。
+// 關閉期間一律完成，由關閉期限決定是否中止。
+func (p *Proxy) keepFilling(written, expected int64) bool {
+	if p.draining.Load() {
+		return true
+	}
+	cfg := p.config
+	if !cfg.CompleteOnDisconnect {
+		return false
+	}
+	if cfg.DisconnectMaxRemaining <= 0 && cfg.DisconnectMinPercent <= 0 {
+		return true
+	}
+	if expected <= 0 {
+		return false
+	}
+	if cfg.DisconnectMaxRemaining > 0 && expected-written > cfg.DisconnectMaxRemaining {
+		return false
+	}
+	return cfg.DisconnectMinPercent <= 0 || float64(written)*100 >= cfg.DisconnectMinPercent*float64(expected)
+}
+
 // finishLock 完成鎖定
 func (p *Proxy) finishLock(lock *fetchLock, err error) {
 	lock.mu.Lock()
@@ -391,22 +1092,65 @@ func (p *Proxy) finishLock(lock *fetchLock, err error) {
 }
 
 // serveFromStreaming 從正在下載的串流讀取
+//
+// 同一下載的讀取者達到 MaxStreamWaiters 或所有下載的讀取者達到 MaxStreamReaders 時返回 503；下載超過 StreamWaitTimeout 沒有進度時中止，
+// 尚未送出內容時返回 504。
 func (p *Proxy) serveFromStreaming(w http.ResponseWriter, r *http.Request, sf *StreamingFile) error {
-	w.Header().Set("X-Cache", "STREAMING")
-
 	if r.Method == http.MethodHead {
+		w.Header().Set("X-Cache", "STREAMING")
+		if p.config.StrictHTTP {
+			p.setStreamingHeaders(w, sf)
+		}
 		return nil
 	}
 
-	reader := sf.NewReader()
+	reader, ok := sf.TryNewReader(r.Context(), p.config.MaxStreamWaiters)
+	var releaseReader func()
+	if ok {
+		var err error
+		if releaseReader, err = p.routines.Acquire(routineStreamReaders, r.URL.Path); err != nil {
+			reader.Close()
+			ok = false
+		}
+	}
+	if !ok {
+		p.stats.waitRejected.Add(1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil
+	}
+	defer releaseReader()
 	defer reader.Close()
+	reader.SetStallTimeout(p.config.StreamWaitTimeout)
+
+	w.Header().Set("X-Cache", "STREAMING")
+	if p.config.StrictHTTP {
+		p.setStreamingHeaders(w, sf)
+	}
+
+	prio := p.requestPriority(r)
+	defer p.scheduler.Begin(prio)()
+
+	// 上游宣告長度時以讀取者處理 Range，尚未下載的部分邊下載邊送出
+	if contentType, expectedSize := sf.Meta(); expectedSize >= 0 && r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, "", time.Time{}, reader)
+		if reader.Stalled() {
+			p.stats.waitTimeouts.Add(1)
+			return errStreamStalled
+		}
+		return nil
+	}
 
 	buf := p.getBuffer()
 	defer p.putBuffer(buf)
 
+	written := false
 	for {
+		p.scheduler.Yield(r.Context(), prio)
 		n, readErr := reader.Read(buf)
 		if n > 0 {
+			written = true
 			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
 				return fmt.Errorf("write response: %w", writeErr)
 			}
@@ -419,6 +1163,18 @@ func (p *Proxy) serveFromStreaming(w http.ResponseWriter, r *http.Request, sf *S
 			if readErr == io.EOF {
 				break
 			}
+			if errors.Is(readErr, errStreamStalled) {
+				p.stats.waitTimeouts.Add(1)
+			}
+			// 尚未送出內容時以錯誤狀態回應，客戶端不會把空的 200 當作完整內容
+			if !written && r.Context().Err() == nil {
+				w.Header().Del("X-Cache")
+				if errors.Is(readErr, errStreamStalled) || errors.Is(readErr, errDownloadStalled) {
+					http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+				} else {
+					http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				}
+			}
 			return fmt.Errorf("read streaming file: %w", readErr)
 		}
 	}
@@ -426,7 +1182,93 @@ func (p *Proxy) serveFromStreaming(w http.ResponseWriter, r *http.Request, sf *S
 	return nil
 }
 
-// Stats 返回代理統計資訊
-func (p *Proxy) Stats() map[string]any {
-	return p.cache.Stats()
+// Stats 返回代理統計資訊的快照
+func (p *Proxy) Stats() Stats {
+	stats := Stats{
+		Version:    StatsVersion,
+		Cache:      p.cache.Stats(),
+		Disk:       p.cache.DiskStats(),
+		HTTP:       p.stats.snapshot(),
+		Upstream:   p.upstreams.Stats(),
+		Components: make(map[string]any),
+	}
+	offline := p.offlineSnapshot()
+	stats.Upstream.Offline = offline.Offline
+	stats.Upstream.OfflineSince = offline.Since
+	stats.Upstream.OfflineMisses = offline.Misses
+
+	components := stats.Components
+	components["scheduler"] = p.scheduler.Stats()
+	components["goroutines"] = p.routines.Stats()
+	components["negative_cache"] = p.cache.negativeCache.Stats()
+	if p.hf != nil {
+		components["huggingface"] = p.hf.Stats()
+	}
+	if p.metalink != nil {
+		components["metalink"] = p.metalink.Stats()
+	}
+	if p.cache.shadows != nil {
+		components["shadow_policies"] = p.cache.shadows.Stats()
+	}
+	if p.fetchSlots != nil {
+		components["prefix_limits"] = p.fetchSlots.Stats()
+	}
+	if p.memCache != nil {
+		components["memory"] = p.memCache.Stats()
+	}
+	if p.readahead != nil {
+		components["range_readahead"] = p.readahead.Stats()
+	}
+	if p.replicator != nil {
+		components["replication"] = p.replicator.Stats()
+	}
+	if p.standby != nil {
+		components["standby"] = p.standby.Stats()
+	}
+	if rx := p.standbyRx.Stats(); rx != nil {
+		components["standby_index"] = rx
+	}
+	if p.uploader != nil {
+		components["uploads"] = p.uploader.Stats()
+	}
+	if p.archiver != nil {
+		components["archive"] = p.archiver.Stats()
+	}
+	if p.prefetcher != nil {
+		components["prefetch"] = p.prefetcher.Stats()
+	}
+	if p.refresher != nil {
+		components["hot_refresh"] = p.refresher.Stats()
+	}
+	if p.refetcher != nil {
+		components["purge_refetch"] = p.refetcher.Stats()
+	}
+	if p.guard != nil {
+		components["cache_guard"] = p.guard.Stats()
+	}
+	if p.peers != nil {
+		components["peers"] = p.peers.Stats()
+	}
+	if p.auth != nil {
+		components["auth"] = p.auth.Stats()
+	}
+	if p.limiter != nil {
+		components["rate_limit"] = p.limiter.Stats()
+	}
+	if p.scans != nil {
+		components["scan_guard"] = p.scans.Stats()
+	}
+	if p.bandwidth != nil {
+		components["bandwidth"] = p.bandwidth.Stats()
+	}
+	if p.outbound != nil {
+		components["outbound"] = p.outbound.Stats()
+	}
+	if p.discovery != nil {
+		components["upstream_srv"] = p.discovery.Stats()
+	}
+	if p.budget != nil {
+		components["upstream_rate_limit"] = p.budget.Stats()
+	}
+	return stats
 }
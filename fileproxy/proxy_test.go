@@ -0,0 +1,66 @@
+package fileproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BenchmarkCacheHit 量測磁碟命中路徑，stats/op 為每個請求呼叫 stat 的平均次數
+func BenchmarkCacheHit(b *testing.B) {
+	body := strings.Repeat("x", 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	for _, ttl := range []time.Duration{0, time.Second} {
+		b.Run(fmt.Sprintf("stat_cache_ttl=%s", ttl), func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.UpstreamURL = upstream.URL
+			cfg.CacheDir = b.TempDir()
+			cfg.StatCacheTTL = ttl
+			cfg.MemoryCacheSize = 0 // 記憶體熱層命中不經過 stat
+			if err := cfg.Validate(); err != nil {
+				b.Fatal(err)
+			}
+			proxy, err := NewProxy(cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer proxy.Close()
+
+			get := func() *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/file", nil))
+				return rec
+			}
+			get()
+			if rec := get(); rec.Header().Get("X-Cache") != "HIT" {
+				b.Fatalf("X-Cache = %q, want HIT", rec.Header().Get("X-Cache"))
+			}
+			entry, ok := proxy.cache.Peek("/file")
+			if !ok {
+				b.Fatal("/file is not cached")
+			}
+
+			// 每次 stat 都會以當下時間更新 checkedAt，沿用檢查結果時不變
+			var stats int
+			for b.Loop() {
+				checked := entry.checkedAt.Load()
+				rec := get()
+				if rec.Code != http.StatusOK || rec.Body.Len() != len(body) {
+					b.Fatalf("status %d, %d bytes", rec.Code, rec.Body.Len())
+				}
+				if entry.checkedAt.Load() != checked {
+					stats++
+				}
+			}
+			b.ReportMetric(float64(stats)/float64(b.N), "stats/op")
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const purgePrefix = "/admin/purge" // 清除快取條目的端點前綴
+
+// PurgeResult 清除請求的結果
+type PurgeResult struct {
+	Key    string `json:"key"`
+	Purged bool   `json:"purged"` // false 表示原本就沒有快取
+
+	Refetching bool `json:"refetching,omitempty"` // 舊內容暫時以 STALE 回應，直到背景重新下載完成
+}
+
+// handlePurge 移除單一路徑的快取與負快取，下一個請求會重新回源
+//
+// 路徑以與請求相同的方式正規化。帶 ?refetch=1 時保留舊檔案並排入背景重新下載，期間的請求不會回源。
+func (p *Proxy) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := p.purgeKey(strings.TrimPrefix(r.URL.Path, purgePrefix))
+	if !ok {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	refetch := wantsRefetch(r)
+	if refetch && p.refetcher == nil {
+		http.Error(w, "refetch is disabled", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.purge(key, refetch))
+}
+
+// purgeKey 以與請求相同的方式正規化要清除的路徑，根路徑與相對路徑返回 false
+func (p *Proxy) purgeKey(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, "/") || raw == "/" {
+		return "", false
+	}
+	path, query := p.config.splitKey(raw)
+	key := p.config.normalizePath(path)
+	if q := p.config.normalizeQuery(query); q != "" {
+		key += "?" + q
+	}
+	return key, true
+}
+
+// purge 移除 key 的快取與負快取，refetch 時保留舊檔案並排入背景重新下載
+func (p *Proxy) purge(key string, refetch bool) PurgeResult {
+	entry, cached := p.cache.Peek(key)
+	_, negative := p.cache.Negative(key)
+	result := PurgeResult{Key: key, Purged: cached || negative}
+	if cached && refetch {
+		result.Refetching = p.refetcher.Schedule([]*CacheEntry{entry}) > 0
+	} else {
+		p.cache.Remove(key)
+	}
+	slog.Info("cache purged", "key", key, "cached", cached, "refetching", result.Refetching)
+	return result
+}
@@ -0,0 +1,126 @@
+package fileproxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// queryAllParams CacheKeyQuery 中表示納入全部參數的值
+const queryAllParams = "*"
+
+// normalizeQuery 返回納入快取 key 的查詢字串，未啟用或沒有參數時為空字串
+//
+// 只保留 CacheKeyQuery 列出的參數（"*" 表示全部），移除 CacheKeyStrip 的追蹤參數，
+// 依參數名稱排序並重新編碼，同一組參數不論順序與跳脫方式都得到相同的 key。
+// 同名參數的多個值保持原順序。
+func (c *Config) normalizeQuery(raw string) string {
+	if len(c.CacheKeyQuery) == 0 || raw == "" {
+		return ""
+	}
+	values, _ := url.ParseQuery(raw) // 無法解析的片段直接捨棄
+	all := slices.Contains(c.CacheKeyQuery, queryAllParams)
+	for name := range values {
+		if !all && !slices.Contains(c.CacheKeyQuery, name) || c.strippedParam(name) {
+			delete(values, name)
+		}
+	}
+	return values.Encode()
+}
+
+// strippedParam 檢查參數是否屬於 CacheKeyStrip，結尾為 * 的項目以前綴比對
+func (c *Config) strippedParam(name string) bool {
+	for _, pattern := range c.CacheKeyStrip {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePath 依 Key* 設定正規化快取 key 的路徑部分
+//
+// 路徑在此之前已由 net/http 解碼百分比編碼，/a%20b 與 /a b 本來就是同一個 key；
+// 正規化只影響 key，上游收到的仍是客戶端請求的路徑。
+func (c *Config) normalizePath(path string) string {
+	if c.KeyMergeSlashes {
+		for strings.Contains(path, "//") {
+			path = strings.ReplaceAll(path, "//", "/")
+		}
+	}
+	if c.KeyTrimSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	if c.KeyFoldCase {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// keyQuery 返回附加在快取 key 路徑後的查詢部分，例如 "?version=2"
+func (c *Config) keyQuery(r *http.Request) string {
+	if q := c.normalizeQuery(r.URL.RawQuery); q != "" {
+		return "?" + q
+	}
+	return ""
+}
+
+// splitKey 將快取 key 拆成路徑與正規化後的查詢字串，未啟用查詢 key 時整個 key 都是路徑
+func (c *Config) splitKey(key string) (path, query string) {
+	if len(c.CacheKeyQuery) == 0 {
+		return key, ""
+	}
+	path, query, _ = strings.Cut(key, "?")
+	return path, query
+}
+
+// withQuery 在 URL 後附加查詢字串，URL 已有查詢字串時以 & 連接
+func withQuery(rawURL, query string) string {
+	switch {
+	case query == "":
+		return rawURL
+	case strings.Contains(rawURL, "?"):
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
+
+// upstreamURLFor 返回請求對應的上游 URL：規則的上游優先，其次為路由，並附加納入 key 的查詢參數
+//
+// 路徑先依 Rewrites 改寫，路由以改寫後的路徑比對。改寫前後不安全的路徑（見 pathViolation）
+// 都不會組成上游 URL，不論請求是否經過 hardenRequest。
+func (p *Proxy) upstreamURLFor(r *http.Request, rule *CacheRule) (string, bool) {
+	if origin, ok := originFrom(r.Context()); ok {
+		return origin.url, true
+	}
+	upstreamURL, ok := p.upstreamBaseFor(r, rule)
+	if !ok {
+		return "", false
+	}
+	return withQuery(upstreamURL, p.config.normalizeQuery(r.URL.RawQuery)), true
+}
+
+// upstreamBaseFor 返回請求對應的上游 URL，不含查詢字串；路徑不安全或沒有對應路由時 ok 為 false
+func (p *Proxy) upstreamBaseFor(r *http.Request, rule *CacheRule) (string, bool) {
+	path := p.config.rewritePath(r.URL.Path)
+	for _, candidate := range []string{r.URL.Path, path} {
+		if reason := pathViolation(candidate); reason != "" {
+			slog.Warn("refusing unsafe upstream path", "path", candidate, "reason", reason)
+			return "", false
+		}
+	}
+	upstreamURL, ok := p.config.upstreamFor(path)
+	if rule != nil && rule.Upstream != "" {
+		upstreamURL, ok = buildUpstreamURL(rule.Upstream, path), true
+	}
+	return upstreamURL, ok
+}
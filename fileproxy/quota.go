@@ -0,0 +1,336 @@
+package fileproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	quotasPath        = "/admin/quotas" // API Key 用量查詢與重設端點
+	quotaUsageFile    = "quota.json"    // 快取目錄中保存用量的檔案
+	quotaFlushPeriod  = time.Minute     // 用量寫回磁碟的間隔
+	quotaDayLayout    = "2006-01-02"    // 日用量的期間（UTC）
+	quotaMonthLayout  = "2006-01"       // 月用量的期間（UTC）
+	quotaRetryCeiling = 24 * time.Hour  // Retry-After 的上限，月額度用盡時不要求客戶端等待整個月
+)
+
+// parseByteSize 解析位元組數，接受 B、KB、MB、GB、TB 後綴（1024 進位），無後綴時為位元組
+func parseByteSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			upper, multiplier = number, unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// keyUsage 單一 API Key 在目前日、月期間的下載位元組數
+type keyUsage struct {
+	bucket *byteBucket // 此 Key 所有下載共用的令牌桶，未設定 rate 時為 nil
+
+	mu         sync.Mutex
+	day        string
+	dayBytes   int64
+	month      string
+	monthBytes int64
+}
+
+// roll 期間改變時歸零，呼叫時須持有 mu
+func (u *keyUsage) roll(now time.Time) {
+	now = now.UTC()
+	if day := now.Format(quotaDayLayout); u.day != day {
+		u.day, u.dayBytes = day, 0
+	}
+	if month := now.Format(quotaMonthLayout); u.month != month {
+		u.month, u.monthBytes = month, 0
+	}
+}
+
+// add 累加下載的位元組數
+func (u *keyUsage) add(n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now())
+	u.dayBytes += n
+	u.monthBytes += n
+}
+
+// usage 返回目前期間的用量
+func (u *keyUsage) usage() (day, month int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now())
+	return u.dayBytes, u.monthBytes
+}
+
+// quotaRetry 額度用盡時返回距離重設的時間，未超額時返回 0
+func (k *APIKey) quotaRetry(u *keyUsage, now time.Time) time.Duration {
+	day, month := u.usage()
+	now = now.UTC()
+	var retry time.Duration
+	if k.MonthlyQuota > 0 && month >= k.MonthlyQuota {
+		retry = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now)
+	}
+	if k.DailyQuota > 0 && day >= k.DailyQuota {
+		retry = max(retry, time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now))
+	}
+	return retry
+}
+
+// meter 對 API Key 有額度或限速時包裝回應，計算寫出的位元組並套用 Key 的令牌桶
+func (kr *keyring) meter(ctx context.Context, w http.ResponseWriter, apiKey *APIKey) http.ResponseWriter {
+	if apiKey == nil || !apiKey.metered() {
+		return w
+	}
+	return &meteredWriter{ResponseWriter: w, ctx: ctx, kr: kr, usage: kr.usage[apiKey.Name]}
+}
+
+// metered 是否需要計量
+func (k *APIKey) metered() bool {
+	return k.DailyQuota > 0 || k.MonthlyQuota > 0 || k.Rate > 0
+}
+
+// meteredWriter 計量並限速的回應寫入者
+type meteredWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	kr    *keyring
+	usage *keyUsage
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	if wait := m.usage.bucket.reserve(len(p)); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			return 0, m.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	n, err := m.ResponseWriter.Write(p)
+	m.usage.add(int64(n))
+	m.kr.metered.Add(int64(n))
+	return n, err
+}
+
+// Flush 轉交給底層回應，保持串流即時送出
+func (m *meteredWriter) Flush() {
+	if flusher, ok := m.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 取得底層回應
+func (m *meteredWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+// storedUsage 用量檔案中單一 Key 的記錄
+type storedUsage struct {
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// loadUsage 讀取保存的用量，檔案不存在時為空
+func (kr *keyring) loadUsage() error {
+	data, err := os.ReadFile(kr.usagePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored map[string]storedUsage
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	for name, s := range stored {
+		if u, ok := kr.usage[name]; ok {
+			u.day, u.dayBytes, u.month, u.monthBytes = s.Day, s.DayBytes, s.Month, s.MonthBytes
+		}
+	}
+	return nil
+}
+
+// saveUsage 以暫存檔加改名寫回用量
+func (kr *keyring) saveUsage() error {
+	stored := make(map[string]storedUsage, len(kr.usage))
+	for name, u := range kr.usage {
+		u.mu.Lock()
+		stored[name] = storedUsage{Day: u.day, DayBytes: u.dayBytes, Month: u.month, MonthBytes: u.monthBytes}
+		u.mu.Unlock()
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	tmp := kr.usagePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.usagePath)
+}
+
+// flushLoop 定期寫回用量，重啟後額度不會歸零
+func (kr *keyring) flushLoop() {
+	defer kr.wg.Done()
+	ticker := time.NewTicker(quotaFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kr.closeCh:
+			return
+		case <-ticker.C:
+			if err := kr.saveUsage(); err != nil {
+				slog.Warn("save quota usage failed", "error", err)
+			}
+		}
+	}
+}
+
+// Close 停止定期寫回並保存最後的用量
+func (kr *keyring) Close() {
+	if kr.closeCh == nil {
+		return
+	}
+	close(kr.closeCh)
+	kr.wg.Wait()
+	if err := kr.saveUsage(); err != nil {
+		slog.Warn("save quota usage failed", "error", err)
+	}
+}
+
+// QuotaUsage 單一 API Key 的額度與用量
+type QuotaUsage struct {
+	Name         string `json:"name"`
+	Rate         int64  `json:"rate,omitempty"` // 每秒位元組數，0 表示不限
+	DailyQuota   int64  `json:"daily_quota,omitempty"`
+	DailyBytes   int64  `json:"daily_bytes"`
+	MonthlyQuota int64  `json:"monthly_quota,omitempty"`
+	MonthlyBytes int64  `json:"monthly_bytes"`
+	OverQuota    bool   `json:"over_quota"`
+}
+
+// quotaUsage 返回 Key 的額度與用量
+func (kr *keyring) quotaUsage(apiKey *APIKey) QuotaUsage {
+	u := kr.usage[apiKey.Name]
+	day, month := u.usage()
+	return QuotaUsage{
+		Name:         apiKey.Name,
+		Rate:         apiKey.Rate,
+		DailyQuota:   apiKey.DailyQuota,
+		DailyBytes:   day,
+		MonthlyQuota: apiKey.MonthlyQuota,
+		MonthlyBytes: month,
+		OverQuota:    apiKey.quotaRetry(u, time.Now()) > 0,
+	}
+}
+
+// handleQuotas GET /admin/quotas 列出計量的 Key；/admin/quotas/{name} 以 GET 查詢、DELETE 重設目前期間的用量
+func (p *Proxy) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	kr := p.auth
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, quotasPath), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list := []QuotaUsage{}
+		if kr != nil {
+			for _, apiKey := range kr.meteredKeys {
+				list = append(list, kr.quotaUsage(apiKey))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var apiKey *APIKey
+	if kr != nil {
+		if i := slices.IndexFunc(kr.meteredKeys, func(k *APIKey) bool { return k.Name == name }); i >= 0 {
+			apiKey = kr.meteredKeys[i]
+		}
+	}
+	if apiKey == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		u := kr.usage[name]
+		u.mu.Lock()
+		u.dayBytes, u.monthBytes = 0, 0
+		u.mu.Unlock()
+		slog.Info("quota usage reset", "key", name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kr.quotaUsage(apiKey))
+}
+
+// newQuotaState 為有額度或限速的 Key 建立用量記錄並讀取保存的用量，沒有需計量的 Key 時不做任何事
+//
+// 用量依名稱記錄，輪替密鑰時同名的新舊 Key 共用額度與令牌桶（以第一個的設定為準）。
+func (kr *keyring) newQuotaState(cfg *Config) {
+	kr.usage = make(map[string]*keyUsage)
+	for i := range cfg.APIKeys {
+		apiKey := &cfg.APIKeys[i]
+		if !apiKey.metered() || kr.usage[apiKey.Name] != nil {
+			continue
+		}
+		kr.meteredKeys = append(kr.meteredKeys, apiKey)
+		kr.usage[apiKey.Name] = &keyUsage{bucket: newByteBucket(apiKey.Rate)}
+	}
+	if len(kr.meteredKeys) == 0 {
+		return
+	}
+	kr.usagePath = filepath.Join(cfg.CacheDir, quotaUsageFile)
+	if err := kr.loadUsage(); err != nil {
+		slog.Warn("load quota usage failed, starting from zero", "error", err)
+	}
+	kr.closeCh = make(chan struct{})
+	kr.wg.Add(1)
+	go kr.flushLoop()
+}
+
+// rejectOverQuota 檢查 Key 是否已用盡額度，用盡時返回 Retry-After 的時間
+func (kr *keyring) rejectOverQuota(apiKey *APIKey) (time.Duration, bool) {
+	if apiKey == nil || (apiKey.DailyQuota <= 0 && apiKey.MonthlyQuota <= 0) {
+		return 0, false
+	}
+	retry := apiKey.quotaRetry(kr.usage[apiKey.Name], time.Now())
+	if retry <= 0 {
+		return 0, false
+	}
+	kr.overQuota.Add(1)
+	return min(retry, quotaRetryCeiling), true
+}
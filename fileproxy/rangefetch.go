@@ -0,0 +1,249 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parallelChunkSize 並行分段下載每段的大小
+const parallelChunkSize = 4 << 20 // 4MB
+
+// errRangeRefused 表示上游對 Range 請求返回了完整內容或不相符的範圍
+var errRangeRefused = errors.New("upstream refused range request")
+
+// upstreamBody 依上游能力包裝回源回應的內容
+//
+// 上游支援 Range 且回應帶有強驗證器時，連線中斷後以 If-Range 從中斷處續傳；
+// 同時支援 HTTP/2 且檔案夠大時，第一段沿用原回應，其餘各段以 Range 請求並行下載、
+// 依序交給呼叫者，多個請求在同一連線上多工，不增加上游連線數。兄弟節點的回應不包裝。
+func (p *Proxy) upstreamBody(ctx context.Context, req *http.Request, resp *http.Response) io.ReadCloser {
+	caps := p.upstreams.Caps(req.URL.String())
+	validator := rangeValidator(resp.Header)
+	if !caps.Ranges || validator == "" || resp.ContentLength <= 0 {
+		return resp.Body
+	}
+	fetcher := &rangeFetcher{
+		proxy:     p,
+		req:       req,
+		validator: validator,
+		size:      resp.ContentLength,
+		attempts:  p.config.FetchResumeAttempts,
+	}
+	if caps.HTTP2 && p.config.ParallelFetchStreams > 1 && resp.ContentLength >= p.config.ParallelFetchMinSize &&
+		resp.ContentLength > parallelChunkSize {
+		p.upstreams.parallel.Add(1)
+		return newParallelReader(ctx, fetcher, resp.Body, p.config.ParallelFetchStreams)
+	}
+	if fetcher.attempts > 0 {
+		return &resumingReader{ctx: ctx, fetcher: fetcher, body: resp.Body, end: resp.ContentLength}
+	}
+	return resp.Body
+}
+
+// rangeValidator 返回 If-Range 可用的驗證器：強 ETag 優先，其次為 Last-Modified
+func rangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// rangeFetcher 以原回源請求的請求頭向上游發出 Range 請求
+type rangeFetcher struct {
+	proxy     *Proxy
+	req       *http.Request
+	validator string
+	size      int64
+	attempts  int // 每段中斷後的續傳次數上限
+}
+
+// open 請求 [start, end) 範圍，上游未以相符的 206 回應時返回 errRangeRefused
+func (f *rangeFetcher) open(ctx context.Context, start, end int64) (io.ReadCloser, error) {
+	req := f.req.Clone(ctx)
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	req.Header.Set("If-Range", f.validator)
+	resp, err := f.proxy.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream range request: %w", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || !contentRangeMatches(resp.Header.Get("Content-Range"), start, end, f.size) {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			f.proxy.upstreams.rangeRefused(f.req.URL.String())
+		}
+		return nil, fmt.Errorf("%w: status %d", errRangeRefused, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// contentRangeMatches 檢查 Content-Range 是否為 bytes start-(end-1)/size
+func contentRangeMatches(value string, start, end, size int64) bool {
+	return value == "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10)+"/"+strconv.FormatInt(size, 10)
+}
+
+// resumingReader 讀取 [offset, end) 的內容，連線中斷時從中斷處以 Range 續傳
+type resumingReader struct {
+	ctx      context.Context
+	fetcher  *rangeFetcher
+	body     io.ReadCloser
+	offset   int64
+	end      int64
+	attempts int
+}
+
+func (rr *resumingReader) Read(b []byte) (int, error) {
+	for {
+		n, err := rr.body.Read(b)
+		rr.offset += int64(n)
+		if err == nil || err == io.EOF && rr.offset >= rr.end {
+			return n, err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if rr.offset >= rr.end || rr.ctx.Err() != nil || rr.attempts >= rr.fetcher.attempts {
+			return n, err
+		}
+
+		rr.attempts++
+		rr.body.Close()
+		body, rerr := rr.fetcher.open(rr.ctx, rr.offset, rr.end)
+		if rerr != nil {
+			slog.Warn("resume upstream download failed", "url", rr.fetcher.req.URL.Redacted(), "offset", rr.offset, "error", rerr)
+			rr.body = io.NopCloser(strings.NewReader(""))
+			return n, err
+		}
+		rr.body = body
+		rr.fetcher.proxy.upstreams.resumed.Add(1)
+		slog.Debug("resumed upstream download", "url", rr.fetcher.req.URL.Redacted(), "offset", rr.offset, "cause", err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (rr *resumingReader) Close() error { return rr.body.Close() }
+
+// chunkResult 單一分段的下載結果
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// parallelReader 第一段讀取原回應，之後的分段最多同時下載 streams 段並依序輸出
+//
+// 每段完整下載到記憶體後才輸出，因此單一下載最多佔用 streams 段的緩衝。
+type parallelReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fetcher *rangeFetcher
+	streams int
+
+	head     io.ReadCloser // 原回應，只讀取第一段
+	headLeft int64
+
+	next    int64              // 下一個尚未派發的分段起點
+	pending []chan chunkResult // 已派發的分段，依順序
+	cur     []byte
+}
+
+func newParallelReader(ctx context.Context, fetcher *rangeFetcher, body io.ReadCloser, streams int) *parallelReader {
+	ctx, cancel := context.WithCancel(ctx)
+	pr := &parallelReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		fetcher:  fetcher,
+		streams:  streams,
+		head:     &resumingReader{ctx: ctx, fetcher: fetcher, body: body, end: parallelChunkSize},
+		headLeft: parallelChunkSize,
+		next:     parallelChunkSize,
+	}
+	pr.dispatch()
+	return pr
+}
+
+// dispatch 補足同時下載的分段數
+func (pr *parallelReader) dispatch() {
+	for len(pr.pending) < pr.streams && pr.next < pr.fetcher.size {
+		start, end := pr.next, min(pr.next+parallelChunkSize, pr.fetcher.size)
+		pr.next = end
+		ch := make(chan chunkResult, 1)
+		pr.pending = append(pr.pending, ch)
+		release := pr.fetcher.proxy.routines.Track(routineFetchers, fmt.Sprintf("%s range %d-%d", pr.fetcher.req.URL.Path, start, end-1))
+		go func() {
+			defer release()
+			data, err := pr.fetchChunk(start, end)
+			ch <- chunkResult{data: data, err: err}
+		}()
+	}
+}
+
+// fetchChunk 下載單一分段，中斷時續傳
+func (pr *parallelReader) fetchChunk(start, end int64) ([]byte, error) {
+	body, err := pr.fetcher.open(pr.ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	rr := &resumingReader{ctx: pr.ctx, fetcher: pr.fetcher, body: body, offset: start, end: end}
+	defer rr.Close()
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(rr, data); err != nil {
+		return nil, fmt.Errorf("read range %d-%d: %w", start, end-1, err)
+	}
+	return data, nil
+}
+
+func (pr *parallelReader) Read(b []byte) (int, error) {
+	if pr.head != nil {
+		n, err := pr.head.Read(b[:min(int64(len(b)), pr.headLeft)])
+		pr.headLeft -= int64(n)
+		if pr.headLeft == 0 {
+			// 第一段讀完後關閉原回應，HTTP/2 下只重設該串流
+			pr.head.Close()
+			pr.head = nil
+			return n, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+
+	for len(pr.cur) == 0 {
+		if len(pr.pending) == 0 {
+			return 0, io.EOF
+		}
+		var res chunkResult
+		select {
+		case res = <-pr.pending[0]:
+		case <-pr.ctx.Done():
+			return 0, pr.ctx.Err()
+		}
+		pr.pending = pr.pending[1:]
+		if res.err != nil {
+			return 0, res.err
+		}
+		pr.cur = res.data
+		pr.dispatch()
+	}
+	n := copy(b, pr.cur)
+	pr.cur = pr.cur[n:]
+	return n, nil
+}
+
+// Close 取消進行中的分段下載並關閉原回應
+func (pr *parallelReader) Close() error {
+	pr.cancel()
+	if pr.head != nil {
+		return pr.head.Close()
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package fileproxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimitShards  = 64          // 分片數，降低鎖競爭
+	rateLimitIdleTTL = time.Minute // 閒置超過此時間的客戶端狀態會被清除
+)
+
+// RateLimit 單一客戶端 IP 的限制，各欄位為 0 表示不限制
+type RateLimit struct {
+	Rate          float64 // 每秒請求數
+	Burst         int     // 令牌桶容量，0 表示與 Rate 相同（至少 1）
+	MaxConcurrent int     // 同時處理中的請求數
+}
+
+// enabled 檢查是否設定任何限制
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0 || l.MaxConcurrent > 0
+}
+
+// burst 返回令牌桶容量
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(math.Ceil(l.Rate), 1)
+}
+
+// RateLimitRule 依路徑前綴覆寫全域限制
+type RateLimitRule struct {
+	Prefix string // 路徑前綴，最長者優先
+	RateLimit
+}
+
+// ParseRateLimitRule 解析命令列格式的規則：PREFIX:OPTION[,OPTION...]
+//
+// 可用選項為 rate=N、burst=N 與 concurrent=N，例如 /npm:rate=20,burst=40,concurrent=4。
+func ParseRateLimitRule(s string) (RateLimitRule, error) {
+	prefix, opts, found := strings.Cut(s, ":")
+	if !found || opts == "" || !strings.HasPrefix(prefix, "/") {
+		return RateLimitRule{}, fmt.Errorf("rate limit rule %q: expected PREFIX:OPTIONS", s)
+	}
+
+	rule := RateLimitRule{Prefix: strings.TrimSuffix(prefix, "/")}
+	for _, opt := range strings.Split(opts, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		var err error
+		switch name {
+		case "rate":
+			rule.Rate, err = strconv.ParseFloat(value, 64)
+		case "burst":
+			rule.Burst, err = strconv.Atoi(value)
+		case "concurrent":
+			rule.MaxConcurrent, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown option %q", name)
+		}
+		if err != nil {
+			return RateLimitRule{}, fmt.Errorf("rate limit rule %q: %w", s, err)
+		}
+	}
+	if rule.Rate < 0 || rule.Burst < 0 || rule.MaxConcurrent < 0 {
+		return RateLimitRule{}, fmt.Errorf("rate limit rule %q: values must not be negative", s)
+	}
+	return rule, nil
+}
+
+// clientLimit 單一客戶端在某個範圍內的狀態
+type clientLimit struct {
+	tokens   float64
+	last     time.Time
+	inflight int
+}
+
+// rateShard 一個分片的客戶端狀態，鍵為範圍與 IP
+type rateShard struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimit
+}
+
+// rateLimiter 以客戶端 IP 為鍵的令牌桶與並發限制
+type rateLimiter struct {
+	global RateLimit
+	rules  []RateLimitRule
+	shards [rateLimitShards]rateShard
+
+	limitedRate       atomic.Int64
+	limitedConcurrent atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// rateLimited 是否設定了全域或任一路徑的限制
+func (c *Config) rateLimited() bool {
+	enabled := c.RateLimit.enabled()
+	for _, rule := range c.RateLimitRules {
+		enabled = enabled || rule.enabled()
+	}
+	return enabled
+}
+
+// newRateLimiter 建立限流器，未設定任何限制時返回 nil
+func newRateLimiter(cfg *Config) *rateLimiter {
+	if !cfg.rateLimited() {
+		return nil
+	}
+
+	rl := &rateLimiter{global: cfg.RateLimit, rules: cfg.RateLimitRules, closeCh: make(chan struct{})}
+	for i := range rl.shards {
+		rl.shards[i].clients = make(map[string]*clientLimit)
+	}
+	rl.wg.Add(1)
+	go rl.cleanupLoop()
+	return rl
+}
+
+// Close 停止清理 goroutine
+func (rl *rateLimiter) Close() {
+	if rl == nil {
+		return
+	}
+	close(rl.closeCh)
+	rl.wg.Wait()
+}
+
+// limitFor 返回 key 適用的限制與範圍名稱
+func (rl *rateLimiter) limitFor(key string) (RateLimit, string) {
+	var best *RateLimitRule
+	for i := range rl.rules {
+		rule := &rl.rules[i]
+		if (key == rule.Prefix || strings.HasPrefix(key, rule.Prefix+"/")) && (best == nil || len(rule.Prefix) > len(best.Prefix)) {
+			best = rule
+		}
+	}
+	if best != nil {
+		return best.RateLimit, best.Prefix
+	}
+	return rl.global, ""
+}
+
+// shard 依鍵選擇分片
+func (rl *rateLimiter) shard(id string) *rateShard {
+	return &rl.shards[shardIndex(id)]
+}
+
+// shardIndex 以 FNV-1a 將鍵分配到分片
+func shardIndex(id string) uint32 {
+	var h uint32 = 2166136261
+	for i := 0; i < len(id); i++ {
+		h = (h ^ uint32(id[i])) * 16777619
+	}
+	return h % rateLimitShards
+}
+
+// remoteIP 返回請求的對端 IP
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Acquire 檢查請求是否允許，允許時返回釋放函式；拒絕時返回建議的重試秒數
+func (rl *rateLimiter) Acquire(r *http.Request, key string) (release func(), retryAfter int, ok bool) {
+	if rl == nil {
+		return func() {}, 0, true
+	}
+	limit, scope := rl.limitFor(key)
+	if !limit.enabled() {
+		return func() {}, 0, true
+	}
+
+	id := scope + " " + remoteIP(r)
+	s := rl.shard(id)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.clients[id]
+	if !exists {
+		c = &clientLimit{tokens: limit.burst(), last: now}
+		s.clients[id] = c
+	}
+
+	if limit.MaxConcurrent > 0 && c.inflight >= limit.MaxConcurrent {
+		rl.limitedConcurrent.Add(1)
+		return nil, 1, false
+	}
+	if limit.Rate > 0 {
+		c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*limit.Rate, limit.burst())
+		c.last = now
+		if c.tokens < 1 {
+			rl.limitedRate.Add(1)
+			return nil, int(math.Ceil((1 - c.tokens) / limit.Rate)), false
+		}
+		c.tokens--
+	}
+
+	c.inflight++
+	return func() {
+		s.mu.Lock()
+		c.inflight--
+		s.mu.Unlock()
+	}, 0, true
+}
+
+// cleanupLoop 定期清除閒置的客戶端狀態
+func (rl *rateLimiter) cleanupLoop() {
+	defer rl.wg.Done()
+	ticker := time.NewTicker(rateLimitIdleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.closeCh:
+			return
+		case now := <-ticker.C:
+			for i := range rl.shards {
+				s := &rl.shards[i]
+				s.mu.Lock()
+				for id, c := range s.clients {
+					if c.inflight == 0 && now.Sub(c.last) > rateLimitIdleTTL {
+						delete(s.clients, id)
+					}
+				}
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Stats 返回限流統計資訊
+func (rl *rateLimiter) Stats() map[string]any {
+	clients := 0
+	for i := range rl.shards {
+		s := &rl.shards[i]
+		s.mu.Lock()
+		clients += len(s.clients)
+		s.mu.Unlock()
+	}
+	return map[string]any{
+		"clients":            clients,
+		"limited_rate":       rl.limitedRate.Load(),
+		"limited_concurrent": rl.limitedConcurrent.Load(),
+	}
+}
@@ -0,0 +1,219 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// readaheadKey 連線預讀狀態的 context key
+type readaheadKey struct{}
+
+// rangeReadahead 連續範圍請求的預讀
+//
+// 影音播放器常以同一條連線對同一檔案發出大量小的連續範圍請求。連線上出現接續前一個範圍的請求後，
+// 保留開啟的快取檔案與預讀緩衝，之後的請求直接從緩衝返回，不再重複開啟、定位與讀取。
+// 檔案在連線關閉、換成其他條目或非連續請求時釋放。
+type rangeReadahead struct {
+	cache *Cache
+	size  int // 每次預讀的大小
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connReadahead // 保留檔案的連線
+
+	sequential atomic.Int64 // 以預讀服務的請求數
+	opens      atomic.Int64 // 為預讀開啟的檔案數
+	refills    atomic.Int64 // 從磁碟填入緩衝的次數
+	bytes      atomic.Int64 // 從緩衝返回的位元組數
+}
+
+// connReadahead 單一連線的預讀狀態
+type connReadahead struct {
+	ra   *rangeReadahead
+	conn net.Conn
+
+	mu      sync.Mutex
+	entry   *CacheEntry // 上一個範圍請求的條目
+	next    int64       // 上一個範圍的結束位置 +1
+	file    *os.File
+	release func()
+	buf     []byte
+	bufOff  int64 // buf[0] 在檔案中的位置
+}
+
+// newRangeReadahead 建立範圍請求預讀，RangeReadahead 為 0 時返回 nil
+func newRangeReadahead(cfg *Config, cache *Cache) *rangeReadahead {
+	if cfg.RangeReadahead <= 0 {
+		return nil
+	}
+	return &rangeReadahead{cache: cache, size: cfg.RangeReadahead, conns: make(map[net.Conn]*connReadahead)}
+}
+
+// ConnContext 為每條連線附加預讀狀態，設定於 http.Server.ConnContext
+func (ra *rangeReadahead) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, readaheadKey{}, &connReadahead{ra: ra, conn: c})
+}
+
+// ConnState 連線關閉時釋放保留的檔案，設定於 http.Server.ConnState
+func (ra *rangeReadahead) ConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	ra.mu.Lock()
+	cr, ok := ra.conns[c]
+	ra.mu.Unlock()
+	if ok {
+		cr.mu.Lock()
+		cr.reset(nil, 0)
+		cr.mu.Unlock()
+	}
+}
+
+// open 單一範圍請求接續同一連線上一個請求時，返回從預讀緩衝讀取的內容；done 須在回應結束後呼叫
+//
+// 其他請求只記錄範圍的結束位置並返回 false，照常開啟檔案。HTTP/2 同一連線上並行的請求不使用預讀。
+func (ra *rangeReadahead) open(r *http.Request, entry *CacheEntry) (content io.ReadSeeker, done func(), ok bool) {
+	if ra == nil {
+		return nil, nil, false
+	}
+	cr, _ := r.Context().Value(readaheadKey{}).(*connReadahead)
+	rangeHeader := r.Header.Get("Range")
+	if cr == nil || rangeHeader == "" || r.Method != http.MethodGet {
+		return nil, nil, false
+	}
+	start, end, valid := parseRange(rangeHeader, entry.Size)
+	if !valid || !cr.mu.TryLock() {
+		return nil, nil, false
+	}
+
+	if cr.entry != entry || start != cr.next {
+		cr.reset(entry, end+1)
+		cr.mu.Unlock()
+		return nil, nil, false
+	}
+	cr.next = end + 1
+	if cr.file == nil {
+		file, release, err := ra.cache.Open(r.Context(), entry)
+		if err != nil {
+			cr.reset(nil, 0)
+			cr.mu.Unlock()
+			return nil, nil, false
+		}
+		cr.file, cr.release = file, release
+		ra.opens.Add(1)
+		ra.mu.Lock()
+		ra.conns[cr.conn] = cr
+		ra.mu.Unlock()
+	}
+	ra.sequential.Add(1)
+	return &readaheadReader{cr: cr, size: entry.Size}, cr.mu.Unlock, true
+}
+
+// reset 釋放保留的檔案並改為記錄 entry 的範圍結束位置，呼叫時須持有 cr.mu
+func (cr *connReadahead) reset(entry *CacheEntry, next int64) {
+	cr.entry, cr.next = entry, next
+	if cr.file == nil {
+		return
+	}
+	cr.release()
+	cr.file, cr.release = nil, nil
+	cr.buf = cr.buf[:0]
+	cr.ra.mu.Lock()
+	delete(cr.ra.conns, cr.conn)
+	cr.ra.mu.Unlock()
+}
+
+// readaheadReader 經由連線的預讀緩衝讀取快取檔案，呼叫期間持有 cr.mu
+type readaheadReader struct {
+	cr   *connReadahead
+	size int64
+	pos  int64
+}
+
+func (rr *readaheadReader) Read(p []byte) (int, error) {
+	if rr.pos >= rr.size {
+		return 0, io.EOF
+	}
+	cr := rr.cr
+	if rr.pos < cr.bufOff || rr.pos >= cr.bufOff+int64(len(cr.buf)) {
+		if cap(cr.buf) < cr.ra.size {
+			cr.buf = make([]byte, cr.ra.size)
+		}
+		n, err := cr.file.ReadAt(cr.buf[:cap(cr.buf)], rr.pos)
+		cr.buf, cr.bufOff = cr.buf[:n], rr.pos
+		cr.ra.refills.Add(1)
+		if n == 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf[rr.pos-cr.bufOff:])
+	rr.pos += int64(n)
+	cr.ra.bytes.Add(int64(n))
+	return n, nil
+}
+
+func (rr *readaheadReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rr.pos
+	case io.SeekEnd:
+		offset += rr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	rr.pos = offset
+	return offset, nil
+}
+
+// Close 釋放所有連線保留的檔案
+func (ra *rangeReadahead) Close() {
+	if ra == nil {
+		return
+	}
+	ra.mu.Lock()
+	conns := make([]*connReadahead, 0, len(ra.conns))
+	for _, cr := range ra.conns {
+		conns = append(conns, cr)
+	}
+	ra.mu.Unlock()
+	for _, cr := range conns {
+		cr.mu.Lock()
+		cr.reset(nil, 0)
+		cr.mu.Unlock()
+	}
+}
+
+// ReadaheadStats 範圍請求預讀統計
+type ReadaheadStats struct {
+	Connections int   `json:"connections"` // 目前保留檔案的連線數
+	Sequential  int64 `json:"sequential"`  // 以預讀服務的連續範圍請求數
+	Opens       int64 `json:"opens"`       // 為預讀開啟的檔案數，與 sequential 的差為省下的開啟次數
+	Refills     int64 `json:"refills"`     // 從磁碟填入緩衝的次數
+	Bytes       int64 `json:"bytes"`       // 從緩衝返回的位元組數
+}
+
+// Stats 返回預讀統計
+func (ra *rangeReadahead) Stats() ReadaheadStats {
+	ra.mu.Lock()
+	conns := len(ra.conns)
+	ra.mu.Unlock()
+	return ReadaheadStats{
+		Connections: conns,
+		Sequential:  ra.sequential.Load(),
+		Opens:       ra.opens.Load(),
+		Refills:     ra.refills.Load(),
+		Bytes:       ra.bytes.Load(),
+	}
+}
@@ -0,0 +1,45 @@
+package fileproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// redirectPolicy 返回上游 http.Client 的重新導向策略
+//
+// PassRedirects 時不跟隨，3xx 由 passRedirect 原樣返回客戶端；否則最多跟隨 MaxRedirects 次，
+// 超過時請求失敗並以 502 回應。跟隨後的最終內容仍以原始請求的 key 快取。
+func redirectPolicy(cfg *Config) func(*http.Request, []*http.Request) error {
+	if cfg.PassRedirects {
+		return func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+	limit := cfg.MaxRedirects
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > limit {
+			return fmt.Errorf("stopped after %d redirects", limit)
+		}
+		return nil
+	}
+}
+
+// isPassedRedirect 回應是否為需原樣返回客戶端的 3xx，304 屬於條件請求不在此列
+func (p *Proxy) isPassedRedirect(status int) bool {
+	return p.config.PassRedirects && status >= 300 && status < 400 && status != http.StatusNotModified
+}
+
+// passRedirect 將上游的 3xx 回應頭、狀態與內容原樣返回客戶端，不寫入快取
+func (p *Proxy) passRedirect(w http.ResponseWriter, r *http.Request, resp *http.Response) error {
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", "BYPASS")
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("copy redirect body: %w", err)
+	}
+	return nil
+}
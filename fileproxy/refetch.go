@@ -0,0 +1,273 @@
+package fileproxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// purgeRefetcher 以 refetch 選項清除的條目在背景錯開重新下載，期間以舊內容回應
+//
+// 大量清除後直接移除條目，隨之而來的未命中會同時湧向上游。改為暫時保留舊條目，
+// 客戶端在 window 內拿到標示為 STALE 的舊內容，刷新器以每秒 rate 個的速度依清除順序重新下載。
+// 下載失敗或超過 window 仍未輪到的條目直接移除，舊內容不會無限期留存。
+type purgeRefetcher struct {
+	proxy  *Proxy
+	rate   int
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]purgedEntry // 依 key，重新下載完成前以舊條目回應
+	queue   []string
+	wake    chan struct{}
+
+	scheduled atomic.Int64
+	refetched atomic.Int64
+	failed    atomic.Int64
+	expired   atomic.Int64
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// purgedEntry 等待重新下載的舊條目
+type purgedEntry struct {
+	entry    *CacheEntry
+	deadline time.Time
+}
+
+// newPurgeRefetcher 建立刷新器，rate 為 0 時返回 nil
+func newPurgeRefetcher(p *Proxy, rate int, window time.Duration) *purgeRefetcher {
+	if rate <= 0 || window <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &purgeRefetcher{
+		proxy:   p,
+		rate:    rate,
+		window:  window,
+		pending: make(map[string]purgedEntry),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		closeCh: make(chan struct{}),
+	}
+	f.wg.Add(1)
+	go f.loop()
+	return f
+}
+
+// Close 停止排程並等待進行中的下載結束，尚未輪到的條目保留在快取中
+func (f *purgeRefetcher) Close() {
+	f.cancel()
+	close(f.closeCh)
+	f.wg.Wait()
+}
+
+// wantsRefetch 檢查清除請求是否帶有 refetch 選項
+func wantsRefetch(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("refetch"))
+	return v
+}
+
+// Schedule 將清除的條目排入重新下載，返回排入的數量
+//
+// 沒有 key 的舊版索引條目、屬於特定使用者或不可快取的路徑無法代為請求，直接移除。
+func (f *purgeRefetcher) Schedule(entries []*CacheEntry) int {
+	cache := f.proxy.cache
+	hashes := make([]keyHash, len(entries))
+	for i, e := range entries {
+		hashes[i] = e.hash
+	}
+	keys := make(map[keyHash]string, len(hashes))
+	if err := cache.store.Keys(hashes, func(hash keyHash, key string) { keys[hash] = key }); err != nil {
+		slog.Warn("purge refetch key lookup failed", "error", err)
+	}
+
+	deadline := time.Now().Add(f.window)
+	scheduled := 0
+	f.mu.Lock()
+	for _, e := range entries {
+		key, ok := keys[e.hash]
+		if !ok || isPrivateKey(key) || !f.refetchable(key) {
+			cache.fileCache.Remove(e.hash)
+			continue
+		}
+		if _, queued := f.pending[key]; !queued {
+			f.queue = append(f.queue, key)
+		}
+		f.pending[key] = purgedEntry{entry: e, deadline: deadline}
+		scheduled++
+	}
+	f.mu.Unlock()
+
+	f.scheduled.Add(int64(scheduled))
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return scheduled
+}
+
+// refetchable 檢查路徑目前的規則是否允許快取
+func (f *purgeRefetcher) refetchable(key string) bool {
+	req, err := http.NewRequest(http.MethodGet, key, nil)
+	return err == nil && f.proxy.ruleFor(req, req.URL.Path).cacheable()
+}
+
+// Stale 返回等待重新下載的舊條目，已過 window 時移除並返回 false；f 為 nil 時返回 false
+func (f *purgeRefetcher) Stale(key string) (*CacheEntry, bool) {
+	if f == nil {
+		return nil, false
+	}
+	f.mu.Lock()
+	purged, ok := f.pending[key]
+	if ok && time.Now().After(purged.deadline) {
+		delete(f.pending, key)
+		f.mu.Unlock()
+		f.drop(key, purged.entry)
+		f.expired.Add(1)
+		return nil, false
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if cur, ok := f.proxy.cache.Peek(key); !ok || cur != purged.entry {
+		return nil, false
+	}
+	return purged.entry, true
+}
+
+// drop 舊條目仍在快取中時移除
+func (f *purgeRefetcher) drop(key string, entry *CacheEntry) {
+	if cur, ok := f.proxy.cache.Peek(key); ok && cur == entry {
+		f.proxy.cache.Remove(key)
+	}
+}
+
+// loop 每 1/rate 秒取出一個條目重新下載，佇列為空時等待新的排程
+func (f *purgeRefetcher) loop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(time.Second / time.Duration(f.rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.closeCh:
+			return
+		case <-ticker.C:
+		}
+		// 離線期間暫停，舊條目照常以快取回應
+		if f.proxy.Offline() {
+			continue
+		}
+		key, purged, ok := f.next()
+		if !ok {
+			select {
+			case <-f.closeCh:
+				return
+			case <-f.wake:
+			}
+			continue
+		}
+		// 背景 goroutine 達到上限時在迴圈中依序下載，等同降低速率
+		f.wg.Add(1)
+		started := f.proxy.routines.Go(routineBackground, "refetch "+key, func() {
+			defer f.wg.Done()
+			f.refetch(key, purged)
+		})
+		if !started {
+			f.refetch(key, purged)
+			f.wg.Done()
+		}
+	}
+}
+
+// next 取出佇列中下一個仍在等待的條目，順便移除已過 window 的條目
+//
+// 佇列依清除時間排列，期限也依序遞增，因此只需檢查佇列前端。
+func (f *purgeRefetcher) next() (string, purgedEntry, bool) {
+	now := time.Now()
+	var expired []purgedEntry
+	var expiredKeys []string
+	defer func() {
+		for i, purged := range expired {
+			f.drop(expiredKeys[i], purged.entry)
+		}
+		f.expired.Add(int64(len(expired)))
+	}()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.queue) > 0 {
+		key := f.queue[0]
+		f.queue = f.queue[1:]
+		purged, ok := f.pending[key]
+		if !ok {
+			continue
+		}
+		if now.After(purged.deadline) {
+			delete(f.pending, key)
+			expired = append(expired, purged)
+			expiredKeys = append(expiredKeys, key)
+			continue
+		}
+		return key, purged, true
+	}
+	f.queue = nil
+	return "", purgedEntry{}, false
+}
+
+// refetch 以低優先級重新下載，成功時新條目取代舊條目，失敗時移除舊條目
+func (f *purgeRefetcher) refetch(key string, purged purgedEntry) {
+	p := f.proxy
+	defer func() {
+		f.mu.Lock()
+		if cur, ok := f.pending[key]; ok && cur.entry == purged.entry {
+			delete(f.pending, key)
+		}
+		f.mu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, key, nil)
+	if err != nil {
+		f.drop(key, purged.entry)
+		return
+	}
+	req.Header.Set(priorityHeader, PriorityLow.String())
+	req = req.WithContext(withRule(req.Context(), p.ruleFor(req, req.URL.Path)))
+
+	w := &prefetchWriter{header: make(http.Header), status: http.StatusOK}
+	err = p.fetchAndServe(req.Context(), w, req, key, nil)
+	if err != nil || w.status != http.StatusOK {
+		f.failed.Add(1)
+		f.drop(key, purged.entry)
+		slog.Warn("purge refetch failed", "key", key, "status", w.status, "error", err)
+		return
+	}
+	f.refetched.Add(1)
+	slog.Debug("purged entry refetched", "key", key)
+}
+
+// Stats 返回刷新統計資訊
+func (f *purgeRefetcher) Stats() map[string]any {
+	f.mu.Lock()
+	waiting := len(f.pending)
+	f.mu.Unlock()
+	return map[string]any{
+		"rate":      f.rate,
+		"window":    f.window.String(),
+		"pending":   waiting,
+		"scheduled": f.scheduled.Load(),
+		"refetched": f.refetched.Load(),
+		"failed":    f.failed.Load(),
+		"expired":   f.expired.Load(),
+	}
+}
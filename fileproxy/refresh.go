@@ -0,0 +1,188 @@
+package fileproxy
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hotRefresher 在熱門條目過期前於背景向上游重新驗證或重新下載
+//
+// 每輪掃描找出 ahead 內將過期、近期命中次數最多的 count 個條目，以低優先級刷新，
+// 熱門檔案因此一直保持新鮮，客戶端不會遇到過期後回源的延遲。命中次數每輪減半，
+// 反映的是近期而非累積的熱度。固定 TTL 的條目才會到期；滑動 TTL 的條目每次命中都會延長。
+type hotRefresher struct {
+	proxy *Proxy
+	count int
+	ahead time.Duration
+
+	scans       atomic.Int64
+	refreshed   atomic.Int64
+	revalidated atomic.Int64
+	failed      atomic.Int64
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newHotRefresher 建立刷新器並啟動掃描，count 為 0 時返回 nil
+func newHotRefresher(p *Proxy, count int, ahead time.Duration) *hotRefresher {
+	if count <= 0 || ahead <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &hotRefresher{
+		proxy:   p,
+		count:   count,
+		ahead:   ahead,
+		ctx:     ctx,
+		cancel:  cancel,
+		closeCh: make(chan struct{}),
+	}
+	h.wg.Add(1)
+	go h.loop()
+	return h
+}
+
+// Close 停止掃描並等待進行中的刷新結束
+func (h *hotRefresher) Close() {
+	h.cancel()
+	close(h.closeCh)
+	h.wg.Wait()
+}
+
+// loop 每半個 ahead 掃描一次，確保條目在過期前至少被看到一次
+func (h *hotRefresher) loop() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(max(h.ahead/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.closeCh:
+			return
+		case <-ticker.C:
+			h.scan()
+		}
+	}
+}
+
+// scan 選出即將過期的熱門條目並逐一刷新
+func (h *hotRefresher) scan() {
+	if h.proxy.Offline() {
+		return
+	}
+	h.scans.Add(1)
+	cache := h.proxy.cache
+	now := time.Now()
+	from, until := now.UnixNano(), now.Add(h.ahead).UnixNano()
+
+	type candidate struct {
+		entry *CacheEntry
+		hits  int64
+	}
+	var candidates []candidate
+	cache.fileCache.forEach(func(e *CacheEntry) {
+		hits := e.hits.Load()
+		if hits == 0 {
+			return
+		}
+		e.hits.Add(-(hits + 1) / 2)
+		if at := e.expiresAt.Load(); at > from && at <= until {
+			candidates = append(candidates, candidate{e, hits})
+		}
+	})
+	if len(candidates) == 0 {
+		return
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int { return cmp.Compare(b.hits, a.hits) })
+	candidates = candidates[:min(len(candidates), h.count)]
+
+	hashes := make([]keyHash, len(candidates))
+	entries := make(map[keyHash]*CacheEntry, len(candidates))
+	for i, c := range candidates {
+		hashes[i] = c.entry.hash
+		entries[c.entry.hash] = c.entry
+	}
+	keys := make(map[keyHash]string, len(hashes))
+	if err := cache.store.Keys(hashes, func(hash keyHash, key string) { keys[hash] = key }); err != nil {
+		slog.Warn("hot refresh key lookup failed", "error", err)
+		return
+	}
+
+	// 依熱度順序刷新，舊版索引沒有 key 或屬於特定使用者的條目無法代為請求
+	for _, hash := range hashes {
+		key, ok := keys[hash]
+		if !ok || isPrivateKey(key) {
+			continue
+		}
+		select {
+		case <-h.closeCh:
+			return
+		default:
+		}
+		h.refresh(key, entries[hash])
+	}
+}
+
+// refresh 以條件請求刷新條目，上游返回新內容時替換快取
+func (h *hotRefresher) refresh(key string, entry *CacheEntry) {
+	status, err := h.proxy.revalidate(h.ctx, key, entry)
+	switch {
+	case err != nil:
+		h.failed.Add(1)
+		slog.Warn("hot refresh failed", "key", key, "error", err)
+	case status == "":
+	case status == "REVALIDATED":
+		h.revalidated.Add(1)
+		slog.Debug("hot entry revalidated", "key", key)
+	default:
+		h.refreshed.Add(1)
+		slog.Debug("hot entry refreshed", "key", key)
+	}
+}
+
+// revalidate 以低優先級的條件請求向上游確認條目，上游返回新內容時替換快取
+//
+// 返回回應的 X-Cache 狀態，REVALIDATED 表示內容未變更；路徑不可快取時不發出請求並返回空字串。
+func (p *Proxy) revalidate(ctx context.Context, key string, entry *CacheEntry) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(priorityHeader, PriorityLow.String())
+	rule := p.ruleFor(req, req.URL.Path)
+	if !rule.cacheable() {
+		return "", nil
+	}
+	req = req.WithContext(withRule(req.Context(), rule))
+
+	w := &prefetchWriter{header: make(http.Header), status: http.StatusOK}
+	if err := p.fetchAndServe(req.Context(), w, req, key, entry); err != nil {
+		return "", err
+	}
+	if w.status != http.StatusOK {
+		return "", fmt.Errorf("status %d", w.status)
+	}
+	return w.header.Get("X-Cache"), nil
+}
+
+// Stats 返回刷新統計資訊
+func (h *hotRefresher) Stats() map[string]any {
+	return map[string]any{
+		"count":       h.count,
+		"ahead":       h.ahead.String(),
+		"scans":       h.scans.Load(),
+		"refreshed":   h.refreshed.Load(),
+		"revalidated": h.revalidated.Load(),
+		"failed":      h.failed.Load(),
+	}
+}
@@ -0,0 +1,206 @@
+package fileproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	replicatePrefix    = "/admin/replicate" // 接收對等節點推送的端點前綴
+	replicationWorkers = 4                  // 並發推送的 worker 數
+	replicationQueue   = 1024               // 待推送佇列長度
+)
+
+// replicator 將完成的快取填充推送到其他區域的對等節點
+type replicator struct {
+	peers  []string
+	token  string
+	cache  *Cache
+	sched  *fetchScheduler
+	client *http.Client
+	queue  chan string
+
+	pushed  atomic.Int64
+	skipped atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newReplicator 建立推送到 peers 的複製器並啟動推送 worker
+func newReplicator(cfg *Config, peers []string, cache *Cache, sched *fetchScheduler) *replicator {
+	rp := &replicator{
+		peers: peers,
+		token: cfg.AdminToken,
+		cache: cache,
+		sched: sched,
+		client: &http.Client{
+			Timeout: cfg.UpstreamTimeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   replicationWorkers,
+				IdleConnTimeout:       90 * time.Second,
+				ExpectContinueTimeout: 5 * time.Second,
+			},
+		},
+		queue:   make(chan string, replicationQueue),
+		closeCh: make(chan struct{}),
+	}
+
+	for i := 0; i < replicationWorkers; i++ {
+		rp.wg.Add(1)
+		go rp.worker()
+	}
+	return rp
+}
+
+// Close 停止推送 worker，未推送的項目會被丟棄
+func (rp *replicator) Close() {
+	close(rp.closeCh)
+	rp.wg.Wait()
+}
+
+// Enqueue 排入待推送的 key，佇列已滿時丟棄
+func (rp *replicator) Enqueue(key string) {
+	select {
+	case rp.queue <- key:
+	case <-rp.closeCh:
+	default:
+		rp.dropped.Add(1)
+		slog.Warn("replication queue full, dropped", "key", key)
+	}
+}
+
+// worker 從佇列取出 key 並推送到所有對等節點
+func (rp *replicator) worker() {
+	defer rp.wg.Done()
+	for {
+		select {
+		case <-rp.closeCh:
+			return
+		case key := <-rp.queue:
+			for _, peer := range rp.peers {
+				if err := rp.push(peer, key); err != nil {
+					rp.failed.Add(1)
+					slog.Warn("replication failed", "peer", peer, "key", key, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// push 推送單一快取條目到對等節點
+//
+// 使用 Expect: 100-continue，對等節點已有該檔案時不會傳送本體。
+func (rp *replicator) push(peer, key string) error {
+	entry, ok := rp.cache.Peek(key)
+	if !ok {
+		return nil // 推送前已被淘汰
+	}
+
+	file, release, err := rp.cache.Open(context.Background(), entry)
+	if err == errEntryEvicted {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open cache file: %w", err)
+	}
+	defer release()
+
+	target := buildUpstreamURL(peer, replicatePrefix+key)
+	// 複製屬於背景流量，讓出給互動式下載
+	body := rp.sched.Reader(context.Background(), PriorityLow, file)
+	req, err := http.NewRequest(http.MethodPut, target, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = entry.Size
+	req.Header.Set("Content-Type", entry.ContentType())
+	req.Header.Set("Expect", "100-continue")
+	req.Header.Set("Authorization", "Bearer "+rp.token)
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("peer request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		rp.pushed.Add(1)
+		slog.Debug("replicated", "peer", peer, "key", key, "size", entry.Size)
+		return nil
+	case http.StatusOK, http.StatusConflict:
+		rp.skipped.Add(1) // 對等節點已有或正在下載
+		return nil
+	default:
+		return fmt.Errorf("peer status: %d", resp.StatusCode)
+	}
+}
+
+// Stats 返回複製統計資訊
+func (rp *replicator) Stats() map[string]any {
+	return map[string]any{
+		"peers":   len(rp.peers),
+		"queued":  len(rp.queue),
+		"pushed":  rp.pushed.Load(),
+		"skipped": rp.skipped.Load(),
+		"failed":  rp.failed.Load(),
+		"dropped": rp.dropped.Load(),
+	}
+}
+
+// handleReplicate 接收對等節點推送的快取填充
+func (p *Proxy) handleReplicate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, replicatePrefix)
+	if !strings.HasPrefix(key, "/") || key == "/" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// 已有快取時直接返回，不讀取本體
+	if _, ok := p.cache.Peek(key); ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	entry, err := p.cache.Put(r.Context(), key, r.Body, r.ContentLength, contentType, 0, nil)
+	if err == errPendingExists {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Warn("replication receive failed", "key", key, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// 熱備節點收到主節點推送的熱門內容時，沿用索引記錄中的存活時間、驗證器與標籤
+	if se, ok := p.cache.store.StandbyEntry(entry.hash); ok && p.cache.adoptStandby(&se) {
+		p.standbyRx.adopted.Add(1)
+		if err := p.cache.store.ApplyStandby([]storeOp{{hash: entry.hash}}, false); err != nil {
+			slog.Warn("clear adopted standby entry failed", "key", key, "error", err)
+		}
+	}
+	slog.Debug("replica received", "key", key, "size", entry.Size)
+	w.WriteHeader(http.StatusCreated)
+}
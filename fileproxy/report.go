@@ -0,0 +1,171 @@
+package fileproxy
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	expiryReportPath = "/admin/expiry-report" // 過期報表端點
+	expiryReportLog  = 20                     // 日誌中列出的前綴數
+	unknownPrefix    = "(unknown)"            // 舊版索引沒有記錄 key 的條目
+)
+
+// ExpiryGroup 同一前綴下即將過期的條目
+type ExpiryGroup struct {
+	Prefix      string    `json:"prefix"`
+	Entries     int       `json:"entries"`
+	Bytes       int64     `json:"bytes"`
+	FirstExpiry time.Time `json:"first_expiry"`
+}
+
+// ExpiryReport 即將過期條目的報表，依位元組數由大到小排序
+type ExpiryReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Window      string        `json:"window"`
+	Depth       int           `json:"depth"`
+	Entries     int           `json:"entries"`
+	Bytes       int64         `json:"bytes"`
+	Groups      []ExpiryGroup `json:"groups"`
+}
+
+// keyPrefix 返回 key 所在目錄的前 depth 層
+func keyPrefix(key string, depth int) string {
+	dir := strings.TrimPrefix(path.Dir(key), "/")
+	if dir == "" {
+		return "/"
+	}
+	parts := strings.SplitN(dir, "/", depth+1)
+	return "/" + strings.Join(parts[:min(len(parts), depth)], "/")
+}
+
+// ExpiryReport 統計 window 內將過期的條目，依 key 前 depth 層目錄分組
+func (c *Cache) ExpiryReport(window time.Duration, depth int) (*ExpiryReport, error) {
+	now := time.Now()
+	from, until := now.UnixNano(), now.Add(window).UnixNano()
+
+	expiring := make(map[keyHash]*CacheEntry)
+	c.fileCache.forEach(func(e *CacheEntry) {
+		if at := e.expiresAt.Load(); at > from && at <= until {
+			expiring[e.hash] = e
+		}
+	})
+
+	hashes := make([]keyHash, 0, len(expiring))
+	for hash := range expiring {
+		hashes = append(hashes, hash)
+	}
+	slices.SortFunc(hashes, func(a, b keyHash) int { return bytes.Compare(a[:], b[:]) })
+
+	report := &ExpiryReport{GeneratedAt: now, Window: window.String(), Depth: depth}
+	groups := make(map[string]*ExpiryGroup)
+	add := func(prefix string, e *CacheEntry) {
+		g, ok := groups[prefix]
+		if !ok {
+			g = &ExpiryGroup{Prefix: prefix}
+			groups[prefix] = g
+		}
+		expiresAt := time.Unix(0, e.expiresAt.Load())
+		if g.Entries == 0 || expiresAt.Before(g.FirstExpiry) {
+			g.FirstExpiry = expiresAt
+		}
+		g.Entries++
+		g.Bytes += e.Size
+		report.Entries++
+		report.Bytes += e.Size
+	}
+
+	// bbolt 依鍵排序存放，排序後查詢可減少隨機讀取
+	err := c.store.Keys(hashes, func(hash keyHash, key string) {
+		add(keyPrefix(key, depth), expiring[hash])
+		delete(expiring, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range expiring {
+		add(unknownPrefix, e)
+	}
+
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	slices.SortFunc(report.Groups, func(a, b ExpiryGroup) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Prefix, b.Prefix))
+	})
+	return report, nil
+}
+
+// nextReportTime 返回下一個 HH:MM（本地時間）
+func nextReportTime(at string, now time.Time) time.Time {
+	t, _ := time.Parse("15:04", at)
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// reportLoop 每日於 ExpiryReportAt 將過期報表寫入日誌
+func (c *Cache) reportLoop() {
+	defer c.wg.Done()
+	for {
+		timer := time.NewTimer(time.Until(nextReportTime(c.config.ExpiryReportAt, time.Now())))
+		select {
+		case <-c.closeCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		report, err := c.ExpiryReport(c.config.ExpiryReportWindow, c.config.ExpiryReportDepth)
+		if err != nil {
+			slog.Warn("expiry report failed", "error", err)
+			continue
+		}
+		slog.Info("expiry report", "window", report.Window, "entries", report.Entries,
+			"bytes", report.Bytes, "prefixes", len(report.Groups))
+		for _, g := range report.Groups[:min(len(report.Groups), expiryReportLog)] {
+			slog.Info("expiry report prefix", "prefix", g.Prefix, "entries", g.Entries,
+				"bytes", g.Bytes, "first_expiry", g.FirstExpiry.Format(time.RFC3339))
+		}
+	}
+}
+
+// handleExpiryReport 即時產生過期報表，可用 window 與 depth 參數覆寫配置
+func (p *Proxy) handleExpiryReport(w http.ResponseWriter, r *http.Request) {
+	window, depth := p.config.ExpiryReportWindow, p.config.ExpiryReportDepth
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid depth", http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+
+	report, err := p.cache.ExpiryReport(window, depth)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		slog.Error("expiry report failed", "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,96 @@
+package fileproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite 在組成上游 URL 前改寫請求路徑
+//
+// 快取 key、路徑規則與授權仍使用公開路徑，只有送往上游的路徑改變，
+// 例如 strip:/mirror 讓本地的 /mirror/ubuntu/... 向上游請求 /ubuntu/...。
+// 每個 Rewrite 只設定一種動作。
+type Rewrite struct {
+	StripPrefix string         // 去掉的路徑前綴，路徑不以此開頭時不改寫
+	AddPrefix   string         // 加上的路徑前綴
+	Pattern     *regexp.Regexp // 正規表示式替換
+	Replacement string         // Pattern 的替換字串，可用 $1 引用群組
+}
+
+// ParseRewrite 解析命令列格式的改寫：strip:/PREFIX、add:/PREFIX 或 regex:PATTERN=>REPLACEMENT
+func ParseRewrite(s string) (Rewrite, error) {
+	kind, arg, found := strings.Cut(s, ":")
+	if !found {
+		return Rewrite{}, fmt.Errorf("rewrite %q: expected strip:/PREFIX, add:/PREFIX or regex:PATTERN=>REPLACEMENT", s)
+	}
+	var rw Rewrite
+	switch kind {
+	case "strip":
+		rw.StripPrefix = strings.TrimSpace(arg)
+	case "add":
+		rw.AddPrefix = strings.TrimSpace(arg)
+	case "regex":
+		pattern, replacement, ok := strings.Cut(arg, "=>")
+		if !ok {
+			return Rewrite{}, fmt.Errorf("rewrite %q: expected regex:PATTERN=>REPLACEMENT", s)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return Rewrite{}, fmt.Errorf("rewrite %q: %w", s, err)
+		}
+		rw.Pattern, rw.Replacement = re, strings.TrimSpace(replacement)
+	default:
+		return Rewrite{}, fmt.Errorf("rewrite %q: unknown kind %q", s, kind)
+	}
+	return rw, rw.validate()
+}
+
+// validate 驗證改寫
+func (rw *Rewrite) validate() error {
+	actions := 0
+	for _, set := range []bool{rw.StripPrefix != "", rw.AddPrefix != "", rw.Pattern != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("rewrite must set exactly one of strip prefix, add prefix or pattern")
+	}
+	for _, prefix := range []string{rw.StripPrefix, rw.AddPrefix} {
+		if prefix != "" && (!strings.HasPrefix(prefix, "/") || prefix == "/" || strings.HasSuffix(prefix, "/")) {
+			return fmt.Errorf("rewrite prefix %q must start with / and not end with /", prefix)
+		}
+	}
+	return nil
+}
+
+// apply 改寫路徑，結果一律以 / 開頭
+func (rw *Rewrite) apply(path string) string {
+	switch {
+	case rw.StripPrefix != "":
+		if path == rw.StripPrefix {
+			return "/"
+		}
+		if rest, ok := strings.CutPrefix(path, rw.StripPrefix+"/"); ok {
+			return "/" + rest
+		}
+		return path
+	case rw.AddPrefix != "":
+		return rw.AddPrefix + path
+	case rw.Pattern != nil:
+		path = rw.Pattern.ReplaceAllString(path, rw.Replacement)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	return path
+}
+
+// rewritePath 依序套用所有改寫，返回送往上游的路徑
+func (c *Config) rewritePath(path string) string {
+	for i := range c.Rewrites {
+		path = c.Rewrites[i].apply(path)
+	}
+	return path
+}
@@ -0,0 +1,102 @@
+package fileproxy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Route 將路徑前綴對應到獨立的上游
+//
+// 請求路徑去掉前綴後拼接到上游 URL，例如 /npm=https://registry.npmjs.org 時
+// /npm/lodash 代理到 https://registry.npmjs.org/lodash。快取 key 保留前綴，
+// 因此不同路由的快取互不衝突。
+type Route struct {
+	Prefix   string // 路徑前綴，例如 /npm
+	Upstream string // 上游服務 URL
+}
+
+// Match 檢查 key 是否屬於此路由
+func (r *Route) Match(key string) bool {
+	return key == r.Prefix || strings.HasPrefix(key, r.Prefix+"/")
+}
+
+// validate 驗證路由
+func (r *Route) validate() error {
+	if !strings.HasPrefix(r.Prefix, "/") || r.Prefix == "/" || strings.HasSuffix(r.Prefix, "/") {
+		return fmt.Errorf("route prefix %q must start with / and not end with /", r.Prefix)
+	}
+	if err := validateOriginURL(r.Upstream); err != nil {
+		return fmt.Errorf("route %q: %w", r.Prefix, err)
+	}
+	return nil
+}
+
+// validateUpstreamURL 檢查上游 URL：http 或 https、有主機名，IPv6 位址須以方括號包住，埠須為 1-65535
+func validateUpstreamURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid upstream %q: %w", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid upstream %q: scheme must be http or https", s)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid upstream %q: missing host", s)
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return fmt.Errorf("invalid upstream %q: IPv6 addresses must be enclosed in brackets", s)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid upstream %q: port out of range", s)
+		}
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("invalid upstream %q: fragments are not allowed", s)
+	}
+	return nil
+}
+
+// buildUpstreamURL 產生 key 對應的上游 URL
+//
+// key 是解碼後的路徑，其中的 %、?、#、空白與非 ASCII 字元在接到上游路徑之後重新跳脫；
+// 上游 URL 的查詢字串保留在最後。上游 URL 已在啟動時驗證，無法解析時退回字串拼接。
+func buildUpstreamURL(upstream, key string) string {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return strings.TrimSuffix(upstream, "/") + key
+	}
+	escaped := strings.TrimSuffix(u.EscapedPath(), "/") + (&url.URL{Path: key}).EscapedPath()
+	u.Path = strings.TrimSuffix(u.Path, "/") + key
+	u.RawPath = escaped
+	return u.String()
+}
+
+// ParseRoute 解析命令列格式的路由：PREFIX=URL
+func ParseRoute(s string) (Route, error) {
+	prefix, upstream, found := strings.Cut(s, "=")
+	if !found {
+		return Route{}, fmt.Errorf("route %q: expected PREFIX=URL", s)
+	}
+	route := Route{Prefix: strings.TrimSpace(prefix), Upstream: strings.TrimSpace(upstream)}
+	return route, route.validate()
+}
+
+// upstreamFor 返回 key 對應的上游 URL，最長前綴優先，無匹配時使用 UpstreamURL
+func (c *Config) upstreamFor(key string) (string, bool) {
+	var best *Route
+	for i := range c.Routes {
+		if r := &c.Routes[i]; r.Match(key) && (best == nil || len(r.Prefix) > len(best.Prefix)) {
+			best = r
+		}
+	}
+	if best != nil {
+		return buildUpstreamURL(best.Upstream, strings.TrimPrefix(key, best.Prefix)), true
+	}
+	if c.UpstreamURL == "" {
+		return "", false
+	}
+	return buildUpstreamURL(c.UpstreamURL, key), true
+}
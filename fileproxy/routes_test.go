@@ -0,0 +1,80 @@
+package fileproxy
+
+import "testing"
+
+func TestBuildUpstreamURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		key      string
+		want     string
+	}{
+		{"ipv6 with port", "http://[::1]:8080", "/a/b", "http://[::1]:8080/a/b"},
+		{"ipv6 without port", "http://[::1]", "/a", "http://[::1]/a"},
+		{"ipv6 with base path", "https://[2001:db8::1]/base/", "/x", "https://[2001:db8::1]/base/x"},
+		{"default port kept", "http://example.com:80", "/a", "http://example.com:80/a"},
+		{"explicit port", "https://example.com:8443/", "/a", "https://example.com:8443/a"},
+		{"no port", "https://example.com", "/a", "https://example.com/a"},
+
+		{"encoded slash in base", "http://h/pre%2Fx/", "/k", "http://h/pre%2Fx/k"},
+		{"literal percent-2F in key", "http://h", "/a%2Fb", "http://h/a%252Fb"},
+		{"literal percent in key", "http://h", "/100%", "http://h/100%25"},
+		{"literal percent-25 in key", "http://h", "/a%25b", "http://h/a%2525b"},
+		{"encoded percent in base", "http://h/50%25/", "/f", "http://h/50%25/f"},
+		{"question mark in key", "http://h", "/a?b", "http://h/a%3Fb"},
+		{"hash in key", "http://h", "/a#b", "http://h/a%23b"},
+		{"space and non-ascii", "http://h", "/a b/é", "http://h/a%20b/%C3%A9"},
+
+		{"base trailing slash", "http://h/base/", "/f", "http://h/base/f"},
+		{"base without trailing slash", "http://h/base", "/f", "http://h/base/f"},
+		{"key trailing slash", "http://h/base", "/dir/", "http://h/base/dir/"},
+		{"both trailing slashes", "http://h/base/", "/dir/", "http://h/base/dir/"},
+		{"root base and root key", "http://h/", "/", "http://h/"},
+		{"base query kept last", "http://h/base/?token=1", "/f", "http://h/base/f?token=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildUpstreamURL(tt.upstream, tt.key); got != tt.want {
+				t.Errorf("buildUpstreamURL(%q, %q) = %q, want %q", tt.upstream, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUpstreamURL(t *testing.T) {
+	tests := []struct {
+		upstream string
+		ok       bool
+	}{
+		{"http://[::1]", true},
+		{"http://[::1]:8080", true},
+		{"https://[2001:db8::1]:443/base/", true},
+		{"http://example.com", true},
+		{"https://example.com:443", true},
+		{"http://example.com:8080/base/", true},
+		{"http://example.com/pre%2Fx/", true},
+		{"http://example.com/?token=1", true},
+
+		{"http://::1", false},
+		{"http://2001:db8::1:8080", false},
+		{"http://[::1]:0", false},
+		{"http://[::1]:65536", false},
+		{"http://example.com:0", false},
+		{"http://example.com:99999", false},
+		{"http://example.com:http", false},
+		{"ftp://example.com", false},
+		{"example.com", false},
+		{"http://", false},
+		{"http://:8080", false},
+		{"http://example.com/#frag", false},
+	}
+	for _, tt := range tests {
+		err := validateUpstreamURL(tt.upstream)
+		if tt.ok && err != nil {
+			t.Errorf("validateUpstreamURL(%q) = %v, want nil", tt.upstream, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("validateUpstreamURL(%q) = nil, want an error", tt.upstream)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+)
+
+// debugGoroutinesPath 各子系統 goroutine 數量與執行最久者的查詢端點
+const debugGoroutinesPath = "/debug/goroutines"
+
+// 追蹤 goroutine 的子系統
+const (
+	routineStreamReaders = "stream_readers" // 附加到進行中下載、等待下載進度的請求
+	routineFetchers      = "fetchers"       // 上游下載（含等待下載名額）與分段下載
+	routineBackground    = "background"     // 預取、清除後回填、失效重新驗證與 Hub 預先下載
+)
+
+const routineDebugOldest = 20 // /debug/goroutines 每個子系統列出的執行最久者數
+
+// errRoutineLimit 子系統的 goroutine 數已達上限
+var errRoutineLimit = errors.New("goroutine limit reached")
+
+// trackedRoutine 一個登記中的 goroutine
+type trackedRoutine struct {
+	label   string
+	started time.Time
+	leaking bool // 已超過 leakAge 並記錄警告
+}
+
+// routineGroup 單一子系統的登記與計數
+type routineGroup struct {
+	limit    int
+	active   map[uint64]*trackedRoutine
+	peak     int
+	started  int64
+	rejected int64
+	leaks    int64 // 曾被判定疑似洩漏的數量（累計）
+}
+
+// routineTracker 依子系統計算 goroutine 數，設定上限時拒絕新的 goroutine，並警告執行過久者
+//
+// 串流讀取者在 cond 上等待下載進度，下載不結束時會一直留著；追蹤後可在 /debug/goroutines 看到
+// 是哪些 key 累積了讀取者，上限則避免它們無限增加耗盡記憶體。
+type routineTracker struct {
+	leakAge time.Duration
+
+	mu     sync.Mutex
+	nextID uint64
+	groups map[string]*routineGroup
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newRoutineTracker 建立 goroutine 追蹤，設定 GoroutineLeakAge 時啟動檢查
+func newRoutineTracker(cfg *Config) *routineTracker {
+	t := &routineTracker{
+		leakAge: cfg.GoroutineLeakAge,
+		groups: map[string]*routineGroup{
+			routineStreamReaders: {limit: cfg.MaxStreamReaders},
+			routineFetchers:      {limit: cfg.MaxFetchers},
+			routineBackground:    {limit: cfg.MaxBackgroundJobs},
+		},
+		closeCh: make(chan struct{}),
+	}
+	for _, g := range t.groups {
+		g.active = make(map[uint64]*trackedRoutine)
+	}
+	if t.leakAge > 0 {
+		t.wg.Add(1)
+		go t.leakLoop()
+	}
+	return t
+}
+
+// Close 停止檢查 goroutine
+func (t *routineTracker) Close() {
+	close(t.closeCh)
+	t.wg.Wait()
+}
+
+// Acquire 為呼叫者的 goroutine 登記，返回結束時呼叫的釋放函式；子系統已達上限時返回 errRoutineLimit
+func (t *routineTracker) Acquire(subsystem, label string) (func(), error) {
+	return t.register(subsystem, label, true)
+}
+
+// Track 登記不受上限限制的 goroutine，用於已由其他上限約束的輔助 goroutine
+func (t *routineTracker) Track(subsystem, label string) func() {
+	release, _ := t.register(subsystem, label, false)
+	return release
+}
+
+// Go 子系統未達上限時以新的 goroutine 執行 fn，返回是否已啟動；未啟動時由呼叫者自行處理
+func (t *routineTracker) Go(subsystem, label string, fn func()) bool {
+	release, err := t.Acquire(subsystem, label)
+	if err != nil {
+		return false
+	}
+	go func() {
+		defer release()
+		fn()
+	}()
+	return true
+}
+
+// register 登記一個 goroutine，limited 為 true 時檢查上限
+func (t *routineTracker) register(subsystem, label string, limited bool) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g := t.groups[subsystem]
+	if limited && g.limit > 0 && len(g.active) >= g.limit {
+		g.rejected++
+		return nil, errRoutineLimit
+	}
+	t.nextID++
+	id := t.nextID
+	r := &trackedRoutine{label: label, started: time.Now()}
+	g.active[id] = r
+	g.started++
+	g.peak = max(g.peak, len(g.active))
+	return sync.OnceFunc(func() {
+		t.mu.Lock()
+		delete(g.active, id)
+		t.mu.Unlock()
+		if r.leaking {
+			slog.Info("suspected leaked goroutine finished", "subsystem", subsystem, "label", label, "age", time.Since(r.started).Round(time.Second))
+		}
+	}), nil
+}
+
+// leakLoop 定期檢查執行超過 leakAge 的 goroutine
+func (t *routineTracker) leakLoop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(min(max(t.leakAge/4, time.Second), time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closeCh:
+			return
+		case <-ticker.C:
+			t.checkLeaks()
+		}
+	}
+}
+
+// checkLeaks 將首次超過 leakAge 的 goroutine 標記為疑似洩漏並記錄警告，每個只警告一次
+func (t *routineTracker) checkLeaks() {
+	type leak struct {
+		subsystem, label string
+		age              time.Duration
+	}
+	var leaks []leak
+	now := time.Now()
+	t.mu.Lock()
+	for name, g := range t.groups {
+		for _, r := range g.active {
+			if age := now.Sub(r.started); !r.leaking && age >= t.leakAge {
+				r.leaking = true
+				g.leaks++
+				leaks = append(leaks, leak{name, r.label, age})
+			}
+		}
+	}
+	t.mu.Unlock()
+	for _, l := range leaks {
+		slog.Warn("possible goroutine leak", "subsystem", l.subsystem, "label", l.label, "age", l.age.Round(time.Second))
+	}
+}
+
+// RoutineGroupStats 單一子系統的 goroutine 統計
+type RoutineGroupStats struct {
+	Active   int   `json:"active"`
+	Peak     int   `json:"peak"`
+	Limit    int   `json:"limit"` // 0 表示不限制
+	Started  int64 `json:"started"`
+	Rejected int64 `json:"rejected"` // 達到上限而拒絕的數量
+	Leaking  int   `json:"leaking"`  // 目前執行超過 leakAge 的數量
+	Leaks    int64 `json:"leaks"`    // 曾被判定疑似洩漏的數量（累計）
+}
+
+// RoutineStats goroutine 統計
+type RoutineStats struct {
+	Runtime    int                          `json:"runtime"` // 程序中所有 goroutine 的數量，包含未追蹤的
+	Subsystems map[string]RoutineGroupStats `json:"subsystems"`
+}
+
+// Stats 返回各子系統的統計
+func (t *routineTracker) Stats() RoutineStats {
+	st := RoutineStats{Runtime: runtime.NumGoroutine(), Subsystems: make(map[string]RoutineGroupStats, len(t.groups))}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, g := range t.groups {
+		gs := RoutineGroupStats{
+			Active:   len(g.active),
+			Peak:     g.peak,
+			Limit:    g.limit,
+			Started:  g.started,
+			Rejected: g.rejected,
+			Leaks:    g.leaks,
+		}
+		for _, r := range g.active {
+			if r.leaking {
+				gs.Leaking++
+			}
+		}
+		st.Subsystems[name] = gs
+	}
+	return st
+}
+
+// RoutineInfo 一個登記中的 goroutine
+type RoutineInfo struct {
+	Label     string    `json:"label"`
+	StartedAt time.Time `json:"started_at"`
+	Age       string    `json:"age"`
+	Leaking   bool      `json:"leaking,omitempty"`
+}
+
+// RoutineGroupDebug 單一子系統的統計與執行最久的 goroutine
+type RoutineGroupDebug struct {
+	RoutineGroupStats
+	Oldest []RoutineInfo `json:"oldest"`
+}
+
+// RoutineDebug /debug/goroutines 的回應
+type RoutineDebug struct {
+	Runtime    int                          `json:"runtime"`
+	LeakAge    string                       `json:"leak_age,omitempty"`
+	Subsystems map[string]RoutineGroupDebug `json:"subsystems"`
+}
+
+// Debug 返回各子系統的統計與執行最久的 goroutine
+func (t *routineTracker) Debug() RoutineDebug {
+	stats := t.Stats()
+	d := RoutineDebug{Runtime: stats.Runtime, Subsystems: make(map[string]RoutineGroupDebug, len(stats.Subsystems))}
+	if t.leakAge > 0 {
+		d.LeakAge = t.leakAge.String()
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, g := range t.groups {
+		oldest := make([]*trackedRoutine, 0, len(g.active))
+		for _, r := range g.active {
+			oldest = append(oldest, r)
+		}
+		slices.SortFunc(oldest, func(a, b *trackedRoutine) int { return a.started.Compare(b.started) })
+		gd := RoutineGroupDebug{RoutineGroupStats: stats.Subsystems[name], Oldest: []RoutineInfo{}}
+		for _, r := range oldest[:min(len(oldest), routineDebugOldest)] {
+			gd.Oldest = append(gd.Oldest, RoutineInfo{
+				Label:     r.label,
+				StartedAt: r.started,
+				Age:       now.Sub(r.started).Round(time.Second).String(),
+				Leaking:   r.leaking,
+			})
+		}
+		d.Subsystems[name] = gd
+	}
+	return d
+}
+
+// handleDebugGoroutines GET /debug/goroutines 返回 goroutine 統計，?stacks=1 改為返回所有 goroutine 的堆疊
+func (p *Proxy) handleDebugGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("stacks") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(w, 1)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.routines.Debug())
+}
@@ -0,0 +1,196 @@
+package fileproxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// CacheRule 依路徑覆寫快取行為
+//
+// Pattern 使用 path.Match 語法，* 不跨越 /；以 /** 結尾時匹配該目錄下任意深度，
+// 以 /**/ 開頭時以其餘部分比對路徑最後幾段，例如 /**/*.deb 匹配任意深度的 .deb 檔案。
+// 多條規則依序比對，第一條匹配的規則生效。
+type CacheRule struct {
+	Pattern       string            // 路徑 glob
+	TTL           time.Duration     // 固定存活時間，0 表示沿用上游回應頭或預設 TTL
+	NotFoundTTL   time.Duration     // 404 快取時間，0 表示使用 NotFoundCacheTTL
+	NoCache       bool              // 不寫入快取，直接透傳上游
+	Revalidate    bool              // 每次請求都以條件請求向上游確認，304 時才使用快取
+	Immutable     bool              // 內容不可變：不因存活時間過期，只在容量壓力下淘汰，不重新驗證
+	VerifySum     bool              // 檔名以內容的 SHA-256 開頭時，完成下載後比對，不符時不寫入快取
+	RewriteLinks  bool              // 將內容中上游的絕對 URL 改寫為 PublicURL，回應不帶 Content-Length
+	IgnoreNoStore bool              // 忽略上游的 Cache-Control: no-store 與 private，照常快取
+	Upstream      string            // 覆寫上游 URL，為空時依路由決定
+	Headers       map[string]string // 附加的回應頭
+	Tags          []string          // 寫入快取時加上的標籤
+}
+
+// Match 檢查路徑是否匹配規則
+func (r *CacheRule) Match(key string) bool {
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/**"); ok {
+		return key == prefix || strings.HasPrefix(key, prefix+"/")
+	}
+	if suffix, ok := strings.CutPrefix(r.Pattern, "/**/"); ok {
+		i := len(key)
+		for range strings.Count(suffix, "/") + 1 {
+			if i = strings.LastIndexByte(key[:i], '/'); i < 0 {
+				return false
+			}
+		}
+		matched, _ := path.Match(suffix, key[i+1:])
+		return matched
+	}
+	matched, _ := path.Match(r.Pattern, key)
+	return matched
+}
+
+// validate 驗證規則
+func (r *CacheRule) validate() error {
+	if !strings.HasPrefix(r.Pattern, "/") {
+		return fmt.Errorf("cache rule pattern %q must start with /", r.Pattern)
+	}
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid cache rule pattern %q: %w", r.Pattern, err)
+	}
+	return r.validateActions()
+}
+
+// validateActions 驗證規則的動作欄位
+func (r *CacheRule) validateActions() error {
+	if r.TTL < 0 || r.NotFoundTTL < 0 {
+		return fmt.Errorf("cache rule %q: ttl must not be negative", r.Pattern)
+	}
+	if r.Immutable && (r.TTL > 0 || r.Revalidate || r.NoCache) {
+		return fmt.Errorf("cache rule %q: immutable cannot be combined with ttl, revalidate or no-cache", r.Pattern)
+	}
+	if r.Upstream != "" {
+		if err := validateOriginURL(r.Upstream); err != nil {
+			return fmt.Errorf("cache rule %q: %w", r.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// ParseCacheRule 解析命令列格式的規則：PATTERN:OPTION[,OPTION...]
+//
+// 可用選項為 ttl=DURATION、notfound-ttl=DURATION、no-cache、revalidate、immutable、verify-sum、rewrite-links、ignore-no-store、
+// upstream=URL 與 header=NAME:VALUE，例如 /metadata/*.json:ttl=30s 或 /blobs/**:ttl=720h,notfound-ttl=1m。
+func ParseCacheRule(s string) (CacheRule, error) {
+	pattern, opts, found := strings.Cut(s, ":")
+	if !found || opts == "" {
+		return CacheRule{}, fmt.Errorf("cache rule %q: expected PATTERN:OPTIONS", s)
+	}
+
+	rule := CacheRule{Pattern: pattern}
+	if err := rule.parseOptions(opts); err != nil {
+		return CacheRule{}, fmt.Errorf("cache rule %q: %w", s, err)
+	}
+	return rule, rule.validate()
+}
+
+// parseOptions 解析逗號分隔的規則選項
+func (r *CacheRule) parseOptions(opts string) error {
+	for _, opt := range strings.Split(opts, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		var err error
+		switch name {
+		case "ttl":
+			r.TTL, err = time.ParseDuration(value)
+		case "notfound-ttl":
+			r.NotFoundTTL, err = time.ParseDuration(value)
+		case "no-cache":
+			r.NoCache = true
+		case "revalidate":
+			r.Revalidate = true
+		case "immutable":
+			r.Immutable = true
+		case "verify-sum":
+			r.VerifySum = true
+		case "rewrite-links":
+			r.RewriteLinks = true
+		case "ignore-no-store":
+			r.IgnoreNoStore = true
+		case "upstream":
+			r.Upstream = value
+		case "tag":
+			if !validTag(value) {
+				return fmt.Errorf("tag option %q: expected 1-%d letters, digits, '.', '_' or '-'", value, maxTagLength)
+			}
+			r.Tags = append(r.Tags, value)
+		case "header":
+			header, hvalue, ok := strings.Cut(value, ":")
+			if !ok || header == "" {
+				return fmt.Errorf("header option %q: expected NAME:VALUE", value)
+			}
+			if isHopHeader(header) {
+				return fmt.Errorf("header option %q: hop-by-hop header not allowed", value)
+			}
+			if r.Headers == nil {
+				r.Headers = make(map[string]string)
+			}
+			r.Headers[http.CanonicalHeaderKey(header)] = strings.TrimSpace(hvalue)
+		default:
+			err = fmt.Errorf("unknown option %q", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySum 檢查規則是否要求比對檔名中的雜湊，nil 表示無規則
+func (r *CacheRule) verifySum() bool {
+	return r != nil && r.VerifySum
+}
+
+// rewriteLinks 檢查規則是否要求改寫內容中的上游連結，nil 表示無規則
+func (r *CacheRule) rewriteLinks() bool {
+	return r != nil && r.RewriteLinks
+}
+
+// cacheable 檢查規則是否允許寫入快取，nil 表示無規則
+func (r *CacheRule) cacheable() bool {
+	return r == nil || !r.NoCache
+}
+
+// revalidate 檢查規則是否要求每次重新驗證，nil 表示無規則
+func (r *CacheRule) revalidate() bool {
+	return r != nil && r.Revalidate
+}
+
+// CacheRuleFor 返回第一條匹配 key 的規則，無匹配時返回 nil
+func (c *Config) CacheRuleFor(key string) *CacheRule {
+	for i := range c.CacheRules {
+		if c.CacheRules[i].Match(key) {
+			return &c.CacheRules[i]
+		}
+	}
+	return nil
+}
+
+// notFoundTTL 返回規則的 404 快取時間，rule 為 nil 時使用 NotFoundCacheTTL
+func (c *Config) notFoundTTL(rule *CacheRule) time.Duration {
+	if rule != nil && rule.NotFoundTTL > 0 {
+		return rule.NotFoundTTL
+	}
+	return c.NotFoundCacheTTL
+}
+
+// negativeTTL 返回上游狀態碼的負快取時間，0 表示不快取
+func (c *Config) negativeTTL(rule *CacheRule, status int) time.Duration {
+	switch {
+	case status == http.StatusNotFound:
+		return c.notFoundTTL(rule)
+	case status == http.StatusForbidden:
+		return c.ForbiddenCacheTTL
+	case status == http.StatusGone:
+		return c.GoneCacheTTL
+	case status >= 500:
+		return c.ServerErrorCacheTTL
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+package fileproxy
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const scanTarpitMax = 256 // 同時延遲回應的請求數上限，超過時立即返回 429，避免佔用過多連線
+
+// scanClient 單一客戶端 IP 的 404 計數與封鎖狀態
+type scanClient struct {
+	misses       float64   // 以 limit/window 速率衰減的 404 計數
+	last         time.Time // 上次更新計數的時間
+	blockedUntil time.Time
+}
+
+// scanShard 一個分片的客戶端狀態
+type scanShard struct {
+	mu      sync.Mutex
+	clients map[string]*scanClient
+}
+
+// scanGuard 偵測大量請求不存在路徑的客戶端並暫時封鎖，避免經由代理列舉上游內容
+//
+// 每個 IP 的 404 與 410 計數以 limit/window 的速率衰減（漏桶），超過 limit 時封鎖 blockFor，
+// 期間該 IP 的所有代理請求返回 429；設定 tarpit 時先延遲再回應，拖慢掃描器。
+type scanGuard struct {
+	limit    float64
+	window   time.Duration
+	blockFor time.Duration
+	tarpit   time.Duration
+	shards   [rateLimitShards]scanShard
+
+	tarpitting atomic.Int64
+	blocks     atomic.Int64 // 觸發封鎖的次數
+	rejected   atomic.Int64 // 封鎖期間拒絕的請求數
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newScanGuard 建立掃描防護，未啟用時返回 nil
+func newScanGuard(cfg *Config) *scanGuard {
+	if cfg.ScanNotFoundLimit <= 0 {
+		return nil
+	}
+	g := &scanGuard{
+		limit:    float64(cfg.ScanNotFoundLimit),
+		window:   cfg.ScanWindow,
+		blockFor: cfg.ScanBlockFor,
+		tarpit:   cfg.ScanTarpit,
+		closeCh:  make(chan struct{}),
+	}
+	for i := range g.shards {
+		g.shards[i].clients = make(map[string]*scanClient)
+	}
+	g.wg.Add(1)
+	go g.cleanupLoop()
+	return g
+}
+
+// Close 停止清理 goroutine
+func (g *scanGuard) Close() {
+	if g == nil {
+		return
+	}
+	close(g.closeCh)
+	g.wg.Wait()
+}
+
+// Reject 客戶端在封鎖期間時以 429 回應（設定 tarpit 時先延遲），返回是否已回應
+func (g *scanGuard) Reject(w http.ResponseWriter, r *http.Request) bool {
+	if g == nil {
+		return false
+	}
+	ip := remoteIP(r)
+	s := &g.shards[shardIndex(ip)]
+	now := time.Now()
+	s.mu.Lock()
+	var until time.Time
+	if c, ok := s.clients[ip]; ok {
+		until = c.blockedUntil
+	}
+	s.mu.Unlock()
+	if !now.Before(until) {
+		return false
+	}
+
+	g.rejected.Add(1)
+	if g.tarpit > 0 {
+		if g.tarpitting.Add(1) <= scanTarpitMax {
+			timer := time.NewTimer(g.tarpit)
+			select {
+			case <-r.Context().Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		g.tarpitting.Add(-1)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(until.Sub(now).Round(time.Second).Seconds()), 1)))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return true
+}
+
+// Writer 包裝回應，返回不存在的狀態時計入客戶端的 404 數
+func (g *scanGuard) Writer(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if g == nil {
+		return w
+	}
+	return &scanWriter{ResponseWriter: w, guard: g, ip: remoteIP(r)}
+}
+
+// observe 記錄一次 404，超過上限時開始封鎖
+func (g *scanGuard) observe(ip string) {
+	s := &g.shards[shardIndex(ip)]
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[ip]
+	if !ok {
+		c = &scanClient{last: now}
+		s.clients[ip] = c
+	}
+	c.misses = max(c.misses-now.Sub(c.last).Seconds()*g.limit/g.window.Seconds(), 0) + 1
+	c.last = now
+	if c.misses > g.limit && !now.Before(c.blockedUntil) {
+		c.misses = 0
+		c.blockedUntil = now.Add(g.blockFor)
+		g.blocks.Add(1)
+		slog.Warn("client blocked for key enumeration", "remote", ip, "limit", g.limit, "window", g.window, "block_for", g.blockFor)
+	}
+}
+
+// cleanupLoop 定期清除計數已衰減且未封鎖的客戶端
+func (g *scanGuard) cleanupLoop() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(max(g.window, rateLimitIdleTTL))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.closeCh:
+			return
+		case now := <-ticker.C:
+			for i := range g.shards {
+				s := &g.shards[i]
+				s.mu.Lock()
+				for ip, c := range s.clients {
+					if now.Sub(c.last) > g.window && !now.Before(c.blockedUntil) {
+						delete(s.clients, ip)
+					}
+				}
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Stats 返回封鎖統計
+func (g *scanGuard) Stats() map[string]any {
+	clients, blocked := 0, 0
+	now := time.Now()
+	for i := range g.shards {
+		s := &g.shards[i]
+		s.mu.Lock()
+		clients += len(s.clients)
+		for _, c := range s.clients {
+			if now.Before(c.blockedUntil) {
+				blocked++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return map[string]any{
+		"clients":  clients,
+		"blocked":  blocked,
+		"blocks":   g.blocks.Load(),
+		"rejected": g.rejected.Load(),
+	}
+}
+
+// scanWriter 記錄回應狀態，404 與 410 計入掃描防護
+type scanWriter struct {
+	http.ResponseWriter
+	guard   *scanGuard
+	ip      string
+	written bool
+}
+
+func (s *scanWriter) WriteHeader(status int) {
+	if !s.written {
+		s.written = true
+		if status == http.StatusNotFound || status == http.StatusGone {
+			s.guard.observe(s.ip)
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *scanWriter) Write(p []byte) (int, error) {
+	s.written = true
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush 轉交給底層回應，保持串流即時送出
+func (s *scanWriter) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 取得底層回應
+func (s *scanWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
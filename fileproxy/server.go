@@ -2,11 +2,16 @@ package fileproxy
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -15,96 +20,338 @@ import (
 type Server struct {
 	config     *Config
 	proxy      *Proxy
+	tls        *tlsTuning
+	accessLog  *accessLogger
 	httpServer *http.Server
+	adminHTTP  *http.Server // 設定 AdminListenAddr 時的管理監聽，否則為 nil
+
+	inherited *inheritedListeners     // 從舊程序或 systemd 繼承、尚未取用的監聽
+	listeners map[string]net.Listener // 服務中的監聽，交接時交給新程序
+	notifier  *systemdNotifier        // 由 systemd 以 Type=notify 啟動時回報狀態，否則為 nil
 }
 
 // NewServer 建立伺服器實例
 func NewServer(cfg *Config) (*Server, error) {
+	tuning, err := newTLSTuning(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	accessLog, err := newAccessLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	proxy, err := NewProxy(cfg)
 	if err != nil {
+		if accessLog != nil {
+			accessLog.Close()
+		}
 		return nil, err
 	}
 
 	mux := http.NewServeMux()
-	server := &Server{config: cfg, proxy: proxy}
+	server := &Server{config: cfg, proxy: proxy, tls: tuning, accessLog: accessLog}
 
-	mux.HandleFunc("/health", server.handleHealth)
-	mux.HandleFunc("/stats", server.handleStats)
-	mux.Handle("/", proxy)
+	// 節點間的複製與摘要端點由對等節點經代理地址呼叫，留在資料面
+	mux.HandleFunc(replicatePrefix+"/", server.requireAdmin(proxy.handleReplicate))
+	mux.HandleFunc(standbyIndexPath, server.requireAdmin(proxy.handleStandbyIndex))
+	if proxy.peers != nil {
+		mux.HandleFunc(digestPath, server.requireAdmin(proxy.handleDigest))
+	}
+	mux.HandleFunc("/", server.handleProxy)
+
+	adminMux := mux
+	if cfg.AdminListenAddr != "" {
+		adminMux = http.NewServeMux()
+	}
+	server.registerAdminRoutes(adminMux)
 
 	server.httpServer = &http.Server{
-		Addr:         cfg.ListenAddr,
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: cfg.UpstreamTimeout + 30*time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:           cfg.ListenAddr,
+		Handler:        server.wrap(mux),
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		ReadTimeout:    30 * time.Second,
+		WriteTimeout:   cfg.UpstreamTimeout + 30*time.Second,
+		IdleTimeout:    120 * time.Second,
+	}
+	if cfg.AdminListenAddr != "" {
+		server.adminHTTP = &http.Server{
+			Addr:           cfg.AdminListenAddr,
+			Handler:        server.wrap(applyMiddleware(cfg.AdminMiddleware, adminMux)),
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
+			ReadTimeout:    30 * time.Second,
+			WriteTimeout:   time.Minute,
+			IdleTimeout:    120 * time.Second,
+		}
+	}
+	if proxy.readahead != nil {
+		server.httpServer.ConnContext = proxy.readahead.ConnContext
+		server.httpServer.ConnState = proxy.readahead.ConnState
+	}
+	if tuning != nil {
+		server.httpServer.TLSConfig = tuning.config
+		if server.adminHTTP != nil {
+			server.adminHTTP.TLSConfig = tuning.config
+		}
 	}
 
 	return server, nil
 }
 
+// registerAdminRoutes 註冊健康檢查、統計與管理端點
+//
+// 管理端點使用獨立監聽時，/stats、/metrics 與面板數據在設定 Token 後同樣需要驗證，
+// 資料面只提供代理內容。/health 一律不需要驗證，供存活探測使用。
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	proxy := s.proxy
+	stats, data, metrics := s.handleStats, proxy.dashboard.handleData, proxy.handleMetrics
+	if s.config.AdminListenAddr != "" {
+		stats, data, metrics = s.requireToken(stats), s.requireToken(data), s.requireToken(metrics)
+	}
+
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/stats", stats)
+	mux.HandleFunc(metricsPath, metrics)
+	mux.Handle("/dashboard/", proxy.dashboard.assetHandler())
+	mux.HandleFunc("/dashboard/data", data)
+	if proxy.uploader != nil {
+		mux.HandleFunc(uploadsPath, s.requireAdmin(proxy.handleUploads))
+	}
+	mux.HandleFunc(expiryReportPath, s.requireAdmin(proxy.handleExpiryReport))
+	mux.HandleFunc(purgePrefix+"/", s.requireAdmin(proxy.handlePurge))
+	mux.HandleFunc(invalidatePath, s.requireAdmin(proxy.handleInvalidate))
+	mux.HandleFunc(provenancePrefix+"/", s.requireAdmin(proxy.handleProvenance))
+	mux.HandleFunc(objectsPrefix+"/", s.requireAdmin(proxy.handleObjects))
+	mux.HandleFunc(cacheEntriesPath, s.requireAdmin(proxy.handleCacheEntries))
+	mux.HandleFunc(offlinePath, s.requireAdmin(proxy.handleOffline))
+	mux.HandleFunc(quotasPath, s.requireAdmin(proxy.handleQuotas))
+	mux.HandleFunc(quotasPath+"/", s.requireAdmin(proxy.handleQuotas))
+	mux.HandleFunc(tagsPath, s.requireAdmin(proxy.handleTags))
+	mux.HandleFunc(tagsPath+"/", s.requireAdmin(proxy.handleTags))
+	mux.HandleFunc(debugGoroutinesPath, s.requireAdmin(proxy.handleDebugGoroutines))
+	if proxy.prefetcher != nil {
+		mux.HandleFunc(prefetchPath, s.requireAdmin(proxy.handlePrefetch))
+		mux.HandleFunc(prefetchPath+"/", s.requireAdmin(proxy.handlePrefetch))
+	}
+	mux.HandleFunc(standbyPromotePath, s.requireAdmin(proxy.handleStandbyPromote))
+	if s.config.SnapshotDir != "" {
+		mux.HandleFunc(snapshotPath, s.requireAdmin(proxy.handleSnapshot))
+	}
+	s.registerFaultRoutes(mux)
+}
+
+// wrap 套用請求驗證與存取日誌
+func (s *Server) wrap(next http.Handler) http.Handler {
+	handler := s.hardenRequest(next)
+	if s.accessLog != nil {
+		handler = s.accessLog.middleware(handler)
+	}
+	return handler
+}
+
 // handleHealth 健康檢查端點
+//
+// 快取故障降級時仍返回 200（請求照常透傳），以 status 與 reason 提示
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]string{"status": "ok"}
+	if degraded, reason := s.proxy.guard.Health(); degraded {
+		health["status"] = "degraded"
+		health["reason"] = reason
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(health)
 }
 
 // handleStats 統計資訊端點
+//
+// ?version=2 返回分節的 Stats，未指定時返回版本 1 的扁平格式。
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.proxy.Stats()
+	if s.tls != nil {
+		stats.Components["tls"] = s.tls.Stats()
+	}
+
+	var body any
+	switch version := r.URL.Query().Get("version"); version {
+	case "", "1":
+		body = stats.legacy()
+	case strconv.Itoa(StatsVersion):
+		body = stats
+	default:
+		http.Error(w, fmt.Sprintf("unsupported stats version %q", version), http.StatusBadRequest)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.proxy.Stats())
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleProxy 分派代理請求，PUT 上傳需要管理 Token
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut && s.proxy.uploader != nil {
+		ctx, span := s.proxy.tracing.startRequest(r)
+		defer span.End()
+		s.requireAdmin(s.proxy.handleUpload)(w, r.WithContext(ctx))
+		return
+	}
+	s.proxy.ServeHTTP(w, r)
+}
+
+// requireAdmin 檢查管理端點的 Bearer Token
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		s.requireToken(next)(w, r)
+	}
+}
+
+// requireToken 設定管理 Token 時檢查 Bearer Token，未設定時直接放行
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
 }
 
 // Start 啟動伺服器
+//
+// 收到 SIGUSR2 時以相同參數啟動新的執行檔並交出監聽 socket，新程序接手後本程序優雅關閉；
+// 交接失敗時繼續服務。
 func (s *Server) Start() error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	if upgradeSignal != nil {
+		signal.Notify(sigCh, upgradeSignal)
+	}
 
-	errCh := make(chan error, 1)
-	useTLS := s.config.TLSCertFile != "" && s.config.TLSKeyFile != ""
+	if err := s.openListeners(); err != nil {
+		return err
+	}
 
-	go func() {
-		slog.Info("server started",
-			"addr", s.config.ListenAddr,
-			"upstream", s.config.UpstreamURL,
-			"cache_dir", s.config.CacheDir,
-			"max_cache_gb", float64(s.config.MaxCacheSize)/(1<<30),
-			"tls", useTLS,
-		)
-
-		var err error
-		if useTLS {
-			err = s.httpServer.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
-		} else {
-			err = s.httpServer.ListenAndServe()
+	errCh := make(chan error, 2)
+	useTLS := s.tls != nil
+
+	slog.Info("server started",
+		"addr", s.config.ListenAddr,
+		"admin_addr", s.config.AdminListenAddr,
+		"upstream", s.config.UpstreamURL,
+		"routes", len(s.config.Routes),
+		"cache_dir", s.config.CacheDir,
+		"max_cache_gb", float64(s.config.MaxCacheSize)/(1<<30),
+		"tls", useTLS,
+	)
+	go s.serve(s.httpServer, s.listeners[listenerProxy], useTLS, errCh)
+	if s.adminHTTP != nil {
+		go s.serve(s.adminHTTP, s.listeners[listenerAdmin], useTLS, errCh)
+	}
+	s.notifier.Ready("serving on " + s.config.ListenAddr)
+
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case sig := <-sigCh:
+			if sig == upgradeSignal {
+				pid, err := s.upgrade()
+				if err != nil {
+					slog.Error("handoff failed, continuing to serve", "error", err)
+					continue
+				}
+				slog.Info("listeners handed off to new process, shutting down", "pid", pid)
+				s.notifier.HandOff(pid)
+				return s.Shutdown()
+			}
+			slog.Info("shutting down", "signal", sig)
+			s.notifier.Stopping()
+			return s.Shutdown()
 		}
-		if err != nil && err != http.ErrServerClosed {
-			errCh <- err
+	}
+}
+
+// openListeners 取用繼承的監聽，沒有或地址不符時依 socket 配置建立
+func (s *Server) openListeners() error {
+	defer s.inherited.Close()
+	s.listeners = make(map[string]net.Listener, 2)
+	addrs := map[string]string{listenerProxy: s.httpServer.Addr}
+	if s.adminHTTP != nil {
+		addrs[listenerAdmin] = s.adminHTTP.Addr
+	}
+	for name, addr := range addrs {
+		if ln, ok := s.inherited.take(name, addr); ok {
+			slog.Info("using inherited listener", "listener", name, "addr", ln.Addr())
+			s.listeners[name] = s.tuneListener(ln)
+			continue
 		}
-	}()
+		ln, err := s.listen(addr)
+		if err != nil {
+			for _, ln := range s.listeners {
+				ln.Close()
+			}
+			return err
+		}
+		s.listeners[name] = ln
+	}
+	return nil
+}
 
-	select {
-	case err := <-errCh:
-		return err
-	case sig := <-sigCh:
-		slog.Info("shutting down", "signal", sig)
-		return s.Shutdown()
+// serve 在背景執行單一監聽，異常結束時送出錯誤
+func (s *Server) serve(srv *http.Server, ln net.Listener, useTLS bool, errCh chan<- error) {
+	var err error
+	if useTLS {
+		err = srv.ServeTLS(ln, s.config.TLSCertFile, s.config.TLSKeyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		errCh <- err
 	}
 }
 
 // Shutdown 優雅關閉伺服器
+//
+// 停止接受新連線後等待進行中的下載完成並寫入快取，最多 ShutdownTimeout；期限到時中止剩餘的下載，
+// 再等待請求結束最多 shutdownGrace，之後才關閉快取索引。
 func (s *Server) Shutdown() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer cancel()
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout+shutdownGrace)
+	defer httpCancel()
 
-	if err := s.httpServer.Shutdown(ctx); err != nil {
-		slog.Error("http shutdown error", "error", err)
-	}
+	s.proxy.Drain()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.httpServer.Shutdown(httpCtx); err != nil {
+			slog.Error("http shutdown error", "error", err)
+		}
+		if s.adminHTTP != nil {
+			if err := s.adminHTTP.Shutdown(httpCtx); err != nil {
+				slog.Error("admin http shutdown error", "error", err)
+			}
+		}
+	}()
+	s.proxy.cache.WaitFills(ctx)
+	<-done
 
 	if err := s.proxy.Close(); err != nil {
 		slog.Error("proxy shutdown error", "error", err)
 	}
 
+	if s.accessLog != nil {
+		if err := s.accessLog.Close(); err != nil {
+			slog.Error("close access log error", "error", err)
+		}
+	}
+
 	slog.Info("server stopped")
 	return nil
 }
@@ -115,10 +362,32 @@ func Run(cfg *Config) error {
 		return err
 	}
 
+	// 等待快取索引期間（包括交接時等待舊程序關閉）也送出看門狗訊號
+	notifier := newSystemdNotifier()
+	defer notifier.Close()
+
+	inherited, err := inheritListeners()
+	if err != nil {
+		return err
+	}
+	// 舊程序結束前寫入，systemd 依 PIDFile= 改為追蹤新程序
+	if err := writePIDFile(cfg.PIDFile); err != nil {
+		inherited.Close()
+		return err
+	}
+	if inherited.handoff() {
+		// 舊程序收到通知後才開始關閉並釋放快取索引鎖，因此先通知再等待索引
+		cfg.IndexLockTimeout = max(cfg.IndexLockTimeout, cfg.ShutdownTimeout+shutdownGrace+handoffIndexWait)
+		inherited.Ready()
+	}
+
 	server, err := NewServer(cfg)
 	if err != nil {
+		inherited.Close()
 		return err
 	}
+	server.inherited = inherited
+	server.notifier = notifier
 
 	return server.Start()
 }
@@ -0,0 +1,275 @@
+package fileproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// 影子策略的准入條件，以 + 附加在淘汰策略之後
+const (
+	shadowSecondHit = "second-hit" // 只寫入第二次未命中的內容
+	shadowMaxPrefix = "max="       // 只寫入不超過此大小的內容
+)
+
+const shadowGhostSize = 1 << 16 // second-hit 記住只未命中過一次的雜湊數
+
+// 觀察到的實際結果
+const (
+	shadowHit     = iota // 實際快取命中
+	shadowMiss           // 實際未命中並寫入快取
+	shadowRefresh        // 已有條目過期或被取代後重新寫入，對所有策略都是未命中
+)
+
+// shadowSpec 影子策略：淘汰策略加上准入條件
+type shadowSpec struct {
+	policy    string
+	secondHit bool
+	maxSize   int64 // 0 表示不限制
+}
+
+// parseShadowPolicy 解析 POLICY[+second-hit][+max=SIZE]，例如 gdsf+max=1GB
+func parseShadowPolicy(s string) (shadowSpec, error) {
+	parts := strings.Split(strings.TrimSpace(s), "+")
+	spec := shadowSpec{policy: parts[0]}
+	if !validEvictionPolicy(spec.policy) {
+		return spec, fmt.Errorf("shadow policy %q: eviction policy must be one of %s", s, strings.Join(evictionPolicies, ", "))
+	}
+	for _, part := range parts[1:] {
+		switch {
+		case part == shadowSecondHit:
+			spec.secondHit = true
+		case strings.HasPrefix(part, shadowMaxPrefix):
+			size, err := parseByteSize(strings.TrimPrefix(part, shadowMaxPrefix))
+			if err != nil || size == 0 {
+				return spec, fmt.Errorf("shadow policy %q: invalid max size", s)
+			}
+			spec.maxSize = size
+		default:
+			return spec, fmt.Errorf("shadow policy %q: unknown admission %q", s, part)
+		}
+	}
+	return spec, nil
+}
+
+// shadowCounters 一組觀察的命中計數
+type shadowCounters struct {
+	lookups  int64
+	hits     int64
+	bytes    int64
+	byteHits int64
+}
+
+// shadowCache 以單一影子策略模擬同容量的快取，只保存雜湊與大小
+type shadowCache struct {
+	name   string
+	spec   shadowSpec
+	active bool // 與目前的淘汰策略相同且沒有准入條件，作為比較基準
+	idx    *entryIndex
+	used   int64
+	ghost  *simplelru.LRU[keyHash, struct{}] // second-hit 只未命中過一次的雜湊
+
+	shadowCounters
+	admitted  int64
+	rejected  int64
+	evictions int64
+	evicted   int // 本次觀察淘汰的條目數，寫入日誌用
+}
+
+// shadowPolicies 影子策略分析：以實際的命中與寫入驅動各影子策略，估計改用其他策略時的命中率
+//
+// 影子只模擬容量淘汰，不模擬過期；過期後重新下載對所有策略都計為未命中。啟動時以索引中的條目
+// 依存取順序填入，與實際快取的起點相同。每個影子的每個條目約佔 200 位元組記憶體。
+type shadowPolicies struct {
+	capacity int64
+
+	mu      sync.Mutex
+	shadows []*shadowCache
+	actual  shadowCounters
+}
+
+// newShadowPolicies 建立影子策略分析，未設定 ShadowPolicies 時返回 nil；目前的淘汰策略一律作為基準加入
+func newShadowPolicies(cfg *Config) *shadowPolicies {
+	if len(cfg.ShadowPolicies) == 0 {
+		return nil
+	}
+	sp := &shadowPolicies{capacity: cfg.MaxCacheSize}
+	names := append([]string{cfg.EvictionPolicy}, cfg.ShadowPolicies...)
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		spec, err := parseShadowPolicy(name)
+		if err != nil || seen[name] {
+			continue // 已由 Validate 檢查，這裡只略過重複的名稱
+		}
+		seen[name] = true
+		s := &shadowCache{name: name, spec: spec, active: i == 0}
+		s.idx = newEntryIndex(spec.policy, func(e *CacheEntry, reason string) {
+			s.used -= e.Size
+			if reason == removeCapacity {
+				s.evictions++
+				s.evicted++
+			}
+		})
+		if spec.secondHit {
+			s.ghost, _ = simplelru.NewLRU[keyHash, struct{}](shadowGhostSize, nil)
+		}
+		sp.shadows = append(sp.shadows, s)
+	}
+	return sp
+}
+
+// seed 以啟動時載入的條目填入所有影子，不計入命中統計
+func (sp *shadowPolicies) seed(hash keyHash, size int64) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for _, s := range sp.shadows {
+		s.admit(hash, size, sp.capacity)
+	}
+}
+
+// observe 記錄一次實際的命中或寫入並驅動所有影子；寫入時記錄各影子策略的處理結果
+func (sp *shadowPolicies) observe(key string, hash keyHash, size int64, kind int) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.actual.lookups++
+	sp.actual.bytes += size
+	if kind == shadowHit {
+		sp.actual.hits++
+		sp.actual.byteHits += size
+	}
+	var attrs []any
+	if kind != shadowHit {
+		attrs = make([]any, 0, 4+2*len(sp.shadows))
+		attrs = append(attrs, "key", key, "size", size)
+	}
+	for _, s := range sp.shadows {
+		outcome := s.observe(hash, size, kind, sp.capacity)
+		if attrs != nil {
+			attrs = append(attrs, s.name, outcome)
+		}
+	}
+	sp.mu.Unlock()
+	if attrs != nil {
+		slog.Info("shadow policies", attrs...)
+	}
+}
+
+// observe 依實際結果更新影子，返回影子的處理結果：hit、admit（含淘汰數）或 reject
+func (s *shadowCache) observe(hash keyHash, size int64, kind int, capacity int64) string {
+	s.lookups++
+	s.bytes += size
+	if kind != shadowRefresh {
+		if _, ok := s.idx.Get(hash); ok {
+			s.hits++
+			s.byteHits += size
+			return "hit"
+		}
+	}
+	if !s.admits(hash, size, capacity) {
+		s.rejected++
+		return "reject"
+	}
+	s.admitted++
+	s.evicted = 0
+	s.admit(hash, size, capacity)
+	if s.evicted > 0 {
+		return fmt.Sprintf("admit evicted=%d", s.evicted)
+	}
+	return "admit"
+}
+
+// admits 准入條件是否允許寫入；second-hit 第一次未命中時只記住雜湊
+func (s *shadowCache) admits(hash keyHash, size int64, capacity int64) bool {
+	if size > capacity || (s.spec.maxSize > 0 && size > s.spec.maxSize) {
+		return false
+	}
+	if s.ghost == nil {
+		return true
+	}
+	if s.ghost.Remove(hash) {
+		return true
+	}
+	s.ghost.Add(hash, struct{}{})
+	return false
+}
+
+// admit 寫入條目並依影子策略淘汰到不超過容量
+func (s *shadowCache) admit(hash keyHash, size int64, capacity int64) {
+	s.idx.RemoveAs(hash, removeReplaced)
+	if size > capacity {
+		return
+	}
+	s.idx.Add(&CacheEntry{hash: hash, Size: size})
+	s.used += size
+	for s.used > capacity {
+		if !s.idx.RemoveVictim(math.MaxInt64, removeCapacity) {
+			break
+		}
+	}
+}
+
+// ShadowPolicyStats 單一影子策略的估計
+type ShadowPolicyStats struct {
+	Policy       string  `json:"policy"`
+	Active       bool    `json:"active,omitempty"` // 目前的淘汰策略，作為比較基準
+	Entries      int     `json:"entries"`
+	Size         int64   `json:"size"`
+	Hits         int64   `json:"hits"`
+	HitRatio     float64 `json:"hit_ratio"`
+	ByteHitRatio float64 `json:"byte_hit_ratio"`
+	Admitted     int64   `json:"admitted"`
+	Rejected     int64   `json:"rejected"` // 准入條件拒絕寫入的次數
+	Evictions    int64   `json:"evictions"`
+}
+
+// ShadowStats 影子策略分析統計
+type ShadowStats struct {
+	Lookups            int64               `json:"lookups"` // 觀察的命中與寫入數
+	ActualHitRatio     float64             `json:"actual_hit_ratio"`
+	ActualByteHitRatio float64             `json:"actual_byte_hit_ratio"`
+	Policies           []ShadowPolicyStats `json:"policies"`
+}
+
+// ratio 返回 part/total，total 為 0 時返回 0
+func ratio(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+// Stats 返回實際與各影子策略的命中率
+func (sp *shadowPolicies) Stats() ShadowStats {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	st := ShadowStats{
+		Lookups:            sp.actual.lookups,
+		ActualHitRatio:     ratio(sp.actual.hits, sp.actual.lookups),
+		ActualByteHitRatio: ratio(sp.actual.byteHits, sp.actual.bytes),
+	}
+	for _, s := range sp.shadows {
+		st.Policies = append(st.Policies, ShadowPolicyStats{
+			Policy:       s.name,
+			Active:       s.active,
+			Entries:      s.idx.Len(),
+			Size:         s.used,
+			Hits:         s.hits,
+			HitRatio:     ratio(s.hits, s.lookups),
+			ByteHitRatio: ratio(s.byteHits, s.bytes),
+			Admitted:     s.admitted,
+			Rejected:     s.rejected,
+			Evictions:    s.evictions,
+		})
+	}
+	return st
+}
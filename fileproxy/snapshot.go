@@ -0,0 +1,164 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotPath = "/admin/snapshot" // 建立快取快照的端點
+
+// errSnapshotRunning 表示已有快照正在建立
+var errSnapshotRunning = errors.New("snapshot already in progress")
+
+// SnapshotResult 快照建立結果
+type SnapshotResult struct {
+	Dir      string `json:"dir"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	Skipped  int    `json:"skipped"` // 建立期間被淘汰或替換的條目
+	Cloned   int    `json:"cloned"`  // 無法硬連結、以 reflink 複製的條目
+	Duration string `json:"duration"`
+}
+
+// Snapshot 以硬連結將目前的快取凍結到 dir，並複製索引庫與憑證雜湊密鑰
+//
+// 快取檔案寫入完成後不會再被修改（新下載寫入新檔案後改名），硬連結可安全共用，
+// 耗時只與條目數有關，與快取大小無關。dir 必須不存在且與快取目錄位於同一檔案系統；無法硬連結時
+// （例如 Btrfs 的不同子卷）改以 reflink 複製。
+// 產生的目錄本身就是合法的快取目錄，可直接以 --cache-dir 啟動另一個實例提供凍結的內容。
+func (c *Cache) Snapshot(dir string) (*SnapshotResult, error) {
+	if !c.snapshotMu.TryLock() {
+		return nil, errSnapshotRunning
+	}
+	defer c.snapshotMu.Unlock()
+
+	start := time.Now()
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+	result, err := c.snapshot(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	return result, nil
+}
+
+// snapshot 先取得條目清單再複製索引，之後連結的檔案都屬於索引中的條目；
+// 索引庫非同步寫入，尚未寫入的條目與連結後才被替換的條目由重啟時的一致性檢查清除
+func (c *Cache) snapshot(dir string) (*SnapshotResult, error) {
+	entries := c.fileCache.Values()
+
+	if err := c.store.Snapshot(filepath.Join(dir, storeFileName)); err != nil {
+		return nil, err
+	}
+	salt, err := os.ReadFile(filepath.Join(c.config.CacheDir, authSaltFile))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, authSaltFile), salt, 0600)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("copy auth salt: %w", err)
+	}
+
+	result := &SnapshotResult{Dir: dir}
+	for _, entry := range entries {
+		linked, cloned, err := c.linkEntry(entry, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !linked {
+			result.Skipped++
+			continue
+		}
+		if cloned {
+			result.Cloned++
+		}
+		result.Entries++
+		result.Bytes += entry.Size
+	}
+	return result, nil
+}
+
+// linkEntry 將條目的快取檔案硬連結到快照目錄，無法連結時以 reflink 複製；條目已被淘汰或替換時返回 false
+func (c *Cache) linkEntry(entry *CacheEntry, dir string) (linked, cloned bool, err error) {
+	file, release, err := c.open(entry)
+	if errors.Is(err, errEntryEvicted) || errors.Is(err, fs.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	defer release()
+
+	rel, err := filepath.Rel(c.config.CacheDir, c.pathFor(entry.hash))
+	if err != nil {
+		return false, false, err
+	}
+	target := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, false, fmt.Errorf("create snapshot directory: %w", err)
+	}
+	if err := os.Link(file.Name(), target); err != nil {
+		// 複製的是已開啟的檔案，不受路徑上的替換影響
+		if cloneToFile(file, target) != nil {
+			return false, false, fmt.Errorf("link cache file: %w", err)
+		}
+		return true, true, nil
+	}
+
+	// 開啟後檔案可能被改名保留，路徑上已是新下載的內容
+	opened, err1 := file.Stat()
+	stat, err2 := os.Stat(target)
+	if err1 != nil || err2 != nil || !os.SameFile(opened, stat) {
+		os.Remove(target)
+		return false, false, nil
+	}
+	return true, false, nil
+}
+
+// handleSnapshot 建立快取快照，?name= 指定快照目錄名稱，預設為目前時間
+func (p *Proxy) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = time.Now().UTC().Format("20060102-150405")
+	}
+	if !filepath.IsLocal(name) || filepath.Base(name) != name {
+		http.Error(w, "invalid snapshot name", http.StatusBadRequest)
+		return
+	}
+
+	result, err := p.cache.Snapshot(filepath.Join(p.config.SnapshotDir, name))
+	switch {
+	case errors.Is(err, errSnapshotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, fs.ErrExist):
+		http.Error(w, "snapshot already exists", http.StatusConflict)
+		return
+	case err != nil:
+		slog.Error("cache snapshot failed", "dir", name, "error", err)
+		http.Error(w, "snapshot failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("cache snapshot created", "dir", result.Dir, "entries", result.Entries,
+		"bytes", result.Bytes, "skipped", result.Skipped, "duration", result.Duration)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,504 @@
+package fileproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SoakOptions 長時間壓力測試的參數
+type SoakOptions struct {
+	Duration       time.Duration // 總執行時間
+	Cycle          time.Duration // 每輪施加負載的時間，之後停止負載並檢查不變量
+	Clients        int           // 並發客戶端數
+	Keys           int           // 假上游提供的路徑數
+	MaxObjectSize  int64         // 單一物件的最大大小，大小以對數均勻分佈，小檔案居多
+	MaxCacheSize   int64         // 快取容量，小於物件總和時持續觸發淘汰
+	FaultRate      float64       // 假上游每個請求注入故障的機率（0~1）
+	GoroutineSlack int           // 相對第一輪容許增加的 goroutine 數
+	DrainTimeout   time.Duration // 停止負載後等待背景下載完成的時間
+	CacheDir       string        // 快取目錄，為空時使用暫存目錄並在結束時刪除
+	DiagDir        string        // 違反不變量時寫入診斷資料的目錄
+}
+
+// Soak 以注入故障的假上游長時間驅動代理，每輪負載後檢查不變量
+//
+// 每輪結束時停止負載、等待背景下載完成，再檢查沒有殘留的下載與鎖、totalSize 與索引及磁碟一致、
+// 沒有殘留的暫存與保留檔案、goroutine 數沒有持續增長。負載期間每個回應都與預期內容比對。
+// 違反任一不變量時將 goroutine、heap、統計與違反項目寫入 DiagDir 並返回錯誤；ctx 結束時正常返回。
+func Soak(ctx context.Context, opts SoakOptions) error {
+	if opts.Clients <= 0 || opts.Keys <= 0 || opts.MaxObjectSize <= 0 || opts.Cycle <= 0 {
+		return fmt.Errorf("soak: clients, keys, max object size and cycle must be positive")
+	}
+	cacheDir := opts.CacheDir
+	if cacheDir == "" {
+		dir, err := os.MkdirTemp("", "fileproxy-soak-")
+		if err != nil {
+			return fmt.Errorf("create soak cache directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		cacheDir = dir
+	}
+
+	upstream := httptest.NewServer(&soakUpstream{opts: opts})
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.UpstreamURL = upstream.URL
+	cfg.CacheDir = cacheDir
+	cfg.MaxCacheSize = opts.MaxCacheSize
+	cfg.NotFoundCacheTTL = time.Second
+	cfg.UpstreamTimeout = 30 * time.Second
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		return err
+	}
+	defer server.proxy.Close()
+	front := httptest.NewServer(server.httpServer.Handler)
+	defer front.Close()
+
+	s := &soakRun{
+		opts:     opts,
+		proxy:    server.proxy,
+		front:    front,
+		upstream: upstream,
+		client:   &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.Clients}},
+	}
+	slog.Info("soak started", "duration", opts.Duration, "cycle", opts.Cycle, "clients", opts.Clients,
+		"keys", opts.Keys, "fault_rate", opts.FaultRate, "cache_dir", cacheDir)
+
+	deadline := time.Now().Add(opts.Duration)
+	for cycle := 1; time.Now().Before(deadline); cycle++ {
+		end := time.Now().Add(opts.Cycle)
+		if end.After(deadline) {
+			end = deadline
+		}
+		loadCtx, cancel := context.WithDeadline(ctx, end)
+		s.proxy.faults.randomize(opts.FaultRate)
+		s.load(loadCtx)
+		cancel()
+		s.proxy.faults.clear()
+		if ctx.Err() != nil {
+			slog.Info("soak interrupted", "cycle", cycle)
+			return nil
+		}
+
+		if violations := s.check(); len(violations) > 0 {
+			for _, v := range violations {
+				slog.Error("soak invariant violated", "cycle", cycle, "violation", v)
+			}
+			dir, err := s.dump(violations)
+			if err != nil {
+				slog.Error("write soak diagnostics failed", "error", err)
+			}
+			return fmt.Errorf("soak: %d invariant violations in cycle %d, diagnostics in %s", len(violations), cycle, dir)
+		}
+		stats := s.proxy.cache.Stats()
+		slog.Info("soak cycle passed", "cycle", cycle, "requests", s.requests.Load(), "errors", s.errors.Load(),
+			"entries", stats.FileEntries, "total_size", stats.TotalSize, "goroutines", s.goroutines)
+	}
+	slog.Info("soak finished", "requests", s.requests.Load(), "errors", s.errors.Load())
+	return nil
+}
+
+// soakRun 一次 soak 測試的狀態
+type soakRun struct {
+	opts     SoakOptions
+	proxy    *Proxy
+	front    *httptest.Server
+	upstream *httptest.Server
+	client   *http.Client
+
+	requests   atomic.Int64
+	errors     atomic.Int64 // 注入的故障造成的錯誤回應與中斷，不算違反
+	violatedMu sync.Mutex
+	violated   []string // 負載期間發現的違反，例如與預期內容不符的回應
+	goroutines int      // 第一輪檢查時的 goroutine 數，之後作為基準
+}
+
+// load 以 Clients 個客戶端施加負載直到 ctx 結束
+func (s *soakRun) load(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range s.opts.Clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(i)))
+			zipf := rand.NewZipf(rng, 1.1, 1, uint64(s.opts.Keys-1))
+			for ctx.Err() == nil {
+				s.request(ctx, rng, int(zipf.Uint64()))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// request 發出一個隨機類型的請求並比對回應內容
+func (s *soakRun) request(ctx context.Context, rng *rand.Rand, id int) {
+	s.requests.Add(1)
+	obj := soakObjectFor(id, s.opts.MaxObjectSize)
+	key := "/obj/" + strconv.Itoa(id)
+
+	method, start, end, abandon := http.MethodGet, int64(-1), int64(-1), false
+	switch n := rng.IntN(100); {
+	case n < 1:
+		s.proxy.purge(key, false)
+		return
+	case n < 20 && obj.size > 0:
+		start = rng.Int64N(obj.size)
+		end = start + rng.Int64N(obj.size-start)
+	case n < 30:
+		method = http.MethodHead
+	case n < 40:
+		abandon = true
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(reqCtx, method, s.front.URL+key, nil)
+	if start >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.errors.Add(1)
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	offset := int64(0)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.ContentLength >= 0 && resp.ContentLength != obj.size {
+			s.violation("%s %s: content length %d, want %d", method, key, resp.ContentLength, obj.size)
+			return
+		}
+	case http.StatusPartialContent:
+		var total int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &offset, new(int64), &total); err != nil || total != obj.size {
+			s.violation("GET %s: bad content range %q for size %d", key, resp.Header.Get("Content-Range"), obj.size)
+			return
+		}
+	default:
+		s.errors.Add(1)
+		return
+	}
+	if method == http.MethodHead {
+		return
+	}
+
+	// 逐塊比對，故障造成的中斷只計數，已收到的內容仍須正確
+	buf := make([]byte, 32<<10)
+	want := make([]byte, len(buf))
+	limit := int64(math.MaxInt64)
+	if abandon {
+		limit = rng.Int64N(obj.size + 1)
+	}
+	for read := int64(0); read < limit; {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			obj.fill(want[:n], offset)
+			if string(buf[:n]) != string(want[:n]) {
+				s.violation("GET %s: content mismatch at offset %d", key, offset)
+				return
+			}
+			offset += int64(n)
+			read += int64(n)
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				s.errors.Add(1)
+			}
+			return
+		}
+	}
+}
+
+// violation 記錄負載期間發現的違反
+func (s *soakRun) violation(format string, args ...any) {
+	s.violatedMu.Lock()
+	defer s.violatedMu.Unlock()
+	if len(s.violated) < 100 {
+		s.violated = append(s.violated, fmt.Sprintf(format, args...))
+	}
+}
+
+// check 等待背景下載完成後檢查不變量，返回違反的項目
+func (s *soakRun) check() []string {
+	c := s.proxy.cache
+	deadline := time.Now().Add(s.opts.DrainTimeout)
+	for (c.PendingCount() > 0 || s.fetchLocks() > 0) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.violatedMu.Lock()
+	violations := append([]string(nil), s.violated...)
+	s.violatedMu.Unlock()
+
+	if n := c.PendingCount(); n > 0 {
+		violations = append(violations, fmt.Sprintf("%d pending downloads left %s after load stopped", n, s.opts.DrainTimeout))
+	}
+	if n := s.fetchLocks(); n > 0 {
+		violations = append(violations, fmt.Sprintf("%d fetch locks left %s after load stopped", n, s.opts.DrainTimeout))
+	}
+	c.readersMu.Lock()
+	readers := len(c.readers)
+	c.readersMu.Unlock()
+	if readers > 0 {
+		violations = append(violations, fmt.Sprintf("%d entries still have open readers", readers))
+	}
+
+	entries := c.fileCache.Values()
+	var indexed int64
+	for _, e := range entries {
+		indexed += e.Size
+	}
+	total := c.totalSize.Load()
+	if indexed != total {
+		violations = append(violations, fmt.Sprintf("total size %d does not match indexed entries %d", total, indexed))
+	}
+	violations = append(violations, s.checkDisk(entries, total)...)
+
+	// 關閉閒置連線後 goroutine 數應回到基準附近
+	s.client.CloseIdleConnections()
+	s.front.CloseClientConnections()
+	s.upstream.CloseClientConnections()
+	n := runtime.NumGoroutine()
+	for settle := time.Now().Add(5 * time.Second); s.goroutines > 0 && n > s.goroutines+s.opts.GoroutineSlack && time.Now().Before(settle); {
+		time.Sleep(100 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	switch {
+	case s.goroutines == 0:
+		s.goroutines = n
+	case n > s.goroutines+s.opts.GoroutineSlack:
+		violations = append(violations, fmt.Sprintf("goroutines grew from %d to %d", s.goroutines, n))
+	}
+	return violations
+}
+
+// fetchLocks 返回仍在進行的回源數
+func (s *soakRun) fetchLocks() int {
+	n := 0
+	s.proxy.fetchLocks.Range(func(any, any) bool { n++; return true })
+	return n
+}
+
+// checkDisk 比對快取目錄中的檔案與索引
+func (s *soakRun) checkDisk(entries []*CacheEntry, total int64) []string {
+	c := s.proxy.cache
+	indexed := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		indexed[hex.EncodeToString(e.hash[:])] = e.Size
+	}
+	var violations []string
+	var disk int64
+	root := c.config.CacheDir
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("walk %s: %v", path, err))
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == uploadSpoolDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(path) == root {
+			return nil // 索引庫、憑證雜湊密鑰等
+		}
+		name := d.Name()
+		switch {
+		case strings.HasSuffix(name, partFileSuffix):
+			violations = append(violations, "leftover download file "+path)
+		case strings.Contains(name, retiredFileSuffix):
+			violations = append(violations, "leftover retired file "+path)
+		default:
+			size, ok := indexed[name]
+			info, err := d.Info()
+			switch {
+			case !ok:
+				violations = append(violations, "orphan cache file "+path)
+			case err == nil && info.Size() != size:
+				violations = append(violations, fmt.Sprintf("cache file %s is %d bytes, index says %d", path, info.Size(), size))
+			}
+			if err == nil {
+				disk += info.Size()
+			}
+			delete(indexed, name)
+		}
+		return nil
+	})
+	for name := range indexed {
+		violations = append(violations, "indexed entry without cache file "+name)
+	}
+	if disk != total {
+		violations = append(violations, fmt.Sprintf("total size %d does not match %d bytes on disk", total, disk))
+	}
+	return violations
+}
+
+// dump 將違反項目與診斷資料寫入新的目錄，返回目錄路徑
+func (s *soakRun) dump(violations []string) (string, error) {
+	dir := filepath.Join(s.opts.DiagDir, "soak-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return dir, err
+	}
+	stats, _ := json.MarshalIndent(s.proxy.Stats(), "", "  ")
+	var pending strings.Builder
+	c := s.proxy.cache
+	c.pendingMu.RLock()
+	for key, sf := range c.pending {
+		fmt.Fprintf(&pending, "%s\t%d bytes\n", key, sf.Size())
+	}
+	c.pendingMu.RUnlock()
+
+	var errs []error
+	write := func(name string, fn func(io.Writer) error) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err == nil {
+			err = errors.Join(fn(f), f.Close())
+		}
+		errs = append(errs, err)
+	}
+	write("violations.txt", func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(violations, "\n")+"\n")
+		return err
+	})
+	write("goroutines.txt", func(w io.Writer) error { return pprof.Lookup("goroutine").WriteTo(w, 2) })
+	write("heap.pprof", pprof.WriteHeapProfile)
+	write("stats.json", func(w io.Writer) error { _, err := w.Write(stats); return err })
+	write("pending.txt", func(w io.Writer) error { _, err := io.WriteString(w, pending.String()); return err })
+	slog.Info("soak diagnostics written", "dir", dir)
+	return dir, errors.Join(errs...)
+}
+
+// soakObject 假上游的物件，內容由 seed 與位移決定，可比對任意區段
+type soakObject struct {
+	seed uint64
+	size int64
+}
+
+// soakObjectFor 依編號產生物件，大小以對數均勻分佈於 0 到 maxSize
+func soakObjectFor(id int, maxSize int64) soakObject {
+	rng := rand.New(rand.NewPCG(uint64(id), 0x50a4))
+	size := int64(math.Exp(rng.Float64()*math.Log(float64(maxSize)+1))) - 1
+	return soakObject{seed: rng.Uint64(), size: size}
+}
+
+// fill 以 offset 起的內容填滿 p
+func (o soakObject) fill(p []byte, offset int64) {
+	for i := range p {
+		x := o.seed ^ uint64(offset+int64(i))*0x9e3779b97f4a7c15
+		p[i] = byte(x ^ x>>29 ^ x>>47)
+	}
+}
+
+// soakReader 以 io.ReadSeeker 提供物件內容，供 http.ServeContent 處理 Range
+type soakReader struct {
+	obj    soakObject
+	offset int64
+}
+
+func (r *soakReader) Read(p []byte) (int, error) {
+	if r.offset >= r.obj.size {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.obj.size-r.offset)]
+	r.obj.fill(p, r.offset)
+	r.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (r *soakReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.obj.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// soakUpstream 依機率注入故障的假上游：錯誤狀態、連線中斷、本體截斷與慢速傳輸
+type soakUpstream struct {
+	opts SoakOptions
+}
+
+func (u *soakUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/obj/"))
+	if err != nil || id < 0 || id >= u.opts.Keys {
+		http.NotFound(w, r)
+		return
+	}
+	obj := soakObjectFor(id, u.opts.MaxObjectSize)
+	if rand.Float64() < u.opts.FaultRate {
+		switch rand.IntN(5) {
+		case 0:
+			http.Error(w, "fault injected", http.StatusInternalServerError)
+			return
+		case 1:
+			http.NotFound(w, r)
+			return
+		case 2:
+			panic(http.ErrAbortHandler)
+		case 3:
+			w = &soakFaultWriter{ResponseWriter: w, cutAt: rand.Int64N(obj.size + 1)}
+		case 4:
+			w = &soakFaultWriter{ResponseWriter: w, cutAt: -1, delay: 5 * time.Millisecond}
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, obj.seed))
+	http.ServeContent(w, r, "", time.Unix(0, 0), &soakReader{obj: obj})
+}
+
+// soakFaultWriter 寫入 cutAt 位元組後中斷連線（-1 表示不中斷），每次寫入前延遲 delay
+type soakFaultWriter struct {
+	http.ResponseWriter
+	cutAt   int64
+	written int64
+	delay   time.Duration
+}
+
+func (w *soakFaultWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	if w.cutAt >= 0 && w.written+int64(len(p)) > w.cutAt {
+		n, _ := w.ResponseWriter.Write(p[:w.cutAt-w.written])
+		w.written += int64(n)
+		panic(http.ErrAbortHandler)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,444 @@
+package fileproxy
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	standbyIndexPath    = "/admin/standby/index"   // 熱備節點接收索引更新的端點
+	standbyPromotePath  = "/admin/standby/promote" // 熱備節點接手後預取尚無內容的條目
+	standbySyncInterval = time.Second              // 傳送累積的索引更新的間隔
+	standbyMaxRecord    = 1 << 20                  // 單筆索引記錄的長度上限
+)
+
+// 索引更新串流中每筆記錄的操作：1 位元組操作、雜湊，新增時其後接 4 位元組長度與索引記錄
+const (
+	standbyOpDelete byte = iota
+	standbyOpPut
+)
+
+// standbyStreamer 將索引的新增與淘汰持續串流到熱備節點
+//
+// 索引庫的每個寫入操作都轉交到這裡，同一雜湊只保留最新的操作，每 standbySyncInterval 批次傳送。
+// 啟動時與傳送失敗後先傳送完整索引，熱備節點以此取代先前收到的記錄，因此斷線期間的變化不會遺失。
+// 設定 hotHits 時，命中次數達到門檻的條目也透過複製端點推送內容，接手後這些檔案直接命中。
+type standbyStreamer struct {
+	url     string
+	token   string
+	cache   *Cache
+	client  *http.Client
+	hotHits int64
+	bodies  *replicator // 熱門條目的內容推送，hotHits 為 0 時為 nil
+
+	mu      sync.Mutex
+	pending map[keyHash]*storedEntry // 尚未傳送的操作，nil 表示刪除
+	pushed  map[keyHash]struct{}     // 已排入內容推送的條目
+	full    bool                     // 下次傳送前需要完整同步
+
+	syncs     atomic.Int64
+	fullSyncs atomic.Int64
+	records   atomic.Int64
+	failed    atomic.Int64
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newStandbyStreamer 建立串流器並開始同步，未設定熱備節點時返回 nil
+func newStandbyStreamer(cfg *Config, cache *Cache, sched *fetchScheduler) *standbyStreamer {
+	if cfg.StandbyURL == "" {
+		return nil
+	}
+	s := &standbyStreamer{
+		url:     cfg.StandbyURL,
+		token:   cfg.AdminToken,
+		cache:   cache,
+		client:  &http.Client{Timeout: cfg.UpstreamTimeout},
+		hotHits: cfg.StandbyHotHits,
+		pending: make(map[keyHash]*storedEntry),
+		pushed:  make(map[keyHash]struct{}),
+		full:    true,
+		closeCh: make(chan struct{}),
+	}
+	if s.hotHits > 0 {
+		s.bodies = newReplicator(cfg, []string{cfg.StandbyURL}, cache, sched)
+	}
+	cache.store.mirror.Store(s)
+	s.wg.Add(1)
+	go s.loop()
+	return s
+}
+
+// Close 停止同步，與熱備節點同步中時傳送最後累積的更新
+func (s *standbyStreamer) Close() {
+	s.cache.store.mirror.Store(nil)
+	close(s.closeCh)
+	s.wg.Wait()
+	s.mu.Lock()
+	inSync := !s.full
+	s.mu.Unlock()
+	if inSync {
+		s.sync()
+	}
+	if s.bodies != nil {
+		s.bodies.Close()
+	}
+}
+
+// record 記錄索引庫的寫入操作
+func (s *standbyStreamer) record(op storeOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[op.hash] = op.entry
+	if op.entry == nil {
+		delete(s.pushed, op.hash)
+	}
+}
+
+func (s *standbyStreamer) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(standbySyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.sync()
+			if s.bodies != nil {
+				s.pushHot()
+			}
+		}
+	}
+}
+
+// sync 傳送累積的更新，需要時先傳送完整索引；失敗時保留未傳送的更新並在下次完整同步
+func (s *standbyStreamer) sync() {
+	s.mu.Lock()
+	full, pending := s.full, s.pending
+	s.pending = make(map[keyHash]*storedEntry)
+	s.mu.Unlock()
+
+	// 完整索引讀自索引庫，可能尚未包含剛排入的操作，之後再傳送累積的更新補上
+	var err error
+	if full {
+		if err = s.send(true, s.writeIndex); err == nil {
+			s.fullSyncs.Add(1)
+			slog.Info("standby index synced", "standby", s.url)
+		}
+	}
+	if err == nil && len(pending) > 0 {
+		err = s.send(false, func(w *bufio.Writer) error {
+			for hash, se := range pending {
+				if err := s.writeRecord(w, hash, se); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.full = false
+		return
+	}
+	s.failed.Add(1)
+	if !s.full {
+		slog.Warn("standby sync failed, will resend full index", "standby", s.url, "error", err)
+	}
+	s.full = true
+	for hash, se := range pending {
+		if _, newer := s.pending[hash]; !newer {
+			s.pending[hash] = se
+		}
+	}
+}
+
+// writeIndex 寫出索引庫中的所有條目
+func (s *standbyStreamer) writeIndex(w *bufio.Writer) error {
+	var werr error
+	err := s.cache.store.Load(func(se storedEntry) {
+		if werr == nil {
+			werr = s.writeRecord(w, se.hash, &se)
+		}
+	})
+	return cmp.Or(werr, err)
+}
+
+// writeRecord 寫出一筆記錄，se 為 nil 表示刪除
+func (s *standbyStreamer) writeRecord(w *bufio.Writer, hash keyHash, se *storedEntry) error {
+	s.records.Add(1)
+	if se == nil {
+		w.WriteByte(standbyOpDelete)
+		_, err := w.Write(hash[:])
+		return err
+	}
+	data := se.encode()
+	w.WriteByte(standbyOpPut)
+	w.Write(hash[:])
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	_, err := w.Write(data)
+	return err
+}
+
+// send 以單一請求串流記錄到熱備節點，full 為 true 時熱備節點先清空先前的記錄
+func (s *standbyStreamer) send(full bool, write func(*bufio.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		err := write(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	target := buildUpstreamURL(s.url, standbyIndexPath)
+	if full {
+		target += "?full=1"
+	}
+	req, err := http.NewRequest(http.MethodPost, target, pr)
+	if err != nil {
+		pr.Close()
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("standby request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("standby status: %d", resp.StatusCode)
+	}
+	s.syncs.Add(1)
+	return nil
+}
+
+// pushHot 將命中次數達到門檻且尚未推送的條目排入內容推送
+func (s *standbyStreamer) pushHot() {
+	// 淘汰回調在索引鎖內呼叫 record，走訪索引時不可持有 s.mu
+	var hot []keyHash
+	s.cache.fileCache.forEach(func(e *CacheEntry) {
+		if e.hits.Load() >= s.hotHits {
+			hot = append(hot, e.hash)
+		}
+	})
+	s.mu.Lock()
+	hashes := slices.DeleteFunc(hot, func(hash keyHash) bool {
+		_, done := s.pushed[hash]
+		return done
+	})
+	s.mu.Unlock()
+	if len(hashes) == 0 {
+		return
+	}
+	err := s.cache.store.Keys(hashes, func(hash keyHash, key string) {
+		if isPrivateKey(key) {
+			return
+		}
+		s.mu.Lock()
+		s.pushed[hash] = struct{}{}
+		s.mu.Unlock()
+		s.bodies.Enqueue(key)
+	})
+	if err != nil {
+		slog.Warn("standby hot key lookup failed", "error", err)
+	}
+}
+
+// Stats 返回熱備同步統計資訊
+func (s *standbyStreamer) Stats() map[string]any {
+	s.mu.Lock()
+	queued, full := len(s.pending), s.full
+	s.mu.Unlock()
+	stats := map[string]any{
+		"standby":    s.url,
+		"queued":     queued,
+		"in_sync":    !full,
+		"syncs":      s.syncs.Load(),
+		"full_syncs": s.fullSyncs.Load(),
+		"records":    s.records.Load(),
+		"failed":     s.failed.Load(),
+	}
+	if s.bodies != nil {
+		stats["bodies"] = s.bodies.Stats()
+	}
+	return stats
+}
+
+// readStandbyRecord 讀取一筆記錄，串流結束時返回 io.EOF
+func readStandbyRecord(r *bufio.Reader) (storeOp, error) {
+	var op storeOp
+	kind, err := r.ReadByte()
+	if err != nil {
+		return op, err
+	}
+	if _, err := io.ReadFull(r, op.hash[:]); err != nil {
+		return op, io.ErrUnexpectedEOF
+	}
+	switch kind {
+	case standbyOpDelete:
+		return op, nil
+	case standbyOpPut:
+	default:
+		return op, fmt.Errorf("unknown record type %d", kind)
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return op, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > standbyMaxRecord {
+		return op, fmt.Errorf("record too large: %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return op, io.ErrUnexpectedEOF
+	}
+	se, ok := decodeStoredEntry(op.hash[:], data)
+	if !ok {
+		return op, errors.New("invalid index record")
+	}
+	op.entry = &se
+	return op, nil
+}
+
+// standbyReceiver 熱備節點的接收統計
+type standbyReceiver struct {
+	received atomic.Int64
+	adopted  atomic.Int64
+	lastSync atomic.Int64 // UnixNano
+}
+
+// Stats 返回接收統計，尚未收到任何記錄時返回 nil
+func (sr *standbyReceiver) Stats() map[string]any {
+	if sr.lastSync.Load() == 0 {
+		return nil
+	}
+	return map[string]any{
+		"received":  sr.received.Load(),
+		"adopted":   sr.adopted.Load(),
+		"last_sync": time.Unix(0, sr.lastSync.Load()),
+	}
+}
+
+// handleStandbyIndex 熱備節點接收主節點的索引更新
+//
+// 本機已有或已有內容檔案的條目直接併入索引，其餘記錄保存在索引庫中，重啟或接手時使用；
+// 刪除記錄同時移除本機的條目。?full=1 表示完整索引，先清空先前保存的記錄。
+func (p *Proxy) handleStandbyIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	full := r.URL.Query().Get("full") == "1"
+	rx := &p.standbyRx
+
+	batch := make([]storeOp, 0, storeBatchSize)
+	apply := func() error {
+		err := p.cache.store.ApplyStandby(batch, full)
+		full, batch = false, batch[:0]
+		return err
+	}
+	br := bufio.NewReader(r.Body)
+	for {
+		op, err := readStandbyRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Warn("standby index receive failed", "error", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		rx.received.Add(1)
+		switch {
+		case op.entry == nil:
+			p.cache.fileCache.Remove(op.hash)
+		case p.cache.adoptStandby(op.entry):
+			rx.adopted.Add(1)
+			op.entry = nil // 已在索引中，不需保留記錄
+		default:
+			p.cache.fileCache.Remove(op.hash) // 本機內容與主節點不同
+		}
+		if batch = append(batch, op); len(batch) < storeBatchSize {
+			continue
+		}
+		if err := apply(); err != nil {
+			slog.Warn("standby index write failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(batch) > 0 || full {
+		if err := apply(); err != nil {
+			slog.Warn("standby index write failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	rx.lastSync.Store(time.Now().UnixNano())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStandbyPromote 熱備節點接手後，依最近存取時間預取索引記錄中尚無內容的條目
+func (p *Proxy) handleStandbyPromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.prefetcher == nil {
+		http.Error(w, "prefetch is disabled", http.StatusBadRequest)
+		return
+	}
+
+	var records []storedEntry
+	err := p.cache.store.LoadStandby(func(se storedEntry) {
+		if se.key != "" && !isPrivateKey(se.key) {
+			records = append(records, se)
+		}
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	slices.SortFunc(records, func(a, b storedEntry) int { return cmp.Compare(b.accessedAt, a.accessedAt) })
+	keys := make([]string, 0, min(len(records), prefetchMaxPaths))
+	for _, se := range records[:cap(keys)] {
+		keys = append(keys, se.key)
+	}
+
+	job, err := p.prefetcher.Enqueue("standby", keys, false, nil)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("standby promoted, prefetch queued", "id", job.ID, "paths", job.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,207 @@
+package fileproxy
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const recentErrorsSize = 50 // 保留的最近錯誤數
+
+// requestStats 請求層級的統計計數
+type requestStats struct {
+	hits         atomic.Int64
+	misses       atomic.Int64
+	streaming    atomic.Int64
+	notFound     atomic.Int64
+	negative     atomic.Int64 // 命中負快取的請求，含 404 以外的狀態
+	stale        atomic.Int64
+	revalidated  atomic.Int64
+	detached     atomic.Int64 // 客戶端斷線後轉為背景繼續的填充
+	abandoned    atomic.Int64 // 客戶端斷線後依策略放棄的填充
+	waitRejected atomic.Int64 // 同一下載的讀取者已達上限而拒絕的請求
+	waitTimeouts atomic.Int64 // 等待下載進度逾時而中止的請求
+	versions     atomic.Int64 // 以 ?version= 提供的舊版本
+	errors       atomic.Int64
+
+	errMu   sync.Mutex
+	errRing []requestError
+	errNext int
+}
+
+// requestError 最近一次請求錯誤
+type requestError struct {
+	Time  time.Time `json:"time"`
+	Key   string    `json:"key"`
+	Error string    `json:"error"`
+}
+
+// recordError 記錄請求錯誤到環形緩衝
+func (s *requestStats) recordError(key string, err error) {
+	s.errors.Add(1)
+
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	e := requestError{Time: time.Now(), Key: key, Error: err.Error()}
+	if len(s.errRing) < recentErrorsSize {
+		s.errRing = append(s.errRing, e)
+	} else {
+		s.errRing[s.errNext] = e
+	}
+	s.errNext = (s.errNext + 1) % recentErrorsSize
+}
+
+// recentErrors 返回最近的錯誤，最新的在前
+func (s *requestStats) recentErrors() []requestError {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	out := make([]requestError, 0, len(s.errRing))
+	for i := 1; i <= len(s.errRing); i++ {
+		idx := (s.errNext - i + recentErrorsSize) % recentErrorsSize
+		out = append(out, s.errRing[idx])
+	}
+	return out
+}
+
+// hitRatio 計算命中率（STREAMING 視為命中）
+func hitRatio(hits, misses, streaming int64) float64 {
+	total := hits + misses + streaming
+	if total == 0 {
+		return 0
+	}
+	return float64(hits+streaming) / float64(total)
+}
+
+// snapshot 返回請求統計
+func (s *requestStats) snapshot() HTTPStats {
+	hits, misses, streaming := s.hits.Load(), s.misses.Load(), s.streaming.Load()
+	return HTTPStats{
+		Hits:         hits,
+		Misses:       misses,
+		Streaming:    streaming,
+		NotFound:     s.notFound.Load(),
+		Negative:     s.negative.Load(),
+		Stale:        s.stale.Load(),
+		Revalidated:  s.revalidated.Load(),
+		Detached:     s.detached.Load(),
+		Abandoned:    s.abandoned.Load(),
+		WaitRejected: s.waitRejected.Load(),
+		WaitTimeouts: s.waitTimeouts.Load(),
+		Versions:     s.versions.Load(),
+		Errors:       s.errors.Load(),
+		HitRatio:     hitRatio(hits, misses, streaming),
+	}
+}
+
+// StatsVersion Stats 結構的版本，欄位有不相容的變更時遞增
+//
+// 版本 1 是舊的扁平格式，/stats 未指定 version 時仍返回版本 1，既有的監控不受影響。
+const StatsVersion = 2
+
+// Stats 代理的統計快照
+//
+// 所有欄位在取得時複製，之後不再變動，可在其他 goroutine 中使用。
+type Stats struct {
+	Version  int           `json:"version"`
+	Cache    CacheStats    `json:"cache"`
+	Disk     DiskStats     `json:"disk"`
+	HTTP     HTTPStats     `json:"http"`
+	Upstream UpstreamStats `json:"upstream"`
+
+	// Components 選用元件的統計（scheduler、memory、peers、rate_limit 等），
+	// 以元件名稱索引；未啟用的元件不會出現，內容格式不在版本保證之內
+	Components map[string]any `json:"components"`
+}
+
+// CacheStats 快取條目與容量
+type CacheStats struct {
+	FileEntries     int     `json:"file_entries"`
+	NegativeEntries int     `json:"negative_entries"`
+	HeadEntries     int     `json:"head_entries"`
+	TotalSize       int64   `json:"total_size"`
+	MaxSize         int64   `json:"max_size"`
+	UsagePercent    float64 `json:"usage_percent"`
+	Pending         int     `json:"pending"`
+	Pinned          int     `json:"pinned"`
+	Corrupted       int64   `json:"corrupted"`
+	Failures        int64   `json:"failures"`        // 快取子系統的累計錯誤數
+	EvictBlocked    int64   `json:"evict_blocked"`   // 只剩不可淘汰的條目而停止淘汰的次數
+	VersionsKept    int64   `json:"versions_kept"`   // 內容改變時保留為舊版本的條目數
+	PendingStalled  int64   `json:"pending_stalled"` // 超過 PendingStallTimeout 沒有進度而中止的下載
+}
+
+// DiskStats 快取所在檔案系統
+type DiskStats struct {
+	Free            int64 `json:"free"`     // 最近一次檢查的剩餘空間，未啟用水位時為 0
+	MinFree         int64 `json:"min_free"` // 剩餘空間水位，0 表示停用
+	Evictions       int64 `json:"evictions"`
+	DeferredDeletes int64 `json:"deferred_deletes"` // 仍有讀取者而延後刪除的檔案
+}
+
+// HTTPStats 代理請求計數
+type HTTPStats struct {
+	Hits         int64   `json:"hits"`
+	Misses       int64   `json:"misses"`
+	Streaming    int64   `json:"streaming"`
+	NotFound     int64   `json:"not_found"`
+	Negative     int64   `json:"negative"`
+	Stale        int64   `json:"stale"`
+	Revalidated  int64   `json:"revalidated"`
+	Detached     int64   `json:"detached"`
+	Abandoned    int64   `json:"abandoned"`
+	WaitRejected int64   `json:"wait_rejected"` // 同一下載的讀取者已達 MaxStreamWaiters
+	WaitTimeouts int64   `json:"wait_timeouts"` // 下載超過 StreamWaitTimeout 沒有進度
+	Versions     int64   `json:"versions"`      // 以 ?version= 提供的舊版本
+	Errors       int64   `json:"errors"`
+	HitRatio     float64 `json:"hit_ratio"`
+}
+
+// UpstreamStats 上游能力與離線狀態
+type UpstreamStats struct {
+	Offline       bool                    `json:"offline"`
+	OfflineSince  time.Time               `json:"offline_since,omitzero"`
+	OfflineMisses int64                   `json:"offline_misses"`
+	ProbeInterval string                  `json:"probe_interval"`
+	Probes        int64                   `json:"probes"`
+	ProbeFailures int64                   `json:"probe_failures"`
+	Resumed       int64                   `json:"resumed"`  // 中斷後以 Range 續傳成功的次數
+	Parallel      int64                   `json:"parallel"` // 以並行分段下載的檔案數
+	Origins       map[string]UpstreamCaps `json:"origins"`  // 依來源（scheme://host）
+}
+
+// legacy 返回版本 1 的扁平格式
+func (s *Stats) legacy() map[string]any {
+	m := make(map[string]any, len(s.Components)+16)
+	maps.Copy(m, s.Components)
+	m["file_entries"] = s.Cache.FileEntries
+	m["negative_entries"] = s.Cache.NegativeEntries
+	m["head_entries"] = s.Cache.HeadEntries
+	m["total_size"] = s.Cache.TotalSize
+	m["max_size"] = s.Cache.MaxSize
+	m["usage_percent"] = s.Cache.UsagePercent
+	m["pending"] = s.Cache.Pending
+	m["corrupted"] = s.Cache.Corrupted
+	m["failures"] = s.Cache.Failures
+	m["deferred_deletes"] = s.Disk.DeferredDeletes
+	m["disk_free"] = s.Disk.Free
+	m["disk_evictions"] = s.Disk.Evictions
+	m["evict_blocked"] = s.Cache.EvictBlocked
+	m["pinned"] = s.Cache.Pinned
+	m["requests"] = s.HTTP
+	m["upstream_capabilities"] = map[string]any{
+		"upstreams": s.Upstream.Origins,
+		"interval":  s.Upstream.ProbeInterval,
+		"probes":    s.Upstream.Probes,
+		"failures":  s.Upstream.ProbeFailures,
+		"resumed":   s.Upstream.Resumed,
+		"parallel":  s.Upstream.Parallel,
+	}
+	m["offline"] = offlineStatus{
+		Offline: s.Upstream.Offline,
+		Since:   s.Upstream.OfflineSince,
+		Misses:  s.Upstream.OfflineMisses,
+	}
+	return m
+}
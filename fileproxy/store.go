@@ -0,0 +1,492 @@
+package fileproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	storeFileName   = "index.db"
+	legacyIndexName = "index.json" // 舊版 JSON 索引，啟動時遷移
+	storeQueueSize  = 4096         // 待寫入操作佇列長度
+	storeBatchSize  = 1024         // 單一交易最多處理的操作數
+	storeRecordV1   = 33           // 版本 1：版本 + size + createdAt + ttl + accessedAt
+	storeRecordV2   = 65           // 版本 2：版本 1 欄位 + sum
+	storeRecordV3   = 67           // 版本 3：版本 2 欄位 + key 長度，其後接 key
+	storeRecordV4   = 71           // 版本 4：版本 3 欄位 + ETag 與 Last-Modified 長度
+	storeRecordSize = 73           // 版本 5：版本 4 欄位 + 標籤長度
+	storeVersion    = 5
+
+	defaultIndexLockTimeout = time.Second // 未設定 IndexLockTimeout 時等待索引鎖的時間
+)
+
+var (
+	entriesBucket    = []byte("entries")
+	standbyBucket    = []byte("standby")    // 熱備節點收到、本機尚無內容的主節點條目
+	provenanceBucket = []byte("provenance") // 條目的來源記錄，隨條目刪除
+)
+
+// storedEntry 索引庫中的條目
+type storedEntry struct {
+	hash        keyHash
+	size        int64
+	contentType string
+	createdAt   int64
+	ttl         time.Duration
+	accessedAt  int64
+	sum         keyHash
+	key         string // 版本 3 起記錄，只用於報表，不載入記憶體索引
+
+	// 版本 4 起記錄，僅需重新驗證的條目有值
+	etag         string
+	lastModified string
+
+	tags string // 版本 5 起記錄，以逗號分隔
+}
+
+// varFields 返回各版本在固定欄位之後、內容類型之前的變長欄位
+func (se *storedEntry) varFields(version byte) []*string {
+	switch version {
+	case 3:
+		return []*string{&se.key}
+	case 4:
+		return []*string{&se.key, &se.etag, &se.lastModified}
+	case storeVersion:
+		return []*string{&se.key, &se.etag, &se.lastModified, &se.tags}
+	}
+	return nil
+}
+
+// encode 序列化條目：固定長度欄位與變長欄位長度，其後接變長欄位與內容類型
+func (se *storedEntry) encode() []byte {
+	fields := se.varFields(storeVersion)
+	buf := make([]byte, storeRecordSize, storeRecordSize+len(se.key)+len(se.contentType))
+	buf[0] = storeVersion
+	binary.BigEndian.PutUint64(buf[1:], uint64(se.size))
+	binary.BigEndian.PutUint64(buf[9:], uint64(se.createdAt))
+	binary.BigEndian.PutUint64(buf[17:], uint64(se.ttl))
+	binary.BigEndian.PutUint64(buf[25:], uint64(se.accessedAt))
+	copy(buf[storeRecordV1:], se.sum[:])
+	for i, f := range fields {
+		value := (*f)[:min(len(*f), 0xffff)]
+		binary.BigEndian.PutUint16(buf[storeRecordV2+2*i:], uint16(len(value)))
+		buf = append(buf, value...)
+	}
+	return append(buf, se.contentType...)
+}
+
+// decodeStoredEntry 反序列化條目
+func decodeStoredEntry(k, v []byte) (storedEntry, bool) {
+	var se storedEntry
+	if len(k) != len(se.hash) || len(v) == 0 {
+		return se, false
+	}
+	recordSize := storeRecordSize
+	switch v[0] {
+	case 1:
+		recordSize = storeRecordV1 // 版本 1 沒有 sum
+	case 2:
+		recordSize = storeRecordV2 // 版本 2 沒有 key
+	case 3:
+		recordSize = storeRecordV3 // 版本 3 沒有驗證器
+	case 4:
+		recordSize = storeRecordV4 // 版本 4 沒有標籤
+	case storeVersion:
+	default:
+		return se, false
+	}
+	if len(v) < recordSize {
+		return se, false
+	}
+	if recordSize >= storeRecordV2 {
+		copy(se.sum[:], v[storeRecordV1:])
+	}
+	for i, f := range se.varFields(v[0]) {
+		n := int(binary.BigEndian.Uint16(v[storeRecordV2+2*i:]))
+		if len(v) < recordSize+n {
+			return se, false
+		}
+		*f = string(v[recordSize : recordSize+n])
+		recordSize += n
+	}
+	copy(se.hash[:], k)
+	se.size = int64(binary.BigEndian.Uint64(v[1:]))
+	se.createdAt = int64(binary.BigEndian.Uint64(v[9:]))
+	se.ttl = time.Duration(binary.BigEndian.Uint64(v[17:]))
+	se.accessedAt = int64(binary.BigEndian.Uint64(v[25:]))
+	se.contentType = string(v[recordSize:])
+	return se, true
+}
+
+// storeOp 待寫入的操作，entry 與 provenance 皆為 nil 表示刪除
+type storeOp struct {
+	hash       keyHash
+	entry      *storedEntry
+	provenance []byte // 條目的來源記錄，只在條目仍存在時寫入
+}
+
+// indexStore 以 bbolt 持久化快取索引
+//
+// 新增與淘汰以非同步批次交易寫入，不阻塞請求路徑；存取時間只在記憶體中
+// 累積，定期寫回，用於重啟後恢復 LRU 順序。
+type indexStore struct {
+	db       *bolt.DB
+	ops      chan storeOp
+	failures atomic.Int64 // 寫入失敗的交易數
+
+	mu      sync.Mutex
+	touched map[keyHash]int64 // 尚未寫回的存取時間
+
+	mirror atomic.Pointer[standbyStreamer] // 設定熱備節點時轉交每個操作
+
+	done chan struct{}
+}
+
+// openIndexStore 開啟索引庫並啟動寫入 goroutine，其他程序持有索引鎖時最多等待 lockTimeout
+func openIndexStore(path string, lockTimeout time.Duration) (*indexStore, error) {
+	if lockTimeout <= 0 {
+		lockTimeout = defaultIndexLockTimeout
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open index store: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(entriesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(standbyBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(provenanceBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init index store: %w", err)
+	}
+
+	s := &indexStore{
+		db:      db,
+		ops:     make(chan storeOp, storeQueueSize),
+		touched: make(map[keyHash]int64),
+		done:    make(chan struct{}),
+	}
+	go s.writer()
+	return s, nil
+}
+
+// Put 記錄新增的條目
+func (s *indexStore) Put(e *CacheEntry, key string) {
+	se := &storedEntry{
+		key:         key,
+		hash:        e.hash,
+		size:        e.Size,
+		contentType: e.ContentType(),
+		createdAt:   e.createdAt,
+		ttl:         e.TTL,
+		accessedAt:  e.createdAt,
+		sum:         e.sum,
+		tags:        e.tagList(),
+	}
+	if v := e.validators; v != nil {
+		se.etag, se.lastModified = v.etag, v.lastModified
+	}
+	s.send(storeOp{hash: e.hash, entry: se})
+}
+
+// Delete 記錄淘汰的條目
+func (s *indexStore) Delete(hash keyHash) {
+	s.send(storeOp{hash: hash})
+}
+
+// send 排入寫入佇列並轉交熱備節點
+func (s *indexStore) send(op storeOp) {
+	if m := s.mirror.Load(); m != nil {
+		m.record(op)
+	}
+	s.ops <- op
+}
+
+// PutProvenance 記錄條目的來源，不轉交熱備節點
+func (s *indexStore) PutProvenance(hash keyHash, data []byte) {
+	s.ops <- storeOp{hash: hash, provenance: data}
+}
+
+// Provenance 讀取條目的來源記錄
+func (s *indexStore) Provenance(hash keyHash) (data []byte, ok bool) {
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(provenanceBucket).Get(hash[:]); v != nil {
+			data, ok = bytes.Clone(v), true
+		}
+		return nil
+	})
+	return data, ok
+}
+
+// Touch 記錄存取時間，由 FlushAccess 寫回
+func (s *indexStore) Touch(hash keyHash, now time.Time) {
+	s.mu.Lock()
+	s.touched[hash] = now.UnixNano()
+	s.mu.Unlock()
+}
+
+// writer 將操作批次寫入交易
+func (s *indexStore) writer() {
+	defer close(s.done)
+	batch := make([]storeOp, 0, storeBatchSize)
+	for op := range s.ops {
+		batch = append(batch[:0], op)
+	collect:
+		for len(batch) < storeBatchSize {
+			select {
+			case op, ok := <-s.ops:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, op)
+			default:
+				break collect
+			}
+		}
+		if err := s.apply(batch); err != nil {
+			s.failures.Add(1)
+			slog.Warn("index store write failed", "ops", len(batch), "error", err)
+		}
+	}
+}
+
+// apply 在單一交易中套用操作
+func (s *indexStore) apply(batch []storeOp) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, pb := tx.Bucket(entriesBucket), tx.Bucket(provenanceBucket)
+		for _, op := range batch {
+			var err error
+			switch {
+			case op.provenance != nil:
+				// 下載完成後條目可能已被淘汰
+				if b.Get(op.hash[:]) != nil {
+					err = pb.Put(op.hash[:], op.provenance)
+				}
+			case op.entry == nil:
+				if err = b.Delete(op.hash[:]); err == nil {
+					err = pb.Delete(op.hash[:])
+				}
+			default:
+				err = b.Put(op.hash[:], op.entry.encode())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FlushAccess 寫回累積的存取時間
+func (s *indexStore) FlushAccess() error {
+	s.mu.Lock()
+	touched := s.touched
+	s.touched = make(map[keyHash]int64)
+	s.mu.Unlock()
+	if len(touched) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		for hash, at := range touched {
+			v := b.Get(hash[:])
+			if len(v) < storeRecordV1 {
+				continue // 已被淘汰
+			}
+			updated := append([]byte(nil), v...)
+			binary.BigEndian.PutUint64(updated[25:], uint64(at))
+			if err := b.Put(hash[:], updated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.failures.Add(1)
+	}
+	return err
+}
+
+// Load 讀取所有條目
+func (s *indexStore) Load(fn func(storedEntry)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			if se, ok := decodeStoredEntry(k, v); ok {
+				fn(se)
+			}
+			return nil
+		})
+	})
+}
+
+// Keys 查詢雜湊對應的 key，舊版記錄沒有 key 時不呼叫 fn
+func (s *indexStore) Keys(hashes []keyHash, fn func(keyHash, string)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		for _, hash := range hashes {
+			if se, ok := decodeStoredEntry(hash[:], b.Get(hash[:])); ok && se.key != "" {
+				fn(hash, se.key)
+			}
+		}
+		return nil
+	})
+}
+
+// ApplyStandby 在單一交易中套用主節點的條目記錄，full 為 true 時先清空熱備記錄
+func (s *indexStore) ApplyStandby(batch []storeOp, full bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if full {
+			if err := tx.DeleteBucket(standbyBucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(standbyBucket); err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket(standbyBucket)
+		for _, op := range batch {
+			var err error
+			if op.entry == nil {
+				err = b.Delete(op.hash[:])
+			} else {
+				err = b.Put(op.hash[:], op.entry.encode())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StandbyEntry 查詢熱備記錄
+func (s *indexStore) StandbyEntry(hash keyHash) (se storedEntry, ok bool) {
+	s.db.View(func(tx *bolt.Tx) error {
+		se, ok = decodeStoredEntry(hash[:], tx.Bucket(standbyBucket).Get(hash[:]))
+		return nil
+	})
+	return se, ok
+}
+
+// LoadStandby 讀取所有熱備記錄
+func (s *indexStore) LoadStandby(fn func(storedEntry)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(standbyBucket).ForEach(func(k, v []byte) error {
+			if se, ok := decodeStoredEntry(k, v); ok {
+				fn(se)
+			}
+			return nil
+		})
+	})
+}
+
+// Snapshot 寫回存取時間後，將索引庫的一致副本複製到 path
+func (s *indexStore) Snapshot(path string) error {
+	if err := s.FlushAccess(); err != nil {
+		slog.Warn("index store flush failed", "error", err)
+	}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("copy index store: %w", err)
+	}
+	return nil
+}
+
+// Close 寫完佇列中的操作與存取時間後關閉
+func (s *indexStore) Close() error {
+	close(s.ops)
+	<-s.done
+	if err := s.FlushAccess(); err != nil {
+		slog.Warn("index store flush failed", "error", err)
+	}
+	return s.db.Close()
+}
+
+// legacyIndex 舊版 JSON 索引
+type legacyIndex struct {
+	Entries []legacyEntry `json:"entries"`
+}
+
+// legacyEntry 舊版索引條目
+type legacyEntry struct {
+	Hash        string        `json:"hash,omitempty"`
+	Key         string        `json:"key,omitempty"` // 更早的版本只記錄 key
+	Size        int64         `json:"size"`
+	ContentType string        `json:"content_type"`
+	CreatedAt   time.Time     `json:"created_at"`
+	TTL         time.Duration `json:"ttl,omitempty"`
+}
+
+// hash 返回條目的 key 雜湊
+func (le *legacyEntry) hash() (keyHash, bool) {
+	var h keyHash
+	if le.Hash == "" {
+		if le.Key == "" {
+			return h, false
+		}
+		return hashKey(le.Key), true
+	}
+	n, err := hex.Decode(h[:], []byte(le.Hash))
+	return h, err == nil && n == len(h)
+}
+
+// migrateLegacyIndex 將舊版 index.json 匯入索引庫後刪除
+func migrateLegacyIndex(dir string, s *indexStore) error {
+	path := filepath.Join(dir, legacyIndexName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var idx legacyIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return fmt.Errorf("parse legacy index: %w", err)
+	}
+	batch := make([]storeOp, 0, len(idx.Entries))
+	for i, le := range idx.Entries {
+		hash, ok := le.hash()
+		if !ok {
+			continue
+		}
+		batch = append(batch, storeOp{hash: hash, entry: &storedEntry{
+			hash:        hash,
+			key:         le.Key,
+			size:        le.Size,
+			contentType: le.ContentType,
+			createdAt:   le.CreatedAt.UnixNano(),
+			ttl:         le.TTL,
+			accessedAt:  int64(i), // 舊索引按 LRU 順序保存，以序號保留相對順序
+		}})
+	}
+	if err := s.apply(batch); err != nil {
+		return err
+	}
+
+	os.Remove(path + ".tmp")
+	slog.Info("legacy index migrated", "entries", len(batch))
+	return os.Remove(path)
+}
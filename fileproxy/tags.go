@@ -0,0 +1,281 @@
+package fileproxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"unique"
+)
+
+const (
+	tagsPath      = "/admin/tags" // 標籤列表與操作端點
+	maxTagLength  = 64
+	maxEntryTags  = 16 // 單一條目最多的標籤數，超過的標籤被捨棄
+	tagsSeparator = ","
+)
+
+// TagInfo 單一標籤的統計
+type TagInfo struct {
+	Tag     string `json:"tag"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	Pinned  bool   `json:"pinned"`
+}
+
+// TagResult 標籤操作的結果
+type TagResult struct {
+	Tag     string `json:"tag"`
+	Entries int    `json:"entries"` // 清除或釘選的條目數
+	Bytes   int64  `json:"bytes"`
+
+	Refetching int `json:"refetching,omitempty"` // 以 refetch 選項清除時排入重新下載的條目數
+}
+
+// tagPins 以標籤釘選的條目雜湊，解除時依此逐一解除
+type tagPins struct {
+	mu     sync.Mutex
+	hashes map[string][]keyHash
+}
+
+// validTag 檢查標籤名稱：1 到 64 個英數字、點、底線或連字號
+func validTag(tag string) bool {
+	if tag == "" || len(tag) > maxTagLength {
+		return false
+	}
+	return !strings.ContainsFunc(tag, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-')
+	})
+}
+
+// internTags 合併標籤、排序去重後驅留，沒有標籤時返回零值
+func internTags(existing string, tags []string) unique.Handle[string] {
+	var merged []string
+	if existing != "" {
+		merged = strings.Split(existing, tagsSeparator)
+	}
+	merged = append(merged, tags...)
+	if len(merged) == 0 {
+		return unique.Handle[string]{}
+	}
+	slices.Sort(merged)
+	merged = slices.Compact(merged)
+	if len(merged) > maxEntryTags {
+		merged = merged[:maxEntryTags]
+	}
+	return unique.Make(strings.Join(merged, tagsSeparator))
+}
+
+// tagList 返回以逗號分隔的標籤，沒有標籤時為空字串
+func (e *CacheEntry) tagList() string {
+	if e.tags == (unique.Handle[string]{}) {
+		return ""
+	}
+	return e.tags.Value()
+}
+
+// Tags 返回條目的標籤
+func (e *CacheEntry) Tags() []string {
+	if list := e.tagList(); list != "" {
+		return strings.Split(list, tagsSeparator)
+	}
+	return nil
+}
+
+// hasTag 檢查條目是否帶有標籤
+func (e *CacheEntry) hasTag(tag string) bool {
+	return slices.Contains(e.Tags(), tag)
+}
+
+// AddTags 為已在快取中的條目加上標籤，條目不存在時返回 false
+//
+// 條目欄位在建立後不再修改，因此以加上標籤的副本替換原條目，沿用同一個檔案。
+func (c *Cache) AddTags(key string, tags []string) bool {
+	old, ok := c.fileCache.Peek(hashKey(key))
+	if !ok {
+		return false
+	}
+	merged := internTags(old.tagList(), tags)
+	if merged == old.tags {
+		return true
+	}
+	entry := &CacheEntry{
+		hash:        old.hash,
+		Size:        old.Size,
+		contentType: old.contentType,
+		createdAt:   old.createdAt,
+		TTL:         old.TTL,
+		sum:         old.sum,
+		validators:  old.validators,
+		tags:        merged,
+	}
+	entry.expiresAt.Store(old.expiresAt.Load())
+	entry.hits.Store(old.hits.Load())
+	entry.served.Store(old.served.Load())
+	entry.checkedAt.Store(old.checkedAt.Load())
+	if !c.fileCache.Replace(old, entry) {
+		return false
+	}
+	c.store.Put(entry, key)
+	return true
+}
+
+// tagged 返回帶有標籤的條目
+func (c *Cache) tagged(tag string) []*CacheEntry {
+	var out []*CacheEntry
+	c.fileCache.forEach(func(e *CacheEntry) {
+		if e.hasTag(tag) {
+			out = append(out, e)
+		}
+	})
+	return out
+}
+
+// TagStats 返回各標籤的條目數與大小，依標籤名稱排序；已釘選但沒有條目的標籤也列出
+func (c *Cache) TagStats() []TagInfo {
+	stats := make(map[string]*TagInfo)
+	c.fileCache.forEach(func(e *CacheEntry) {
+		for _, tag := range e.Tags() {
+			info, ok := stats[tag]
+			if !ok {
+				info = &TagInfo{Tag: tag}
+				stats[tag] = info
+			}
+			info.Entries++
+			info.Bytes += e.Size
+		}
+	})
+	c.tagPins.mu.Lock()
+	for tag := range c.tagPins.hashes {
+		if _, ok := stats[tag]; !ok {
+			stats[tag] = &TagInfo{Tag: tag}
+		}
+		stats[tag].Pinned = true
+	}
+	c.tagPins.mu.Unlock()
+
+	out := make([]TagInfo, 0, len(stats))
+	for _, info := range stats {
+		out = append(out, *info)
+	}
+	slices.SortFunc(out, func(a, b TagInfo) int { return strings.Compare(a.Tag, b.Tag) })
+	return out
+}
+
+// PurgeTag 移除帶有標籤的所有條目，並解除標籤的釘選
+func (c *Cache) PurgeTag(tag string) TagResult {
+	c.UnpinTag(tag)
+	result := TagResult{Tag: tag}
+	for _, e := range c.tagged(tag) {
+		if c.fileCache.Remove(e.hash) {
+			result.Entries++
+			result.Bytes += e.Size
+		}
+	}
+	return result
+}
+
+// refetchTag 解除標籤的釘選，並將帶有標籤的條目交給 refetcher 重新下載
+func (p *Proxy) refetchTag(tag string) TagResult {
+	p.cache.UnpinTag(tag)
+	result := TagResult{Tag: tag}
+	entries := p.cache.tagged(tag)
+	for _, e := range entries {
+		result.Entries++
+		result.Bytes += e.Size
+	}
+	result.Refetching = p.refetcher.Schedule(entries)
+	return result
+}
+
+// PinTag 釘選目前帶有標籤的條目，之後才加上標籤的條目不受影響；重複釘選時以目前的條目為準
+func (c *Cache) PinTag(tag string) TagResult {
+	c.UnpinTag(tag)
+	result := TagResult{Tag: tag}
+	entries := c.tagged(tag)
+	hashes := make([]keyHash, 0, len(entries))
+	for _, e := range entries {
+		c.fileCache.Pin(e.hash)
+		hashes = append(hashes, e.hash)
+		result.Entries++
+		result.Bytes += e.Size
+	}
+	c.tagPins.mu.Lock()
+	c.tagPins.hashes[tag] = hashes
+	c.tagPins.mu.Unlock()
+	return result
+}
+
+// UnpinTag 解除標籤的釘選，標籤未被釘選時返回 false
+func (c *Cache) UnpinTag(tag string) bool {
+	c.tagPins.mu.Lock()
+	hashes, ok := c.tagPins.hashes[tag]
+	delete(c.tagPins.hashes, tag)
+	c.tagPins.mu.Unlock()
+	for _, hash := range hashes {
+		c.fileCache.Unpin(hash)
+	}
+	return ok
+}
+
+// handleTags GET /admin/tags 列出標籤統計；/admin/tags/{tag} 以 DELETE 清除（?refetch=1 時在背景重新下載），
+// /admin/tags/{tag}/pin 以 PUT 釘選、DELETE 解除釘選
+func (p *Proxy) handleTags(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, tagsPath), "/")
+	tag, action, _ := strings.Cut(rest, "/")
+
+	if tag == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.cache.TagStats())
+		return
+	}
+	if !validTag(tag) || (action != "" && action != "pin") {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var result any
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		stats, info := p.cache.TagStats(), TagInfo{Tag: tag}
+		if i, ok := slices.BinarySearchFunc(stats, tag, func(t TagInfo, tag string) int {
+			return strings.Compare(t.Tag, tag)
+		}); ok {
+			info = stats[i]
+		}
+		result = info
+	case action == "" && r.Method == http.MethodDelete:
+		var purged TagResult
+		switch {
+		case !wantsRefetch(r):
+			purged = p.cache.PurgeTag(tag)
+		case p.refetcher == nil:
+			http.Error(w, "refetch is disabled", http.StatusBadRequest)
+			return
+		default:
+			purged = p.refetchTag(tag)
+		}
+		slog.Info("cache tag purged", "tag", tag, "entries", purged.Entries, "bytes", purged.Bytes, "refetching", purged.Refetching)
+		result = purged
+	case action == "pin" && r.Method == http.MethodPut:
+		pinned := p.cache.PinTag(tag)
+		slog.Info("cache tag pinned", "tag", tag, "entries", pinned.Entries)
+		result = pinned
+	case action == "pin" && r.Method == http.MethodDelete:
+		if p.cache.UnpinTag(tag) {
+			slog.Info("cache tag unpinned", "tag", tag)
+		}
+		result = TagResult{Tag: tag}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
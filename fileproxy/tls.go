@@ -0,0 +1,129 @@
+package fileproxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// tlsCurves 可設定的金鑰交換曲線名稱
+var tlsCurves = map[string]tls.CurveID{
+	"x25519":         tls.X25519,
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"p256":           tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p521":           tls.CurveP521,
+}
+
+// parseCurves 將曲線名稱轉為 tls.CurveID，保留設定的順序
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		id, ok := tlsCurves[strings.ToLower(strings.ReplaceAll(name, "-", ""))]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls curve %q", name)
+		}
+		curves = append(curves, id)
+	}
+	return curves, nil
+}
+
+// loadSessionTicketKeys 讀取 session ticket 金鑰檔
+//
+// 每行一把 32 位元組的十六進位金鑰，第一把用於加密新票證，其餘只用於解密，
+// 方便輪替；空行與 # 開頭的註解會被忽略。多個實例共用同一檔案時，
+// 客戶端可以在任一實例或重啟後恢復連線。
+func loadSessionTicketKeys(path string) ([][32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open session ticket keys: %w", err)
+	}
+	defer f.Close()
+
+	var keys [][32]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(text)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("%s:%d: expected 64 hex characters", path, line)
+		}
+		keys = append(keys, [32]byte(raw))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read session ticket keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no session ticket keys", path)
+	}
+	return keys, nil
+}
+
+// tlsTuning 客戶端 TLS 設定與握手統計
+type tlsTuning struct {
+	config *tls.Config
+
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+// newTLSTuning 依配置建立 TLS 設定，未啟用 TLS 時返回 nil
+func newTLSTuning(cfg *Config) (*tlsTuning, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, nil
+	}
+
+	t := &tlsTuning{}
+	t.config = &tls.Config{
+		MinVersion:             tls.VersionTLS12,
+		SessionTicketsDisabled: cfg.TLSDisableSessionTickets,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			t.handshakes.Add(1)
+			if cs.DidResume {
+				t.resumed.Add(1)
+			}
+			return nil
+		},
+	}
+
+	if len(cfg.TLSCurves) > 0 {
+		curves, err := parseCurves(cfg.TLSCurves)
+		if err != nil {
+			return nil, err
+		}
+		t.config.CurvePreferences = curves
+	}
+
+	if cfg.TLSSessionTicketKeyFile != "" && !cfg.TLSDisableSessionTickets {
+		keys, err := loadSessionTicketKeys(cfg.TLSSessionTicketKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		t.config.SetSessionTicketKeys(keys)
+	}
+
+	return t, nil
+}
+
+// Stats 返回 TLS 握手統計資訊
+func (t *tlsTuning) Stats() map[string]any {
+	handshakes := t.handshakes.Load()
+	resumed := t.resumed.Load()
+	var ratio float64
+	if handshakes > 0 {
+		ratio = float64(resumed) / float64(handshakes)
+	}
+	return map[string]any{
+		"handshakes":      handshakes,
+		"resumed":         resumed,
+		"resumption_rate": ratio,
+		"session_tickets": !t.config.SessionTicketsDisabled,
+	}
+}
@@ -0,0 +1,164 @@
+package fileproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName          = "github.com/shared-utils/fileproxy"
+	defaultServiceName  = "fileproxy"
+	tracingShutdownWait = 5 * time.Second // 關閉時等待匯出剩餘 span 的時間
+)
+
+// tracing OpenTelemetry 追蹤
+//
+// 未設定 OTLP 端點時使用 noop tracer：不記錄任何 span，但收到的 traceparent
+// 仍會原樣轉發給上游，上游的追蹤不會因經過代理而中斷。
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	provider   *sdktrace.TracerProvider // 停用時為 nil
+}
+
+// newTracing 依配置建立追蹤，未設定端點時返回 noop 實作
+func newTracing(cfg *Config) (*tracing, error) {
+	t := &tracing{
+		tracer:     noop.NewTracerProvider().Tracer(tracerName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	if cfg.OTLPEndpoint == "" {
+		return t, nil
+	}
+
+	var opts []otlptracehttp.Option
+	if strings.Contains(cfg.OTLPEndpoint, "://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPEndpoint))
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+
+	serviceName := cfg.TraceServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	)
+	t.tracer = t.provider.Tracer(tracerName)
+	return t, nil
+}
+
+// Close 匯出剩餘的 span 並關閉
+func (t *tracing) Close() error {
+	if t.provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownWait)
+	defer cancel()
+	return t.provider.Shutdown(ctx)
+}
+
+// startRequest 以請求頭中的追蹤上下文為父節點，開始客戶端請求的 span
+func (t *tracing) startRequest(r *http.Request) (context.Context, trace.Span) {
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return t.tracer.Start(ctx, "fileproxy.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+}
+
+// startFetch 開始回源下載的 span，結束前涵蓋整個本體傳輸
+func (t *tracing) startFetch(ctx context.Context, upstreamURL string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "fileproxy.upstream_fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.URLFull(upstreamURL)))
+}
+
+// startSpan 以 ctx 中 span 所屬的 TracerProvider 開始子 span，供快取等不持有 tracer 的元件使用
+//
+// ctx 沒有 span 或追蹤停用時返回 noop span；沿用父節點的取樣決定。
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// inject 將追蹤上下文寫入回源請求頭
+func (t *tracing) inject(ctx context.Context, h http.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// spanError 在 span 上記錄錯誤
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// spanWriter 記錄回應狀態碼，請求結束時寫入 span
+type spanWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *spanWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *spanWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush 轉交給底層回應，保持串流即時送出
+func (s *spanWriter) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 取得底層回應
+func (s *spanWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// finish 寫入回應狀態與快取結果
+func (s *spanWriter) finish(span trace.Span) {
+	if s.status != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(s.status))
+	}
+	if cache := s.Header().Get("X-Cache"); cache != "" {
+		span.SetAttributes(attribute.String("fileproxy.cache", cache))
+	}
+	if s.status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(s.status))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package fileproxy
+
+// setUmask 非 Unix 平台沒有 umask，返回 0
+func setUmask(mask int) int {
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package fileproxy
+
+import "syscall"
+
+// setUmask 設定程序的 umask 並返回原本的值
+func setUmask(mask int) int {
+	return syscall.Umask(mask)
+}
@@ -0,0 +1,379 @@
+package fileproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadSpoolDir    = ".uploads"       // 待上傳檔案目錄（位於快取目錄下）
+	uploadsPath       = "/admin/uploads" // 上傳狀態端點
+	uploadWorkers     = 2                // 並發上傳的 worker 數
+	uploadQueueSize   = 1024             // 佇列長度
+	uploadMaxAttempts = 5                // 最大嘗試次數
+	uploadRetryBase   = 2 * time.Second  // 重試基礎間隔
+	uploadHistorySize = 100              // 保留的已結束任務數
+)
+
+// 上傳任務狀態
+const (
+	uploadStateQueued  = "queued"
+	uploadStateRunning = "uploading"
+	uploadStateDone    = "done"
+	uploadStateFailed  = "failed"
+)
+
+// uploadJob 非同步上傳任務
+type uploadJob struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	spoolPath string
+}
+
+// uploader 寫後上傳：上傳先寫入快取，再非同步 PUT 到上游
+//
+// 待上傳的檔案以硬連結保存在 spool 目錄，快取淘汰不影響上傳，
+// 重啟後會從 spool 目錄恢復未完成的任務。
+type uploader struct {
+	config *Config
+	cache  *Cache
+	client *http.Client
+	dir    string
+	queue  chan *uploadJob
+
+	mu      sync.Mutex
+	jobs    map[string]*uploadJob // 未結束的任務
+	history []*uploadJob          // 最近結束的任務
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newUploader 建立上傳器並恢復未完成的任務
+func newUploader(cfg *Config, cache *Cache, client *http.Client) (*uploader, error) {
+	u := &uploader{
+		config:  cfg,
+		cache:   cache,
+		client:  client,
+		dir:     filepath.Join(cfg.CacheDir, uploadSpoolDir),
+		queue:   make(chan *uploadJob, uploadQueueSize),
+		jobs:    make(map[string]*uploadJob),
+		closeCh: make(chan struct{}),
+	}
+	if err := os.MkdirAll(u.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create upload spool: %w", err)
+	}
+
+	for i := 0; i < uploadWorkers; i++ {
+		u.wg.Add(1)
+		go u.worker()
+	}
+	u.recover()
+	return u, nil
+}
+
+// Close 停止上傳 worker，未完成的任務保留在 spool 目錄
+func (u *uploader) Close() {
+	close(u.closeCh)
+	u.wg.Wait()
+}
+
+// spoolName 產生 spool 檔名，同一 key 的多次上傳互不覆蓋
+func spoolName(key string, t time.Time) string {
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash[:]), t.UnixNano())
+}
+
+// recover 從 spool 目錄恢復未完成的任務
+func (u *uploader) recover() {
+	matches, _ := filepath.Glob(filepath.Join(u.dir, "*.json"))
+	recovered := 0
+	for _, meta := range matches {
+		data, err := os.ReadFile(meta)
+		if err != nil {
+			continue
+		}
+		var job uploadJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			os.Remove(meta)
+			continue
+		}
+		job.spoolPath = strings.TrimSuffix(meta, ".json")
+		if _, err := os.Stat(job.spoolPath); err != nil {
+			os.Remove(meta)
+			continue
+		}
+		job.State = uploadStateQueued
+
+		u.mu.Lock()
+		u.jobs[job.Key] = &job
+		u.mu.Unlock()
+		u.queue <- &job
+		recovered++
+	}
+	if recovered > 0 {
+		slog.Info("pending uploads recovered", "count", recovered)
+	}
+}
+
+// Enqueue 將快取條目加入上傳佇列，返回任務狀態快照
+func (u *uploader) Enqueue(key string, entry *CacheEntry) (uploadJob, error) {
+	now := time.Now()
+	spoolPath := filepath.Join(u.dir, spoolName(key, now))
+	if err := os.Link(u.cache.FilePath(entry), spoolPath); err != nil {
+		return uploadJob{}, fmt.Errorf("link upload spool: %w", err)
+	}
+
+	job := &uploadJob{
+		Key:         key,
+		Size:        entry.Size,
+		ContentType: entry.ContentType(),
+		State:       uploadStateQueued,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		spoolPath:   spoolPath,
+	}
+
+	data, _ := json.Marshal(job)
+	if err := os.WriteFile(spoolPath+".json", data, 0644); err != nil {
+		os.Remove(spoolPath)
+		return uploadJob{}, fmt.Errorf("write upload metadata: %w", err)
+	}
+
+	u.mu.Lock()
+	u.jobs[job.Key] = job
+	snapshot := *job
+	u.mu.Unlock()
+
+	select {
+	case u.queue <- job:
+		return snapshot, nil
+	default:
+		err := fmt.Errorf("upload queue full")
+		u.finish(job, err)
+		return uploadJob{}, err
+	}
+}
+
+// worker 從佇列取出任務並上傳，失敗時以指數退避重試
+func (u *uploader) worker() {
+	defer u.wg.Done()
+	for {
+		select {
+		case <-u.closeCh:
+			return
+		case job := <-u.queue:
+			u.run(job)
+		}
+	}
+}
+
+// run 執行單一任務直到成功、重試耗盡或關閉
+func (u *uploader) run(job *uploadJob) {
+	for {
+		u.update(job, uploadStateRunning, nil)
+		err := u.put(job)
+		if err == nil {
+			u.finish(job, nil)
+			return
+		}
+		// 離線期間不計入嘗試次數，恢復後繼續上傳
+		if errors.Is(err, errOffline) {
+			u.mu.Lock()
+			job.Attempts--
+			u.mu.Unlock()
+			u.update(job, uploadStateQueued, err)
+			select {
+			case <-u.closeCh:
+				return
+			case <-time.After(uploadRetryBase):
+			}
+			continue
+		}
+
+		slog.Warn("upload failed", "key", job.Key, "attempt", job.Attempts, "error", err)
+		if job.Attempts >= uploadMaxAttempts {
+			u.finish(job, err)
+			return
+		}
+		u.update(job, uploadStateQueued, err)
+
+		select {
+		case <-u.closeCh:
+			return
+		case <-time.After(uploadRetryBase << (job.Attempts - 1)):
+		}
+	}
+}
+
+// put 將 spool 檔案 PUT 到上游
+func (u *uploader) put(job *uploadJob) error {
+	file, err := os.Open(job.spoolPath)
+	if err != nil {
+		return fmt.Errorf("open upload spool: %w", err)
+	}
+	defer file.Close()
+
+	upstreamURL, ok := u.config.upstreamFor(u.config.rewritePath(job.Key))
+	if !ok {
+		return fmt.Errorf("no route for %s", job.Key)
+	}
+	req, err := http.NewRequest(http.MethodPut, upstreamURL, file)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = job.Size
+	req.Header.Set("Content-Type", job.ContentType)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upstream request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("upstream status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// update 更新任務狀態
+func (u *uploader) update(job *uploadJob, state string, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	job.State = state
+	job.UpdatedAt = time.Now()
+	if state == uploadStateRunning {
+		job.Attempts++
+	}
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// finish 結束任務並清理 spool 檔案
+func (u *uploader) finish(job *uploadJob, err error) {
+	os.Remove(job.spoolPath)
+	os.Remove(job.spoolPath + ".json")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job.UpdatedAt = time.Now()
+	if err != nil {
+		job.State = uploadStateFailed
+		job.Error = err.Error()
+	} else {
+		job.State = uploadStateDone
+		job.Error = ""
+		slog.Debug("upload completed", "key", job.Key, "size", job.Size)
+	}
+
+	if u.jobs[job.Key] == job {
+		delete(u.jobs, job.Key)
+	}
+	u.history = append(u.history, job)
+	if len(u.history) > uploadHistorySize {
+		u.history = u.history[len(u.history)-uploadHistorySize:]
+	}
+}
+
+// snapshot 返回任務列表，可依 key 過濾
+func (u *uploader) snapshot(key string) []uploadJob {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	out := make([]uploadJob, 0, len(u.jobs)+len(u.history))
+	for _, job := range u.jobs {
+		if key == "" || job.Key == key {
+			out = append(out, *job)
+		}
+	}
+	for i := len(u.history) - 1; i >= 0; i-- {
+		if key == "" || u.history[i].Key == key {
+			out = append(out, *u.history[i])
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return out
+}
+
+// Stats 返回上傳統計資訊
+func (u *uploader) Stats() map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	failed := 0
+	for _, job := range u.history {
+		if job.State == uploadStateFailed {
+			failed++
+		}
+	}
+	return map[string]any{
+		"pending":        len(u.jobs),
+		"recent_failed":  failed,
+		"recent_history": len(u.history),
+	}
+}
+
+// handleUpload 接收上傳：先寫入快取，再排入上游上傳佇列
+func (p *Proxy) handleUpload(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path
+	if key == "/" || strings.HasSuffix(key, "/") {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	entry, err := p.cache.Put(r.Context(), key, r.Body, r.ContentLength, contentType, 0, nil)
+	if err == errPendingExists {
+		http.Error(w, "Conflict", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Warn("upload receive failed", "key", key, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	job, err := p.uploader.Enqueue(key, entry)
+	if err != nil {
+		p.stats.recordError(key, err)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleUploads 返回上傳任務狀態，支援 ?key= 過濾
+func (p *Proxy) handleUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.uploader.snapshot(r.URL.Query().Get("key")))
+}
@@ -0,0 +1,255 @@
+package fileproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UpstreamCaps 單一上游來源（scheme://host）的能力
+type UpstreamCaps struct {
+	HTTP2    bool      `json:"http2"`
+	Ranges   bool      `json:"ranges"`
+	ProbedAt time.Time `json:"probed_at,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// capabilityProber 探測並記錄上游是否支援 HTTP/2 與 Range 請求
+//
+// 啟動時與每個 interval 以 Range: bytes=0-0 請求各上游的根路徑，依協定版本與 206、
+// Accept-Ranges 判斷能力；一般回源的回應也會更新記錄，因此根路徑不是檔案的上游
+// 在第一次下載後即可得知是否支援 Range。下載依記錄自動決定是否續傳與並行分段下載，
+// 不需逐一設定上游。Range 請求得到 200 時視為不支援並停止使用。
+type capabilityProber struct {
+	client   *http.Client
+	interval time.Duration
+	targets  []string // 探測的上游 URL，每個來源一個
+
+	mu   sync.RWMutex
+	caps map[string]UpstreamCaps // 依來源
+
+	probes   atomic.Int64
+	failures atomic.Int64
+	resumed  atomic.Int64 // 中斷後以 Range 續傳成功的次數
+	parallel atomic.Int64 // 以並行分段下載的檔案數
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newCapabilityProber 建立探測器並在背景開始首次探測，interval 為 0 時只在啟動時探測
+func newCapabilityProber(cfg *Config, client *http.Client) *capabilityProber {
+	cp := &capabilityProber{
+		client:   client,
+		interval: cfg.UpstreamProbeInterval,
+		targets:  probeTargets(cfg),
+		caps:     make(map[string]UpstreamCaps),
+		closeCh:  make(chan struct{}),
+	}
+	cp.wg.Add(1)
+	go cp.loop()
+	return cp
+}
+
+// probeTargets 返回配置中所有上游 URL，同一來源只保留第一個
+func probeTargets(cfg *Config) []string {
+	var urls []string
+	if cfg.UpstreamURL != "" {
+		urls = append(urls, cfg.UpstreamURL)
+	}
+	for _, route := range cfg.Routes {
+		urls = append(urls, route.Upstream)
+	}
+	for _, rule := range cfg.CacheRules {
+		if rule.Upstream != "" {
+			urls = append(urls, rule.Upstream)
+		}
+	}
+
+	var targets []string
+	var seen []string
+	for _, u := range urls {
+		origin := upstreamOrigin(u)
+		if origin == "" || slices.Contains(seen, origin) {
+			continue
+		}
+		seen = append(seen, origin)
+		targets = append(targets, u)
+	}
+	return targets
+}
+
+// upstreamOrigin 返回 URL 的 scheme://host，無法解析時為空字串
+func upstreamOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Close 停止定期探測
+func (cp *capabilityProber) Close() {
+	close(cp.closeCh)
+	cp.wg.Wait()
+}
+
+// loop 立即探測一次，之後每個 interval 重新探測
+func (cp *capabilityProber) loop() {
+	defer cp.wg.Done()
+	cp.probeAll()
+	if cp.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.closeCh:
+			return
+		case <-ticker.C:
+			cp.probeAll()
+		}
+	}
+}
+
+// probeAll 依序探測所有上游
+func (cp *capabilityProber) probeAll() {
+	for _, target := range cp.targets {
+		select {
+		case <-cp.closeCh:
+			return
+		default:
+		}
+		cp.probe(target)
+	}
+}
+
+// probe 以 Range: bytes=0-0 請求上游根路徑並更新能力
+//
+// 根路徑返回錯誤狀態時只更新協定版本，Range 支援沿用先前的記錄。
+func (cp *capabilityProber) probe(target string) {
+	cp.probes.Add(1)
+	origin := upstreamOrigin(target)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	req.Header.Set("Via", viaValue(1, 1))
+	resp, err := cp.client.Do(req)
+	if errors.Is(err, errOffline) {
+		return
+	}
+	if err != nil {
+		cp.failures.Add(1)
+		cp.update(origin, func(c *UpstreamCaps) {
+			c.ProbedAt = time.Now()
+			c.Error = err.Error()
+		})
+		slog.Warn("upstream probe failed", "upstream", origin, "error", err)
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	cp.update(origin, func(c *UpstreamCaps) {
+		c.ProbedAt = time.Now()
+		c.Error = ""
+		c.HTTP2 = resp.ProtoMajor == 2
+		if ranges, known := rangeSupport(resp); known {
+			c.Ranges = ranges
+		}
+	})
+}
+
+// rangeSupport 依回應判斷上游是否支援 Range，無法判斷時 known 為 false
+func rangeSupport(resp *http.Response) (ranges, known bool) {
+	if resp.StatusCode == http.StatusPartialContent {
+		return true, true
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, false
+	}
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Accept-Ranges"))) {
+	case "bytes":
+		return true, true
+	case "none":
+		return false, true
+	}
+	return false, false
+}
+
+// observe 以一般回源的回應更新能力
+func (cp *capabilityProber) observe(upstreamURL string, resp *http.Response) {
+	ranges, known := rangeSupport(resp)
+	http2 := resp.ProtoMajor == 2
+	origin := upstreamOrigin(upstreamURL)
+	cp.mu.RLock()
+	c, ok := cp.caps[origin]
+	cp.mu.RUnlock()
+	if ok && c.HTTP2 == http2 && (!known || c.Ranges == ranges) {
+		return
+	}
+	cp.update(origin, func(c *UpstreamCaps) {
+		c.HTTP2 = http2
+		if known {
+			c.Ranges = ranges
+		}
+	})
+}
+
+// rangeRefused Range 請求得到完整內容時停止對該來源使用 Range
+func (cp *capabilityProber) rangeRefused(upstreamURL string) {
+	cp.update(upstreamOrigin(upstreamURL), func(c *UpstreamCaps) { c.Ranges = false })
+}
+
+// update 修改來源的能力，變化時記錄日誌
+func (cp *capabilityProber) update(origin string, fn func(c *UpstreamCaps)) {
+	if origin == "" {
+		return
+	}
+	cp.mu.Lock()
+	old, known := cp.caps[origin]
+	c := old
+	fn(&c)
+	cp.caps[origin] = c
+	cp.mu.Unlock()
+	if !known || old.HTTP2 != c.HTTP2 || old.Ranges != c.Ranges {
+		slog.Info("upstream capabilities", "upstream", origin, "http2", c.HTTP2, "ranges", c.Ranges)
+	}
+}
+
+// Caps 返回上游 URL 所屬來源的能力，尚未得知時為零值
+func (cp *capabilityProber) Caps(upstreamURL string) UpstreamCaps {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	return cp.caps[upstreamOrigin(upstreamURL)]
+}
+
+// Stats 返回探測統計資訊，不含離線狀態
+func (cp *capabilityProber) Stats() UpstreamStats {
+	cp.mu.RLock()
+	origins := maps.Clone(cp.caps)
+	cp.mu.RUnlock()
+	return UpstreamStats{
+		ProbeInterval: cp.interval.String(),
+		Probes:        cp.probes.Load(),
+		ProbeFailures: cp.failures.Load(),
+		Resumed:       cp.resumed.Load(),
+		Parallel:      cp.parallel.Load(),
+		Origins:       origins,
+	}
+}
@@ -0,0 +1,230 @@
+package fileproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// epochThreshold 大於此值的 reset 視為 Unix 時間戳，否則為距離重設的秒數
+const epochThreshold = 1_000_000_000
+
+// upstreamRateLimitedError 等待上游限流預算重設的時間超過上限
+type upstreamRateLimitedError struct {
+	origin string
+	retry  time.Duration
+}
+
+func (e *upstreamRateLimitedError) Error() string {
+	return fmt.Sprintf("upstream %s rate limit exhausted, resets in %s", e.origin, e.retry.Round(time.Second))
+}
+
+// originBudget 單一上游來源最近一次回報的限流預算
+type originBudget struct {
+	mu        sync.Mutex
+	limit     int64     // 每個期間的請求數，上游未提供時為 0
+	remaining int64     // 期間內剩餘的請求數，送出請求時先行扣減
+	reset     time.Time // 預算重設的時間，零值表示沒有預算資訊
+	next      time.Time // 平均分配時下一個請求最早的送出時間
+}
+
+// reserve 為一個請求預留預算，返回送出前需等待的時間
+//
+// 預算用盡時等待到重設；剩餘預算低於 reserve 比例（上游未提供上限時一律）時，
+// 將剩餘的請求平均分配到重設前，避免在期間開頭用完後收到 429。
+func (b *originBudget) reserve(now time.Time, reserve float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reset.IsZero() || !now.Before(b.reset) {
+		return 0
+	}
+	window := b.reset.Sub(now)
+	if b.remaining <= 0 {
+		return window
+	}
+	if b.limit > 0 && float64(b.remaining) > float64(b.limit)*reserve {
+		b.remaining--
+		return 0
+	}
+	slot := b.next
+	if slot.Before(now) {
+		slot = now
+	}
+	b.next = slot.Add(window / time.Duration(b.remaining+1))
+	b.remaining--
+	return slot.Sub(now)
+}
+
+// update 以上游回應頭更新預算
+func (b *originBudget) update(limit, remaining int64, reset time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limit > 0 {
+		b.limit = limit
+	}
+	b.remaining, b.reset = remaining, reset
+}
+
+// snapshot 返回預算狀態
+func (b *originBudget) snapshot() map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]any{
+		"limit":     b.limit,
+		"remaining": b.remaining,
+		"reset":     b.reset,
+	}
+}
+
+// upstreamBudget 依上游 X-RateLimit-* 與 RateLimit-* 回應頭調節回源速度
+//
+// 每個來源記錄最近一次回報的剩餘請求數與重設時間：剩餘預算降到 reserve 比例以下時，
+// 之後的請求平均分散到重設前送出；預算用盡或收到帶 Retry-After 的 429 時，
+// 請求等待到重設再送出，等待超過 maxWait 時直接失敗，由代理返回 503 而不是打到上游換來 429。
+type upstreamBudget struct {
+	next    http.RoundTripper
+	reserve float64
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	origins map[string]*originBudget
+
+	paced     atomic.Int64 // 被延後送出的請求數
+	rejected  atomic.Int64 // 等待超過上限而失敗的請求數
+	throttled atomic.Int64 // 上游仍返回 429 的次數
+}
+
+// newUpstreamBudget 包裝上游 Transport，未啟用時返回 nil
+func newUpstreamBudget(cfg *Config, next http.RoundTripper) *upstreamBudget {
+	if !cfg.UpstreamRateHeaders {
+		return nil
+	}
+	return &upstreamBudget{
+		next:    next,
+		reserve: cfg.UpstreamRateReserve,
+		maxWait: cfg.UpstreamRateMaxWait,
+		origins: make(map[string]*originBudget),
+	}
+}
+
+// origin 返回來源的預算記錄
+func (u *upstreamBudget) origin(name string) *originBudget {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.origins[name]
+	if !ok {
+		b = &originBudget{}
+		u.origins[name] = b
+	}
+	return b
+}
+
+func (u *upstreamBudget) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	b := u.origin(origin)
+	if wait := b.reserve(time.Now(), u.reserve); wait > 0 {
+		if u.maxWait > 0 && wait > u.maxWait {
+			u.rejected.Add(1)
+			return nil, &upstreamRateLimitedError{origin: origin, retry: wait}
+		}
+		u.paced.Add(1)
+		slog.Debug("pacing upstream request", "upstream", origin, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	resp, err := u.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	now := time.Now()
+	if limit, remaining, reset, ok := parseRateLimit(resp.Header, now); ok {
+		b.update(limit, remaining, reset)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		u.throttled.Add(1)
+		if retry, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			b.update(0, 0, now.Add(retry))
+		}
+		slog.Warn("upstream rate limited", "upstream", origin, "retry_after", resp.Header.Get("Retry-After"))
+	}
+	return resp, nil
+}
+
+// rejectRateLimited 上游限流預算用盡時以 503 與 Retry-After 回應，返回是否已回應
+func rejectRateLimited(w http.ResponseWriter, err error) bool {
+	var limited *upstreamRateLimitedError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(limited.retry.Round(time.Second), time.Second).Seconds())))
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	return true
+}
+
+// parseRateLimit 解析 X-RateLimit-* 或 RateLimit-* 頭，沒有剩餘數或重設時間時 ok 為 false
+func parseRateLimit(h http.Header, now time.Time) (limit, remaining int64, reset time.Time, ok bool) {
+	value := func(name string) string {
+		if v := h.Get("X-RateLimit-" + name); v != "" {
+			return v
+		}
+		return h.Get("RateLimit-" + name)
+	}
+	remaining, err := strconv.ParseInt(strings.TrimSpace(value("Remaining")), 10, 64)
+	if err != nil {
+		return 0, 0, time.Time{}, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value("Reset")), 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, time.Time{}, false
+	}
+	if n > epochThreshold {
+		reset = time.Unix(n, 0)
+	} else {
+		reset = now.Add(time.Duration(n) * time.Second)
+	}
+	limit, _ = strconv.ParseInt(strings.TrimSpace(value("Limit")), 10, 64)
+	return limit, max(remaining, 0), reset, true
+}
+
+// parseRetryAfter 解析秒數或 HTTP 日期格式的 Retry-After
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Stats 返回各來源的預算與調節統計
+func (u *upstreamBudget) Stats() map[string]any {
+	u.mu.Lock()
+	origins := make(map[string]any, len(u.origins))
+	for name, b := range u.origins {
+		origins[name] = b.snapshot()
+	}
+	u.mu.Unlock()
+	return map[string]any{
+		"origins":   origins,
+		"paced":     u.paced.Load(),
+		"rejected":  u.rejected.Load(),
+		"throttled": u.throttled.Load(),
+	}
+}
@@ -0,0 +1,137 @@
+package fileproxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// versionPrevious ?version= 取得最近一個舊版本的值
+const versionPrevious = "previous"
+
+// errInvalidVersion ?version= 的值既不是 previous 也不是 RFC 3339 時間
+var errInvalidVersion = errors.New("version must be \"previous\" or an RFC 3339 timestamp")
+
+// versionKey 返回 key 第 n 個舊版本（1 為最近一個）的條目 key，請求路徑無法構成
+func versionKey(key string, n int) string {
+	return key + privateKeySep + "version" + privateKeySep + strconv.Itoa(n)
+}
+
+// keepVersion 重新下載的內容與目前條目不同時，將目前條目保留為最近的舊版本
+//
+// 較舊的版本依序後移，超過 KeepVersions 的刪除。舊版本以硬連結共用檔案，不複製內容；
+// 建立時間記錄被取代的時間，不因存活時間過期，只在容量壓力下淘汰。須在移除目前條目之前呼叫。
+func (c *Cache) keepVersion(key string, current *CacheEntry, sum keyHash) {
+	n := c.config.KeepVersions
+	if n <= 0 || isPrivateKey(key) || (sum != keyHash{} && current.sum == sum) {
+		return
+	}
+	now := time.Now().UnixNano()
+	c.fileCache.RemoveAs(hashKey(versionKey(key, n)), removeReplaced)
+	for i := n - 1; i >= 1; i-- {
+		if entry, ok := c.fileCache.Peek(hashKey(versionKey(key, i))); ok {
+			c.moveVersion(entry, versionKey(key, i+1), entry.createdAt)
+		}
+	}
+	if c.moveVersion(current, versionKey(key, 1), now) {
+		c.versionsKept.Add(1)
+	}
+}
+
+// moveVersion 以硬連結將條目的檔案放到 dst 的位置並加入索引，dst 原有的條目先移除
+func (c *Cache) moveVersion(src *CacheEntry, dst string, replacedAt int64) bool {
+	hash := hashKey(dst)
+	c.fileCache.RemoveAs(hash, removeReplaced)
+	path := c.pathFor(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Warn("keep cache version failed", "error", err)
+		return false
+	}
+	if err := os.Link(c.pathFor(src.hash), path); err != nil {
+		// 條目已在其他地方被淘汰，或檔案系統不支援硬連結
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("keep cache version failed", "error", err)
+		}
+		return false
+	}
+	entry := &CacheEntry{
+		hash:        hash,
+		Size:        src.Size,
+		contentType: src.contentType,
+		createdAt:   replacedAt,
+		TTL:         immutableTTL,
+		sum:         src.sum,
+		validators:  src.validators,
+		tags:        src.tags,
+	}
+	c.refresh(entry)
+	c.fileCache.Add(entry)
+	c.store.Put(entry, dst)
+	c.totalSize.Add(entry.Size)
+	return true
+}
+
+// Version 依 ?version= 的值返回 key 的條目
+//
+// previous 返回最近一個舊版本；時間戳返回當時提供的版本：舊版本的建立時間是它被取代的時間，
+// 因此選擇時間戳之後才被取代的版本中最新的一個，早於所有保留版本時返回最舊的版本。
+func (c *Cache) Version(key, spec string) (*CacheEntry, bool, error) {
+	if spec == versionPrevious {
+		entry, ok := c.fileCache.Peek(hashKey(versionKey(key, 1)))
+		return entry, ok, nil
+	}
+	at, err := time.Parse(time.RFC3339, spec)
+	if err != nil {
+		return nil, false, errInvalidVersion
+	}
+	candidate, found := c.fileCache.Peek(hashKey(key))
+	for i := 1; i <= c.config.KeepVersions; i++ {
+		entry, ok := c.fileCache.Peek(hashKey(versionKey(key, i)))
+		if !ok || entry.createdAt <= at.UnixNano() {
+			break
+		}
+		candidate, found = entry, true
+	}
+	return candidate, found, nil
+}
+
+// serveVersion 以 ?version= 從保留的舊版本提供內容，不向上游請求，沒有符合的版本時返回 404
+func (p *Proxy) serveVersion(w http.ResponseWriter, r *http.Request, spec string) error {
+	query := r.URL.Query()
+	query.Del("version")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	key := p.cacheKey(r)
+
+	entry, ok, err := p.cache.Version(key, spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if !ok || !p.validateCacheFile(entry) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	if entry.hash != hashKey(key) {
+		w.Header().Set("X-Superseded-At", entry.CreatedAt().UTC().Format(http.TimeFormat))
+	}
+	err = p.serveFromCache(w, r, entry, "VERSION")
+	if errors.Is(err, errEntryEvicted) || errors.Is(err, fs.ErrNotExist) {
+		w.Header().Del("X-Superseded-At")
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("serve version: %w", err)
+	}
+	p.stats.versions.Add(1)
+	return nil
+}